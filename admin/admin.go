@@ -2,11 +2,18 @@ package admin
 
 import (
 	"SPG_ATM_Machine/internal/api"
+	"SPG_ATM_Machine/internal/config"
 	"SPG_ATM_Machine/internal/db"
+	"SPG_ATM_Machine/internal/models"
+	"SPG_ATM_Machine/session"
 	"SPG_ATM_Machine/utils"
+	"database/sql"
 	"fmt"
-	"strings"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func viewChoices() {
@@ -15,62 +22,356 @@ func viewChoices() {
 	fmt.Println("Enter 2 to View Deposits/Withdrawals")
 	fmt.Println("Enter 3 to Set Deposit/Withdrawal limits")
 	fmt.Println("Enter 4 to Unlock Account for Customer")
-	fmt.Println("Enter 5 to Exit")
+	fmt.Println("Enter 5 to Set Customer Daily Limits")
+	fmt.Println("Enter 6 to Manage ATM Cards")
+	fmt.Println("Enter 7 to View Audit Log")
+	fmt.Println("Enter 8 to Review Cash Counts")
+	fmt.Println("Enter 9 to Manage Terminals")
+	fmt.Println("Enter 10 to Reset a PIN")
+	fmt.Println("Enter 11 to Open Customer Account")
+	fmt.Println("Enter 12 to Manage Customers")
+	fmt.Println("Enter 13 to Create Admin/Cash Handler")
+	fmt.Println("Enter 14 to Adjust Account Balance")
+	fmt.Println("Enter 15 to Review Pending Requests")
+	fmt.Println("Enter 16 to Reprint a Receipt")
+	fmt.Println("Enter 17 to Clear Cheque Deposits")
+	fmt.Println("Enter 18 to Exit")
+}
+
+// Tells the admin their change is waiting for a second admin
+func printRequested(a models.Approval) {
+	fmt.Printf("Request #%d submitted: %s\n", a.ID, a.Summary)
+	fmt.Printf("Another admin must approve it before %s.\n", a.ExpiresAt)
+}
+
+// Shows an account's lock state and lock history, then offers to request an
+// unlock
+func unlockAccount(database *sql.DB, actor models.Actor, limits config.Limits) error {
+	username, err := utils.TypeInput("Enter the username of the account to unlock: ")
+	if err != nil {
+		return err
+	}
+	info, err := api.GetUserAuth(database, actor, username)
+	if err != nil {
+		fmt.Println("Error fetching account:", err)
+		return nil
+	}
+
+	switch {
+	case info.Locked:
+		fmt.Printf("'%s' is locked until an admin unlocks it.\n", username)
+	case info.LockedUntil != "":
+		fmt.Printf("'%s' is locked until %s (lockout %d in a row).\n", username, info.LockedUntil, info.Lockouts)
+	default:
+		fmt.Printf("'%s' is not locked (%d failed attempts).\n", username, info.FailedAttempts)
+	}
+
+	events, err := api.GetLockHistory(database, actor, username, 10)
+	if err != nil {
+		fmt.Println("Error fetching lock history:", err)
+		return nil
+	}
+	if len(events) > 0 {
+		fmt.Println("\n===== LOCK HISTORY =====")
+		fmt.Printf("%-19s | %-16s | %-19s | %-14s | %s\n", "Time", "Event", "Locked Until", "By", "Terminal")
+		fmt.Println(strings.Repeat("-", 85))
+		for _, e := range events {
+			fmt.Printf("%-19s | %-16s | %-19s | %-14s | %s\n", e.At, e.Event, e.LockedUntil, e.Actor, e.Terminal)
+		}
+	}
+
+	choice, err := utils.TypeInput("Enter U to request an unlock or S to skip: ")
+	if err != nil {
+		return err
+	}
+	choice = strings.ToUpper(choice)
+	if choice != "U" {
+		return nil
+	}
+	request, err := api.UnlockAccount(database, actor, username, limits)
+	if err != nil {
+		fmt.Println("Error requesting unlock:", err)
+		return nil
+	}
+	printRequested(request)
+	return nil
 }
 
-func createNewUser() {
+// Issues a one-time temporary PIN that the user must change at their next
+// login. A locked account stays locked until an unlock is approved.
+func resetPIN(database *sql.DB, actor models.Actor) error {
+	username, err := utils.TypeInput("Enter the username whose PIN to reset: ")
+	if err != nil {
+		return err
+	}
+	tempPIN, err := api.ResetPIN(database, actor, username)
+	if err != nil {
+		fmt.Println("Error resetting PIN:", err)
+		return nil
+	}
+	fmt.Printf("Temporary PIN for '%s': %s\n", username, tempPIN)
+	fmt.Println("Give it to the user. They must choose a new PIN when they next log in.")
+	return nil
+}
+
+// Lists a customer's accounts and opens another checking or savings account
+func openAccount(database *sql.DB, actor models.Actor) error {
+	username, err := utils.TypeInput("Enter the username of the customer: ")
+	if err != nil {
+		return err
+	}
+	list, err := api.ListAccounts(database, actor, username)
+	if err != nil {
+		fmt.Println("Error getting accounts:", err)
+		return nil
+	}
+	fmt.Printf("%-10s | %-8s | %-6s | %12s | %-8s | %-19s\n", "Account", "Type", "Status", "Balance ($)", "Rate (%)", "Opened")
+	fmt.Println(strings.Repeat("-", 78))
+	for _, a := range list {
+		rate := fmt.Sprintf("%d.%02d", a.InterestRate/100, a.InterestRate%100)
+		fmt.Printf("%-10s | %-8s | %-6s | %12s | %-8s | %-19s\n", a.Number, a.Type, a.Status, a.Balance, rate, a.OpenedAt)
+	}
+
+	accountType := ""
+	rate := 0
+	choice, err := utils.TypeInput("Enter C to open a checking account, V for a savings account, or S to skip: ")
+	if err != nil {
+		return err
+	}
+	switch strings.ToUpper(choice) {
+	case "C":
+		accountType = models.AccountChecking
+	case "V":
+		accountType = models.AccountSavings
+		rate, err = utils.TypeInt("Enter the yearly interest rate in basis points (e.g. 250 for 2.50%): ")
+		if err != nil {
+			return err
+		}
+	case "S":
+		return nil
+	default:
+		fmt.Println("Invalid choice.")
+		return nil
+	}
+
+	account, err := api.OpenAccount(database, actor, username, accountType, rate)
+	if err != nil {
+		fmt.Println("Error opening account:", err)
+		return nil
+	}
+	fmt.Printf("Opened %s account %s for '%s'\n", account.Type, account.Number, username)
+	return nil
+}
+
+// Prints a customer's profile, lock state and accounts
+func showCustomer(p models.CustomerProfile) {
+	u := p.User
+	fmt.Printf("\n===== CUSTOMER %s =====\n", u.Username)
+	fmt.Println("Name:          ", u.FullName)
+	fmt.Println("Date of birth: ", u.DOB)
+	switch {
+	case u.Locked == 1:
+		fmt.Println("Login:          locked until an admin unlocks it")
+	case p.LockedUntil != "":
+		fmt.Println("Login:          locked until", p.LockedUntil)
+	default:
+		fmt.Printf("Login:          active (%d failed PIN attempts)\n", u.FailedAttempts)
+	}
+
+	fmt.Printf("%-10s | %-8s | %-6s | %12s | %10s | %-19s | %s\n", "Account", "Type", "Status", "Balance ($)", "Held ($)", "Opened", "Last status change")
+	fmt.Println(strings.Repeat("-", 113))
+	for _, a := range p.Accounts {
+		change := "-"
+		if a.StatusAt != "" {
+			change = fmt.Sprintf("%s by %s", a.StatusAt, a.StatusBy)
+			if a.StatusReason != "" {
+				change += ": " + a.StatusReason
+			}
+		}
+		fmt.Printf("%-10s | %-8s | %-6s | %12s | %10s | %-19s | %s\n", a.Number, a.Type, a.Status, a.Balance, a.Held, a.OpenedAt, change)
+	}
+	fmt.Printf("Total balance: $%s\n", p.Total)
+}
+
+// Searches customers by name or username, then shows one and lets the admin
+// edit their name and date of birth, or freeze, unfreeze or close an account
+func manageCustomers(database *sql.DB, actor models.Actor) error {
+	query, err := utils.TypeInput("Enter part of a name or username (blank for every customer): ")
+	if err != nil {
+		return err
+	}
+	found, err := api.SearchCustomers(database, actor, query)
+	if err != nil {
+		fmt.Println("Error searching customers:", err)
+		return nil
+	}
+	if len(found) == 0 {
+		fmt.Println("No customers found.")
+		return nil
+	}
+	fmt.Printf("%-15s | %-30s | %-10s\n", "Username", "Name", "DOB")
+	fmt.Println(strings.Repeat("-", 61))
+	for _, u := range found {
+		fmt.Printf("%-15s | %-30s | %-10s\n", u.Username, u.FullName, u.DOB)
+	}
+
+	username, err := utils.TypeInput("Enter the username to view, or press enter to skip: ")
+	if err != nil {
+		return err
+	}
+	if username == "" {
+		return nil
+	}
+	profile, err := api.GetCustomerProfile(database, actor, username)
+	if err != nil {
+		fmt.Println("Error getting customer:", err)
+		return nil
+	}
+	showCustomer(profile)
+
+	choice, err := utils.TypeInput("Enter E to edit name and date of birth, F to freeze an account, U to unfreeze one,\n" +
+		"X to close one, or S to skip: ")
+	if err != nil {
+		return err
+	}
+	choice = strings.ToUpper(choice)
+	switch choice {
+	case "E":
+		name, err := utils.TypeInput(fmt.Sprintf("Enter the full name (blank to keep %s): ", profile.User.FullName))
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			name = profile.User.FullName
+		}
+		dob, err := utils.TypeInput(fmt.Sprintf("Enter the date of birth as MM/DD/YYYY (blank to keep %s): ", profile.User.DOB))
+		if err != nil {
+			return err
+		}
+		if dob == "" {
+			dob = profile.User.DOB
+		}
+		if err := api.UpdateCustomer(database, actor, username, name, dob); err != nil {
+			fmt.Println("Error updating customer:", err)
+			return nil
+		}
+		fmt.Println("Customer updated.")
+	case "F", "U", "X":
+		number, err := utils.TypeInput("Enter the account number: ")
+		if err != nil {
+			return err
+		}
+		owned := false
+		for _, a := range profile.Accounts {
+			owned = owned || a.Number == number
+		}
+		if !owned {
+			fmt.Printf("'%s' has no account %s\n", username, number)
+			return nil
+		}
+		reason, err := utils.TypeInput("Enter the reason: ")
+		if err != nil {
+			return err
+		}
+
+		switch choice {
+		case "F":
+			err = api.FreezeAccount(database, actor, number, reason)
+		case "U":
+			err = api.UnfreezeAccount(database, actor, number, reason)
+		case "X":
+			var answer string
+			answer, err = utils.TypeInput("Closing can't be undone. Close account " + number + "? (Y/N) ")
+			if err != nil {
+				return err
+			}
+			if strings.ToUpper(answer) != "Y" {
+				fmt.Println("Account not closed.")
+				return nil
+			}
+			err = api.CloseAccount(database, actor, number, reason)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			return nil
+		}
+		fmt.Println("Account updated.")
+	case "S":
+		// skip
+	default:
+		fmt.Println("Invalid choice.")
+	}
+	return nil
+}
+
+func createNewUser(database *sql.DB, actor models.Actor) error {
 	fmt.Println("Let's create a new account for you.")
 
 	var (
-		newUsername         string
-		newPin              string
-		newName             string
-		newDateOfBirth      string
-		floatStartingAmount float64
+		newUsername    string
+		newPin         string
+		newName        string
+		newDateOfBirth string
+		startingAmount models.Money
+		err            error
 	)
 
 	for {
-		newUsername = utils.TypeInput("Please enter a username: ")
+		newUsername, err = utils.TypeInput("Please enter a username: ")
+		if err != nil {
+			return err
+		}
 		break
 	}
 	for {
-		newPin = utils.TypeInput("Please enter a 6-digit PIN: ")
+		newPin, err = utils.TypeInput("Please enter a 6-digit PIN: ")
+		if err != nil {
+			return err
+		}
 		if utils.ValidatePIN(newPin) {
 			break
 		}
 	}
 	for {
-		newName = utils.TypeInput("Please enter your Name: ")
+		newName, err = utils.TypeInput("Please enter your Name: ")
+		if err != nil {
+			return err
+		}
 		if utils.ValidateName(newName) {
 			break
 		}
 	}
 	for {
-		newDateOfBirth = utils.TypeInput("Please enter your date of birth (MM/DD/YYYY): ")
+		newDateOfBirth, err = utils.TypeInput("Please enter your date of birth (MM/DD/YYYY): ")
+		if err != nil {
+			return err
+		}
 		if utils.ValidateDate(newDateOfBirth) {
 			break
 		}
 	}
 	for {
-		startingAmount := utils.TypeInput("Starting Amount: ")
-		amount, ok := utils.ParseAmount(startingAmount)
+		amountStr, err := utils.TypeInput("Starting Amount: ")
+		if err != nil {
+			return err
+		}
+		amount, ok := utils.ParseAmount(amountStr)
 		if ok {
-			floatStartingAmount = amount
+			startingAmount = amount
 			break
 		}
 	}
-	
-	database, err := db.Connect()
+
+	err = api.CreateUser(database, actor, newName, newDateOfBirth, newPin, startingAmount, newUsername)
 	if err != nil {
-		fmt.Println("Error connecting to database:", err)
-		return
+		fmt.Println("Error creating user:", err)
+		return nil
 	}
-	defer database.Close()
 
-	err = api.CreateUser(database, newName, newDateOfBirth, newPin, floatStartingAmount, newUsername, "customer")
+	// Every new customer gets a card to log in with
+	cardPath, err := issueCard(database, actor, newUsername)
 	if err != nil {
-		fmt.Println("Error creating user:", err)
-		return
+		fmt.Println("Account created, but the card could not be issued:", err)
 	}
 
 	// Summary
@@ -79,83 +380,929 @@ func createNewUser() {
 	fmt.Println("PIN:", newPin)
 	fmt.Println("Name:", newName)
 	fmt.Println("Date of Birth:", newDateOfBirth)
-	fmt.Printf("Starting Amount: $%.2f\n\n", floatStartingAmount)
+	fmt.Printf("Starting Amount: $%s\n", startingAmount)
+	if cardPath != "" {
+		fmt.Println("Card file:", cardPath)
+	}
+	fmt.Println()
+	return nil
+}
+
+// Requests an admin or cash handler, optionally scoped to some of their role's
+// permissions. The admin who approves it issues their card.
+func createStaffUser(database *sql.DB, actor models.Actor, limits config.Limits) error {
+	allowed, err := api.HasPermission(database, actor, models.PermStaffCreate)
+	if err != nil {
+		fmt.Println("Error checking permissions:", err)
+		return nil
+	}
+	if !allowed {
+		fmt.Println("You don't have permission to create admins or cash handlers.")
+		return nil
+	}
+
+	role := ""
+	choice, err := utils.TypeInput("Enter A to create an admin, H for a cash handler, or S to skip: ")
+	if err != nil {
+		return err
+	}
+	switch strings.ToUpper(choice) {
+	case "A":
+		role = models.RoleAdmin
+	case "H":
+		role = models.RoleCashHandler
+	case "S":
+		return nil
+	default:
+		fmt.Println("Invalid choice.")
+		return nil
+	}
+	details, err := api.GetRole(database, role)
+	if err != nil {
+		fmt.Println("Error fetching role:", err)
+		return nil
+	}
+
+	username, err := utils.TypeInput("Please enter a username: ")
+	if err != nil {
+		return err
+	}
+	pin, err := utils.TypeInput("Please enter a 6-digit PIN: ")
+	if err != nil {
+		return err
+	}
+	if !utils.ValidatePIN(pin) {
+		return nil
+	}
+	name, err := utils.TypeInput("Please enter their Name: ")
+	if err != nil {
+		return err
+	}
+	if !utils.ValidateName(name) {
+		return nil
+	}
+	dob, err := utils.TypeInput("Please enter their date of birth (MM/DD/YYYY): ")
+	if err != nil {
+		return err
+	}
+	if !utils.ValidateDate(dob) {
+		return nil
+	}
+
+	fmt.Printf("\nPermissions of the %s role:\n", role)
+	for i, p := range details.Permissions {
+		fmt.Printf("%2d) %-18s %s\n", i+1, p.Name, p.Description)
+	}
+	var scope []string
+	answer, err := utils.TypeInput("Enter the numbers of the permissions to grant separated by commas, or A for all: ")
+	if err != nil {
+		return err
+	}
+	answer = strings.ToUpper(answer)
+	if answer != "A" {
+		for _, field := range strings.Split(answer, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || n < 1 || n > len(details.Permissions) {
+				fmt.Printf("Invalid permission number %q.\n", strings.TrimSpace(field))
+				return nil
+			}
+			scope = append(scope, details.Permissions[n-1].Name)
+		}
+	}
 
+	request, err := api.RequestStaffUser(database, actor, name, dob, pin, username, role, scope, limits)
+	if err != nil {
+		fmt.Println("Error requesting user:", err)
+		return nil
+	}
+	fmt.Println()
+	printRequested(request)
+	fmt.Println("Give them their PIN once the request is approved and their card issued.")
+	fmt.Println()
+	return nil
 }
 
-func Menu(username string) {
-	fmt.Printf("Welcome, Admin %s! What would you like do to today?\n", username)
-	database, err := db.Connect()
+// Folder newly issued card files are written to
+const cardDir = "cards"
+
+// Issues a card to the user and writes its card file, returning the file's path
+func issueCard(database *sql.DB, actor models.Actor, username string) (string, error) {
+	card, err := api.IssueCard(database, actor, username)
 	if err != nil {
-		fmt.Println("Error connecting to database:", err)
+		return "", err
+	}
+	if err := os.MkdirAll(cardDir, 0o700); err != nil {
+		return "", fmt.Errorf("could not create card folder: %v", err)
+	}
+	path := filepath.Join(cardDir, card.CardID+".txt")
+	if err := utils.WriteCardFile(path, card); err != nil {
+		return "", fmt.Errorf("could not write card file: %v", err)
+	}
+	return path, nil
+}
+
+// Issue, list, re-date and hot-list ATM cards
+func manageCards(database *sql.DB, actor models.Actor) error {
+	choice, err := utils.TypeInput("Enter I to issue a card, L to list a user's cards, E to change a card's expiry, H to hot-list a card, or S to skip: ")
+	if err != nil {
+		return err
+	}
+	choice = strings.ToUpper(choice)
+	switch choice {
+	case "I":
+		username, err := utils.TypeInput("Enter the username to issue a card to: ")
+		if err != nil {
+			return err
+		}
+		path, err := issueCard(database, actor, username)
+		if err != nil {
+			fmt.Println("Error issuing card:", err)
+			return nil
+		}
+		fmt.Printf("Card issued to '%s'. Card file: %s\n", username, path)
+	case "L":
+		username, err := utils.TypeInput("Enter the username: ")
+		if err != nil {
+			return err
+		}
+		cards, err := api.ListCards(database, actor, username)
+		if err != nil {
+			fmt.Println("Error listing cards:", err)
+			return nil
+		}
+		if len(cards) == 0 {
+			fmt.Printf("No cards issued to '%s'.\n", username)
+			return nil
+		}
+		fmt.Printf("%-14s | %-19s | %-6s | %-19s | %s\n", "Card ID", "PAN", "Expiry", "Issued", "Status")
+		fmt.Println(strings.Repeat("-", 80))
+		for _, c := range cards {
+			status := "active"
+			if c.Revoked {
+				status = "hot-listed"
+			} else if c.Expired(time.Now()) {
+				status = "expired"
+			}
+			fmt.Printf("%-14s | %-19s | %-6s | %-19s | %s\n", c.CardID, c.MaskedPAN(), c.Expiry, c.IssuedAt, status)
+		}
+	case "E":
+		cardID, err := utils.TypeInput("Enter the card ID: ")
+		if err != nil {
+			return err
+		}
+		expiry, err := utils.TypeInput("Enter the new expiry (MM/YY): ")
+		if err != nil {
+			return err
+		}
+		if err := api.SetCardExpiry(database, actor, cardID, expiry); err != nil {
+			fmt.Println("Error updating expiry:", err)
+			return nil
+		}
+		card, err := api.GetCard(database, actor, cardID)
+		if err != nil {
+			fmt.Println("Error fetching card:", err)
+			return nil
+		}
+		path := filepath.Join(cardDir, card.CardID+".txt")
+		if err := utils.WriteCardFile(path, card); err != nil {
+			fmt.Println("Expiry updated, but the card file could not be rewritten:", err)
+			return nil
+		}
+		fmt.Printf("Card '%s' now expires %s. Updated card file: %s\n", cardID, expiry, path)
+	case "H":
+		cardID, err := utils.TypeInput("Enter the card ID to hot-list: ")
+		if err != nil {
+			return err
+		}
+		if err := api.RevokeCard(database, actor, cardID); err != nil {
+			fmt.Println("Error hot-listing card:", err)
+			return nil
+		}
+		fmt.Printf("Card '%s' has been hot-listed.\n", cardID)
+	case "S":
+		// skip
+	default:
+		fmt.Println("Invalid choice. Please enter I, L, E, H, or S.")
+	}
+	return nil
+}
+
+// Shows the daily limits and usage of a customer's account and lets the admin
+// override them
+func setCustomerLimits(database *sql.DB, actor models.Actor, cfg config.Limits) error {
+	customer, err := utils.TypeInput("Enter the username of the customer: ")
+	if err != nil {
+		return err
+	}
+	account, err := utils.TypeInput("Enter the account number (blank for their main checking account): ")
+	if err != nil {
+		return err
+	}
+	limits, err := api.GetVelocityLimits(database, actor, customer, account)
+	if err != nil {
+		fmt.Println("Error fetching limits:", err)
+		return nil
+	}
+	usage, err := api.GetVelocityUsage(database, actor, customer, account)
+	if err != nil {
+		fmt.Println("Error fetching usage:", err)
+		return nil
+	}
+
+	fmt.Printf("\nDaily Withdrawal Limit: $%s (withdrawn today: $%s)\n", limits.DailyWithdrawal, usage.WithdrawnToday)
+	fmt.Printf("Rolling 24h Withdrawal Limit: $%s (withdrawn last 24h: $%s)\n", limits.RollingWithdrawal, usage.WithdrawnRolling)
+	fmt.Printf("Daily Deposit Limit: $%s (deposited today: $%s)\n", limits.DailyDeposit, usage.DepositedToday)
+	fmt.Printf("Rolling 24h Deposit Limit: $%s (deposited last 24h: $%s)\n\n", limits.RollingDeposit, usage.DepositedRolling)
+
+	fields := map[string]string{
+		"1": api.LimitDailyWithdrawal,
+		"2": api.LimitRollingWithdrawal,
+		"3": api.LimitDailyDeposit,
+		"4": api.LimitRollingDeposit,
+	}
+	fmt.Println("Enter 1 for daily withdrawal, 2 for rolling withdrawal, 3 for daily deposit, 4 for rolling deposit")
+	choice, err := utils.TypeInput("Enter a limit to change, R to reset to defaults, or S to skip: ")
+	if err != nil {
+		return err
+	}
+	choice = strings.ToUpper(choice)
+	switch choice {
+	case "R":
+		request, err := api.ClearVelocityOverrides(database, actor, customer, account, cfg)
+		if err != nil {
+			fmt.Println("Error resetting limits:", err)
+			return nil
+		}
+		printRequested(request)
+	case "S":
+		// skip
+	default:
+		field, ok := fields[choice]
+		if !ok {
+			fmt.Println("Invalid choice. Please enter 1-4, R, or S.")
+			return nil
+		}
+		newLimitStr, err := utils.TypeInput("Enter new limit: ")
+		if err != nil {
+			return err
+		}
+		newLimit, err := models.ParseMoney(newLimitStr)
+		if err != nil {
+			fmt.Println("Invalid amount. Please try again:", err)
+			return nil
+		}
+		request, err := api.SetVelocityOverride(database, actor, customer, account, field, newLimit, cfg)
+		if err != nil {
+			fmt.Println("Error updating limit:", err)
+			return nil
+		}
+		printRequested(request)
+	}
+	return nil
+}
+
+// Number of audit entries shown by the audit log viewer
+const auditPageSize = 20
+
+// Shows the latest audit entries and checks the whole hash chain
+func viewAuditLog(database *sql.DB, actor models.Actor) {
+	entries, err := api.GetAuditLog(database, actor, auditPageSize)
+	if err != nil {
+		fmt.Println("Error fetching audit log:", err)
 		return
 	}
-	defer database.Close()
-	
-	viewChoices()
+
+	fmt.Printf("\n===== AUDIT LOG (last %d) =====\n", auditPageSize)
+	if len(entries) == 0 {
+		fmt.Println("No audited actions yet.")
+	}
+	for _, e := range entries {
+		fmt.Printf("#%d %s %s (%s) %s %s\n", e.ID, e.At, e.Actor, e.Role, e.Action, e.Target)
+		if e.Before != "" {
+			fmt.Println("    before:", e.Before)
+		}
+		if e.After != "" {
+			fmt.Println("    after: ", e.After)
+		}
+	}
+
+	checked, err := api.VerifyAuditLog(database, actor)
+	if err != nil {
+		fmt.Printf("AUDIT CHAIN BROKEN after %d good entries: %v\n", checked, err)
+		return
+	}
+	fmt.Printf("Audit chain verified: %d entries intact.\n", checked)
+}
+
+// Approve or reject cash handler counts that found a variance
+func reviewCashCounts(database *sql.DB, actor models.Actor) error {
+	counts, err := api.ListCashCounts(database, actor, models.CountPending)
+	if err != nil {
+		fmt.Println("Error fetching cash counts:", err)
+		return nil
+	}
+	if len(counts) == 0 {
+		fmt.Println("No cash counts are waiting for review.")
+		return nil
+	}
+
+	for _, c := range counts {
+		fmt.Printf("\nCount #%d of %s by %s, submitted %s\n", c.ID, c.Terminal, c.Handler, c.SubmittedAt)
+		fmt.Printf("%-6s | %8s | %8s | %8s\n", "Note", "Expected", "Counted", "Variance")
+		variance := c.Variance()
+		for i, d := range api.Denominations {
+			fmt.Printf("$%-5d | %8d | %8d | %+8d\n", d, c.Expected[i], c.Counted[i], variance[i])
+		}
+		fmt.Printf("Total variance: $%s\n", c.VarianceAmount)
+	}
+	fmt.Println()
+
+	choice, err := utils.TypeInput("Enter A to approve a write-off, R to reject a count, or S to skip: ")
+	if err != nil {
+		return err
+	}
+	choice = strings.ToUpper(choice)
+	if choice != "A" && choice != "R" {
+		if choice != "S" {
+			fmt.Println("Invalid choice. Please enter A, R, or S.")
+		}
+		return nil
+	}
+	countIDStr, err := utils.TypeInput("Enter the count number: ")
+	if err != nil {
+		return err
+	}
+	countID, err := strconv.Atoi(countIDStr)
+	if err != nil {
+		fmt.Println("Invalid count number.")
+		return nil
+	}
+
+	if choice == "A" {
+		if err := api.ApproveCashCount(database, actor, countID); err != nil {
+			fmt.Println("Error approving count:", err)
+			return nil
+		}
+		count, err := api.GetCashCount(database, actor, countID)
+		if err != nil {
+			fmt.Println("Error fetching count:", err)
+			return nil
+		}
+		fmt.Printf("Count #%d approved; %s now holds the counted notes.\n", countID, count.Terminal)
+		api.PrintNewATMBalance(database, count.Terminal)
+		return nil
+	}
+	if err := api.RejectCashCount(database, actor, countID); err != nil {
+		fmt.Println("Error rejecting count:", err)
+		return nil
+	}
+	fmt.Printf("Count #%d rejected; the cash handler will need to recount.\n", countID)
+	return nil
+}
+
+// Credits or debits an account by hand. Large adjustments wait for another
+// admin's approval.
+func adjustBalance(database *sql.DB, actor models.Actor, limits config.Limits) error {
+	number, err := utils.TypeInput("Enter the account number: ")
+	if err != nil {
+		return err
+	}
+	direction, err := utils.TypeInput("Enter C to credit the account, D to debit it, or S to skip: ")
+	if err != nil {
+		return err
+	}
+	direction = strings.ToUpper(direction)
+	if direction != "C" && direction != "D" {
+		if direction != "S" {
+			fmt.Println("Invalid choice. Please enter C, D, or S.")
+		}
+		return nil
+	}
+	amountStr, err := utils.TypeInput("Enter the amount: ")
+	if err != nil {
+		return err
+	}
+	amount, err := models.ParseMoney(amountStr)
+	if err != nil {
+		fmt.Println("Invalid amount. Please try again:", err)
+		return nil
+	}
+	if direction == "D" {
+		amount = -amount
+	}
+	memo, err := utils.TypeInput("Enter the reason for the adjustment: ")
+	if err != nil {
+		return err
+	}
+
+	request, err := api.AdjustBalance(database, actor, number, amount, memo, limits)
+	if err != nil {
+		fmt.Println("Error adjusting balance:", err)
+		return nil
+	}
+	if request.ID == 0 {
+		fmt.Printf("Account %s adjusted by $%s.\n", number, amount)
+		return nil
+	}
+	printRequested(request)
+	return nil
+}
+
+// Approve or reject other admins' pending requests
+func reviewRequests(database *sql.DB, actor models.Actor) error {
+	requests, err := api.ListApprovals(database, actor, models.ApprovalPending)
+	if err != nil {
+		fmt.Println("Error fetching requests:", err)
+		return nil
+	}
+	if len(requests) == 0 {
+		fmt.Println("No requests are waiting for approval.")
+		return nil
+	}
+
+	fmt.Println("\n===== PENDING REQUESTS =====")
+	for _, r := range requests {
+		fmt.Printf("#%d %s by %s at %s (expires %s)\n", r.ID, r.Kind, r.RequestedBy, r.RequestedAt, r.ExpiresAt)
+		fmt.Println("    ", r.Summary)
+	}
+	fmt.Println()
+
+	choice, err := utils.TypeInput("Enter A to approve a request, R to reject one, or S to skip: ")
+	if err != nil {
+		return err
+	}
+	choice = strings.ToUpper(choice)
+	if choice != "A" && choice != "R" {
+		if choice != "S" {
+			fmt.Println("Invalid choice. Please enter A, R, or S.")
+		}
+		return nil
+	}
+	idStr, err := utils.TypeInput("Enter the request number: ")
+	if err != nil {
+		return err
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		fmt.Println("Invalid request number.")
+		return nil
+	}
+
+	if choice == "R" {
+		reason, err := utils.TypeInput("Enter the reason for rejecting it: ")
+		if err != nil {
+			return err
+		}
+		if err := api.RejectRequest(database, actor, id, reason); err != nil {
+			fmt.Println("Error rejecting request:", err)
+			return nil
+		}
+		fmt.Printf("Request #%d rejected.\n", id)
+		return nil
+	}
+
+	var request models.Approval
+	for _, r := range requests {
+		if r.ID == id {
+			request = r
+		}
+	}
+	if err := api.ApproveRequest(database, actor, id); err != nil {
+		fmt.Println("Error approving request:", err)
+		return nil
+	}
+	fmt.Printf("Request #%d approved and applied.\n", id)
+
+	// New staff need a card to log in with
+	if request.Kind == api.AuditCreateUser {
+		cardPath, err := issueCard(database, actor, request.Target)
+		if err != nil {
+			fmt.Println("User created, but the card could not be issued:", err)
+			return nil
+		}
+		fmt.Println("Card file:", cardPath)
+	}
+	return nil
+}
+
+// Reprints a customer's receipt by transaction id and spools the copy
+func reprintReceipt(database *sql.DB, actor models.Actor, bankName string) error {
+	idStr, err := utils.TypeInput("Enter the transaction ID: ")
+	if err != nil {
+		return err
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		fmt.Println("Invalid transaction ID.")
+		return nil
+	}
+	receipt, err := api.ReprintReceipt(database, actor, id)
+	if err != nil {
+		fmt.Println("Error reprinting receipt:", err)
+		return nil
+	}
+	receipt.Bank = bankName
+	if err := api.WriteReceiptText(os.Stdout, receipt); err != nil {
+		fmt.Println("Error printing receipt:", err)
+		return nil
+	}
+	path, err := api.SpoolReceipt(receipt)
+	if err != nil {
+		fmt.Println("Error printing receipt:", err)
+		return nil
+	}
+	fmt.Println("Receipt saved to", path)
+	return nil
+}
+
+// Works through the cheque and envelope deposits waiting to be cleared,
+// accepting (crediting) or rejecting each one
+func clearCheques(database *sql.DB, actor models.Actor) error {
 	for {
-		choice := utils.TypeInput("Enter your choice (0-5): ")
+		queue, err := api.ListChequeDeposits(database, actor, models.ChequePending)
+		if err != nil {
+			fmt.Println("Error fetching deposits:", err)
+			return nil
+		}
+		if len(queue) == 0 {
+			fmt.Println("No cheque deposits are waiting to be cleared.")
+			return nil
+		}
+
+		fmt.Println("\n===== CHEQUE CLEARING QUEUE =====")
+		for _, d := range queue {
+			fmt.Printf("#%d $%s to %s (%s) at %s on %s\n", d.ID, d.Amount, d.Account, d.Owner, d.Terminal, d.DepositedAt)
+			fmt.Println("     image:", d.ImagePath)
+		}
+		fmt.Println()
+
+		choice, err := utils.TypeInput("Enter A to accept a deposit, R to reject one, or S to stop: ")
+		if err != nil {
+			return err
+		}
+		choice = strings.ToUpper(choice)
+		if choice != "A" && choice != "R" {
+			if choice != "S" {
+				fmt.Println("Invalid choice. Please enter A, R, or S.")
+			}
+			return nil
+		}
+		idStr, err := utils.TypeInput("Enter the deposit number: ")
+		if err != nil {
+			return err
+		}
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			fmt.Println("Invalid deposit number.")
+			return nil
+		}
+
+		if choice == "A" {
+			d, err := api.AcceptCheque(database, actor, id)
+			if err != nil {
+				fmt.Println("Error accepting deposit:", err)
+				continue
+			}
+			fmt.Printf("Deposit #%d accepted: $%s credited to %s (transaction %d).\n", d.ID, d.Amount, d.Account, d.TransactionID)
+			continue
+		}
+		reason, err := utils.TypeInput("Enter the reason for rejecting it (shown to the customer): ")
+		if err != nil {
+			return err
+		}
+		d, err := api.RejectCheque(database, actor, id, reason)
+		if err != nil {
+			fmt.Println("Error rejecting deposit:", err)
+			continue
+		}
+		fmt.Printf("Deposit #%d rejected; %s will be told at their next login.\n", d.ID, d.Owner)
+	}
+}
+
+// Asks which terminal to act on, defaulting to the one this program runs as
+func chooseTerminal(current string) (string, error) {
+	terminal, err := utils.TypeInput(fmt.Sprintf("Enter the terminal ID (blank for %s): ", current))
+	if err != nil {
+		return "", err
+	}
+	terminal = strings.ToUpper(terminal)
+	if terminal == "" {
+		return current, nil
+	}
+	return terminal, nil
+}
+
+// Lists the cash at every terminal with totals across all of them
+func showTerminals(database *sql.DB, actor models.Actor) {
+	terminals, err := api.ListTerminals(database, actor)
+	if err != nil {
+		fmt.Println("Error fetching terminals:", err)
+		return
+	}
+
+	fmt.Println("\n===== TERMINALS =====")
+	fmt.Printf("%-16s | %-15s | %14s | %12s | %12s | %s\n", "Terminal", "Branch", "Cash ($)", "Withdraw ($)", "Deposit ($)", "Cash Handlers")
+	fmt.Println(strings.Repeat("-", 110))
+	var total models.Money
+	notes := make([]int, len(api.Denominations))
+	for _, t := range terminals {
+		handlers := strings.Join(t.Handlers, ", ")
+		if handlers == "" {
+			handlers = "-"
+		}
+		id := t.ID
+		if t.OutOfService {
+			id += " (OOS)"
+		}
+		fmt.Printf("%-16s | %-15s | %14s | %12s | %12s | %s\n", id, t.Branch, t.Balance, t.WithdrawalLimit, t.DepositLimit, handlers)
+		total += t.Balance
+		for i, n := range t.Notes {
+			notes[i] += n
+		}
+	}
+	fmt.Println(strings.Repeat("-", 110))
+	fmt.Printf("%-16s | %-15s | %14s |\n", fmt.Sprintf("TOTAL (%d)", len(terminals)), "", total)
+	fmt.Println("Notes across all terminals:", api.FormatNotes(notes))
+	fmt.Println("(OOS) = out of service for withdrawals")
+}
+
+// Register terminals and assign cash handlers to them
+func manageTerminals(database *sql.DB, actor models.Actor) error {
+	showTerminals(database, actor)
+	choice, err := utils.TypeInput("Enter R to register a terminal, A to assign a cash handler, U to unassign a cash handler,\n" +
+		"C to set cassette capacities, O to set out of service, or S to skip: ")
+	if err != nil {
+		return err
+	}
+	choice = strings.ToUpper(choice)
+	switch choice {
+	case "R":
+		terminal, err := utils.TypeInput("Enter the new terminal ID (e.g. ATM-0002): ")
+		if err != nil {
+			return err
+		}
+		terminal = strings.ToUpper(terminal)
+		branch, err := utils.TypeInput("Enter the branch: ")
+		if err != nil {
+			return err
+		}
+		withdrawalLimitStr, err := utils.TypeInput("Enter the withdrawal limit: ")
+		if err != nil {
+			return err
+		}
+		withdrawalLimit, err := models.ParseMoney(withdrawalLimitStr)
+		if err != nil {
+			fmt.Println("Invalid amount:", err)
+			return nil
+		}
+		depositLimitStr, err := utils.TypeInput("Enter the deposit limit: ")
+		if err != nil {
+			return err
+		}
+		depositLimit, err := models.ParseMoney(depositLimitStr)
+		if err != nil {
+			fmt.Println("Invalid amount:", err)
+			return nil
+		}
+		if err := api.RegisterTerminal(database, actor, terminal, branch, withdrawalLimit, depositLimit); err != nil {
+			fmt.Println("Error registering terminal:", err)
+			return nil
+		}
+		fmt.Printf("Terminal %s registered at %s. Assign a cash handler to load it.\n", terminal, branch)
+	case "A", "U":
+		terminal, err := utils.TypeInput("Enter the terminal ID: ")
+		if err != nil {
+			return err
+		}
+		terminal = strings.ToUpper(terminal)
+		username, err := utils.TypeInput("Enter the cash handler's username: ")
+		if err != nil {
+			return err
+		}
+		if choice == "A" {
+			err := api.AssignTerminal(database, actor, terminal, username)
+			if err != nil {
+				fmt.Println("Error assigning cash handler:", err)
+				return nil
+			}
+			fmt.Printf("'%s' can now service %s.\n", username, terminal)
+			return nil
+		}
+		if err := api.UnassignTerminal(database, actor, terminal, username); err != nil {
+			fmt.Println("Error unassigning cash handler:", err)
+			return nil
+		}
+		fmt.Printf("'%s' can no longer service %s.\n", username, terminal)
+	case "C":
+		terminal, err := utils.TypeInput("Enter the terminal ID: ")
+		if err != nil {
+			return err
+		}
+		terminal = strings.ToUpper(terminal)
+		if err := api.ShowCassettes(database, actor, terminal); err != nil {
+			fmt.Println("Error fetching cassettes:", err)
+			return nil
+		}
+		denomination, err := utils.TypeInt("Enter the denomination of the cassette: ")
+		if err != nil {
+			return err
+		}
+		capacity, err := utils.TypeInt("Enter its capacity in notes: ")
+		if err != nil {
+			return err
+		}
+		lowWater, err := utils.TypeInt("Enter its low-water mark in notes: ")
+		if err != nil {
+			return err
+		}
+		if err := api.SetCassetteLimits(database, actor, terminal, denomination, capacity, lowWater); err != nil {
+			fmt.Println("Error updating cassette:", err)
+			return nil
+		}
+		api.ShowCassettes(database, actor, terminal)
+	case "O":
+		terminal, err := utils.TypeInput("Enter the terminal ID: ")
+		if err != nil {
+			return err
+		}
+		terminal = strings.ToUpper(terminal)
+		if err := api.ShowCassettes(database, actor, terminal); err != nil {
+			fmt.Println("Error fetching cassettes:", err)
+			return nil
+		}
+		denomination, err := utils.TypeInt("Enter a denomination to switch one cassette, or 0 for the whole ATM: ")
+		if err != nil {
+			return err
+		}
+		state, err := utils.TypeInput("Enter O to take it out of service or I to put it back in service: ")
+		if err != nil {
+			return err
+		}
+		state = strings.ToUpper(state)
+		if state != "O" && state != "I" {
+			fmt.Println("Invalid choice. Please enter O or I.")
+			return nil
+		}
+		reason := ""
+		if state == "O" && denomination == 0 {
+			reason, err = utils.TypeInput("Enter the reason: ")
+			if err != nil {
+				return err
+			}
+		}
+		if err := api.SetOutOfService(database, actor, terminal, denomination, state == "O", reason); err != nil {
+			fmt.Println("Error updating service status:", err)
+			return nil
+		}
+		api.ShowCassettes(database, actor, terminal)
+	case "S":
+		// skip
+	default:
+		fmt.Println("Invalid choice. Please enter R, A, U, C, O, or S.")
+	}
+	return nil
+}
+
+func Menu(store *db.Store, sess *session.Session) error {
+	username := sess.Username
+	fmt.Printf("Welcome, Admin %s! What would you like do to today?\n", username)
+	database := store.DB
+	actor := models.Actor{Username: username, Role: models.RoleAdmin}
+
+	viewChoices()
+	for sess.Active() {
+		choice, err := sess.Choose("Enter your choice (0-18): ", "0", "18")
+		if err != nil {
+			return err
+		}
 
 		switch choice {
 		case "0":
 			viewChoices()
 		case "1":
-			createNewUser()
+			if err := createNewUser(database, actor); err != nil {
+				return err
+			}
 		case "2":
-			err = api.ShowTransactions(database)
+			err := api.ShowTransactions(database, actor)
 			if err != nil {
 				fmt.Println("Error:", err)
 			}
 		case "3":
-			withdrawalLimit, depositLimit, err := api.GetATMLimits(database)
+			terminal, err := chooseTerminal(store.Config.Terminal.ID)
+			if err != nil {
+				return err
+			}
+			withdrawalLimit, depositLimit, err := api.GetTerminalLimits(database, actor, terminal)
 			if err != nil {
 				fmt.Println("Error fetching limits:", err)
-			} else {
-				fmt.Printf("\nCurrent Withdrawal Limit: $%.2f\n", withdrawalLimit)
-				fmt.Printf("Current Deposit Limit: $%.2f\n\n", depositLimit)
-			}			
-			limitChoice := strings.ToUpper(utils.TypeInput("Enter W to change withdrawal limit, D to change deposit limit, or S to skip: "))
+				break
+			}
+			fmt.Printf("\nCurrent Withdrawal Limit at %s: $%s\n", terminal, withdrawalLimit)
+			fmt.Printf("Current Deposit Limit at %s: $%s\n\n", terminal, depositLimit)
+			limitChoice, err := utils.TypeInput("Enter W to change withdrawal limit, D to change deposit limit, or S to skip: ")
+			if err != nil {
+				return err
+			}
+			limitChoice = strings.ToUpper(limitChoice)
 			switch limitChoice {
 			case "W":
-				limitStr := utils.TypeInput("Enter new withdrawal limit: ")
-				newLimit, err := strconv.ParseFloat(limitStr, 64)
+				limitStr, err := utils.TypeInput("Enter new withdrawal limit: ")
 				if err != nil {
-					fmt.Println("Invalid number. Please try again.")
+					return err
+				}
+				newLimit, err := models.ParseMoney(limitStr)
+				if err != nil {
+					fmt.Println("Invalid amount. Please try again:", err)
 					break
 				}
-				err = api.UpdateWithdrawalLimit(database, newLimit)
+				request, err := api.UpdateWithdrawalLimit(database, actor, terminal, newLimit, store.Config.Limits)
 				if err != nil {
 					fmt.Println("Error updating withdrawal limit:", err)
+					break
 				}
+				printRequested(request)
 			case "D":
-				limitStr := utils.TypeInput("Enter new deposit limit: ")
-				newLimit, err := strconv.ParseFloat(limitStr, 64)
+				limitStr, err := utils.TypeInput("Enter new deposit limit: ")
 				if err != nil {
-					fmt.Println("Invalid number. Please try again.")
+					return err
+				}
+				newLimit, err := models.ParseMoney(limitStr)
+				if err != nil {
+					fmt.Println("Invalid amount. Please try again:", err)
 					break
 				}
-				err = api.UpdateDepositLimit(database, newLimit)
+				request, err := api.UpdateDepositLimit(database, actor, terminal, newLimit, store.Config.Limits)
 				if err != nil {
 					fmt.Println("Error updating deposit limit:", err)
+					break
 				}
+				printRequested(request)
 			case "S":
 				// skip
 			default:
 				fmt.Println("Invalid choice. Please enter W, D, or S.")
 			}
 		case "4":
-			username := utils.TypeInput("Enter the username of the account to unlock: ")
-			err := api.UnlockAccount(database, username)
-			if err != nil {
-				fmt.Println("Error unlocking account:", err)
-			} else {
-				fmt.Printf("Account '%s' has been successfully unlocked.\n", username)
+			if err := unlockAccount(database, actor, store.Config.Limits); err != nil {
+				return err
 			}
 		case "5":
-			fmt.Println("Thank you for banking with JP Goldman Stanley!")
-			return
+			if err := setCustomerLimits(database, actor, store.Config.Limits); err != nil {
+				return err
+			}
+		case "6":
+			if err := manageCards(database, actor); err != nil {
+				return err
+			}
+		case "7":
+			viewAuditLog(database, actor)
+		case "8":
+			if err := reviewCashCounts(database, actor); err != nil {
+				return err
+			}
+		case "9":
+			if err := manageTerminals(database, actor); err != nil {
+				return err
+			}
+		case "10":
+			if err := resetPIN(database, actor); err != nil {
+				return err
+			}
+		case "11":
+			if err := openAccount(database, actor); err != nil {
+				return err
+			}
+		case "12":
+			if err := manageCustomers(database, actor); err != nil {
+				return err
+			}
+		case "13":
+			if err := createStaffUser(database, actor, store.Config.Limits); err != nil {
+				return err
+			}
+		case "14":
+			if err := adjustBalance(database, actor, store.Config.Limits); err != nil {
+				return err
+			}
+		case "15":
+			if err := reviewRequests(database, actor); err != nil {
+				return err
+			}
+		case "16":
+			if err := reprintReceipt(database, actor, store.Config.Branding.BankName); err != nil {
+				return err
+			}
+		case "17":
+			if err := clearCheques(database, actor); err != nil {
+				return err
+			}
+		case "18":
+			fmt.Printf("Thank you for banking with %s!\n", store.Config.Branding.BankName)
+			return nil
 		default:
 			fmt.Println("Invalid option, please try again.")
 		}
 	}
+	return nil
 }
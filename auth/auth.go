@@ -4,148 +4,147 @@ import (
 	"SPG_ATM_Machine/admin"
 	"SPG_ATM_Machine/customer"
 	"SPG_ATM_Machine/handler"
-	"SPG_ATM_Machine/internal/db"
 	"SPG_ATM_Machine/internal/api"
-	"bufio"
+	"SPG_ATM_Machine/internal/db"
+	"SPG_ATM_Machine/internal/models"
+	"SPG_ATM_Machine/session"
+	"SPG_ATM_Machine/utils"
+	"errors"
 	"fmt"
-	"os"
-	"strings"
 	"log"
-	"golang.org/x/crypto/bcrypt"
-	"golang.org/x/term"
+	"strings"
 )
 
-// Check idcard.txt for the role. Simulates putting in atm card. 
-func ParseIDCard(filePath string) (string, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	if scanner.Scan() {
-		return scanner.Text(), nil
-	}
-
-	if err := scanner.Err(); err != nil {
-		return "", err
-	}
-
-	return "", fmt.Errorf("file is empty")
-}
-
-// Prompts the user for their username.
-func PromptUsername() string {
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Print("Enter username: ")
-	username, _ := reader.ReadString('\n')
-	return strings.TrimSpace(username)
-}
-
 // Prompts the user for their PIN.
-func PromptPIN() string {
-	fmt.Print("Enter PIN: ")
-	bytePin, err := term.ReadPassword(int(os.Stdin.Fd()))
-	fmt.Println()
-	if err != nil {
-		log.Println("Error reading PIN:", err)
-		return ""
-	}
-	return strings.TrimSpace(string(bytePin))
+func PromptPIN() (string, error) {
+	return utils.TypeSecret("Enter PIN:")
 }
 
-func Login() (bool, string) {
-	username := PromptUsername()
-	if username == "" {
-		return false, ""
+// Reads the inserted card, looks up the account it is bound to and checks
+// the PIN for that account, then starts a session for the user. A terminal
+// that rejects too many cards stops taking logins for a while.
+func Login(store *db.Store, reader CardReader) (bool, *session.Session) {
+	terminal := store.Config.Terminal.ID
+	if err := api.CheckLoginThrottle(store.DB, terminal, store.Config.Limits); err != nil {
+		fmt.Println("Login unavailable:", err)
+		return false, nil
 	}
 
-	pin := PromptPIN()
-	if pin == "" {
-		return false, ""
+	card, err := reader.ReadCard()
+	if err != nil {
+		fmt.Println("Could not read card:", err)
+		return false, nil
 	}
 
-	conn, err := db.Connect()
+	username, err := api.VerifyCard(store.DB, card)
 	if err != nil {
-		fmt.Println("Error connecting to database:", err)
-		return false, ""
+		fmt.Println("Card rejected:", err)
+		if err := api.RecordRejectedCard(store.DB, terminal, err.Error(), store.Config.Limits); err != nil {
+			log.Println("DB error:", err)
+		}
+		return false, nil
 	}
-	defer conn.Close()
+	fmt.Printf("Card %s accepted.\n", card.MaskedPAN())
 
-	userInfo, err := api.GetUserAuth(conn, username)
-	if err != nil {
-		fmt.Println("Invalid login.")
-		return false, ""
+	pin, err := PromptPIN()
+	if err != nil || pin == "" {
+		return false, nil
 	}
 
-	if userInfo.Locked {
+	err = api.CheckPIN(store.DB, terminal, username, pin, store.Config.Limits)
+	switch {
+	case err == nil:
+	case errors.Is(err, api.ErrAccountLocked):
 		fmt.Println("Account is locked. Contact admin.")
-		return false, ""
+		return false, nil
+	case errors.Is(err, api.ErrTemporarilyLocked):
+		fmt.Printf("Too many failed attempts. Your %s. Try again later.\n", err)
+		return false, nil
+	case errors.Is(err, api.ErrTooManyAttempts):
+		fmt.Println("Too many failed attempts. Your account has been locked. Contact an Admin")
+		return false, nil
+	case errors.Is(err, api.ErrInvalidPIN):
+		fmt.Println("Invalid login." + strings.TrimPrefix(err.Error(), api.ErrInvalidPIN.Error()))
+		return false, nil
+	default:
+		log.Println("DB error:", err)
+		fmt.Println("An error occurred. Contact admin.")
+		return false, nil
 	}
 
-	// Compare hashed Pin
-	err = bcrypt.CompareHashAndPassword([]byte(userInfo.PINHash), []byte(pin))
+	mustChange, err := api.PINChangeRequired(store.DB, username)
 	if err != nil {
-		// Increment failed attempts via API
-		newAttempts, locked, apiErr := api.IncrementFailedAttempts(conn, username)
-		if apiErr != nil {
-			log.Println("DB error:", apiErr)
-			fmt.Println("An error occurred. Contact admin.")
-			return false, ""
-		}
-
-		if locked {
-			fmt.Println("Too many failed attempts. Your account has been locked. Contact an Admin")
-			return false, ""
-		}
-
-		fmt.Printf("Invalid login. (%d/3 attempts)\n", newAttempts)
-		return false, ""
-	}
-
-	// Reset failed attempts on successful login
-	if err := api.ResetFailedAttempts(conn, username); err != nil {
 		log.Println("DB error:", err)
 		fmt.Println("An error occurred. Contact admin.")
+		return false, nil
+	}
+	if mustChange && !changeTemporaryPIN(store, username, pin) {
+		return false, nil
 	}
 
-	return true, username
-}
-
-func RouteUser(username string) {
-
-	conn, err := db.Connect()
+	role, err := api.FetchUserRole(store.DB, username)
 	if err != nil {
-		log.Println("DB error:", err)
-		fmt.Println("An error occurred. Contact admin.", err)
-		return
+		log.Println("Error fetching role:", err)
+		fmt.Println("An error occurred. Contact admin.")
+		return false, nil
 	}
-	defer conn.Close()
-
+	return true, session.New(username, role, store.Config.Session)
+}
 
-	dbRole, err := api.FetchUserRole(conn, username)
+// A user who logged in with a temporary PIN has to choose a new one before
+// reaching their menu
+func changeTemporaryPIN(store *db.Store, username, tempPIN string) bool {
+	role, err := api.FetchUserRole(store.DB, username)
 	if err != nil {
 		log.Println("Error fetching role:", err)
 		fmt.Println("An error occurred. Contact admin.")
-		return
+		return false
 	}
-
-	cardRole, err := ParseIDCard("auth/idcard.txt")
-	if err != nil || cardRole != dbRole {
-		fmt.Println("Invalid login.")
-		return
+	actor := models.Actor{Username: username, Role: role}
+
+	fmt.Println("You logged in with a temporary PIN. Please choose a new PIN.")
+	fmt.Println("It must be 6 digits, without a digit three times in a row, three sequential digits or your date of birth.")
+	for tries := 0; tries < 3; tries++ {
+		newPIN, err := utils.TypeSecret("Enter new PIN:")
+		if err != nil {
+			return false
+		}
+		confirm, err := utils.TypeSecret("Confirm new PIN:")
+		if err != nil {
+			return false
+		}
+		if confirm != newPIN {
+			fmt.Println("The PINs do not match.")
+			continue
+		}
+		err = api.ChangePIN(store.DB, actor, store.Config.Terminal.ID, username, tempPIN, newPIN, store.Config.Limits)
+		if err != nil {
+			fmt.Println("PIN not changed:", err)
+			continue
+		}
+		fmt.Println("PIN changed.")
+		return true
 	}
+	fmt.Println("Your PIN was not changed. Log in again with your temporary PIN.")
+	return false
+}
 
-	fmt.Println("Login Successful")
-	switch dbRole {
-	case "admin":
-		admin.Menu(username)
-	case "customer":
-		customer.Menu(username)
-	case "cash handler":
-		handler.Menu(username)
-	default:
+// The menu for each role. What a user can do from it is decided by the
+// permissions internal/api checks, not by the menu.
+var menus = map[string]func(store *db.Store, sess *session.Session) error{
+	models.RoleAdmin:       admin.Menu,
+	models.RoleCustomer:    customer.Menu,
+	models.RoleCashHandler: handler.Menu,
+}
+
+// Opens the menu for the user's role, handing it the shared store, and runs
+// it until the user exits or the session ends
+func RouteUser(store *db.Store, sess *session.Session) {
+	menu, ok := menus[sess.Role]
+	if !ok {
 		fmt.Println("Error validating user type")
+		return
 	}
+	fmt.Println("Login Successful")
+	sess.Run(func() error { return menu(store, sess) })
 }
@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"SPG_ATM_Machine/utils"
+)
+
+// Default location of the simulated card slot
+const DefaultCardPath = "auth/idcard.txt"
+
+// Reads the card inserted into the ATM
+type CardReader interface {
+	ReadCard() (models.Card, error)
+}
+
+// Simulates the card slot with a card record file. Putting a different
+// card record in the file is the same as inserting a different card.
+type FileCardReader struct {
+	Path string
+}
+
+func NewFileCardReader(path string) *FileCardReader {
+	return &FileCardReader{Path: path}
+}
+
+func (r *FileCardReader) ReadCard() (models.Card, error) {
+	return utils.ParseCardFile(r.Path)
+}
@@ -0,0 +1,60 @@
+// Command accrue-interest pays daily interest on savings accounts up to a
+// date. Run it once a day, e.g. from cron; a second run on the same day pays
+// nothing more.
+//
+//	go run ./cmd/accrue-interest -as admin [-config file] [-date YYYY-MM-DD]
+//
+// The -as user needs the accounts.interest permission.
+package main
+
+import (
+	"SPG_ATM_Machine/internal/api"
+	"SPG_ATM_Machine/internal/config"
+	"SPG_ATM_Machine/internal/db"
+	"SPG_ATM_Machine/internal/models"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+func main() {
+	configPath := flag.String("config", "", "config file (default $ATM_CONFIG or "+config.DefaultPath+")")
+	as := flag.String("as", "", "username to accrue as (required)")
+	date := flag.String("date", "", "accrue up to this date (default: today)")
+	flag.Parse()
+	if *as == "" {
+		fmt.Fprintln(os.Stderr, "-as is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	asOf := time.Now()
+	if *date != "" {
+		var err error
+		asOf, err = time.ParseInLocation("2006-01-02", *date, time.Local)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid date %q, use YYYY-MM-DD\n", *date)
+			os.Exit(2)
+		}
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading config:", err)
+		os.Exit(1)
+	}
+	database, err := db.Connect(cfg.Database)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening database:", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	run, err := api.AccrueInterest(database, models.Actor{Username: *as}, asOf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Accrued interest to %s: $%s on %d accounts\n", asOf.Format("2006-01-02"), run.Total, run.Accounts)
+}
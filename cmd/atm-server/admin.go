@@ -0,0 +1,995 @@
+package main
+
+import (
+	"SPG_ATM_Machine/internal/api"
+	"SPG_ATM_Machine/internal/models"
+	"SPG_ATM_Machine/utils"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type createCustomerRequest struct {
+	Username        string       `json:"username"`
+	PIN             string       `json:"pin"`
+	FullName        string       `json:"full_name"`
+	DOB             string       `json:"dob"`
+	StartingBalance models.Money `json:"starting_balance"`
+}
+
+// A card record in full, as written to a card file when a card is issued
+type cardRecord struct {
+	CardID string `json:"card_id"`
+	PAN    string `json:"pan"`
+	Expiry string `json:"expiry"`
+	Issuer string `json:"issuer"`
+}
+
+type createCustomerResponse struct {
+	Username string     `json:"username"`
+	Card     cardRecord `json:"card"`
+}
+
+// Creates a customer and issues their first card, like the admin menu does
+func (s *server) handleCreateCustomer(w http.ResponseWriter, r *http.Request, sess *session) {
+	var req createCustomerRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	switch {
+	case req.Username == "":
+		writeError(w, http.StatusBadRequest, "username is required")
+		return
+	case !utils.ValidatePIN(req.PIN):
+		writeError(w, http.StatusBadRequest, "PIN must be exactly 6 digits")
+		return
+	case !utils.ValidateName(req.FullName):
+		writeError(w, http.StatusBadRequest, "name can only contain letters and spaces")
+		return
+	case !utils.ValidateDate(req.DOB):
+		writeError(w, http.StatusBadRequest, "date of birth must be in MM/DD/YY or MM/DD/YYYY format")
+		return
+	}
+
+	err := api.CreateUser(s.db, sess.actor(), req.FullName, req.DOB, req.PIN, req.StartingBalance, req.Username)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	card, err := api.IssueCard(s.db, sess.actor(), req.Username)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, createCustomerResponse{Username: req.Username, Card: newCardRecord(card)})
+}
+
+type createStaffRequest struct {
+	Username    string   `json:"username"`
+	PIN         string   `json:"pin"`
+	FullName    string   `json:"full_name"`
+	DOB         string   `json:"dob"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
+
+// Requests an admin or cash handler, scoped to the listed permissions or with
+// all of their role's when none are listed. Approving the request creates them
+// and issues their first card.
+func (s *server) handleCreateStaff(w http.ResponseWriter, r *http.Request, sess *session) {
+	var req createStaffRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	switch {
+	case req.Role != models.RoleAdmin && req.Role != models.RoleCashHandler:
+		writeError(w, http.StatusBadRequest, `role must be "admin" or "cash handler"`)
+		return
+	case req.Username == "":
+		writeError(w, http.StatusBadRequest, "username is required")
+		return
+	case !utils.ValidatePIN(req.PIN):
+		writeError(w, http.StatusBadRequest, "PIN must be exactly 6 digits")
+		return
+	case !utils.ValidateName(req.FullName):
+		writeError(w, http.StatusBadRequest, "name can only contain letters and spaces")
+		return
+	case !utils.ValidateDate(req.DOB):
+		writeError(w, http.StatusBadRequest, "date of birth must be in MM/DD/YY or MM/DD/YYYY format")
+		return
+	}
+
+	request, err := api.RequestStaffUser(s.db, sess.actor(), req.FullName, req.DOB, req.PIN, req.Username, req.Role, req.Permissions, s.cfg.Limits)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, newApprovalView(request))
+}
+
+type permission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func newPermissions(perms []models.Permission) []permission {
+	views := []permission{}
+	for _, p := range perms {
+		views = append(views, permission{Name: p.Name, Description: p.Description})
+	}
+	return views
+}
+
+type roleView struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Permissions []permission `json:"permissions"`
+}
+
+// Every role and the permissions it grants
+func (s *server) handleRoles(w http.ResponseWriter, r *http.Request, sess *session) {
+	roles, err := api.ListRoles(s.db, sess.actor())
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	views := []roleView{}
+	for _, role := range roles {
+		views = append(views, roleView{Name: role.Name, Description: role.Description, Permissions: newPermissions(role.Permissions)})
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func newCardRecord(c models.Card) cardRecord {
+	return cardRecord{CardID: c.CardID, PAN: c.PAN, Expiry: c.Expiry, Issuer: c.Issuer}
+}
+
+type transactionView struct {
+	ID            int           `json:"id"`
+	Username      string        `json:"username"`
+	Account       string        `json:"account,omitempty"`
+	Terminal      string        `json:"terminal,omitempty"`
+	Date          string        `json:"date"`
+	Kind          string        `json:"kind"`
+	Amount        models.Money  `json:"amount"`
+	BalanceAfter  *models.Money `json:"balance_after,omitempty"`
+	Counterparty  string        `json:"counterparty,omitempty"`
+	CorrelationID string        `json:"correlation_id,omitempty"`
+}
+
+// Transactions at every terminal, or only the one named by ?terminal=
+func (s *server) handleTransactions(w http.ResponseWriter, r *http.Request, sess *session) {
+	terminal := r.URL.Query().Get("terminal")
+	txns, err := api.ListTransactions(s.db, sess.actor())
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	views := []transactionView{}
+	for _, t := range txns {
+		if terminal != "" && t.Terminal != terminal {
+			continue
+		}
+		views = append(views, transactionView{
+			ID:            t.ID,
+			Username:      t.Username,
+			Account:       t.Account,
+			Terminal:      t.Terminal,
+			Date:          t.Date,
+			Kind:          t.Kind,
+			Amount:        t.Amount,
+			BalanceAfter:  t.BalanceAfter,
+			Counterparty:  t.Counterparty,
+			CorrelationID: t.CorrelationID,
+		})
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// Reprints a customer's receipt by transaction id, spooling the copy for the
+// printer like the admin menu does
+func (s *server) handleReprintReceipt(w http.ResponseWriter, r *http.Request, sess *session) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid transaction id")
+		return
+	}
+	receipt, err := api.ReprintReceipt(s.db, sess.actor(), id)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	receipt.Bank = s.cfg.Branding.BankName
+	if _, err := api.SpoolReceipt(receipt); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, receipt)
+}
+
+type atmLimitsView struct {
+	WithdrawalLimit *models.Money `json:"withdrawal_limit,omitempty"`
+	DepositLimit    *models.Money `json:"deposit_limit,omitempty"`
+}
+
+// The terminal named by ?terminal=, or the server's own
+func (s *server) terminalParam(r *http.Request) string {
+	if t := r.URL.Query().Get("terminal"); t != "" {
+		return t
+	}
+	return s.terminal()
+}
+
+func (s *server) handleATMLimits(w http.ResponseWriter, r *http.Request, sess *session) {
+	withdrawalLimit, depositLimit, err := api.GetTerminalLimits(s.db, sess.actor(), s.terminalParam(r))
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, atmLimitsView{WithdrawalLimit: &withdrawalLimit, DepositLimit: &depositLimit})
+}
+
+// Requests a change to whichever of a terminal's per-transaction limits are
+// given, one request per limit
+func (s *server) handleSetATMLimits(w http.ResponseWriter, r *http.Request, sess *session) {
+	var req atmLimitsView
+	if !readJSON(w, r, &req) {
+		return
+	}
+	views := []approvalView{}
+	if req.WithdrawalLimit != nil {
+		request, err := api.UpdateWithdrawalLimit(s.db, sess.actor(), s.terminalParam(r), *req.WithdrawalLimit, s.cfg.Limits)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		views = append(views, newApprovalView(request))
+	}
+	if req.DepositLimit != nil {
+		request, err := api.UpdateDepositLimit(s.db, sess.actor(), s.terminalParam(r), *req.DepositLimit, s.cfg.Limits)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		views = append(views, newApprovalView(request))
+	}
+	writeJSON(w, http.StatusAccepted, views)
+}
+
+func (s *server) handleUnlock(w http.ResponseWriter, r *http.Request, sess *session) {
+	request, err := api.UnlockAccount(s.db, sess.actor(), r.PathValue("username"), s.cfg.Limits)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, newApprovalView(request))
+}
+
+type customerLimitsResponse struct {
+	Limits           velocityView `json:"limits"`
+	WithdrawnToday   models.Money `json:"withdrawn_today"`
+	DepositedToday   models.Money `json:"deposited_today"`
+	WithdrawnRolling models.Money `json:"withdrawn_rolling"`
+	DepositedRolling models.Money `json:"deposited_rolling"`
+}
+
+// Daily limits and usage of the customer's account in ?account=, or of their
+// main checking account
+func (s *server) handleCustomerLimits(w http.ResponseWriter, r *http.Request, sess *session) {
+	username := r.PathValue("username")
+	account := r.URL.Query().Get("account")
+	limits, err := api.GetVelocityLimits(s.db, sess.actor(), username, account)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	usage, err := api.GetVelocityUsage(s.db, sess.actor(), username, account)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, customerLimitsResponse{
+		Limits:           newVelocityView(limits),
+		WithdrawnToday:   usage.WithdrawnToday,
+		DepositedToday:   usage.DepositedToday,
+		WithdrawnRolling: usage.WithdrawnRolling,
+		DepositedRolling: usage.DepositedRolling,
+	})
+}
+
+type setCustomerLimitRequest struct {
+	Account string       `json:"account"`
+	Field   string       `json:"field"`
+	Limit   models.Money `json:"limit"`
+}
+
+// Requests an override of one of the daily limits of a customer's account,
+// their main checking account if none is given. field is one of the
+// api.Limit* names.
+func (s *server) handleSetCustomerLimit(w http.ResponseWriter, r *http.Request, sess *session) {
+	var req setCustomerLimitRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	request, err := api.SetVelocityOverride(s.db, sess.actor(), r.PathValue("username"), req.Account, req.Field, req.Limit, s.cfg.Limits)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, newApprovalView(request))
+}
+
+// Requests the overrides on the customer's account in ?account= be removed
+func (s *server) handleResetCustomerLimits(w http.ResponseWriter, r *http.Request, sess *session) {
+	account := r.URL.Query().Get("account")
+	request, err := api.ClearVelocityOverrides(s.db, sess.actor(), r.PathValue("username"), account, s.cfg.Limits)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, newApprovalView(request))
+}
+
+type cardView struct {
+	CardID   string `json:"card_id"`
+	PAN      string `json:"pan"`
+	Expiry   string `json:"expiry"`
+	IssuedAt string `json:"issued_at"`
+	Status   string `json:"status"`
+}
+
+// Lists a user's cards with their PANs masked
+func (s *server) handleListCards(w http.ResponseWriter, r *http.Request, sess *session) {
+	cards, err := api.ListCards(s.db, sess.actor(), r.PathValue("username"))
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	views := []cardView{}
+	for _, c := range cards {
+		status := "active"
+		if c.Revoked {
+			status = "hot-listed"
+		} else if c.Expired(time.Now()) {
+			status = "expired"
+		}
+		views = append(views, cardView{CardID: c.CardID, PAN: c.MaskedPAN(), Expiry: c.Expiry, IssuedAt: c.IssuedAt, Status: status})
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (s *server) handleIssueCard(w http.ResponseWriter, r *http.Request, sess *session) {
+	card, err := api.IssueCard(s.db, sess.actor(), r.PathValue("username"))
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newCardRecord(card))
+}
+
+type cardExpiryRequest struct {
+	Expiry string `json:"expiry"`
+}
+
+func (s *server) handleCardExpiry(w http.ResponseWriter, r *http.Request, sess *session) {
+	var req cardExpiryRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	cardID := r.PathValue("card_id")
+	if err := api.SetCardExpiry(s.db, sess.actor(), cardID, req.Expiry); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	card, err := api.GetCard(s.db, sess.actor(), cardID)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newCardRecord(card))
+}
+
+func (s *server) handleRevokeCard(w http.ResponseWriter, r *http.Request, sess *session) {
+	if err := api.RevokeCard(s.db, sess.actor(), r.PathValue("card_id")); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type auditView struct {
+	ID       int             `json:"id"`
+	At       string          `json:"at"`
+	Actor    string          `json:"actor"`
+	Role     string          `json:"role"`
+	Action   string          `json:"action"`
+	Target   string          `json:"target"`
+	Before   json.RawMessage `json:"before,omitempty"`
+	After    json.RawMessage `json:"after,omitempty"`
+	PrevHash string          `json:"prev_hash"`
+	Hash     string          `json:"hash"`
+}
+
+// The latest audit entries, ?limit=N of them (default 100)
+func (s *server) handleAuditLog(w http.ResponseWriter, r *http.Request, sess *session) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		limit = n
+	}
+
+	entries, err := api.GetAuditLog(s.db, sess.actor(), limit)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	views := []auditView{}
+	for _, e := range entries {
+		views = append(views, auditView{
+			ID:       e.ID,
+			At:       e.At,
+			Actor:    e.Actor,
+			Role:     e.Role,
+			Action:   e.Action,
+			Target:   e.Target,
+			Before:   json.RawMessage(e.Before),
+			After:    json.RawMessage(e.After),
+			PrevHash: e.PrevHash,
+			Hash:     e.Hash,
+		})
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+type auditVerifyResponse struct {
+	Intact  bool   `json:"intact"`
+	Checked int    `json:"checked"`
+	Problem string `json:"problem,omitempty"`
+}
+
+func (s *server) handleVerifyAudit(w http.ResponseWriter, r *http.Request, sess *session) {
+	checked, err := api.VerifyAuditLog(s.db, sess.actor())
+	resp := auditVerifyResponse{Intact: err == nil, Checked: checked}
+	if err != nil {
+		resp.Problem = err.Error()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) handleCashCounts(w http.ResponseWriter, r *http.Request, sess *session) {
+	counts, err := api.ListCashCounts(s.db, sess.actor(), r.URL.Query().Get("status"))
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	views := []countView{}
+	for _, c := range counts {
+		views = append(views, newCountView(c))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (s *server) handleApproveCount(w http.ResponseWriter, r *http.Request, sess *session) {
+	id, ok := countID(w, r)
+	if !ok {
+		return
+	}
+	if err := api.ApproveCashCount(s.db, sess.actor(), id); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	s.writeCount(w, sess.actor(), id)
+}
+
+func (s *server) handleRejectCount(w http.ResponseWriter, r *http.Request, sess *session) {
+	id, ok := countID(w, r)
+	if !ok {
+		return
+	}
+	if err := api.RejectCashCount(s.db, sess.actor(), id); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	s.writeCount(w, sess.actor(), id)
+}
+
+func (s *server) writeCount(w http.ResponseWriter, actor models.Actor, id int) {
+	c, err := api.GetCashCount(s.db, actor, id)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newCountView(c))
+}
+
+type cashEventView struct {
+	ID         int          `json:"id"`
+	At         string       `json:"at"`
+	Terminal   string       `json:"terminal"`
+	Handler    string       `json:"handler"`
+	Kind       string       `json:"kind"`
+	Notes      notes        `json:"notes"`
+	Amount     models.Money `json:"amount"`
+	CountID    int          `json:"count_id,omitempty"`
+	ApprovedBy string       `json:"approved_by,omitempty"`
+}
+
+func (s *server) handleCashEvents(w http.ResponseWriter, r *http.Request, sess *session) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		limit = n
+	}
+
+	events, err := api.GetCashEvents(s.db, sess.actor(), limit)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	views := []cashEventView{}
+	for _, e := range events {
+		views = append(views, cashEventView{
+			ID:         e.ID,
+			At:         e.At,
+			Terminal:   e.Terminal,
+			Handler:    e.Handler,
+			Kind:       e.Kind,
+			Notes:      notesFromCounts(e.Notes),
+			Amount:     e.Amount,
+			CountID:    e.CountID,
+			ApprovedBy: e.ApprovedBy,
+		})
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+type terminalView struct {
+	ID              string       `json:"id"`
+	Branch          string       `json:"branch"`
+	Balance         models.Money `json:"balance"`
+	Notes           notes        `json:"notes"`
+	WithdrawalLimit models.Money `json:"withdrawal_limit"`
+	DepositLimit    models.Money `json:"deposit_limit"`
+	OutOfService    bool         `json:"out_of_service"`
+	Handlers        []string     `json:"handlers"`
+}
+
+type terminalsResponse struct {
+	Terminals []terminalView `json:"terminals"`
+	Balance   models.Money   `json:"total_balance"`
+	Notes     notes          `json:"total_notes"`
+}
+
+// Every terminal's cash and limits, with the totals across all of them
+func (s *server) handleTerminals(w http.ResponseWriter, r *http.Request, sess *session) {
+	terminals, err := api.ListTerminals(s.db, sess.actor())
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	resp := terminalsResponse{Terminals: []terminalView{}}
+	total := make([]int, len(api.Denominations))
+	for _, t := range terminals {
+		handlers := t.Handlers
+		if handlers == nil {
+			handlers = []string{}
+		}
+		resp.Terminals = append(resp.Terminals, terminalView{
+			ID:              t.ID,
+			Branch:          t.Branch,
+			Balance:         t.Balance,
+			Notes:           notesFromCounts(t.Notes),
+			WithdrawalLimit: t.WithdrawalLimit,
+			DepositLimit:    t.DepositLimit,
+			OutOfService:    t.OutOfService,
+			Handlers:        handlers,
+		})
+		resp.Balance += t.Balance
+		for i, n := range t.Notes {
+			total[i] += n
+		}
+	}
+	resp.Notes = notesFromCounts(total)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type registerTerminalRequest struct {
+	ID              string       `json:"id"`
+	Branch          string       `json:"branch"`
+	WithdrawalLimit models.Money `json:"withdrawal_limit"`
+	DepositLimit    models.Money `json:"deposit_limit"`
+}
+
+func (s *server) handleRegisterTerminal(w http.ResponseWriter, r *http.Request, sess *session) {
+	var req registerTerminalRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	err := api.RegisterTerminal(s.db, sess.actor(), req.ID, req.Branch, req.WithdrawalLimit, req.DepositLimit)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *server) handleAssignTerminal(w http.ResponseWriter, r *http.Request, sess *session) {
+	if err := api.AssignTerminal(s.db, sess.actor(), r.PathValue("terminal"), r.PathValue("username")); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) handleUnassignTerminal(w http.ResponseWriter, r *http.Request, sess *session) {
+	if err := api.UnassignTerminal(s.db, sess.actor(), r.PathValue("terminal"), r.PathValue("username")); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) handleCassettes(w http.ResponseWriter, r *http.Request, sess *session) {
+	s.writeCassettes(w, sess.actor(), r.PathValue("terminal"))
+}
+
+type cassetteRequest struct {
+	Capacity int `json:"capacity"`
+	LowWater int `json:"low_water"`
+}
+
+func (s *server) handleSetCassette(w http.ResponseWriter, r *http.Request, sess *session) {
+	denomination, err := strconv.Atoi(r.PathValue("denomination"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid denomination")
+		return
+	}
+	var req cassetteRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	terminal := r.PathValue("terminal")
+	err = api.SetCassetteLimits(s.db, sess.actor(), terminal, denomination, req.Capacity, req.LowWater)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	s.writeCassettes(w, sess.actor(), terminal)
+}
+
+func (s *server) handleTerminalService(w http.ResponseWriter, r *http.Request, sess *session) {
+	s.setService(w, r, sess, r.PathValue("terminal"))
+}
+
+type resetPINResponse struct {
+	Username     string `json:"username"`
+	TemporaryPIN string `json:"temporary_pin"`
+}
+
+func (s *server) handleResetPIN(w http.ResponseWriter, r *http.Request, sess *session) {
+	username := r.PathValue("username")
+	pin, err := api.ResetPIN(s.db, sess.actor(), username)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resetPINResponse{Username: username, TemporaryPIN: pin})
+}
+
+type openAccountRequest struct {
+	Type         string `json:"type"`
+	InterestRate int    `json:"interest_rate_bp"`
+}
+
+func (s *server) handleCustomerAccounts(w http.ResponseWriter, r *http.Request, sess *session) {
+	s.writeAccounts(w, sess.actor(), r.PathValue("username"))
+}
+
+func (s *server) handleOpenAccount(w http.ResponseWriter, r *http.Request, sess *session) {
+	var req openAccountRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	account, err := api.OpenAccount(s.db, sess.actor(), r.PathValue("username"), req.Type, req.InterestRate)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newAccountView(account))
+}
+
+type customerView struct {
+	Username string `json:"username"`
+	FullName string `json:"full_name"`
+	DOB      string `json:"dob"`
+}
+
+// Customers whose name or username contains ?q=, or all of them
+func (s *server) handleSearchCustomers(w http.ResponseWriter, r *http.Request, sess *session) {
+	found, err := api.SearchCustomers(s.db, sess.actor(), r.URL.Query().Get("q"))
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	views := []customerView{}
+	for _, u := range found {
+		views = append(views, customerView{Username: u.Username, FullName: u.FullName, DOB: u.DOB})
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+type customerProfileResponse struct {
+	customerView
+	Locked         bool          `json:"locked"`
+	LockedUntil    string        `json:"locked_until,omitempty"`
+	FailedAttempts int           `json:"failed_attempts"`
+	Accounts       []accountView `json:"accounts"`
+	Total          models.Money  `json:"total_balance"`
+}
+
+func (s *server) writeCustomer(w http.ResponseWriter, actor models.Actor, username string) {
+	p, err := api.GetCustomerProfile(s.db, actor, username)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	resp := customerProfileResponse{
+		customerView:   customerView{Username: p.User.Username, FullName: p.User.FullName, DOB: p.User.DOB},
+		Locked:         p.User.Locked == 1,
+		LockedUntil:    p.LockedUntil,
+		FailedAttempts: p.User.FailedAttempts,
+		Accounts:       []accountView{},
+		Total:          p.Total,
+	}
+	for _, a := range p.Accounts {
+		resp.Accounts = append(resp.Accounts, newAccountView(a))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) handleCustomer(w http.ResponseWriter, r *http.Request, sess *session) {
+	s.writeCustomer(w, sess.actor(), r.PathValue("username"))
+}
+
+type updateCustomerRequest struct {
+	FullName string `json:"full_name"`
+	DOB      string `json:"dob"`
+}
+
+func (s *server) handleUpdateCustomer(w http.ResponseWriter, r *http.Request, sess *session) {
+	var req updateCustomerRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	username := r.PathValue("username")
+	if err := api.UpdateCustomer(s.db, sess.actor(), username, req.FullName, req.DOB); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	s.writeCustomer(w, sess.actor(), username)
+}
+
+type accountStatusRequest struct {
+	Reason string `json:"reason"`
+}
+
+// Wraps FreezeAccount, UnfreezeAccount or CloseAccount as a handler
+func (s *server) handleAccountStatus(change func(db *sql.DB, actor models.Actor, number, reason string) error) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, sess *session) {
+		var req accountStatusRequest
+		if !readJSON(w, r, &req) {
+			return
+		}
+		if err := change(s.db, sess.actor(), r.PathValue("number"), req.Reason); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type lockEventView struct {
+	At          string `json:"at"`
+	Event       string `json:"event"`
+	LockedUntil string `json:"locked_until,omitempty"`
+	Actor       string `json:"actor"`
+	Terminal    string `json:"terminal,omitempty"`
+}
+
+type lockStatusResponse struct {
+	Username       string          `json:"username"`
+	Locked         bool            `json:"locked"`
+	LockedUntil    string          `json:"locked_until,omitempty"`
+	FailedAttempts int             `json:"failed_attempts"`
+	Lockouts       int             `json:"lockouts"`
+	History        []lockEventView `json:"history"`
+}
+
+// A user's lock state and their latest lock and unlock events
+func (s *server) handleLockHistory(w http.ResponseWriter, r *http.Request, sess *session) {
+	username := r.PathValue("username")
+	info, err := api.GetUserAuth(s.db, sess.actor(), username)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	events, err := api.GetLockHistory(s.db, sess.actor(), username, 50)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	resp := lockStatusResponse{
+		Username:       username,
+		Locked:         info.Locked,
+		LockedUntil:    info.LockedUntil,
+		FailedAttempts: info.FailedAttempts,
+		Lockouts:       info.Lockouts,
+		History:        []lockEventView{},
+	}
+	for _, e := range events {
+		resp.History = append(resp.History, lockEventView{
+			At:          e.At,
+			Event:       e.Event,
+			LockedUntil: e.LockedUntil,
+			Actor:       e.Actor,
+			Terminal:    e.Terminal,
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type approvalView struct {
+	ID          int    `json:"id"`
+	Kind        string `json:"kind"`
+	Target      string `json:"target"`
+	Summary     string `json:"summary"`
+	RequestedBy string `json:"requested_by"`
+	RequestedAt string `json:"requested_at"`
+	ExpiresAt   string `json:"expires_at"`
+	Status      string `json:"status"`
+	DecidedBy   string `json:"decided_by,omitempty"`
+	DecidedAt   string `json:"decided_at,omitempty"`
+	Note        string `json:"note,omitempty"`
+}
+
+func newApprovalView(a models.Approval) approvalView {
+	return approvalView{
+		ID:          a.ID,
+		Kind:        a.Kind,
+		Target:      a.Target,
+		Summary:     a.Summary,
+		RequestedBy: a.RequestedBy,
+		RequestedAt: a.RequestedAt,
+		ExpiresAt:   a.ExpiresAt,
+		Status:      a.Status,
+		DecidedBy:   a.DecidedBy,
+		DecidedAt:   a.DecidedAt,
+		Note:        a.Note,
+	}
+}
+
+func (s *server) handleApprovals(w http.ResponseWriter, r *http.Request, sess *session) {
+	list, err := api.ListApprovals(s.db, sess.actor(), r.URL.Query().Get("status"))
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	views := []approvalView{}
+	for _, a := range list {
+		views = append(views, newApprovalView(a))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func approvalID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request id")
+		return 0, false
+	}
+	return id, true
+}
+
+type approveResponse struct {
+	approvalView
+	Card *cardRecord `json:"card,omitempty"`
+}
+
+// Approves and applies another admin's request. Approving a new admin or cash
+// handler also issues their first card, like the admin menu does.
+func (s *server) handleApprove(w http.ResponseWriter, r *http.Request, sess *session) {
+	id, ok := approvalID(w, r)
+	if !ok {
+		return
+	}
+	if err := api.ApproveRequest(s.db, sess.actor(), id); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	request, err := api.GetApproval(s.db, sess.actor(), id)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	resp := approveResponse{approvalView: newApprovalView(request)}
+	if request.Kind == api.AuditCreateUser {
+		card, err := api.IssueCard(s.db, sess.actor(), request.Target)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		record := newCardRecord(card)
+		resp.Card = &record
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type rejectRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (s *server) handleReject(w http.ResponseWriter, r *http.Request, sess *session) {
+	id, ok := approvalID(w, r)
+	if !ok {
+		return
+	}
+	var req rejectRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if err := api.RejectRequest(s.db, sess.actor(), id, req.Reason); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	request, err := api.GetApproval(s.db, sess.actor(), id)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newApprovalView(request))
+}
+
+type adjustRequest struct {
+	Direction string       `json:"direction"`
+	Amount    models.Money `json:"amount"`
+	Memo      string       `json:"memo"`
+}
+
+// Credits or debits an account by hand. Small adjustments are posted at once
+// (201); large ones are queued for another admin's approval (202).
+func (s *server) handleAdjust(w http.ResponseWriter, r *http.Request, sess *session) {
+	var req adjustRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	amount := req.Amount
+	switch req.Direction {
+	case "credit":
+	case "debit":
+		amount = -amount
+	default:
+		writeError(w, http.StatusBadRequest, `direction must be "credit" or "debit"`)
+		return
+	}
+	request, err := api.AdjustBalance(s.db, sess.actor(), r.PathValue("number"), amount, req.Memo, s.cfg.Limits)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if request.ID != 0 {
+		writeJSON(w, http.StatusAccepted, newApprovalView(request))
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
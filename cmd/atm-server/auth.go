@@ -0,0 +1,176 @@
+package main
+
+import (
+	"SPG_ATM_Machine/internal/api"
+	"SPG_ATM_Machine/internal/models"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+type loginRequest struct {
+	CardID string `json:"card_id"`
+	PAN    string `json:"pan"`
+	Expiry string `json:"expiry"`
+	Issuer string `json:"issuer"`
+	PIN    string `json:"pin"`
+	NewPIN string `json:"new_pin"` // required after an admin reset the PIN
+}
+
+type loginResponse struct {
+	Token     string    `json:"token"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Checks the card and PIN the same way auth.Login does and starts a session
+func (s *server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req loginRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if err := api.CheckLoginThrottle(s.db, s.terminal(), s.cfg.Limits); err != nil {
+		if errors.Is(err, api.ErrLoginThrottled) {
+			writeError(w, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		log.Println("DB error:", err)
+		writeError(w, http.StatusInternalServerError, "an error occurred")
+		return
+	}
+
+	card := models.Card{CardID: req.CardID, PAN: req.PAN, Expiry: req.Expiry, Issuer: req.Issuer}
+	username, err := api.VerifyCard(s.db, card)
+	if err != nil {
+		if err := api.RecordRejectedCard(s.db, s.terminal(), err.Error(), s.cfg.Limits); err != nil {
+			log.Println("DB error:", err)
+		}
+		writeError(w, http.StatusUnauthorized, "card rejected: "+err.Error())
+		return
+	}
+
+	err = api.CheckPIN(s.db, s.terminal(), username, req.PIN, s.cfg.Limits)
+	switch {
+	case err == nil:
+	case errors.Is(err, api.ErrAccountLocked), errors.Is(err, api.ErrTooManyAttempts):
+		writeError(w, http.StatusLocked, err.Error()+". Contact an admin")
+		return
+	case errors.Is(err, api.ErrTemporarilyLocked):
+		writeError(w, http.StatusLocked, err.Error())
+		return
+	case errors.Is(err, api.ErrInvalidPIN):
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	default:
+		log.Println("DB error:", err)
+		writeError(w, http.StatusInternalServerError, "an error occurred")
+		return
+	}
+
+	role, err := api.FetchUserRole(s.db, username)
+	if err != nil {
+		log.Println("Error fetching role:", err)
+		writeError(w, http.StatusInternalServerError, "an error occurred")
+		return
+	}
+	switch role {
+	case models.RoleAdmin, models.RoleCustomer, models.RoleCashHandler:
+	default:
+		writeError(w, http.StatusForbidden, "error validating user type")
+		return
+	}
+
+	// A temporary PIN only works to choose a new one
+	mustChange, err := api.PINChangeRequired(s.db, username)
+	if err != nil {
+		log.Println("DB error:", err)
+		writeError(w, http.StatusInternalServerError, "an error occurred")
+		return
+	}
+	if mustChange {
+		if req.NewPIN == "" {
+			writeError(w, http.StatusForbidden, "PIN change required: log in again with new_pin")
+			return
+		}
+		actor := models.Actor{Username: username, Role: role}
+		err := api.ChangePIN(s.db, actor, s.terminal(), username, req.PIN, req.NewPIN, s.cfg.Limits)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+	}
+
+	if role == models.RoleCashHandler {
+		assigned, err := api.CanServiceTerminal(s.db, username, s.terminal())
+		if err != nil {
+			log.Println(err)
+			writeError(w, http.StatusInternalServerError, "an error occurred")
+			return
+		}
+		if !assigned {
+			writeError(w, http.StatusForbidden, "not assigned to terminal "+s.terminal())
+			return
+		}
+	}
+
+	sess, err := s.startSession(username, role)
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusInternalServerError, "an error occurred")
+		return
+	}
+	writeJSON(w, http.StatusOK, loginResponse{Token: sess.Token, Username: username, Role: role, ExpiresAt: sess.ExpiresAt})
+}
+
+// The permissions the logged in user holds
+func (s *server) handlePermissions(w http.ResponseWriter, r *http.Request, sess *session) {
+	perms, err := api.UserPermissions(s.db, sess.Username)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newPermissions(perms))
+}
+
+func (s *server) handleLogout(w http.ResponseWriter, r *http.Request, sess *session) {
+	s.endSession(sess.Token)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Reports an operation internal/api refused or failed, such as a limit, a
+// shortfall of funds or notes, or an unknown user. Missing permissions are 403s.
+func writeAPIError(w http.ResponseWriter, err error) {
+	log.Println("API error:", err)
+	if errors.Is(err, api.ErrPermissionDenied) {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	writeError(w, http.StatusUnprocessableEntity, err.Error())
+}
+
+type changePINRequest struct {
+	OldPIN string `json:"old_pin"`
+	NewPIN string `json:"new_pin"`
+}
+
+func (s *server) handleChangePIN(w http.ResponseWriter, r *http.Request, sess *session) {
+	var req changePINRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	err := api.ChangePIN(s.db, sess.actor(), s.terminal(), sess.Username, req.OldPIN, req.NewPIN, s.cfg.Limits)
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, api.ErrAccountLocked), errors.Is(err, api.ErrTooManyAttempts), errors.Is(err, api.ErrTemporarilyLocked):
+		s.endSession(sess.Token)
+		writeError(w, http.StatusLocked, err.Error()+". Contact an admin")
+	case errors.Is(err, api.ErrInvalidPIN):
+		writeError(w, http.StatusUnauthorized, err.Error())
+	default:
+		writeAPIError(w, err)
+	}
+}
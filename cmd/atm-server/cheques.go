@@ -0,0 +1,127 @@
+package main
+
+import (
+	"SPG_ATM_Machine/internal/api"
+	"SPG_ATM_Machine/internal/models"
+	"net/http"
+	"strconv"
+)
+
+type chequeView struct {
+	ID            int          `json:"id"`
+	Account       string       `json:"account"`
+	Owner         string       `json:"owner"`
+	Terminal      string       `json:"terminal,omitempty"`
+	Amount        models.Money `json:"amount"`
+	ImagePath     string       `json:"image_path"`
+	DepositedAt   string       `json:"deposited_at"`
+	Status        string       `json:"status"`
+	ReviewedBy    string       `json:"reviewed_by,omitempty"`
+	ReviewedAt    string       `json:"reviewed_at,omitempty"`
+	Reason        string       `json:"reason,omitempty"`
+	TransactionID int          `json:"transaction_id,omitempty"`
+}
+
+func newChequeView(d models.ChequeDeposit) chequeView {
+	return chequeView{
+		ID:            d.ID,
+		Account:       d.Account,
+		Owner:         d.Owner,
+		Terminal:      d.Terminal,
+		Amount:        d.Amount,
+		ImagePath:     d.ImagePath,
+		DepositedAt:   d.DepositedAt,
+		Status:        d.Status,
+		ReviewedBy:    d.ReviewedBy,
+		ReviewedAt:    d.ReviewedAt,
+		Reason:        d.Reason,
+		TransactionID: d.TransactionID,
+	}
+}
+
+func writeCheques(w http.ResponseWriter, list []models.ChequeDeposit) {
+	views := []chequeView{}
+	for _, d := range list {
+		views = append(views, newChequeView(d))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+type chequeDepositRequest struct {
+	Account   string       `json:"account"`
+	Amount    models.Money `json:"amount"`
+	ImagePath string       `json:"image_path"`
+}
+
+// Takes a cheque or envelope deposit. It answers 202 since the amount is
+// only held until an admin clears it.
+func (s *server) handleDepositCheque(w http.ResponseWriter, r *http.Request, sess *session) {
+	var req chequeDepositRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	d, err := api.DepositCheque(s.db, sess.actor(), s.terminal(), req.Account, req.Amount, req.ImagePath)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, newChequeView(d))
+}
+
+// The customer's cheque deposits, with the reason for any that were rejected
+func (s *server) handleCustomerCheques(w http.ResponseWriter, r *http.Request, sess *session) {
+	list, err := api.GetChequeDeposits(s.db, sess.actor(), sess.Username)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeCheques(w, list)
+}
+
+func (s *server) handleCheques(w http.ResponseWriter, r *http.Request, sess *session) {
+	list, err := api.ListChequeDeposits(s.db, sess.actor(), r.URL.Query().Get("status"))
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeCheques(w, list)
+}
+
+func chequeID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid deposit id")
+		return 0, false
+	}
+	return id, true
+}
+
+func (s *server) handleAcceptCheque(w http.ResponseWriter, r *http.Request, sess *session) {
+	id, ok := chequeID(w, r)
+	if !ok {
+		return
+	}
+	d, err := api.AcceptCheque(s.db, sess.actor(), id)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newChequeView(d))
+}
+
+func (s *server) handleRejectCheque(w http.ResponseWriter, r *http.Request, sess *session) {
+	id, ok := chequeID(w, r)
+	if !ok {
+		return
+	}
+	var req rejectRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	d, err := api.RejectCheque(s.db, sess.actor(), id, req.Reason)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newChequeView(d))
+}
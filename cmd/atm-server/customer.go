@@ -0,0 +1,278 @@
+package main
+
+import (
+	"SPG_ATM_Machine/internal/api"
+	"SPG_ATM_Machine/internal/models"
+	"log"
+	"net/http"
+)
+
+type balanceResponse struct {
+	Account       string          `json:"account"`
+	Balance       models.Money    `json:"balance"`
+	Held          models.Money    `json:"held"`
+	TransactionID int             `json:"transaction_id,omitempty"`
+	Receipt       *models.Receipt `json:"receipt,omitempty"`
+}
+
+// Spools the receipt for the printer when the customer asked for one and
+// returns it for the response. The money has already moved, so a receipt that
+// can't be spooled is only logged.
+func (s *server) printReceipt(r models.Receipt, wanted bool) *models.Receipt {
+	if !wanted {
+		return nil
+	}
+	r.Bank = s.cfg.Branding.BankName
+	if _, err := api.SpoolReceipt(r); err != nil {
+		log.Println("could not spool receipt:", err)
+	}
+	return &r
+}
+
+type accountView struct {
+	Number       string       `json:"number"`
+	Type         string       `json:"type"`
+	Status       string       `json:"status"`
+	Balance      models.Money `json:"balance"`
+	Held         models.Money `json:"held"`
+	InterestRate int          `json:"interest_rate_bp"`
+	OpenedAt     string       `json:"opened_at"`
+	StatusReason string       `json:"status_reason,omitempty"`
+	StatusAt     string       `json:"status_changed_at,omitempty"`
+	StatusBy     string       `json:"status_changed_by,omitempty"`
+}
+
+func newAccountView(a models.Account) accountView {
+	return accountView{
+		Number:       a.Number,
+		Type:         a.Type,
+		Status:       a.Status,
+		Balance:      a.Balance,
+		Held:         a.Held,
+		InterestRate: a.InterestRate,
+		OpenedAt:     a.OpenedAt,
+		StatusReason: a.StatusReason,
+		StatusAt:     a.StatusAt,
+		StatusBy:     a.StatusBy,
+	}
+}
+
+func (s *server) writeAccounts(w http.ResponseWriter, actor models.Actor, username string) {
+	list, err := api.ListAccounts(s.db, actor, username)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	views := make([]accountView, 0, len(list))
+	for _, a := range list {
+		views = append(views, newAccountView(a))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (s *server) handleAccounts(w http.ResponseWriter, r *http.Request, sess *session) {
+	s.writeAccounts(w, sess.actor(), sess.Username)
+}
+
+// Balance of the account in ?account=, or of the main checking account
+func (s *server) handleBalance(w http.ResponseWriter, r *http.Request, sess *session) {
+	s.writeBalance(w, sess.actor(), sess.Username, r.URL.Query().Get("account"))
+}
+
+func (s *server) writeBalance(w http.ResponseWriter, actor models.Actor, username, account string) {
+	acct, err := api.GetAccount(s.db, actor, username, account)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, balanceResponse{Account: acct.Number, Balance: acct.Balance, Held: acct.Held})
+}
+
+// Account is optional in the requests below and defaults to the main checking
+// account. Receipt asks for a receipt to be printed and returned.
+type depositRequest struct {
+	Account string `json:"account"`
+	Notes   notes  `json:"notes"`
+	Receipt bool   `json:"receipt"`
+}
+
+func (s *server) handleDeposit(w http.ResponseWriter, r *http.Request, sess *session) {
+	var req depositRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	counts, err := req.Notes.counts()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	acct, err := api.GetAccount(s.db, sess.actor(), sess.Username, req.Account)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	receipt, err := api.DepositCash(s.db, sess.actor(), s.terminal(), acct.Number, counts)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, balanceResponse{Account: acct.Number, Balance: receipt.Available,
+		TransactionID: receipt.TransactionID, Receipt: s.printReceipt(receipt, req.Receipt)})
+}
+
+type withdrawRequest struct {
+	Account    string       `json:"account"`
+	Amount     models.Money `json:"amount"`
+	SmallBills bool         `json:"small_bills"`
+	Receipt    bool         `json:"receipt"`
+}
+
+type withdrawResponse struct {
+	Account       string          `json:"account"`
+	Amount        models.Money    `json:"amount"`
+	Notes         notes           `json:"notes"`
+	Balance       models.Money    `json:"balance"`
+	TransactionID int             `json:"transaction_id"`
+	Receipt       *models.Receipt `json:"receipt,omitempty"`
+}
+
+// Plans the notes and withdraws in one go; there is no one to confirm the plan
+func (s *server) handleWithdraw(w http.ResponseWriter, r *http.Request, sess *session) {
+	var req withdrawRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	plan, err := api.PlanWithdrawal(s.db, s.terminal(), req.Amount, req.SmallBills)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	acct, err := api.GetAccount(s.db, sess.actor(), sess.Username, req.Account)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	receipt, err := api.WithdrawCash(s.db, sess.actor(), s.terminal(), acct.Number, plan)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, withdrawResponse{Account: acct.Number, Amount: plan.Amount, Notes: notesFromCounts(plan.Counts),
+		Balance: receipt.Available, TransactionID: receipt.TransactionID, Receipt: s.printReceipt(receipt, req.Receipt)})
+}
+
+type transferRequest struct {
+	Account   string       `json:"account"`
+	To        string       `json:"to"`         // another customer
+	ToAccount string       `json:"to_account"` // or one of your own accounts
+	Amount    models.Money `json:"amount"`
+	Receipt   bool         `json:"receipt"`
+}
+
+// Transfers to another customer or between the customer's own accounts, with
+// the same checks as the customer menu
+func (s *server) handleTransfer(w http.ResponseWriter, r *http.Request, sess *session) {
+	var req transferRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if (req.To == "") == (req.ToAccount == "") {
+		writeError(w, http.StatusBadRequest, "give either to or to_account")
+		return
+	}
+	from, err := api.GetAccount(s.db, sess.actor(), sess.Username, req.Account)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if req.ToAccount != "" {
+		receipt, err := api.TransferBetweenAccounts(s.db, sess.actor(), s.terminal(), from.Number, req.ToAccount, req.Amount)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, balanceResponse{Account: from.Number, Balance: receipt.Available,
+			TransactionID: receipt.TransactionID, Receipt: s.printReceipt(receipt, req.Receipt)})
+		return
+	}
+	if req.To == sess.Username {
+		writeError(w, http.StatusBadRequest, "cannot transfer to yourself")
+		return
+	}
+	if role, err := api.FetchUserRole(s.db, req.To); err != nil || role != models.RoleCustomer {
+		writeError(w, http.StatusNotFound, "specified user does not exist")
+		return
+	}
+
+	receipt, err := api.TransferFunds(s.db, sess.actor(), s.terminal(), from.Number, req.To, req.Amount)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, balanceResponse{Account: from.Number, Balance: receipt.Available,
+		TransactionID: receipt.TransactionID, Receipt: s.printReceipt(receipt, req.Receipt)})
+}
+
+type velocityView struct {
+	DailyWithdrawal   models.Money `json:"daily_withdrawal"`
+	DailyDeposit      models.Money `json:"daily_deposit"`
+	RollingWithdrawal models.Money `json:"rolling_withdrawal"`
+	RollingDeposit    models.Money `json:"rolling_deposit"`
+}
+
+func newVelocityView(l models.VelocityLimits) velocityView {
+	return velocityView{
+		DailyWithdrawal:   l.DailyWithdrawal,
+		DailyDeposit:      l.DailyDeposit,
+		RollingWithdrawal: l.RollingWithdrawal,
+		RollingDeposit:    l.RollingDeposit,
+	}
+}
+
+type limitsResponse struct {
+	WithdrawalLimit     models.Money `json:"withdrawal_limit"`
+	DepositLimit        models.Money `json:"deposit_limit"`
+	Daily               velocityView `json:"daily"`
+	RemainingWithdrawal models.Money `json:"remaining_withdrawal"`
+	RemainingDeposit    models.Money `json:"remaining_deposit"`
+}
+
+// The daily limits of one of the logged in customer's own accounts and what
+// they have used of them
+func (s *server) ownVelocity(sess *session, account string) (models.VelocityLimits, models.VelocityUsage, error) {
+	limits, err := api.GetVelocityLimits(s.db, sess.actor(), sess.Username, account)
+	if err != nil {
+		return models.VelocityLimits{}, models.VelocityUsage{}, err
+	}
+	usage, err := api.GetVelocityUsage(s.db, sess.actor(), sess.Username, account)
+	if err != nil {
+		return models.VelocityLimits{}, models.VelocityUsage{}, err
+	}
+	return limits, usage, nil
+}
+
+// The ATM's per-transaction limits and the daily limits of the account in
+// ?account=, or of the main checking account
+func (s *server) handleLimits(w http.ResponseWriter, r *http.Request, sess *session) {
+	withdrawalLimit, depositLimit, err := api.GetATMLimits(s.db, s.terminal())
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	limits, usage, err := s.ownVelocity(sess, r.URL.Query().Get("account"))
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	remainingWithdrawal, remainingDeposit := api.RemainingVelocity(limits, usage)
+
+	writeJSON(w, http.StatusOK, limitsResponse{
+		WithdrawalLimit:     withdrawalLimit,
+		DepositLimit:        depositLimit,
+		Daily:               newVelocityView(limits),
+		RemainingWithdrawal: remainingWithdrawal,
+		RemainingDeposit:    remainingDeposit,
+	})
+}
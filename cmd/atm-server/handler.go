@@ -0,0 +1,271 @@
+package main
+
+import (
+	"SPG_ATM_Machine/internal/api"
+	"SPG_ATM_Machine/internal/models"
+	"net/http"
+	"strconv"
+)
+
+type cashResponse struct {
+	Balance models.Money `json:"balance"`
+	Notes   notes        `json:"notes"`
+}
+
+func (s *server) writeCashStatus(w http.ResponseWriter) {
+	balance, err := api.GetATMBalance(s.db, s.terminal())
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	stock, err := api.GetATMDenominations(s.db, s.terminal())
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cashResponse{Balance: balance, Notes: notesFromCounts(stock)})
+}
+
+func (s *server) handleATMCash(w http.ResponseWriter, r *http.Request, sess *session) {
+	s.writeCashStatus(w)
+}
+
+type loadCashRequest struct {
+	Notes notes `json:"notes"`
+}
+
+func (s *server) handleLoadCash(w http.ResponseWriter, r *http.Request, sess *session) {
+	var req loadCashRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	counts, err := req.Notes.counts()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := api.LoadATMCash(s.db, sess.actor(), s.terminal(), counts); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	s.writeCashStatus(w)
+}
+
+type unloadCashRequest struct {
+	Amount models.Money `json:"amount"`
+	Notes  notes        `json:"notes"`
+}
+
+func (s *server) handleUnloadCash(w http.ResponseWriter, r *http.Request, sess *session) {
+	var req unloadCashRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	c, err := req.Notes.counts()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// counts follow api.Denominations, smallest first
+	err = api.UnloadATMCash(s.db, sess.actor(), s.terminal(), req.Amount, c[5], c[4], c[3], c[2], c[1], c[0])
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	s.writeCashStatus(w)
+}
+
+type countView struct {
+	ID          int          `json:"id"`
+	Terminal    string       `json:"terminal"`
+	Handler     string       `json:"handler"`
+	Status      string       `json:"status"`
+	StartedAt   string       `json:"started_at"`
+	SubmittedAt string       `json:"submitted_at,omitempty"`
+	ReviewedBy  string       `json:"reviewed_by,omitempty"`
+	ReviewedAt  string       `json:"reviewed_at,omitempty"`
+	Counted     notes        `json:"counted,omitempty"`
+	Expected    notes        `json:"expected,omitempty"`
+	Variance    notes        `json:"variance,omitempty"`
+	Amount      models.Money `json:"variance_amount"`
+}
+
+// The expected notes are left out until the count is submitted, so the
+// handler counts blind
+func newCountView(c models.CashCount) countView {
+	v := countView{
+		ID:          c.ID,
+		Terminal:    c.Terminal,
+		Handler:     c.Handler,
+		Status:      c.Status,
+		StartedAt:   c.StartedAt,
+		SubmittedAt: c.SubmittedAt,
+		ReviewedBy:  c.ReviewedBy,
+		ReviewedAt:  c.ReviewedAt,
+		Amount:      c.VarianceAmount,
+	}
+	if c.Counted != nil {
+		v.Counted = notesFromCounts(c.Counted)
+		v.Expected = notesFromCounts(c.Expected)
+		v.Variance = notesFromCounts(c.Variance())
+	}
+	return v
+}
+
+func countID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid count id")
+		return 0, false
+	}
+	return id, true
+}
+
+func (s *server) handleStartCount(w http.ResponseWriter, r *http.Request, sess *session) {
+	c, err := api.StartCashCount(s.db, sess.actor(), s.terminal())
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newCountView(c))
+}
+
+type submitCountRequest struct {
+	Notes notes `json:"notes"`
+}
+
+func (s *server) handleSubmitCount(w http.ResponseWriter, r *http.Request, sess *session) {
+	id, ok := countID(w, r)
+	if !ok {
+		return
+	}
+	var req submitCountRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	counted, err := req.Notes.counts()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c, err := api.SubmitCashCount(s.db, sess.actor(), id, counted)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newCountView(c))
+}
+
+func (s *server) handleCancelCount(w http.ResponseWriter, r *http.Request, sess *session) {
+	id, ok := countID(w, r)
+	if !ok {
+		return
+	}
+	if err := api.CancelCashCount(s.db, sess.actor(), id); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type cassetteView struct {
+	Denomination int  `json:"denomination"`
+	Notes        int  `json:"notes"`
+	Capacity     int  `json:"capacity"`
+	LowWater     int  `json:"low_water"`
+	OutOfService bool `json:"out_of_service"`
+}
+
+type cassettesResponse struct {
+	Terminal     string         `json:"terminal"`
+	OutOfService bool           `json:"out_of_service"`
+	Reason       string         `json:"reason,omitempty"`
+	Cassettes    []cassetteView `json:"cassettes"`
+}
+
+func (s *server) writeCassettes(w http.ResponseWriter, actor models.Actor, terminal string) {
+	list, err := api.GetCassettes(s.db, actor, terminal)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	st, err := api.GetServiceStatus(s.db, terminal)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	resp := cassettesResponse{Terminal: terminal, OutOfService: st.OutOfService, Reason: st.Reason}
+	for _, c := range list {
+		resp.Cassettes = append(resp.Cassettes, cassetteView(c))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) handleATMCassettes(w http.ResponseWriter, r *http.Request, sess *session) {
+	s.writeCassettes(w, sess.actor(), s.terminal())
+}
+
+type serviceRequest struct {
+	OutOfService bool   `json:"out_of_service"`
+	Reason       string `json:"reason"`
+	Denomination int    `json:"denomination"` // 0 for the whole terminal
+}
+
+func (s *server) setService(w http.ResponseWriter, r *http.Request, sess *session, terminal string) {
+	var req serviceRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	err := api.SetOutOfService(s.db, sess.actor(), terminal, req.Denomination, req.OutOfService, req.Reason)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	s.writeCassettes(w, sess.actor(), terminal)
+}
+
+func (s *server) handleATMService(w http.ResponseWriter, r *http.Request, sess *session) {
+	s.setService(w, r, sess, s.terminal())
+}
+
+type cashAlertView struct {
+	ID             int    `json:"id"`
+	Terminal       string `json:"terminal"`
+	Denomination   int    `json:"denomination"`
+	Kind           string `json:"kind"`
+	Notes          int    `json:"notes"`
+	CreatedAt      string `json:"created_at"`
+	AcknowledgedBy string `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt string `json:"acknowledged_at,omitempty"`
+}
+
+// Unresolved cash alerts: a cash handler sees their assigned terminals, an
+// admin sees every terminal
+func (s *server) handleCashAlerts(w http.ResponseWriter, r *http.Request, sess *session) {
+	alerts, err := api.GetCashAlerts(s.db, sess.actor())
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	resp := []cashAlertView{}
+	for _, a := range alerts {
+		resp = append(resp, cashAlertView(a))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"alerts": resp})
+}
+
+func (s *server) handleAckCashAlert(w http.ResponseWriter, r *http.Request, sess *session) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid alert id")
+		return
+	}
+	if err := api.AcknowledgeCashAlert(s.db, sess.actor(), id); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,54 @@
+// Command atm-server exposes the ATM core in internal/api as a JSON API over
+// HTTP, for the teller dashboard and other network clients.
+package main
+
+import (
+	"SPG_ATM_Machine/internal/config"
+	"SPG_ATM_Machine/internal/db"
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address to listen on")
+	sessionTTL := flag.Duration("session-ttl", 15*time.Minute, "how long a session stays valid without use")
+	configPath := flag.String("config", "", "config file (default $ATM_CONFIG or "+config.DefaultPath+")")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalln("Error loading config:", err)
+	}
+	store, err := db.OpenStore(cfg)
+	if err != nil {
+		log.Fatalln("Error connecting to database:", err)
+	}
+	defer store.Close()
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           newServer(store, *sessionTTL).routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Println("ATM server listening on", *addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalln("Server error:", err)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"SPG_ATM_Machine/internal/api"
+	"SPG_ATM_Machine/internal/config"
+	"SPG_ATM_Machine/internal/db"
+	"SPG_ATM_Machine/internal/models"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A logged in user. Clients send the token as "Authorization: Bearer <token>".
+type session struct {
+	Token     string
+	Username  string
+	Role      string
+	ExpiresAt time.Time
+}
+
+// Who the session acts as in internal/api
+func (sess *session) actor() models.Actor {
+	return models.Actor{Username: sess.Username, Role: sess.Role}
+}
+
+type server struct {
+	db  *sql.DB
+	cfg config.Config
+	ttl time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+func newServer(store *db.Store, ttl time.Duration) *server {
+	return &server{db: store.DB, cfg: store.Config, ttl: ttl, sessions: map[string]*session{}}
+}
+
+// The terminal customers and cash handlers are served at
+func (s *server) terminal() string {
+	return s.cfg.Terminal.ID
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login", s.handleLogin)
+	mux.HandleFunc("POST /logout", s.require(s.handleLogout, models.RoleAdmin, models.RoleCustomer, models.RoleCashHandler))
+	mux.HandleFunc("GET /permissions", s.require(s.handlePermissions, models.RoleAdmin, models.RoleCustomer, models.RoleCashHandler))
+
+	// Customer menu
+	mux.HandleFunc("GET /accounts", s.require(s.handleAccounts, models.RoleCustomer))
+	mux.HandleFunc("GET /balance", s.require(s.handleBalance, models.RoleCustomer))
+	mux.HandleFunc("POST /deposit", s.require(s.handleDeposit, models.RoleCustomer))
+	mux.HandleFunc("POST /deposit/cheque", s.require(s.handleDepositCheque, models.RoleCustomer))
+	mux.HandleFunc("GET /deposit/cheques", s.require(s.handleCustomerCheques, models.RoleCustomer))
+	mux.HandleFunc("POST /withdraw", s.require(s.handleWithdraw, models.RoleCustomer))
+	mux.HandleFunc("POST /transfer", s.require(s.handleTransfer, models.RoleCustomer))
+	mux.HandleFunc("GET /limits", s.require(s.handleLimits, models.RoleCustomer))
+	mux.HandleFunc("POST /pin", s.require(s.handleChangePIN, models.RoleCustomer))
+
+	// Cash handler menu
+	mux.HandleFunc("GET /atm/cash", s.require(s.handleATMCash, models.RoleCashHandler))
+	mux.HandleFunc("POST /atm/cash/load", s.require(s.handleLoadCash, models.RoleCashHandler))
+	mux.HandleFunc("POST /atm/cash/unload", s.require(s.handleUnloadCash, models.RoleCashHandler))
+	mux.HandleFunc("POST /atm/counts", s.require(s.handleStartCount, models.RoleCashHandler))
+	mux.HandleFunc("PUT /atm/counts/{id}", s.require(s.handleSubmitCount, models.RoleCashHandler))
+	mux.HandleFunc("DELETE /atm/counts/{id}", s.require(s.handleCancelCount, models.RoleCashHandler))
+	mux.HandleFunc("GET /atm/cassettes", s.require(s.handleATMCassettes, models.RoleCashHandler))
+	mux.HandleFunc("PUT /atm/service", s.require(s.handleATMService, models.RoleCashHandler))
+	mux.HandleFunc("GET /atm/alerts", s.require(s.handleCashAlerts, models.RoleCashHandler, models.RoleAdmin))
+	mux.HandleFunc("POST /atm/alerts/{id}/ack", s.require(s.handleAckCashAlert, models.RoleCashHandler, models.RoleAdmin))
+
+	// Admin menu
+	mux.HandleFunc("POST /admin/customers", s.require(s.handleCreateCustomer, models.RoleAdmin))
+	mux.HandleFunc("POST /admin/staff", s.require(s.handleCreateStaff, models.RoleAdmin))
+	mux.HandleFunc("GET /admin/roles", s.require(s.handleRoles, models.RoleAdmin))
+	mux.HandleFunc("GET /admin/transactions", s.require(s.handleTransactions, models.RoleAdmin))
+	mux.HandleFunc("POST /admin/transactions/{id}/receipt", s.require(s.handleReprintReceipt, models.RoleAdmin))
+	mux.HandleFunc("GET /admin/limits", s.require(s.handleATMLimits, models.RoleAdmin))
+	mux.HandleFunc("PUT /admin/limits", s.require(s.handleSetATMLimits, models.RoleAdmin))
+	mux.HandleFunc("POST /admin/users/{username}/unlock", s.require(s.handleUnlock, models.RoleAdmin))
+	mux.HandleFunc("GET /admin/users/{username}/locks", s.require(s.handleLockHistory, models.RoleAdmin))
+	mux.HandleFunc("POST /admin/users/{username}/pin/reset", s.require(s.handleResetPIN, models.RoleAdmin))
+	mux.HandleFunc("GET /admin/users", s.require(s.handleSearchCustomers, models.RoleAdmin))
+	mux.HandleFunc("GET /admin/users/{username}", s.require(s.handleCustomer, models.RoleAdmin))
+	mux.HandleFunc("PUT /admin/users/{username}", s.require(s.handleUpdateCustomer, models.RoleAdmin))
+	mux.HandleFunc("POST /admin/accounts/{number}/freeze", s.require(s.handleAccountStatus(api.FreezeAccount), models.RoleAdmin))
+	mux.HandleFunc("POST /admin/accounts/{number}/unfreeze", s.require(s.handleAccountStatus(api.UnfreezeAccount), models.RoleAdmin))
+	mux.HandleFunc("POST /admin/accounts/{number}/close", s.require(s.handleAccountStatus(api.CloseAccount), models.RoleAdmin))
+	mux.HandleFunc("POST /admin/accounts/{number}/adjust", s.require(s.handleAdjust, models.RoleAdmin))
+	mux.HandleFunc("GET /admin/users/{username}/accounts", s.require(s.handleCustomerAccounts, models.RoleAdmin))
+	mux.HandleFunc("POST /admin/users/{username}/accounts", s.require(s.handleOpenAccount, models.RoleAdmin))
+	mux.HandleFunc("GET /admin/users/{username}/limits", s.require(s.handleCustomerLimits, models.RoleAdmin))
+	mux.HandleFunc("PUT /admin/users/{username}/limits", s.require(s.handleSetCustomerLimit, models.RoleAdmin))
+	mux.HandleFunc("DELETE /admin/users/{username}/limits", s.require(s.handleResetCustomerLimits, models.RoleAdmin))
+	mux.HandleFunc("GET /admin/users/{username}/cards", s.require(s.handleListCards, models.RoleAdmin))
+	mux.HandleFunc("POST /admin/users/{username}/cards", s.require(s.handleIssueCard, models.RoleAdmin))
+	mux.HandleFunc("PUT /admin/cards/{card_id}/expiry", s.require(s.handleCardExpiry, models.RoleAdmin))
+	mux.HandleFunc("POST /admin/cards/{card_id}/revoke", s.require(s.handleRevokeCard, models.RoleAdmin))
+	mux.HandleFunc("GET /admin/audit", s.require(s.handleAuditLog, models.RoleAdmin))
+	mux.HandleFunc("GET /admin/audit/verify", s.require(s.handleVerifyAudit, models.RoleAdmin))
+	mux.HandleFunc("GET /admin/counts", s.require(s.handleCashCounts, models.RoleAdmin))
+	mux.HandleFunc("POST /admin/counts/{id}/approve", s.require(s.handleApproveCount, models.RoleAdmin))
+	mux.HandleFunc("POST /admin/counts/{id}/reject", s.require(s.handleRejectCount, models.RoleAdmin))
+	mux.HandleFunc("GET /admin/cash/events", s.require(s.handleCashEvents, models.RoleAdmin))
+	mux.HandleFunc("GET /admin/cheques", s.require(s.handleCheques, models.RoleAdmin))
+	mux.HandleFunc("POST /admin/cheques/{id}/accept", s.require(s.handleAcceptCheque, models.RoleAdmin))
+	mux.HandleFunc("POST /admin/cheques/{id}/reject", s.require(s.handleRejectCheque, models.RoleAdmin))
+	mux.HandleFunc("GET /admin/approvals", s.require(s.handleApprovals, models.RoleAdmin))
+	mux.HandleFunc("POST /admin/approvals/{id}/approve", s.require(s.handleApprove, models.RoleAdmin))
+	mux.HandleFunc("POST /admin/approvals/{id}/reject", s.require(s.handleReject, models.RoleAdmin))
+	mux.HandleFunc("GET /admin/terminals", s.require(s.handleTerminals, models.RoleAdmin))
+	mux.HandleFunc("POST /admin/terminals", s.require(s.handleRegisterTerminal, models.RoleAdmin))
+	mux.HandleFunc("PUT /admin/terminals/{terminal}/handlers/{username}", s.require(s.handleAssignTerminal, models.RoleAdmin))
+	mux.HandleFunc("DELETE /admin/terminals/{terminal}/handlers/{username}", s.require(s.handleUnassignTerminal, models.RoleAdmin))
+	mux.HandleFunc("GET /admin/terminals/{terminal}/cassettes", s.require(s.handleCassettes, models.RoleAdmin))
+	mux.HandleFunc("PUT /admin/terminals/{terminal}/cassettes/{denomination}", s.require(s.handleSetCassette, models.RoleAdmin))
+	mux.HandleFunc("PUT /admin/terminals/{terminal}/service", s.require(s.handleTerminalService, models.RoleAdmin))
+	return mux
+}
+
+type handlerFunc func(w http.ResponseWriter, r *http.Request, sess *session)
+
+// Wraps a handler so it only runs for a live session with one of the roles,
+// the same split auth.RouteUser makes between the menus. internal/api then
+// checks the user's permissions for the operation itself.
+func (s *server) require(h handlerFunc, roles ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sess := s.session(r)
+		if sess == nil {
+			writeError(w, http.StatusUnauthorized, "missing or expired session")
+			return
+		}
+		for _, role := range roles {
+			if sess.Role == role {
+				h(w, r, sess)
+				return
+			}
+		}
+		writeError(w, http.StatusForbidden, fmt.Sprintf("not available to role %q", sess.Role))
+	}
+}
+
+// Looks up the request's session and extends it
+func (s *server) session(r *http.Request) *session {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess := s.sessions[token]
+	if sess == nil {
+		return nil
+	}
+	if time.Now().After(sess.ExpiresAt) {
+		delete(s.sessions, token)
+		return nil
+	}
+	sess.ExpiresAt = time.Now().Add(s.ttl)
+	current := *sess
+	return &current
+}
+
+func (s *server) startSession(username, role string) (*session, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate session token: %v", err)
+	}
+	sess := &session{
+		Token:     hex.EncodeToString(b),
+		Username:  username,
+		Role:      role,
+		ExpiresAt: time.Now().Add(s.ttl),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[sess.Token] = sess
+	current := *sess
+	return &current, nil
+}
+
+func (s *server) endSession(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+}
+
+// Decodes a JSON request body, rejecting unknown fields
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Error writing response:", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// Note counts keyed by denomination, e.g. {"20": 2, "100": 1}
+type notes map[string]int
+
+// Orders note counts like api.Denominations
+func (n notes) counts() ([]int, error) {
+	counts := make([]int, len(api.Denominations))
+	for key, count := range n {
+		i := denominationIndex(key)
+		if i < 0 {
+			return nil, fmt.Errorf("unknown denomination %q", key)
+		}
+		if count < 0 {
+			return nil, fmt.Errorf("negative count for $%s notes", key)
+		}
+		counts[i] = count
+	}
+	return counts, nil
+}
+
+func denominationIndex(key string) int {
+	value, err := strconv.Atoi(key)
+	if err != nil {
+		return -1
+	}
+	for i, d := range api.Denominations {
+		if d == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func notesFromCounts(counts []int) notes {
+	n := notes{}
+	for i, d := range api.Denominations {
+		n[strconv.Itoa(d)] = counts[i]
+	}
+	return n
+}
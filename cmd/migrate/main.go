@@ -0,0 +1,113 @@
+// Command migrate shows and changes the schema version of data.db.
+//
+//	go run ./cmd/migrate [-config file] status     list migrations and which are applied
+//	go run ./cmd/migrate [-config file] up [N]     apply migrations up to N (default: latest)
+//	go run ./cmd/migrate [-config file] down N     roll back migrations newer than N
+package main
+
+import (
+	"SPG_ATM_Machine/internal/config"
+	"SPG_ATM_Machine/internal/db"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate [-config file] status | up [N] | down N")
+	os.Exit(2)
+}
+
+func main() {
+	configPath := flag.String("config", "", "config file (default $ATM_CONFIG or "+config.DefaultPath+")")
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 1 {
+		usage()
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading config:", err)
+		os.Exit(1)
+	}
+	database, err := db.Open(cfg.Database)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening database:", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	switch args[0] {
+	case "status":
+		err = printStatus(database)
+	case "up":
+		target := db.LatestVersion()
+		if len(args) > 1 {
+			target = parseVersion(args[1])
+		}
+		err = migrate(database, target, db.MigrateUp)
+	case "down":
+		if len(args) != 2 {
+			usage()
+		}
+		err = migrate(database, parseVersion(args[1]), db.MigrateDown)
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func parseVersion(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid version %q\n", s)
+		os.Exit(2)
+	}
+	return n
+}
+
+// Runs db.MigrateUp or db.MigrateDown, which refuse to move the schema the
+// other way
+func migrate(database *sql.DB, target int, run func(*sql.DB, int) error) error {
+	from, err := db.CurrentVersion(database)
+	if err != nil {
+		return err
+	}
+	if err := run(database, target); err != nil {
+		return err
+	}
+	fmt.Printf("Schema version %d -> %d\n", from, target)
+	return nil
+}
+
+func printStatus(database *sql.DB) error {
+	version, err := db.CurrentVersion(database)
+	if err != nil {
+		return err
+	}
+	status, err := db.Status(database)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Schema version %d (latest %d)\n", version, db.LatestVersion())
+	if version > db.LatestVersion() {
+		fmt.Println("This database was migrated by a newer build.")
+	}
+	for _, m := range status {
+		applied := "pending"
+		if m.AppliedAt != "" {
+			applied = "applied " + m.AppliedAt
+		}
+		fmt.Printf("%4d  %-25s %s\n", m.Version, m.Name, applied)
+	}
+	return nil
+}
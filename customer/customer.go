@@ -3,8 +3,13 @@ package customer
 import (
 	"SPG_ATM_Machine/internal/api"
 	"SPG_ATM_Machine/internal/db"
+	"SPG_ATM_Machine/internal/models"
+	"SPG_ATM_Machine/session"
 	"SPG_ATM_Machine/utils"
+	"database/sql"
+	"errors"
 	"fmt"
+	"os"
 	"strings"
 )
 
@@ -15,33 +20,399 @@ func viewChoices() {
 	fmt.Println("Enter 3 to Withdraw Money")
 	fmt.Println("Enter 4 to Transfer Funds")
 	fmt.Println("Enter 5 to View ATM Limits")
-	fmt.Println("Enter 6 to Exit")
+	fmt.Println("Enter 6 to View Mini Statement")
+	fmt.Println("Enter 7 to Export Statement")
+	fmt.Println("Enter 8 to Change PIN")
+	fmt.Println("Enter 9 to Switch Account")
+	fmt.Println("Enter 10 to Exit")
 }
 
-func Menu(username string) {
-	fmt.Printf("\nWelcome %s! What would you like do to today?\n", username)
-	database, err := db.Connect()
+// Lists the customer's accounts that aren't closed and lets them pick one.
+// With a single account there is nothing to choose.
+func chooseAccount(database *sql.DB, actor models.Actor, prompt, exclude string) (models.Account, bool, error) {
+	all, err := api.ListAccounts(database, actor, actor.Username)
+	if err != nil {
+		fmt.Println("Could not get accounts:", err)
+		return models.Account{}, false, nil
+	}
+	var list []models.Account
+	for _, a := range all {
+		if a.Status != models.AccountClosed && a.Number != exclude {
+			list = append(list, a)
+		}
+	}
+	switch len(list) {
+	case 0:
+		if exclude == "" {
+			fmt.Println("You have no open accounts. Please contact the bank.")
+		} else {
+			fmt.Println("You have no other open accounts.")
+		}
+		return models.Account{}, false, nil
+	case 1:
+		if exclude == "" {
+			return list[0], true, nil
+		}
+	}
+
+	for i, a := range list {
+		fmt.Printf("%d) %s %-8s $%s\n", i+1, a.Number, a.Type, a.Balance)
+	}
+	n, err := utils.TypeInt(prompt)
+	if err != nil {
+		return models.Account{}, false, err
+	}
+	if n < 1 || n > len(list) {
+		fmt.Println("Invalid option.")
+		return models.Account{}, false, nil
+	}
+	return list[n-1], true, nil
+}
+
+// Tells the customer which account the menu now acts on
+func announceAccount(a models.Account) {
+	fmt.Printf("Using %s account %s\n", a.Type, a.Number)
+	if a.Status == models.AccountFrozen {
+		fmt.Println("This account is frozen: you can deposit into it but not withdraw or transfer out. Please contact the bank.")
+	}
+}
+
+// Prints every account the customer holds, marking the one in use
+func showBalances(database *sql.DB, actor models.Actor, current string) {
+	list, err := api.ListAccounts(database, actor, actor.Username)
 	if err != nil {
-		fmt.Println("Error connecting to database:", err)
+		fmt.Println("Could not get balance:", err)
 		return
 	}
-	viewChoices()
+	for _, a := range list {
+		marker := " "
+		if a.Number == current {
+			marker = "*"
+		}
+		status := ""
+		if a.Status != models.AccountOpen {
+			status = " (" + a.Status + ")"
+		}
+		held := ""
+		if a.Held != 0 {
+			held = fmt.Sprintf(", $%s on hold", a.Held)
+		}
+		fmt.Printf("%s %s %-8s $%s available%s%s\n", marker, a.Number, a.Type, a.Balance, held, status)
+	}
+	fmt.Println("* account in use")
+}
+
+// Takes a cheque or envelope into the account in use. It is held until the
+// bank clears it.
+func depositCheque(database *sql.DB, actor models.Actor, terminal string, account models.Account) error {
+	amountStr, err := utils.TypeInput("Enter the amount of the cheque or envelope: ")
+	if err != nil {
+		return err
+	}
+	amount, ok := utils.ParseAmount(amountStr)
+	if !ok {
+		return nil
+	}
+	image, err := utils.TypeInput("Enter the path of the scanned cheque or envelope image: ")
+	if err != nil {
+		return err
+	}
+	deposit, err := api.DepositCheque(database, actor, terminal, account.Number, amount, image)
+	if err != nil {
+		fmt.Println("Deposit failed, please take your cheque:", err)
+		return nil
+	}
+	fmt.Printf("Cheque deposit #%d of $%s received. The funds are on hold until the bank clears it.\n", deposit.ID, deposit.Amount)
+	return nil
+}
+
+// Tells the customer about deposits the bank has returned since they last logged in
+func showChequeNotices(database *sql.DB, actor models.Actor) {
+	notices, err := api.TakeChequeNotices(database, actor, actor.Username)
+	if err != nil {
+		fmt.Println("Could not get notices:", err)
+		return
+	}
+	for _, d := range notices {
+		fmt.Printf("NOTICE: your cheque deposit #%d of $%s to account %s was rejected and not credited: %s\n", d.ID, d.Amount, d.Account, d.Reason)
+	}
+}
+
+// Asks whether the customer wants a receipt, and if so prints it and spools
+// it for the receipt printer
+func offerReceipt(r models.Receipt, bankName string) error {
+	answer, err := utils.TypeInput("Would you like a receipt? (Y/N)")
+	if err != nil {
+		return err
+	}
+	if strings.ToUpper(answer) != "Y" {
+		return nil
+	}
+	r.Bank = bankName
+	if err := api.WriteReceiptText(os.Stdout, r); err != nil {
+		fmt.Println("Could not print receipt:", err)
+		return nil
+	}
+	path, err := api.SpoolReceipt(r)
+	if err != nil {
+		fmt.Println("Could not print receipt:", err)
+		return nil
+	}
+	fmt.Println("Please take your receipt. Saved to", path)
+	return nil
+}
+
+// Moves money from the account in use to another of the customer's accounts
+func transferOwnAccounts(database *sql.DB, actor models.Actor, terminal, bankName string, from models.Account) error {
+	to, ok, err := chooseAccount(database, actor, "Enter the account to transfer to:", from.Number)
+	if err != nil || !ok {
+		return err
+	}
+	amountStr, err := utils.TypeInput("Enter amount to transfer: ")
+	if err != nil {
+		return err
+	}
+	amount, ok := utils.ParseAmount(amountStr)
+	if !ok {
+		return nil
+	}
+	answer, err := utils.TypeInput(fmt.Sprintf("Confirm transfer of '%s' from %s to %s? (Y/N)", amount, from.Number, to.Number))
+	if err != nil {
+		return err
+	}
+	if strings.ToUpper(answer) != "Y" {
+		fmt.Println("Transfer cancelled.")
+		return nil
+	}
+	receipt, err := api.TransferBetweenAccounts(database, actor, terminal, from.Number, to.Number, amount)
+	if err != nil {
+		fmt.Printf("Transfer failed: %v\n", err)
+		return nil
+	}
+	fmt.Printf("Transfer success. Your new balance is $%s \n", receipt.Available)
+	return offerReceipt(receipt, bankName)
+}
+
+// Changes the customer's PIN after checking the current one. Returns false if
+// too many wrong PINs locked the account and the session has to end.
+func changePIN(store *db.Store, username string) (bool, error) {
+	actor := models.Actor{Username: username, Role: models.RoleCustomer}
+	oldPIN, err := utils.TypeSecret("Enter your current PIN:")
+	if err != nil {
+		return false, err
+	}
+	fmt.Println("Your new PIN must be 6 digits, without a digit three times in a row, three sequential digits or your date of birth.")
+	newPIN, err := utils.TypeSecret("Enter new PIN:")
+	if err != nil {
+		return false, err
+	}
+	confirm, err := utils.TypeSecret("Confirm new PIN:")
+	if err != nil {
+		return false, err
+	}
+	if confirm != newPIN {
+		fmt.Println("The PINs do not match. Your PIN was not changed.")
+		return true, nil
+	}
+
+	err = api.ChangePIN(store.DB, actor, store.Config.Terminal.ID, username, oldPIN, newPIN, store.Config.Limits)
+	switch {
+	case err == nil:
+		fmt.Println("Your PIN has been changed.")
+	case errors.Is(err, api.ErrTooManyAttempts), errors.Is(err, api.ErrAccountLocked):
+		fmt.Println("Too many failed attempts. Your account has been locked. Contact an Admin")
+		return false, nil
+	case errors.Is(err, api.ErrTemporarilyLocked):
+		fmt.Printf("Too many failed attempts. Your %s.\n", err)
+		return false, nil
+	default:
+		fmt.Println("PIN not changed:", err)
+	}
+	return true, nil
+}
+
+// Shows the customer the notes the ATM will dispense and lets them accept
+// the plan, switch to small bills or cancel.
+func chooseDispensePlan(database *sql.DB, terminal string, amount models.Money) (api.DispensePlan, bool, error) {
+	smallBills := false
 	for {
-		choice := utils.TypeInput("Enter your choice (0-6): ")
+		plan, err := api.PlanWithdrawal(database, terminal, amount, smallBills)
+		if err != nil {
+			fmt.Println("ERROR:", err)
+			if !smallBills {
+				return api.DispensePlan{}, false, nil
+			}
+			smallBills = false
+			continue
+		}
+
+		fmt.Printf("The ATM will dispense $%s as: %s\n", amount, plan)
+		prompt := "Enter A to accept, S for small bills, or C to cancel:"
+		if smallBills {
+			prompt = "Enter A to accept, L for larger notes, or C to cancel:"
+		}
+		answer, err := utils.TypeInput(prompt)
+		if err != nil {
+			return api.DispensePlan{}, false, err
+		}
+		switch strings.ToUpper(answer) {
+		case "A":
+			return plan, true, nil
+		case "S":
+			smallBills = true
+		case "L":
+			smallBills = false
+		case "C":
+			fmt.Println("Withdrawal cancelled.")
+			return api.DispensePlan{}, false, nil
+		default:
+			fmt.Println("Invalid option, please try again.")
+		}
+	}
+}
+
+// Prints how much more the customer can withdraw from and deposit into the
+// account today
+func printRemainingToday(database *sql.DB, actor models.Actor, account string) {
+	limits, err := api.GetVelocityLimits(database, actor, actor.Username, account)
+	if err != nil {
+		return
+	}
+	usage, err := api.GetVelocityUsage(database, actor, actor.Username, account)
+	if err != nil {
+		return
+	}
+	withdrawal, deposit := api.RemainingVelocity(limits, usage)
+	fmt.Printf("Remaining today: $%s to withdraw, $%s to deposit\n", withdrawal, deposit)
+}
+
+// Prints the customer's most recent transactions, at most size of them
+func showMiniStatement(database *sql.DB, actor models.Actor, account string, size int) {
+	txns, err := api.GetRecentTransactions(database, actor, actor.Username, account, size)
+	if err != nil {
+		fmt.Println("Could not get transactions:", err)
+		return
+	}
+
+	fmt.Printf("\n===== MINI STATEMENT %s (last %d) =====\n", account, size)
+	if len(txns) == 0 {
+		fmt.Println("No transactions yet.")
+		return
+	}
+	fmt.Printf("%-19s | %-12s | %12s | %12s | %-15s\n", "Date", "Type", "Amount ($)", "Balance ($)", "Counterparty")
+	fmt.Println(strings.Repeat("-", 82))
+	for _, t := range txns {
+		balanceAfter := "-"
+		if t.BalanceAfter != nil {
+			balanceAfter = t.BalanceAfter.String()
+		}
+		fmt.Printf("%-19s | %-12s | %12s | %12s | %-15s\n", t.Date, t.Kind, t.Amount, balanceAfter, t.Counterparty)
+	}
+
+	acct, err := api.GetAccount(database, actor, actor.Username, account)
+	if err == nil {
+		fmt.Printf("Current balance: $%s\n", acct.Balance)
+	}
+}
+
+// Asks for a date range and writes the statement to a CSV or text file
+func exportStatement(database *sql.DB, actor models.Actor, account, bankName string) error {
+	fromStr, err := utils.TypeInput("Enter statement start date (MM/DD/YYYY): ")
+	if err != nil {
+		return err
+	}
+	from, ok := utils.ParseDate(fromStr)
+	if !ok {
+		return nil
+	}
+	toStr, err := utils.TypeInput("Enter statement end date (MM/DD/YYYY): ")
+	if err != nil {
+		return err
+	}
+	to, ok := utils.ParseDate(toStr)
+	if !ok {
+		return nil
+	}
+
+	statement, err := api.GetStatement(database, actor, actor.Username, account, from, to)
+	if err != nil {
+		fmt.Println("Could not build statement:", err)
+		return nil
+	}
+	statement.Bank = bankName
+
+	choice, err := utils.TypeInput("Enter C for a CSV file or T for a printable text file: ")
+	if err != nil {
+		return err
+	}
+	format := ""
+	switch strings.ToUpper(choice) {
+	case "C":
+		format = "csv"
+	case "T":
+		format = "txt"
+	default:
+		fmt.Println("Invalid choice. Statement not exported.")
+		return nil
+	}
+
+	path, err := api.ExportStatement(statement, format)
+	if err != nil {
+		fmt.Println("Could not export statement:", err)
+		return nil
+	}
+	fmt.Printf("Opening balance $%s, closing balance $%s, %d entries.\n", statement.Opening, statement.Closing, len(statement.Lines))
+	fmt.Println("Statement saved to", path)
+	return nil
+}
+
+func Menu(store *db.Store, sess *session.Session) error {
+	username := sess.Username
+	fmt.Printf("\nWelcome %s! What would you like do to today?\n", username)
+	database := store.DB
+	terminal := store.Config.Terminal.ID
+	actor := models.Actor{Username: username, Role: models.RoleCustomer}
+	if st, err := api.GetServiceStatus(database, terminal); err == nil && st.OutOfService {
+		fmt.Println("NOTICE: this ATM is out of service for withdrawals. Deposits and transfers are still available.")
+	}
+	showChequeNotices(database, actor)
+	account, ok, err := chooseAccount(database, actor, "Enter the account to use:", "")
+	if err != nil || !ok {
+		return err
+	}
+	announceAccount(account)
+	viewChoices()
+	for sess.Active() {
+		choice, err := sess.Choose("Enter your choice (0-10): ", "0", "10")
+		if err != nil {
+			return err
+		}
 		switch choice {
 		case "0":
 			viewChoices()
 		case "1":
-			balance, err := api.GetUserBalance(database, username)
+			showBalances(database, actor, account.Number)
+		case "2":
+			kind, err := utils.TypeInput("Enter C to deposit cash or Q to deposit a cheque or envelope:")
 			if err != nil {
-				fmt.Println("Could not get balance:", err)
+				return err
+			}
+			switch strings.ToUpper(kind) {
+			case "C":
+			case "Q":
+				if err := depositCheque(database, actor, terminal, account); err != nil {
+					return err
+				}
+				continue
+			default:
+				fmt.Println("Invalid option")
 				continue
 			}
-			fmt.Printf("Your balance is $%.2f \n", balance)
-		case "2":
 			fmt.Printf("Enter the quantity of each denomination you're depositing in deposit.txt \n")
 			fmt.Printf("Each line is the next higher denomination 1,5,10,20,50,100, e.g. a 3 on line 6 is $300 \n")
-			utils.TypeInput("Press enter here when you are ready to continue:")
+			if _, err := utils.TypeInput("Press enter here when you are ready to continue:"); err != nil {
+				return err
+			}
 
 			input_denoms, err := utils.ParseDeposit("customer/deposit.txt")
 
@@ -49,131 +420,130 @@ func Menu(username string) {
 				fmt.Println("Invalid Input:", err)
 				continue
 			}
-			err = api.DepositATM(database, input_denoms)
+			receipt, err := api.DepositCash(database, actor, terminal, account.Number, input_denoms)
 			if err != nil {
-				fmt.Println("Could not update ATM balance:", err)
+				fmt.Println("Deposit failed, please take your cash:", err)
 				continue
 			}
-
-			denominations_values := []int{1, 5, 10, 20, 50, 100}
-			deposit := 0
-			for denom := range input_denoms {
-				deposit += input_denoms[denom] * denominations_values[denom]
+			fmt.Printf("Your new balance is $%s \n", receipt.Available)
+			printRemainingToday(database, actor, account.Number)
+			if err := offerReceipt(receipt, store.Config.Branding.BankName); err != nil {
+				return err
 			}
+		case "3":
 
-			newBalance, err := api.DepositBalance(database, username, float64(deposit))
+			amountStr, err := utils.TypeInput("Enter how much money to withdraw: ")
 			if err != nil {
-				fmt.Println("Could not update balance:", err)
-				continue
+				return err
 			}
-			fmt.Printf("Your new balance is $%.2f \n", newBalance)
-		case "3":
-
-			amountStr := utils.TypeInput("Enter how much money to withdraw: ")
 			amount, _ := utils.ParseAmount(amountStr)
 
 			if amount == 0 {
 				continue
 			}
 
+			plan, ok, err := chooseDispensePlan(database, terminal, amount)
 			if err != nil {
-				fmt.Println("Invalid Input:", err)
-				continue
+				return err
 			}
-
-			fmt.Println("Enter bill breakdown for withdrawal:")
-			nHundreds := utils.TypeInt("Hundreds: ")
-			nFifties := utils.TypeInt("Fifties: ")
-			nTwenties := utils.TypeInt("Twenties: ")
-			nTens := utils.TypeInt("Tens: ")
-			nFives := utils.TypeInt("Fives: ")
-			nOnes := utils.TypeInt("Ones: ")
-
-			denoms := []int{nOnes, nFives, nTens, nTwenties, nFifties, nHundreds}
-
-			err = api.WithdrawATM(database, amount, nHundreds, nFifties, nTwenties, nTens, nFives, nOnes)
-			if err != nil {
-				fmt.Println("ERROR:", err)
+			if !ok {
 				continue
 			}
-
-			newBalance, err := api.WithdrawBalance(database, username, float64(amount))
+			receipt, err := api.WithdrawCash(database, actor, terminal, account.Number, plan)
 			if err != nil {
-				_ = api.DepositATM(database, denoms)
 				fmt.Println("Transaction failed, withdrawal cancelled")
-				fmt.Println("Could not update balance:", err)
+				fmt.Println("ERROR:", err)
 				continue
 			}
-			fmt.Printf("Your new balance is $%.2f \n", newBalance)
+			fmt.Printf("Please take your cash: %s\n", plan)
+			fmt.Printf("Your new balance is $%s \n", receipt.Available)
+			printRemainingToday(database, actor, account.Number)
+			if err := offerReceipt(receipt, store.Config.Branding.BankName); err != nil {
+				return err
+			}
 
 		case "4":
-			var transferTarget string
-			var transferAmt float64
-			for {
-				transferTarget = utils.TypeInput("Enter username to transfer funds to: ")
-				break
-			}
-			stmtCheck, err := database.Prepare("SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)")
+			kind, err := utils.TypeInput("Enter O to transfer between your own accounts or C to another customer:")
 			if err != nil {
-				fmt.Println("Specified user does not exist")
-				continue
+				return err
 			}
-			defer stmtCheck.Close()
-
-			//Error handling to check if the username exists or not
-			var exists bool
-			if err := stmtCheck.QueryRow(transferTarget).Scan(&exists); err != nil {
-				fmt.Printf("failed to check username: %v\n", err)
+			switch strings.ToUpper(kind) {
+			case "O":
+				if err := transferOwnAccounts(database, actor, terminal, store.Config.Branding.BankName, account); err != nil {
+					return err
+				}
 				continue
-			}
-			if !exists {
-				fmt.Println("Specified user does not exist")
+			case "C":
+			default:
+				fmt.Println("Invalid option")
 				continue
 			}
 
+			var transferTarget string
+			var transferAmt models.Money
+			for {
+				transferTarget, err = utils.TypeInput("Enter username to transfer funds to: ")
+				if err != nil {
+					return err
+				}
+				break
+			}
 			if transferTarget == username {
 				fmt.Println("Invalid option")
 				continue
 			}
 
-			var role string
-			err = database.QueryRow("SELECT role FROM users WHERE username = ?", transferTarget).Scan(&role)
+			//Only other customers can be paid
+			var exists bool
+			exists, err = api.CustomerExists(database, transferTarget)
 			if err != nil {
-				fmt.Printf("failed to check user role: %v\n", err)
+				fmt.Printf("failed to check username: %v\n", err)
 				continue
 			}
-			if role != "customer" {
+			if !exists {
 				fmt.Println("Specified user does not exist")
 				continue
 			}
 
 			for {
-				transferAmtStr := utils.TypeInput("Enter amount to transfer: ")
+				transferAmtStr, err := utils.TypeInput("Enter amount to transfer: ")
+				if err != nil {
+					return err
+				}
 				amount, ok := utils.ParseAmount(transferAmtStr)
 				if ok {
 					transferAmt = amount
 					break
 				}
 			}
-			balance, err := api.GetUserBalance(database, username)
+			current, err := api.GetAccount(database, actor, username, account.Number)
 			if err != nil {
 				fmt.Println("Could not get balance:", err)
 				continue
 			}
+			balance := current.Balance
 
 			if transferAmt > balance {
-				fmt.Printf("Invalid transfer amount. Your current balance is: '%.2f'\n", balance)
+				fmt.Printf("Invalid transfer amount. Your current balance is: '%s'\n", balance)
 				continue
 			}
 
 			for {
-				answer := strings.ToUpper(utils.TypeInput(fmt.Sprintf("Confirm transfer of '%.2f' from '%s' to '%s'? (Y/N)", transferAmt, username, transferTarget)))
+				answer, err := utils.TypeInput(fmt.Sprintf("Confirm transfer of '%s' from '%s' to '%s'? (Y/N)", transferAmt, username, transferTarget))
+				if err != nil {
+					return err
+				}
+				answer = strings.ToUpper(answer)
 				if answer == "Y" {
-					if err := api.TransferFunds(database, username, transferTarget, transferAmt); err != nil {
+					receipt, err := api.TransferFunds(database, actor, terminal, account.Number, transferTarget, transferAmt)
+					if err != nil {
 						fmt.Printf("Transfer failed: %v\n", err)
 						continue
 					}
 					fmt.Println("Transfer success")
+					if err := offerReceipt(receipt, store.Config.Branding.BankName); err != nil {
+						return err
+					}
 					break
 				} else if answer == "N" {
 					fmt.Println("Transfer cancelled.")
@@ -183,18 +553,52 @@ func Menu(username string) {
 				}
 			}
 		case "5":
-			withdrawalLimit, depositLimit, err := api.GetATMLimits(database)
+			withdrawalLimit, depositLimit, err := api.GetATMLimits(database, terminal)
 			if err != nil {
 				fmt.Println("Failed Withdrawal/Deposit Limit Fetch")
 			}
-			fmt.Printf("Withdraw Limit: $%v\n", withdrawalLimit)
-			fmt.Printf("Deposit Limit: $%v\n", depositLimit)
+			fmt.Printf("Withdraw Limit: $%s\n", withdrawalLimit)
+			fmt.Printf("Deposit Limit: $%s\n", depositLimit)
+
+			limits, err := api.GetVelocityLimits(database, actor, username, account.Number)
+			if err != nil {
+				fmt.Println("Failed Daily Limit Fetch")
+				continue
+			}
+			fmt.Printf("Daily Withdraw Limit: $%s (rolling 24 hours: $%s)\n", limits.DailyWithdrawal, limits.RollingWithdrawal)
+			fmt.Printf("Daily Deposit Limit: $%s (rolling 24 hours: $%s)\n", limits.DailyDeposit, limits.RollingDeposit)
+			printRemainingToday(database, actor, account.Number)
 
 		case "6":
-			fmt.Println("Thank you for banking with JP Goldman Stanley!")
-			return
+			showMiniStatement(database, actor, account.Number, store.Config.Limits.MiniStatementSize)
+
+		case "7":
+			if err := exportStatement(database, actor, account.Number, store.Config.Branding.BankName); err != nil {
+				return err
+			}
+
+		case "8":
+			ok, err := changePIN(store, username)
+			if err != nil || !ok {
+				return err
+			}
+
+		case "9":
+			next, ok, err := chooseAccount(database, actor, "Enter the account to use:", "")
+			if err != nil {
+				return err
+			}
+			if ok {
+				account = next
+				announceAccount(account)
+			}
+
+		case "10":
+			fmt.Printf("Thank you for banking with %s!\n", store.Config.Branding.BankName)
+			return nil
 		default:
 			fmt.Println("Invalid option, please try again.")
 		}
 	}
+	return nil
 }
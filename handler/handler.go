@@ -3,8 +3,12 @@ package handler
 import (
 	"SPG_ATM_Machine/internal/api"
 	"SPG_ATM_Machine/internal/db"
+	"SPG_ATM_Machine/internal/models"
+	"SPG_ATM_Machine/session"
 	"SPG_ATM_Machine/utils"
+	"database/sql"
 	"fmt"
+	"strings"
 )
 
 func viewChoices() {
@@ -12,42 +16,196 @@ func viewChoices() {
 	fmt.Println("Enter 1 to View Total ATM Cash")
 	fmt.Println("Enter 2 to Deposit Cash to ATM")
 	fmt.Println("Enter 3 to Withdraw Cash from ATM")
-	fmt.Println("Enter 4 to Exit")
+	fmt.Println("Enter 4 to Reconcile ATM Cash")
+	fmt.Println("Enter 5 to View Cash Alerts")
+	fmt.Println("Enter 6 to Set Out of Service")
+	fmt.Println("Enter 7 to Exit")
 }
 
-func Menu(username string) {
-	fmt.Printf("\nWelcome Handler %s! What would you like do to today?\n", username)
+// Prints the cash alerts for the handler's terminals. Returns false if there
+// are none.
+func showCashAlerts(database *sql.DB, actor models.Actor) bool {
+	alerts, err := api.GetCashAlerts(database, actor)
+	if err != nil {
+		fmt.Println("Could not get cash alerts:", err)
+		return false
+	}
+	if len(alerts) == 0 {
+		fmt.Println("No cash alerts.")
+		return false
+	}
+
+	fmt.Println("\n===== CASH ALERTS =====")
+	for _, a := range alerts {
+		seen := "NEW"
+		if a.AcknowledgedBy != "" {
+			seen = "seen by " + a.AcknowledgedBy
+		}
+		fmt.Printf("#%d %s since %s [%s]\n", a.ID, api.FormatCashAlert(a), a.CreatedAt, seen)
+	}
+	return true
+}
+
+func reviewCashAlerts(database *sql.DB, actor models.Actor) error {
+	if !showCashAlerts(database, actor) {
+		return nil
+	}
+	id, err := utils.TypeInt("Enter an alert # to acknowledge, or 0 to go back: ")
+	if err != nil || id <= 0 {
+		return err
+	}
+	if err := api.AcknowledgeCashAlert(database, actor, id); err != nil {
+		fmt.Println("Could not acknowledge alert:", err)
+		return nil
+	}
+	fmt.Printf("Alert #%d acknowledged. It clears once the cassette is refilled.\n", id)
+	return nil
+}
+
+// Take the whole terminal, or a single cassette, out of service for
+// withdrawals, or put it back
+func setOutOfService(database *sql.DB, actor models.Actor, terminal string) error {
+	if err := api.ShowCassettes(database, actor, terminal); err != nil {
+		fmt.Println("ERROR:", err)
+		return nil
+	}
+
+	denomination, err := utils.TypeInt("Enter a denomination to switch one cassette, or 0 for the whole ATM: ")
+	if err != nil {
+		return err
+	}
+	choice, err := utils.TypeInput("Enter O to take it out of service or I to put it back in service: ")
+	if err != nil {
+		return err
+	}
+	choice = strings.ToUpper(choice)
+	if choice != "O" && choice != "I" {
+		fmt.Println("Invalid option.")
+		return nil
+	}
+	outOfService := choice == "O"
+
+	reason := ""
+	if outOfService && denomination == 0 {
+		if reason, err = utils.TypeInput("Reason: "); err != nil {
+			return err
+		}
+	}
+	if err := api.SetOutOfService(database, actor, terminal, denomination, outOfService, reason); err != nil {
+		fmt.Println("Could not update service status:", err)
+		return nil
+	}
+	api.ShowCassettes(database, actor, terminal)
+	return nil
+}
+
+// Count the cassettes and compare them to what the ATM should hold. The
+// expected notes aren't shown until the count is entered.
+func reconcileCash(database *sql.DB, actor models.Actor, terminal string) error {
+	count, open, err := api.GetOpenCashCount(database, actor.Username, terminal)
+	if err != nil {
+		fmt.Println("ERROR:", err)
+		return nil
+	}
+
+	if !open {
+		choice, err := utils.TypeInput("Enter N to start a new cash count or S to skip: ")
+		if err != nil {
+			return err
+		}
+		if strings.ToUpper(choice) != "N" {
+			return nil
+		}
+		count, err = api.StartCashCount(database, actor, terminal)
+		if err != nil {
+			fmt.Println("Could not start count:", err)
+			return nil
+		}
+		fmt.Printf("Count #%d started. Loads and unloads are blocked until it is finished.\n", count.ID)
+	}
 
-	//connects database ask team if I should close the connection shortly after
-	database, err := db.Connect()
+	choice, err := utils.TypeInput("Enter E to enter the counted notes, C to cancel the count, or S to come back later: ")
 	if err != nil {
-		fmt.Println("Error connecting to database:", err)
-		return
+		return err
 	}
+	switch strings.ToUpper(choice) {
+	case "E":
+		fmt.Println("Enter the notes counted in each cassette:")
+		counted := make([]int, len(api.Denominations))
+		for i := len(api.Denominations) - 1; i >= 0; i-- {
+			if counted[i], err = utils.TypeInt(fmt.Sprintf("$%d notes: ", api.Denominations[i])); err != nil {
+				return err
+			}
+		}
+		count, err = api.SubmitCashCount(database, actor, count.ID, counted)
+		if err != nil {
+			fmt.Println("Could not submit count:", err)
+			return nil
+		}
+		if count.Status == models.CountBalanced {
+			fmt.Println("Count balanced: the cassettes match the ATM's records.")
+			return nil
+		}
+		fmt.Printf("Variance of $%s found (%s).\n", count.VarianceAmount, api.FormatNotes(count.Variance()))
+		fmt.Println("The write-off has been sent to an admin for approval.")
+	case "C":
+		if err := api.CancelCashCount(database, actor, count.ID); err != nil {
+			fmt.Println("Could not cancel count:", err)
+			return nil
+		}
+		fmt.Printf("Count #%d cancelled.\n", count.ID)
+	}
+	return nil
+}
+
+func Menu(store *db.Store, sess *session.Session) error {
+	username := sess.Username
+	database := store.DB
+	terminal := store.Config.Terminal.ID
+	actor := models.Actor{Username: username, Role: models.RoleCashHandler}
+
+	//handlers can only service the terminals assigned to them
+	assigned, err := api.CanServiceTerminal(database, username, terminal)
+	if err != nil {
+		fmt.Println("Could not check terminal assignment:", err)
+		return nil
+	}
+	if !assigned {
+		fmt.Printf("Handler %s is not assigned to terminal %s. Please contact an admin.\n", username, terminal)
+		return nil
+	}
+
+	fmt.Printf("\nWelcome Handler %s! You are servicing terminal %s. What would you like do to today?\n", username, terminal)
+	showCashAlerts(database, actor)
 
 	//cash handler operation
 	viewChoices()
-	for {
-		choice := utils.TypeInput("Enter your choice (0-4): ")
+	for sess.Active() {
+		choice, err := sess.Choose("Enter your choice (0-7): ", "0", "7")
+		if err != nil {
+			return err
+		}
 		switch choice {
 		case "0":
 			viewChoices()
 
 		case "1": //gets balance of ATM
-			bal, err := api.GetATMBalance(database)
+			bal, err := api.GetATMBalance(database, terminal)
 
 			if err != nil {
 				fmt.Println("Could not get balance:", err)
-				return
+				return nil
 			}
 
-			fmt.Printf("ATM Total balance is $%.2f\n", bal)
+			fmt.Printf("ATM Total balance is $%s\n", bal)
 
 		case "2": //deposits balance
 
 			fmt.Printf("Enter the quantity of each denomination you're depositing in deposit.txt \n")
 			fmt.Printf("Each line is the next higher denomination 1,5,10,20,50,100, e.g. a 3 on line 6 is $300 \n")
-			utils.TypeInput("Press enter here when you are ready to continue:")
+			if _, err := utils.TypeInput("Press enter here when you are ready to continue:"); err != nil {
+				return err
+			}
 
 			input_denoms, err := utils.ParseDeposit("handler/deposit.txt")
 
@@ -55,39 +213,58 @@ func Menu(username string) {
 				fmt.Println("Invalid Input:", err)
 				continue
 			}
-			err = api.DepositATM(database, input_denoms)
+			err = api.LoadATMCash(database, actor, terminal, input_denoms)
 			if err != nil {
 				fmt.Println("Could not update ATM balance:", err)
 				continue
 			}
-			api.PrintNewATMBalance(database)
+			api.PrintNewATMBalance(database, terminal)
 
 		case "3": //withdaw from atm
 
-			amountStr := utils.TypeInput("Enter amount to Withdraw from the ATM: ")
+			amountStr, err := utils.TypeInput("Enter amount to Withdraw from the ATM: ")
+			if err != nil {
+				return err
+			}
 			amount, _ := utils.ParseAmount(amountStr)
-			if amount == 0	{
+			if amount == 0 {
 				continue
 			}
 			fmt.Println("Enter bill breakdown for withdrawal:")
-			nHundreds := utils.TypeInt("Hundreds: ")
-			nFifties := utils.TypeInt("Fifties: ")
-			nTwenties := utils.TypeInt("Twenties: ")
-			nTens := utils.TypeInt("Tens: ")
-			nFives := utils.TypeInt("Fives: ")
-			nOnes := utils.TypeInt("Ones: ")
-
-			err := api.WithdrawATM(database, amount, nHundreds, nFifties, nTwenties, nTens, nFives, nOnes)
+			var bills [6]int // hundreds down to ones
+			for i, label := range []string{"Hundreds: ", "Fifties: ", "Twenties: ", "Tens: ", "Fives: ", "Ones: "} {
+				if bills[i], err = utils.TypeInt(label); err != nil {
+					return err
+				}
+			}
+
+			err = api.UnloadATMCash(database, actor, terminal, amount, bills[0], bills[1], bills[2], bills[3], bills[4], bills[5])
 			if err != nil {
 				fmt.Println("ERROR:", err)
 				continue
 			}
 
 		case "4":
-			fmt.Println("Thank you for banking with JP Goldman Stanley!")
-			return
+			if err := reconcileCash(database, actor, terminal); err != nil {
+				return err
+			}
+
+		case "5":
+			if err := reviewCashAlerts(database, actor); err != nil {
+				return err
+			}
+
+		case "6":
+			if err := setOutOfService(database, actor, terminal); err != nil {
+				return err
+			}
+
+		case "7":
+			fmt.Printf("Thank you for banking with %s!\n", store.Config.Branding.BankName)
+			return nil
 		default:
 			fmt.Println("Invalid option, please try again.")
 		}
 	}
+	return nil
 }
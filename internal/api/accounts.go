@@ -0,0 +1,400 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Layout of interest_accrued_to in the accounts table
+const accrualDateLayout = "2006-01-02"
+
+const accountColumns = `
+	SELECT a.id, a.number, a.user_id, u.username, a.type, a.status,
+		a.interest_rate_bp, a.opened_at, a.ledger_code, COALESCE(a.status_reason, ''),
+		COALESCE(a.status_changed_at, ''), COALESCE(a.status_changed_by, ''),
+		(SELECT COALESCE(SUM(d.amount), 0) FROM cheque_deposits d
+			WHERE d.account_id = a.id AND d.status = 'pending')
+	FROM accounts a
+	JOIN users u ON u.id = a.user_id`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// Scans one row of accountColumns, without the balance but with the held funds
+func scanAccount(row scanner) (models.Account, error) {
+	var a models.Account
+	err := row.Scan(&a.ID, &a.Number, &a.UserID, &a.Owner, &a.Type, &a.Status,
+		&a.InterestRate, &a.OpenedAt, &a.Ledger, &a.StatusReason, &a.StatusAt, &a.StatusBy, &a.Held)
+	return a, err
+}
+
+// List a customer's accounts with their balances, oldest first. Customers can
+// list their own; anyone else needs users.view.
+func ListAccounts(db *sql.DB, actor models.Actor, username string) ([]models.Account, error) {
+	if err := authorizeUserRead(db, actor, username); err != nil {
+		return nil, err
+	}
+	return listAccounts(db, username)
+}
+
+func listAccounts(db *sql.DB, username string) ([]models.Account, error) {
+	rows, err := db.Query(accountColumns+` WHERE u.username = ? ORDER BY a.id`, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query accounts: %v", err)
+	}
+
+	var list []models.Account
+	for rows.Next() {
+		a, err := scanAccount(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan account: %v", err)
+		}
+		list = append(list, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Balances are read once the rows are closed, since the pool may only have one connection
+	for i := range list {
+		if list[i].Balance, err = ledgerBalance(db, list[i].Ledger); err != nil {
+			return nil, fmt.Errorf("could not get balance: %v", err)
+		}
+	}
+	return list, nil
+}
+
+// Gets one of a customer's accounts with its balance. An empty number picks
+// the customer's main account: their first checking account that isn't closed.
+// Customers can read their own; anyone else needs users.view.
+func GetAccount(db *sql.DB, actor models.Actor, username, number string) (models.Account, error) {
+	if err := authorizeUserRead(db, actor, username); err != nil {
+		return models.Account{}, err
+	}
+	return customerAccount(db, username, number)
+}
+
+func customerAccount(q dbtx, username, number string) (models.Account, error) {
+	var row *sql.Row
+	if number == "" {
+		row = q.QueryRow(accountColumns+`
+			WHERE u.username = ? AND a.type = ? AND a.status != ?
+			ORDER BY a.id LIMIT 1`, username, models.AccountChecking, models.AccountClosed)
+	} else {
+		// Someone else's account is reported as not found
+		row = q.QueryRow(accountColumns+` WHERE u.username = ? AND a.number = ?`, username, number)
+	}
+
+	a, err := scanAccount(row)
+	if err == sql.ErrNoRows {
+		if number == "" {
+			return models.Account{}, fmt.Errorf("'%s' has no open checking account", username)
+		}
+		return models.Account{}, fmt.Errorf("account %s not found", number)
+	}
+	if err != nil {
+		return models.Account{}, fmt.Errorf("could not get account: %v", err)
+	}
+	if a.Balance, err = ledgerBalance(q, a.Ledger); err != nil {
+		return models.Account{}, fmt.Errorf("could not get balance: %v", err)
+	}
+	return a, nil
+}
+
+// Only open accounts can be debited
+func checkDebit(a models.Account) error {
+	if a.Status != models.AccountOpen {
+		return fmt.Errorf("account %s is %s", a.Number, a.Status)
+	}
+	return nil
+}
+
+// Open and frozen accounts can be credited
+func checkCredit(a models.Account) error {
+	if a.Status == models.AccountClosed {
+		return fmt.Errorf("account %s is closed", a.Number)
+	}
+	return nil
+}
+
+// Picks the id and number for a new account. The number is the type digit
+// (1 checking, 2 savings) followed by the 9-digit account id.
+func nextAccountNumber(tx *sql.Tx, accountType string) (int, string, error) {
+	var id int
+	err := tx.QueryRow(`
+		SELECT COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'accounts'), 0) + 1`).Scan(&id)
+	if err != nil {
+		return 0, "", fmt.Errorf("could not number account: %v", err)
+	}
+	prefix := 1
+	if accountType == models.AccountSavings {
+		prefix = 2
+	}
+	return id, fmt.Sprintf("%d%09d", prefix, id), nil
+}
+
+// Adds an accounts row for a user, backed by the ledger account ledgerCode
+func addAccount(tx *sql.Tx, id int, number string, userID int, accountType string, rateBP int, ledgerCode string) error {
+	_, err := tx.Exec(`
+		INSERT INTO accounts (id, number, user_id, type, ledger_code, interest_rate_bp, opened_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id, number, userID, accountType, ledgerCode, rateBP, time.Now().Format(dbDateLayout))
+	if err != nil {
+		return fmt.Errorf("failed to open account: %v", err)
+	}
+	return nil
+}
+
+// Opens another checking or savings account for a customer on behalf of
+// actor and returns it. rateBP is the yearly interest rate in basis points,
+// which only savings accounts earn.
+func OpenAccount(db *sql.DB, actor models.Actor, username, accountType string, rateBP int) (models.Account, error) {
+	if err := authorize(db, actor, models.PermAccountsOpen); err != nil {
+		return models.Account{}, err
+	}
+	switch accountType {
+	case models.AccountChecking:
+		if rateBP != 0 {
+			return models.Account{}, fmt.Errorf("checking accounts don't earn interest")
+		}
+	case models.AccountSavings:
+		if rateBP < 0 || rateBP > 10000 {
+			return models.Account{}, fmt.Errorf("interest rate must be between 0 and 10000 basis points")
+		}
+	default:
+		return models.Account{}, fmt.Errorf("unknown account type %q", accountType)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return models.Account{}, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var role string
+	err = tx.QueryRow("SELECT id, role FROM users WHERE username = ?", username).Scan(&userID, &role)
+	if err == sql.ErrNoRows {
+		return models.Account{}, fmt.Errorf("no user found with username '%s'", username)
+	}
+	if err != nil {
+		return models.Account{}, fmt.Errorf("could not get user: %v", err)
+	}
+	if role != models.RoleCustomer {
+		return models.Account{}, fmt.Errorf("'%s' is not a customer", username)
+	}
+
+	// The ledger account is named after the account number
+	id, number, err := nextAccountNumber(tx, accountType)
+	if err != nil {
+		return models.Account{}, err
+	}
+	ledgerCode := "ACCT-" + number
+	_, err = tx.Exec(`
+		INSERT INTO ledger_accounts (code, name, type, user_id)
+		VALUES (?, ?, 'customer', ?)`, ledgerCode, username+" "+accountType, userID)
+	if err != nil {
+		return models.Account{}, fmt.Errorf("failed to open ledger account: %v", err)
+	}
+
+	if err := addAccount(tx, id, number, userID, accountType, rateBP, ledgerCode); err != nil {
+		return models.Account{}, err
+	}
+
+	after := map[string]any{"number": number, "type": accountType, "interest_rate_bp": rateBP}
+	if err := recordAudit(tx, actor, AuditAccountOpen, username, nil, after); err != nil {
+		return models.Account{}, err
+	}
+
+	account, err := customerAccount(tx, username, number)
+	if err != nil {
+		return models.Account{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.Account{}, err
+	}
+	return account, nil
+}
+
+// Moves amount between two of a customer's own accounts, made at terminal.
+// Returns the receipt for the account the money came from.
+func TransferBetweenAccounts(db *sql.DB, actor models.Actor, terminal, fromNumber, toNumber string, amount models.Money) (models.Receipt, error) {
+	if err := authorize(db, actor, models.PermTransfer); err != nil {
+		return models.Receipt{}, err
+	}
+	username := actor.Username
+	if amount <= 0 {
+		return models.Receipt{}, fmt.Errorf("transfer amount must be greater than zero")
+	}
+	if fromNumber == toNumber {
+		return models.Receipt{}, fmt.Errorf("cannot transfer to the same account")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return models.Receipt{}, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	from, err := customerAccount(tx, username, fromNumber)
+	if err != nil {
+		return models.Receipt{}, err
+	}
+	to, err := customerAccount(tx, username, toNumber)
+	if err != nil {
+		return models.Receipt{}, err
+	}
+	if err := checkDebit(from); err != nil {
+		return models.Receipt{}, err
+	}
+	if err := checkCredit(to); err != nil {
+		return models.Receipt{}, err
+	}
+	if from.Balance < amount {
+		return models.Receipt{}, fmt.Errorf("not enough in balance to transfer. Current balance: $%s", from.Balance)
+	}
+
+	memo := fmt.Sprintf("transfer from %s to %s", from.Number, to.Number)
+	if err := postTransfer(tx, EntryTransfer, memo, from.Ledger, to.Ledger, amount); err != nil {
+		return models.Receipt{}, fmt.Errorf("failed to post transfer: %v", err)
+	}
+
+	correlationID, err := newCorrelationID()
+	if err != nil {
+		return models.Receipt{}, err
+	}
+	fromBalance := from.Balance - amount
+	toBalance := to.Balance + amount
+	legs := []models.Transaction{
+		{USER_ID: from.UserID, Account: from.Number, Kind: models.KindTransferOut, Amount: -amount, BalanceAfter: &fromBalance, CorrelationID: correlationID, Terminal: terminal},
+		{USER_ID: to.UserID, Account: to.Number, Kind: models.KindTransferIn, Amount: amount, BalanceAfter: &toBalance, CorrelationID: correlationID, Terminal: terminal},
+	}
+	var ids []int64
+	for _, leg := range legs {
+		id, err := logTransaction(tx, leg)
+		if err != nil {
+			return models.Receipt{}, err
+		}
+		ids = append(ids, id)
+	}
+	r, err := receipt(tx, int(ids[0]))
+	if err != nil {
+		return models.Receipt{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Receipt{}, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return r, nil
+}
+
+// Totals from one interest run
+type InterestRun struct {
+	Accounts int          // accounts credited
+	Total    models.Money // interest paid
+}
+
+// Accrues simple daily interest on savings accounts that aren't closed, for
+// each day since their last accrual up to asOf. Interest is paid from the
+// INTEREST_EXPENSE ledger account and rounded down to the cent; while it
+// rounds to nothing the days carry over to the next run. Running it twice for
+// the same day pays nothing the second time.
+func AccrueInterest(db *sql.DB, actor models.Actor, asOf time.Time) (InterestRun, error) {
+	if err := authorize(db, actor, models.PermInterestAccrue); err != nil {
+		return InterestRun{}, err
+	}
+	// Dates are compared as UTC midnights so a DST change can't lose or add a
+	// day
+	asOf = time.Date(asOf.Year(), asOf.Month(), asOf.Day(), 0, 0, 0, 0, time.UTC)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return InterestRun{}, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	type savings struct {
+		account models.Account
+		since   string
+	}
+	rows, err := tx.Query(`
+		SELECT a.id, a.number, a.user_id, a.interest_rate_bp, a.ledger_code,
+			COALESCE(a.interest_accrued_to, substr(a.opened_at, 1, 10))
+		FROM accounts a
+		WHERE a.type = ? AND a.status != ? AND a.interest_rate_bp > 0
+		ORDER BY a.id`, models.AccountSavings, models.AccountClosed)
+	if err != nil {
+		return InterestRun{}, fmt.Errorf("failed to query savings accounts: %v", err)
+	}
+	var due []savings
+	for rows.Next() {
+		var s savings
+		a := &s.account
+		if err := rows.Scan(&a.ID, &a.Number, &a.UserID, &a.InterestRate, &a.Ledger, &s.since); err != nil {
+			rows.Close()
+			return InterestRun{}, fmt.Errorf("failed to scan account: %v", err)
+		}
+		due = append(due, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return InterestRun{}, err
+	}
+
+	var run InterestRun
+	for _, s := range due {
+		since, err := time.Parse(accrualDateLayout, s.since)
+		if err != nil {
+			return InterestRun{}, fmt.Errorf("account %s has a bad accrual date %q", s.account.Number, s.since)
+		}
+		days := int64(asOf.Sub(since) / (24 * time.Hour))
+		if days <= 0 {
+			continue
+		}
+
+		balance, err := ledgerBalance(tx, s.account.Ledger)
+		if err != nil {
+			return InterestRun{}, fmt.Errorf("could not get balance: %v", err)
+		}
+		interest := models.Money(0)
+		if balance > 0 {
+			interest = models.Money(int64(balance) * int64(s.account.InterestRate) * days / (10000 * 365))
+			if interest == 0 {
+				continue
+			}
+
+			memo := fmt.Sprintf("interest on %s for %d days", s.account.Number, days)
+			if err := postTransfer(tx, EntryInterest, memo, InterestAccount, s.account.Ledger, interest); err != nil {
+				return InterestRun{}, err
+			}
+			newBalance := balance + interest
+			_, err = logTransaction(tx, models.Transaction{
+				USER_ID:      s.account.UserID,
+				Account:      s.account.Number,
+				Kind:         models.KindInterest,
+				Amount:       interest,
+				BalanceAfter: &newBalance,
+			})
+			if err != nil {
+				return InterestRun{}, err
+			}
+			run.Accounts++
+			run.Total += interest
+		}
+
+		_, err = tx.Exec("UPDATE accounts SET interest_accrued_to = ? WHERE id = ?", asOf.Format(accrualDateLayout), s.account.ID)
+		if err != nil {
+			return InterestRun{}, fmt.Errorf("failed to update account: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return InterestRun{}, fmt.Errorf("failed to commit interest: %v", err)
+	}
+	return run, nil
+}
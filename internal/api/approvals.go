@@ -0,0 +1,297 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/config"
+	"SPG_ATM_Machine/internal/models"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// What a second admin's approval applies for each kind of request, and the
+// permission both admins need for it. apply runs as the admin who made the
+// request, so the change is audited under their name.
+var approvalKinds = map[string]struct {
+	permission string
+	apply      func(tx *sql.Tx, maker models.Actor, payload []byte) error
+}{
+	AuditWithdrawalLimit: {models.PermLimitsUpdate, func(tx *sql.Tx, maker models.Actor, payload []byte) error {
+		var c atmLimitChange
+		if err := json.Unmarshal(payload, &c); err != nil {
+			return err
+		}
+		return updateATMLimit(tx, maker, AuditWithdrawalLimit, c)
+	}},
+	AuditDepositLimit: {models.PermLimitsUpdate, func(tx *sql.Tx, maker models.Actor, payload []byte) error {
+		var c atmLimitChange
+		if err := json.Unmarshal(payload, &c); err != nil {
+			return err
+		}
+		return updateATMLimit(tx, maker, AuditDepositLimit, c)
+	}},
+	AuditVelocityLimit: {models.PermLimitsUpdate, func(tx *sql.Tx, maker models.Actor, payload []byte) error {
+		var c velocityChange
+		if err := json.Unmarshal(payload, &c); err != nil {
+			return err
+		}
+		return setVelocityLimits(tx, maker, c)
+	}},
+	AuditVelocityReset: {models.PermLimitsUpdate, func(tx *sql.Tx, maker models.Actor, payload []byte) error {
+		var c velocityChange
+		if err := json.Unmarshal(payload, &c); err != nil {
+			return err
+		}
+		c.Field = ""
+		return setVelocityLimits(tx, maker, c)
+	}},
+	AuditUnlockAccount: {models.PermUsersUnlock, func(tx *sql.Tx, maker models.Actor, payload []byte) error {
+		var c unlockChange
+		if err := json.Unmarshal(payload, &c); err != nil {
+			return err
+		}
+		return unlockAccount(tx, maker, c)
+	}},
+	AuditAdjustment: {models.PermAccountsAdjust, func(tx *sql.Tx, maker models.Actor, payload []byte) error {
+		var c adjustment
+		if err := json.Unmarshal(payload, &c); err != nil {
+			return err
+		}
+		return adjustBalance(tx, maker, c)
+	}},
+	AuditCreateUser: {models.PermStaffCreate, func(tx *sql.Tx, maker models.Actor, payload []byte) error {
+		var u newUser
+		if err := json.Unmarshal(payload, &u); err != nil {
+			return err
+		}
+		return createUser(tx, maker, u)
+	}},
+}
+
+// Stores a pending request for kind, to be applied with payload once another
+// admin approves it. It expires after limits.ApprovalExpiryHours.
+func requestApproval(db *sql.DB, actor models.Actor, kind, target, summary string, payload any, limits config.Limits) (models.Approval, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return models.Approval{}, fmt.Errorf("failed to encode request: %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return models.Approval{}, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	makerID, err := userIDByName(tx, actor.Username)
+	if err != nil {
+		return models.Approval{}, err
+	}
+	now := time.Now()
+	expires := now.Add(time.Duration(limits.ApprovalExpiryHours) * time.Hour)
+	res, err := tx.Exec(`
+		INSERT INTO approvals (kind, target, summary, payload, requested_by, requested_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		kind, target, summary, string(encoded), makerID, now.Format(dbDateLayout), expires.Format(dbDateLayout))
+	if err != nil {
+		return models.Approval{}, fmt.Errorf("failed to store request: %v", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return models.Approval{}, err
+	}
+
+	// The payload can hold a PIN hash, so only the summary is logged
+	after := map[string]any{"kind": kind, "target": target, "summary": summary, "expires_at": expires.Format(dbDateLayout)}
+	if err := recordAudit(tx, actor, AuditApprovalRequest, fmt.Sprintf("approval %d", id), nil, after); err != nil {
+		return models.Approval{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.Approval{}, fmt.Errorf("failed to commit request: %v", err)
+	}
+	return getApproval(db, int(id))
+}
+
+const approvalColumns = `
+	SELECT a.id, a.kind, a.target, a.summary, m.username, a.requested_at, a.expires_at,
+		a.status, COALESCE(c.username, ''), COALESCE(a.decided_at, ''), COALESCE(a.note, '')
+	FROM approvals a
+	JOIN users m ON m.id = a.requested_by
+	LEFT JOIN users c ON c.id = a.decided_by`
+
+func scanApproval(row scanner) (models.Approval, error) {
+	var a models.Approval
+	err := row.Scan(&a.ID, &a.Kind, &a.Target, &a.Summary, &a.RequestedBy, &a.RequestedAt, &a.ExpiresAt,
+		&a.Status, &a.DecidedBy, &a.DecidedAt, &a.Note)
+	return a, err
+}
+
+func getApproval(q dbtx, id int) (models.Approval, error) {
+	a, err := scanApproval(q.QueryRow(approvalColumns+" WHERE a.id = ?", id))
+	if err == sql.ErrNoRows {
+		return models.Approval{}, fmt.Errorf("request %d not found", id)
+	}
+	if err != nil {
+		return models.Approval{}, fmt.Errorf("could not get request: %v", err)
+	}
+	return a, nil
+}
+
+// Gets one request, whatever its status
+func GetApproval(db *sql.DB, actor models.Actor, id int) (models.Approval, error) {
+	if err := authorize(db, actor, models.PermApprovalsReview); err != nil {
+		return models.Approval{}, err
+	}
+	if err := expireApprovals(db); err != nil {
+		return models.Approval{}, err
+	}
+	return getApproval(db, id)
+}
+
+// Marks pending requests past their expiry as expired
+func expireApprovals(q dbtx) error {
+	_, err := q.Exec("UPDATE approvals SET status = ? WHERE status = ? AND expires_at <= ?",
+		models.ApprovalExpired, models.ApprovalPending, time.Now().Format(dbDateLayout))
+	if err != nil {
+		return fmt.Errorf("failed to expire requests: %v", err)
+	}
+	return nil
+}
+
+// Lists requests with status, or every request when status is empty, oldest
+// first. Expired requests are marked as such first.
+func ListApprovals(db *sql.DB, actor models.Actor, status string) ([]models.Approval, error) {
+	if err := authorize(db, actor, models.PermApprovalsReview); err != nil {
+		return nil, err
+	}
+	if err := expireApprovals(db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(approvalColumns+` WHERE ? = '' OR a.status = ? ORDER BY a.id`, status, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query requests: %v", err)
+	}
+	defer rows.Close()
+
+	var list []models.Approval
+	for rows.Next() {
+		a, err := scanApproval(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan request: %v", err)
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// Loads a request that can still be decided, checking it hasn't expired
+func pendingApproval(tx *sql.Tx, id int) (models.Approval, []byte, error) {
+	if err := expireApprovals(tx); err != nil {
+		return models.Approval{}, nil, err
+	}
+	a, err := getApproval(tx, id)
+	if err != nil {
+		return models.Approval{}, nil, err
+	}
+	if a.Status != models.ApprovalPending {
+		return models.Approval{}, nil, fmt.Errorf("request %d is %s", id, a.Status)
+	}
+	var payload string
+	if err := tx.QueryRow("SELECT payload FROM approvals WHERE id = ?", id).Scan(&payload); err != nil {
+		return models.Approval{}, nil, fmt.Errorf("could not get request: %v", err)
+	}
+	return a, []byte(payload), nil
+}
+
+func decideApproval(tx *sql.Tx, actor models.Actor, a models.Approval, status, note string) error {
+	checkerID, err := userIDByName(tx, actor.Username)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE approvals SET status = ?, decided_by = ?, decided_at = ?, note = ?
+		WHERE id = ?`, status, checkerID, time.Now().Format(dbDateLayout), nullString(note), a.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update request: %v", err)
+	}
+
+	action := AuditApprovalApprove
+	if status == models.ApprovalRejected {
+		action = AuditApprovalReject
+	}
+	after := map[string]any{"status": status, "kind": a.Kind, "requested_by": a.RequestedBy}
+	if note != "" {
+		after["note"] = note
+	}
+	return recordAudit(tx, actor, action, fmt.Sprintf("approval %d", a.ID), map[string]any{"status": a.Status}, after)
+}
+
+// A second admin approves a request and the change it holds is applied, all
+// in one transaction. The admin who made the request can't approve it, and
+// both admins must still hold the permission the change needs.
+func ApproveRequest(db *sql.DB, actor models.Actor, id int) error {
+	if err := authorize(db, actor, models.PermApprovalsReview); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	a, payload, err := pendingApproval(tx, id)
+	if err != nil {
+		// An expired request stays marked as expired
+		tx.Commit()
+		return err
+	}
+	if a.RequestedBy == actor.Username {
+		return fmt.Errorf("request %d was made by you; another admin must approve it", id)
+	}
+	kind, ok := approvalKinds[a.Kind]
+	if !ok {
+		return fmt.Errorf("request %d has unknown kind %q", id, a.Kind)
+	}
+	if err := authorize(tx, actor, kind.permission); err != nil {
+		return err
+	}
+	role, err := fetchUserRole(tx, a.RequestedBy)
+	if err != nil {
+		return fmt.Errorf("could not get requester's role: %v", err)
+	}
+	maker := models.Actor{Username: a.RequestedBy, Role: role}
+	if err := authorize(tx, maker, kind.permission); err != nil {
+		return fmt.Errorf("the requester can no longer make this change: %w", err)
+	}
+
+	if err := kind.apply(tx, maker, payload); err != nil {
+		return err
+	}
+	if err := decideApproval(tx, actor, a, models.ApprovalApproved, ""); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Refuses a request with a reason. The admin who made it can also withdraw
+// it this way.
+func RejectRequest(db *sql.DB, actor models.Actor, id int, reason string) error {
+	if err := authorize(db, actor, models.PermApprovalsReview); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	a, _, err := pendingApproval(tx, id)
+	if err != nil {
+		tx.Commit()
+		return err
+	}
+	if err := decideApproval(tx, actor, a, models.ApprovalRejected, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
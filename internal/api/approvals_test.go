@@ -0,0 +1,88 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/config"
+	"SPG_ATM_Machine/internal/models"
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestApproveRequest(t *testing.T) {
+	maker := models.Actor{Username: "admin"}
+	checker := models.Actor{Username: "checker"}
+	tests := []struct {
+		name       string
+		decide     func(conn *sql.DB, id int) error
+		wantErr    string
+		wantStatus string
+		wantLimit  models.Money // 0 when the default still applies
+	}{
+		{"second admin approves", func(conn *sql.DB, id int) error {
+			return ApproveRequest(conn, checker, id)
+		}, "", models.ApprovalApproved, models.Dollars(200)},
+		{"maker cannot approve", func(conn *sql.DB, id int) error {
+			return ApproveRequest(conn, maker, id)
+		}, "another admin must approve it", models.ApprovalPending, 0},
+		{"rejected", func(conn *sql.DB, id int) error {
+			if err := RejectRequest(conn, checker, id, "too high"); err != nil {
+				return err
+			}
+			return ApproveRequest(conn, checker, id)
+		}, "is rejected", models.ApprovalRejected, 0},
+		{"expired", func(conn *sql.DB, id int) error {
+			if _, err := conn.Exec("UPDATE approvals SET expires_at = '2000-01-01 00:00:00' WHERE id = ?", id); err != nil {
+				return err
+			}
+			return ApproveRequest(conn, checker, id)
+		}, "is expired", models.ApprovalExpired, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := openWithdrawDB(t, 0, 0)
+			_, err := conn.Exec(`INSERT INTO users (full_name, username, role) VALUES ('Test Checker', 'checker', ?)`, models.RoleAdmin)
+			if err != nil {
+				t.Fatalf("create checker: %v", err)
+			}
+			defaults, err := GetDefaultVelocityLimits(conn)
+			if err != nil {
+				t.Fatalf("get default limits: %v", err)
+			}
+			want := tc.wantLimit
+			if want == 0 {
+				want = defaults.DailyWithdrawal
+			}
+
+			a, err := SetVelocityOverride(conn, maker, "customer", "", LimitDailyWithdrawal, models.Dollars(200), config.Default().Limits)
+			if err != nil {
+				t.Fatalf("request override: %v", err)
+			}
+			if a.Status != models.ApprovalPending {
+				t.Fatalf("new request is %s, want %s", a.Status, models.ApprovalPending)
+			}
+
+			err = tc.decide(conn, a.ID)
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("got error %v, want one containing %q", err, tc.wantErr)
+			}
+
+			got, err := GetApproval(conn, checker, a.ID)
+			if err != nil {
+				t.Fatalf("get request: %v", err)
+			}
+			if got.Status != tc.wantStatus {
+				t.Errorf("request is %s, want %s", got.Status, tc.wantStatus)
+			}
+			limits, err := GetVelocityLimits(conn, maker, "customer", "")
+			if err != nil {
+				t.Fatalf("get limits: %v", err)
+			}
+			if limits.DailyWithdrawal != want {
+				t.Errorf("daily withdrawal limit is $%s, want $%s", limits.DailyWithdrawal, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditCreateUser      = "user.create"
+	AuditUnlockAccount   = "account.unlock"
+	AuditAccountOpen     = "account.open"
+	AuditAccountFreeze   = "account.freeze"
+	AuditAccountUnfreeze = "account.unfreeze"
+	AuditAccountClose    = "account.close"
+	AuditCustomerUpdate  = "user.update"
+	AuditPINChange       = "user.pin.change"
+	AuditPINReset        = "user.pin.reset"
+	AuditWithdrawalLimit = "atm.limit.withdrawal"
+	AuditDepositLimit    = "atm.limit.deposit"
+	AuditVelocityLimit   = "limits.velocity.update"
+	AuditVelocityReset   = "limits.velocity.reset"
+	AuditCashLoad        = "atm.cash.load"
+	AuditCashUnload      = "atm.cash.unload"
+	AuditCashCount       = "atm.cash.count"
+	AuditCashWriteOff    = "atm.cash.write_off"
+	AuditCashCountReject = "atm.cash.count.reject"
+	AuditTerminalCreate  = "terminal.create"
+	AuditTerminalAssign  = "terminal.assign"
+	AuditTerminalRemove  = "terminal.unassign"
+	AuditCassetteUpdate  = "atm.cassette.update"
+	AuditServiceStatus   = "atm.service"
+	AuditCardIssue       = "card.issue"
+	AuditCardExpiry      = "card.expiry"
+	AuditCardRevoke      = "card.revoke"
+	AuditAdjustment      = "account.adjust"
+	AuditApprovalRequest = "approval.request"
+	AuditApprovalApprove = "approval.approve"
+	AuditApprovalReject  = "approval.reject"
+	AuditReceiptReprint  = "receipt.reprint"
+	AuditChequeAccept    = "deposit.cheque.accept"
+	AuditChequeReject    = "deposit.cheque.reject"
+)
+
+// Hash of an entry's contents and the hash before it
+func auditHash(e models.AuditEntry) string {
+	// A JSON array keeps field boundaries unambiguous
+	fields, _ := json.Marshal([]string{e.PrevHash, e.At, e.Actor, e.Role, e.Action, e.Target, e.Before, e.After})
+	sum := sha256.Sum256(fields)
+	return hex.EncodeToString(sum[:])
+}
+
+// Encodes before/after values for the log; nil is recorded as ""
+func auditValue(v any) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit value: %v", err)
+	}
+	return string(b), nil
+}
+
+// Appends an entry to the audit log. It runs inside the caller's transaction
+// so the entry is written if and only if the action is; the transaction's
+// write lock also keeps two entries from chaining off the same hash.
+func recordAudit(tx *sql.Tx, actor models.Actor, action, target string, before, after any) error {
+	e := models.AuditEntry{
+		At:     time.Now().Format(dbDateLayout),
+		Actor:  actor.Username,
+		Role:   actor.Role,
+		Action: action,
+		Target: target,
+	}
+	var err error
+	if e.Before, err = auditValue(before); err != nil {
+		return err
+	}
+	if e.After, err = auditValue(after); err != nil {
+		return err
+	}
+
+	err = tx.QueryRow("SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1").Scan(&e.PrevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read audit log: %v", err)
+	}
+	e.Hash = auditHash(e)
+
+	_, err = tx.Exec(`
+		INSERT INTO audit_log (at, actor, role, action, target, before, after, prev_hash, hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.At, e.Actor, e.Role, e.Action, e.Target, e.Before, e.After, e.PrevHash, e.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	return nil
+}
+
+func listAudit(db *sql.DB, query string, args ...any) ([]models.AuditEntry, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var e models.AuditEntry
+		err := rows.Scan(&e.ID, &e.At, &e.Actor, &e.Role, &e.Action, &e.Target, &e.Before, &e.After, &e.PrevHash, &e.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %v", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// The most recent n audit entries, oldest first
+func GetAuditLog(db *sql.DB, actor models.Actor, n int) ([]models.AuditEntry, error) {
+	if err := authorize(db, actor, models.PermAuditView); err != nil {
+		return nil, err
+	}
+	return listAudit(db, `
+		SELECT * FROM (
+			SELECT id, at, actor, role, action, target, before, after, prev_hash, hash
+			FROM audit_log ORDER BY id DESC LIMIT ?
+		) ORDER BY id ASC`, n)
+}
+
+// Walks the whole audit log recomputing each entry's hash and checking it
+// links to the entry before. Returns how many entries were checked, and an
+// error naming the first entry that was altered or whose predecessor is missing.
+func VerifyAuditLog(db *sql.DB, actor models.Actor) (int, error) {
+	if err := authorize(db, actor, models.PermAuditView); err != nil {
+		return 0, err
+	}
+	entries, err := listAudit(db, `
+		SELECT id, at, actor, role, action, target, before, after, prev_hash, hash
+		FROM audit_log ORDER BY id ASC`)
+	if err != nil {
+		return 0, err
+	}
+
+	prev := ""
+	for i, e := range entries {
+		if e.PrevHash != prev {
+			return i, fmt.Errorf("audit entry %d does not follow the entry before it; an entry was removed or reordered", e.ID)
+		}
+		if auditHash(e) != e.Hash {
+			return i, fmt.Errorf("audit entry %d has been altered", e.ID)
+		}
+		prev = e.Hash
+	}
+	return len(entries), nil
+}
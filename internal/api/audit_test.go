@@ -0,0 +1,60 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"strings"
+	"testing"
+)
+
+func TestVerifyAuditLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  string // SQL run against the log, past its triggers, before verifying
+		checked int
+		wantErr string
+	}{
+		{"untouched", "", 3, ""},
+		{"altered entry", "UPDATE audit_log SET target = 'someone else' WHERE id = 2", 1, "audit entry 2 has been altered"},
+		{"altered hash", "UPDATE audit_log SET hash = 'ff' WHERE id = 2", 1, "audit entry 2 has been altered"},
+		{"removed entry", "DELETE FROM audit_log WHERE id = 2", 1, "audit entry 3 does not follow"},
+		// The chain alone can't tell a truncated log from a shorter one
+		{"removed last entry", "DELETE FROM audit_log WHERE id = 3", 2, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Creating the customer writes the first entry
+			conn := openWithdrawDB(t, 0, 0)
+			admin := models.Actor{Username: "admin"}
+			for _, username := range []string{"second", "third"} {
+				if err := CreateUser(conn, admin, "Test Customer", "2000-01-01", "1234", 0, username); err != nil {
+					t.Fatalf("create %s: %v", username, err)
+				}
+			}
+			if tc.tamper != "" {
+				// Someone with the database file can drop the triggers that
+				// keep the log append-only; the hash chain still catches them
+				_, err := conn.Exec("DROP TRIGGER audit_log_no_update; DROP TRIGGER audit_log_no_delete")
+				if err != nil {
+					t.Fatalf("drop triggers: %v", err)
+				}
+				if _, err := conn.Exec(tc.tamper); err != nil {
+					t.Fatalf("tamper: %v", err)
+				}
+			}
+
+			checked, err := VerifyAuditLog(conn, admin)
+			if checked != tc.checked {
+				t.Errorf("checked %d entries, want %d", checked, tc.checked)
+			}
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
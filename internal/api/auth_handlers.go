@@ -1,21 +1,33 @@
 package api
 
 import (
+	"SPG_ATM_Machine/internal/config"
+	"SPG_ATM_Machine/internal/models"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 type UserAuthInfo struct {
 	PINHash        string
 	FailedAttempts int
 	Locked         bool
+	LockedUntil    string // end of a temporary lockout, if any
+	Lockouts       int    // lockouts since the last good PIN
 	Role           string
 }
 
 func FetchUserRole(conn *sql.DB, username string) (string, error) {
+	return fetchUserRole(conn, username)
+}
+
+func fetchUserRole(q dbtx, username string) (string, error) {
 	var role string
-	stmt, err := conn.Prepare("SELECT role FROM users WHERE username = ?")
+	stmt, err := q.Prepare("SELECT role FROM users WHERE username = ?")
 	if err != nil {
 		return "", err
 	}
@@ -25,9 +37,17 @@ func FetchUserRole(conn *sql.DB, username string) (string, error) {
 	return strings.ToLower(role), err
 }
 
-func GetUserAuth(db *sql.DB, username string) (*UserAuthInfo, error) {
-	stmt, err := db.Prepare(`
-		SELECT pin, failed_attempts, locked, role
+// Gets a user's PIN hash and lock state for an admin looking into a lockout
+func GetUserAuth(db *sql.DB, actor models.Actor, username string) (*UserAuthInfo, error) {
+	if err := authorize(db, actor, models.PermUsersUnlock); err != nil {
+		return nil, err
+	}
+	return getUserAuth(db, username)
+}
+
+func getUserAuth(q dbtx, username string) (*UserAuthInfo, error) {
+	stmt, err := q.Prepare(`
+		SELECT pin, failed_attempts, locked, COALESCE(locked_until, ''), lockout_count, role
 		FROM users
 		WHERE username = ?
 	`)
@@ -39,7 +59,7 @@ func GetUserAuth(db *sql.DB, username string) (*UserAuthInfo, error) {
 	var info UserAuthInfo
 	var lockedInt int
 
-	err = stmt.QueryRow(username).Scan(&info.PINHash, &info.FailedAttempts, &lockedInt, &info.Role)
+	err = stmt.QueryRow(username).Scan(&info.PINHash, &info.FailedAttempts, &lockedInt, &info.LockedUntil, &info.Lockouts, &info.Role)
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("user not found")
 	} else if err != nil {
@@ -51,53 +71,292 @@ func GetUserAuth(db *sql.DB, username string) (*UserAuthInfo, error) {
 	return &info, nil
 }
 
-// Increments the failed attempts for a user.
-func IncrementFailedAttempts(db *sql.DB, username string) (int, bool, error) {
-	stmt, err := db.Prepare("SELECT failed_attempts FROM users WHERE username = ?")
+// Who an unlock approval is for
+type unlockChange struct {
+	Username string `json:"username"`
+}
+
+// Asks for a user's account to be unlocked. It is unlocked once another admin
+// approves it.
+func UnlockAccount(db *sql.DB, actor models.Actor, username string, limits config.Limits) (models.Approval, error) {
+	if err := authorize(db, actor, models.PermUsersUnlock); err != nil {
+		return models.Approval{}, err
+	}
+	info, err := getUserAuth(db, username)
 	if err != nil {
-		return 0, false, err
+		return models.Approval{}, fmt.Errorf("no user found with username '%s'", username)
+	}
+
+	state := fmt.Sprintf("%d failed attempts", info.FailedAttempts)
+	switch {
+	case info.Locked:
+		state = "locked"
+	case info.LockedUntil != "":
+		state = "locked until " + info.LockedUntil
+	}
+	summary := fmt.Sprintf("unlock '%s' (%s)", username, state)
+	return requestApproval(db, actor, AuditUnlockAccount, username, summary, unlockChange{Username: username}, limits)
+}
+
+// Resets failed attempts and lockouts and unlocks a user's account inside tx
+func unlockAccount(tx *sql.Tx, actor models.Actor, change unlockChange) error {
+	username := change.Username
+
+	// Check the user exists, noting their state for the audit log
+	var attempts, locked, lockouts int
+	var lockedUntil string
+	err := tx.QueryRow(`
+		SELECT failed_attempts, locked, COALESCE(locked_until, ''), lockout_count
+		FROM users WHERE username = ?`, username).Scan(&attempts, &locked, &lockedUntil, &lockouts)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("no user found with username '%s'", username)
+	} else if err != nil {
+		return fmt.Errorf("database error checking user existence: %v", err)
+	}
+
+	// Reset failed attempts and unlock
+	_, err = tx.Exec(`
+		UPDATE users SET failed_attempts = 0, locked = 0, locked_until = NULL, lockout_count = 0
+		WHERE username = ?`, username)
+	if err != nil {
+		return fmt.Errorf("failed to unlock account for '%s': %v", username, err)
+	}
+	if locked == 1 || lockedUntil != "" {
+		if err := recordLockEvent(tx, username, models.UnlockAdmin, "", actor.Username, ""); err != nil {
+			return err
+		}
+	}
+
+	before := map[string]any{"failed_attempts": attempts, "locked": locked == 1, "locked_until": lockedUntil, "lockouts": lockouts}
+	after := map[string]any{"failed_attempts": 0, "locked": false, "locked_until": "", "lockouts": 0}
+	return recordAudit(tx, actor, AuditUnlockAccount, username, before, after)
+}
+
+var (
+	ErrAccountLocked     = errors.New("account is locked")
+	ErrTemporarilyLocked = errors.New("account is temporarily locked")
+	ErrInvalidPIN        = errors.New("invalid login")
+	ErrTooManyAttempts   = errors.New("too many failed attempts, account locked")
+	ErrLoginThrottled    = errors.New("too many rejected cards at this terminal")
+)
+
+// Checks a PIN for a user at terminal. limits.MaxPINAttempts wrong PINs in a
+// row lock the account for a while, each lockout twice as long as the one
+// before, and limits.MaxLockouts lockouts in a row lock it until an admin
+// unlocks it. A wrong PIN returns an error wrapping ErrInvalidPIN with the
+// attempt count; a lockout one wrapping ErrTemporarilyLocked with the time it
+// ends. A lockout that has run out is lifted.
+func CheckPIN(db *sql.DB, terminal, username, pin string, limits config.Limits) error {
+	userInfo, err := getUserAuth(db, username)
+	if err != nil {
+		return ErrInvalidPIN
+	}
+	if userInfo.Locked {
+		return ErrAccountLocked
+	}
+
+	if userInfo.LockedUntil != "" {
+		until, err := time.ParseInLocation(dbDateLayout, userInfo.LockedUntil, time.Local)
+		if err != nil {
+			return fmt.Errorf("invalid lockout time: %v", err)
+		}
+		if time.Now().Before(until) {
+			return fmt.Errorf("%w until %s", ErrTemporarilyLocked, userInfo.LockedUntil)
+		}
+		if err := liftLockout(db, terminal, username); err != nil {
+			return err
+		}
 	}
-	defer stmt.Close()
 
-	var attempts int
-	if err := stmt.QueryRow(username).Scan(&attempts); err != nil {
-		return 0, false, err
+	if bcrypt.CompareHashAndPassword([]byte(userInfo.PINHash), []byte(pin)) != nil {
+		return recordFailedPIN(db, terminal, username, limits)
+	}
+
+	_, err = db.Exec("UPDATE users SET failed_attempts = 0, lockout_count = 0 WHERE username = ?", username)
+	if err != nil {
+		return fmt.Errorf("could not reset failed attempts: %v", err)
+	}
+	return nil
+}
+
+// Counts a wrong PIN, locking the account once there are too many
+func recordFailedPIN(db *sql.DB, terminal, username string, limits config.Limits) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var attempts, lockouts int
+	err = tx.QueryRow("SELECT failed_attempts, lockout_count FROM users WHERE username = ?", username).Scan(&attempts, &lockouts)
+	if err != nil {
+		return fmt.Errorf("could not record failed attempt: %v", err)
 	}
 
 	attempts++
-	locked := false
-	if attempts >= 3 {
-		locked = true
-		_, err = db.Exec("UPDATE users SET failed_attempts = ?, locked = 1 WHERE username = ?", attempts, username)
-	} else {
-		_, err = db.Exec("UPDATE users SET failed_attempts = ? WHERE username = ?", attempts, username)
+	if attempts < limits.MaxPINAttempts {
+		if _, err := tx.Exec("UPDATE users SET failed_attempts = ? WHERE username = ?", attempts, username); err != nil {
+			return fmt.Errorf("could not record failed attempt: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w (%d/%d attempts)", ErrInvalidPIN, attempts, limits.MaxPINAttempts)
 	}
-	return attempts, locked, err
+
+	lockouts++
+	if lockouts >= limits.MaxLockouts {
+		_, err := tx.Exec(`
+			UPDATE users SET failed_attempts = ?, lockout_count = ?, locked = 1, locked_until = NULL
+			WHERE username = ?`, attempts, lockouts, username)
+		if err != nil {
+			return fmt.Errorf("could not lock account: %v", err)
+		}
+		if err := recordLockEvent(tx, username, models.LockPermanent, "", models.SystemActor, terminal); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return ErrTooManyAttempts
+	}
+
+	until := time.Now().Add(lockoutDuration(lockouts, limits)).Format(dbDateLayout)
+	_, err = tx.Exec("UPDATE users SET failed_attempts = 0, lockout_count = ?, locked_until = ? WHERE username = ?",
+		lockouts, until, username)
+	if err != nil {
+		return fmt.Errorf("could not lock account: %v", err)
+	}
+	if err := recordLockEvent(tx, username, models.LockTemporary, until, models.SystemActor, terminal); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w until %s", ErrTemporarilyLocked, until)
 }
 
-// Resets failed attempts to 0 for the user.
-func ResetFailedAttempts(db *sql.DB, username string) error {
-	_, err := db.Exec("UPDATE users SET failed_attempts = 0 WHERE username = ?", username)
-	return err
+// The nth lockout in a row lasts twice as long as the one before it
+func lockoutDuration(n int, limits config.Limits) time.Duration {
+	d := time.Duration(limits.LockoutSeconds) * time.Second
+	max := time.Duration(limits.MaxLockoutSeconds) * time.Second
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
 }
 
-// Resets failed attempts and unlocks a user's account
-func UnlockAccount(db *sql.DB, username string) error {
-	// Check if user exists
-	var exists int
-	err := db.QueryRow("SELECT COUNT(1) FROM users WHERE username = ?", username).Scan(&exists)
+// Ends a temporary lockout that has run out. The lockout count is kept, so
+// the next lockout lasts longer.
+func liftLockout(db *sql.DB, terminal, username string) error {
+	tx, err := db.Begin()
 	if err != nil {
-		return fmt.Errorf("database error checking user existence: %v", err)
+		return fmt.Errorf("failed to start transaction: %v", err)
 	}
-	if exists == 0 {
-		return fmt.Errorf("no user found with username '%s'", username)
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE users SET locked_until = NULL, failed_attempts = 0
+		WHERE username = ? AND locked_until IS NOT NULL`, username)
+	if err != nil {
+		return fmt.Errorf("could not lift lockout: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		if err := recordLockEvent(tx, username, models.UnlockAuto, "", models.SystemActor, terminal); err != nil {
+			return err
+		}
 	}
+	return tx.Commit()
+}
 
-	// Reset failed attempts and unlock
-	_, err = db.Exec("UPDATE users SET failed_attempts = 0, locked = 0 WHERE username = ?", username)
+func recordLockEvent(q dbtx, username, event, lockedUntil, actor, terminal string) error {
+	_, err := q.Exec(`
+		INSERT INTO lock_events (at, user_id, event, locked_until, actor, terminal_id)
+		SELECT ?, id, ?, ?, ?, ? FROM users WHERE username = ?`,
+		time.Now().Format(dbDateLayout), event, nullString(lockedUntil), actor, nullString(terminal), username)
 	if err != nil {
-		return fmt.Errorf("failed to unlock account for '%s': %v", username, err)
+		return fmt.Errorf("failed to record lock event: %v", err)
 	}
+	return nil
+}
 
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// Gets the latest n lock and unlock events for a user, newest first
+func GetLockHistory(db *sql.DB, actor models.Actor, username string, n int) ([]models.LockEvent, error) {
+	if err := authorize(db, actor, models.PermUsersUnlock); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`
+		SELECT e.id, e.at, u.username, e.event, COALESCE(e.locked_until, ''), e.actor, COALESCE(e.terminal_id, '')
+		FROM lock_events e
+		JOIN users u ON u.id = e.user_id
+		WHERE u.username = ?
+		ORDER BY e.id DESC
+		LIMIT ?`, username, n)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query lock history: %v", err)
+	}
+	defer rows.Close()
+
+	var events []models.LockEvent
+	for rows.Next() {
+		var e models.LockEvent
+		if err := rows.Scan(&e.ID, &e.At, &e.Username, &e.Event, &e.LockedUntil, &e.Actor, &e.Terminal); err != nil {
+			return nil, fmt.Errorf("failed to read lock event: %v", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Fails with ErrLoginThrottled while terminal has rejected
+// limits.UnknownCardAttempts cards within the last
+// limits.UnknownCardWindowSeconds
+func CheckLoginThrottle(db *sql.DB, terminal string, limits config.Limits) error {
+	window := time.Duration(limits.UnknownCardWindowSeconds) * time.Second
+	since := time.Now().Add(-window).Format(dbDateLayout)
+
+	// The oldest of the latest rejections decides when the terminal reopens
+	var n int
+	var oldest sql.NullString
+	err := db.QueryRow(`
+		SELECT COUNT(*), MIN(at) FROM (
+			SELECT at FROM login_failures
+			WHERE terminal_id = ? AND at > ?
+			ORDER BY at DESC
+			LIMIT ?)`, terminal, since, limits.UnknownCardAttempts).Scan(&n, &oldest)
+	if err != nil {
+		return fmt.Errorf("could not check login throttle: %v", err)
+	}
+	if n < limits.UnknownCardAttempts {
+		return nil
+	}
+
+	at, err := time.ParseInLocation(dbDateLayout, oldest.String, time.Local)
+	if err != nil {
+		return fmt.Errorf("invalid login failure time: %v", err)
+	}
+	return fmt.Errorf("%w, try again after %s", ErrLoginThrottled, at.Add(window).Format(dbDateLayout))
+}
+
+// Records a card that terminal rejected at login. Rejections older than the
+// throttling window are dropped.
+func RecordRejectedCard(db *sql.DB, terminal, reason string, limits config.Limits) error {
+	now := time.Now()
+	since := now.Add(-time.Duration(limits.UnknownCardWindowSeconds) * time.Second).Format(dbDateLayout)
+	if _, err := db.Exec("DELETE FROM login_failures WHERE at <= ?", since); err != nil {
+		return fmt.Errorf("failed to prune login failures: %v", err)
+	}
+	_, err := db.Exec("INSERT INTO login_failures (terminal_id, at, reason) VALUES (?, ?, ?)",
+		terminal, now.Format(dbDateLayout), reason)
+	if err != nil {
+		return fmt.Errorf("failed to record login failure: %v", err)
+	}
 	return nil
 }
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/config"
+	"SPG_ATM_Machine/internal/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	limits := config.Limits{LockoutSeconds: 60, MaxLockoutSeconds: 600}
+	tests := []struct {
+		n    int
+		want time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{4, 8 * time.Minute},
+		// Capped at MaxLockoutSeconds
+		{5, 10 * time.Minute},
+		{50, 10 * time.Minute},
+	}
+	for _, tc := range tests {
+		if got := lockoutDuration(tc.n, limits); got != tc.want {
+			t.Errorf("lockoutDuration(%d) = %v, want %v", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestCheckPINLockouts(t *testing.T) {
+	conn := openWithdrawDB(t, 0, 0)
+	limits := config.Limits{MaxPINAttempts: 3, LockoutSeconds: 60, MaxLockoutSeconds: 600, MaxLockouts: 3}
+
+	// Each round is MaxPINAttempts wrong PINs in a row, after the previous
+	// lockout has run out
+	rounds := []struct {
+		name    string
+		wantErr error
+		lockFor time.Duration // 0 when the account is locked for good
+	}{
+		{"first lockout", ErrTemporarilyLocked, time.Minute},
+		{"second lockout doubles", ErrTemporarilyLocked, 2 * time.Minute},
+		{"third lockout is permanent", ErrTooManyAttempts, 0},
+	}
+	for _, round := range rounds {
+		t.Run(round.name, func(t *testing.T) {
+			_, err := conn.Exec("UPDATE users SET locked_until = NULL WHERE username = 'customer'")
+			if err != nil {
+				t.Fatalf("end lockout: %v", err)
+			}
+			for i := 1; i < limits.MaxPINAttempts; i++ {
+				if err := CheckPIN(conn, testTerminal, "customer", "0000", limits); !errors.Is(err, ErrInvalidPIN) {
+					t.Fatalf("wrong PIN %d: got %v, want %v", i, err, ErrInvalidPIN)
+				}
+			}
+			start := time.Now()
+			if err := CheckPIN(conn, testTerminal, "customer", "0000", limits); !errors.Is(err, round.wantErr) {
+				t.Fatalf("last wrong PIN: got %v, want %v", err, round.wantErr)
+			}
+
+			info, err := getUserAuth(conn, "customer")
+			if err != nil {
+				t.Fatalf("get user: %v", err)
+			}
+			if round.lockFor == 0 {
+				if !info.Locked {
+					t.Errorf("account is not locked")
+				}
+				return
+			}
+			until, err := time.ParseInLocation(dbDateLayout, info.LockedUntil, time.Local)
+			if err != nil {
+				t.Fatalf("parse locked_until %q: %v", info.LockedUntil, err)
+			}
+			// locked_until is stored to the second
+			if d := until.Sub(start); d < round.lockFor-2*time.Second || d > round.lockFor+time.Second {
+				t.Errorf("locked for %v, want %v", d, round.lockFor)
+			}
+			if err := CheckPIN(conn, testTerminal, "customer", "1234", limits); !errors.Is(err, ErrTemporarilyLocked) {
+				t.Errorf("right PIN while locked: got %v, want %v", err, ErrTemporarilyLocked)
+			}
+		})
+	}
+
+	var events int
+	err := conn.QueryRow("SELECT COUNT(*) FROM lock_events WHERE event IN (?, ?)", models.LockTemporary, models.LockPermanent).Scan(&events)
+	if err != nil {
+		t.Fatalf("count lock events: %v", err)
+	}
+	if events != len(rounds) {
+		t.Errorf("recorded %d lock events, want %d", events, len(rounds))
+	}
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// How long a newly issued card is valid for
+const cardValidYears = 3
+
+const cardColumns = `
+	SELECT c.id, c.card_id, c.pan, c.expiry, c.issuer, c.user_id, COALESCE(u.username, ''),
+		c.revoked, c.issued_at
+	FROM cards c
+	LEFT JOIN users u ON u.id = c.user_id`
+
+func scanCard(row interface{ Scan(...any) error }) (models.Card, error) {
+	var c models.Card
+	var revoked int
+	err := row.Scan(&c.ID, &c.CardID, &c.PAN, &c.Expiry, &c.Issuer, &c.UserID, &c.Username, &revoked, &c.IssuedAt)
+	c.Revoked = revoked == 1
+	return c, err
+}
+
+// Issue a new card to a user, valid for three years
+func IssueCard(db *sql.DB, actor models.Actor, username string) (models.Card, error) {
+	if err := authorize(db, actor, models.PermCardsManage); err != nil {
+		return models.Card{}, err
+	}
+	userID, err := GetUserID(db, username)
+	if err != nil {
+		return models.Card{}, fmt.Errorf("no user found with username '%s'", username)
+	}
+
+	cardID, pan, err := models.NewCardNumbers()
+	if err != nil {
+		return models.Card{}, err
+	}
+	card := models.Card{
+		CardID:   cardID,
+		PAN:      pan,
+		Expiry:   time.Now().AddDate(cardValidYears, 0, 0).Format("01/06"),
+		Issuer:   models.CardIssuer,
+		UserID:   userID,
+		Username: username,
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return models.Card{}, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO cards (card_id, pan, expiry, issuer, user_id, issued_at)
+		VALUES (?, ?, ?, ?, ?, datetime('now', 'localtime'))`,
+		card.CardID, card.PAN, card.Expiry, card.Issuer, card.UserID)
+	if err != nil {
+		return models.Card{}, fmt.Errorf("failed to issue card: %v", err)
+	}
+
+	// The log only ever sees the masked PAN
+	after := map[string]string{"card_id": card.CardID, "pan": card.MaskedPAN(), "expiry": card.Expiry}
+	if err := recordAudit(tx, actor, AuditCardIssue, username, nil, after); err != nil {
+		return models.Card{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Card{}, fmt.Errorf("failed to issue card: %v", err)
+	}
+	return card, nil
+}
+
+// Get an issued card by its card id
+func GetCard(db *sql.DB, actor models.Actor, cardID string) (models.Card, error) {
+	if err := authorize(db, actor, models.PermCardsManage); err != nil {
+		return models.Card{}, err
+	}
+	return getCard(db, cardID)
+}
+
+func getCard(q dbtx, cardID string) (models.Card, error) {
+	card, err := scanCard(q.QueryRow(cardColumns+" WHERE c.card_id = ?", cardID))
+	if err == sql.ErrNoRows {
+		return models.Card{}, fmt.Errorf("no card found with id '%s'", cardID)
+	}
+	return card, err
+}
+
+// List the cards issued to a user
+func ListCards(db *sql.DB, actor models.Actor, username string) ([]models.Card, error) {
+	if err := authorize(db, actor, models.PermCardsManage); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(cardColumns+" WHERE u.username = ? ORDER BY c.id", username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cards []models.Card
+	for rows.Next() {
+		card, err := scanCard(rows)
+		if err != nil {
+			return nil, err
+		}
+		cards = append(cards, card)
+	}
+	return cards, rows.Err()
+}
+
+// Checks a card read at the ATM against the issued card it claims to be and
+// returns the username it is bound to
+func VerifyCard(db *sql.DB, presented models.Card) (string, error) {
+	issued, err := getCard(db, presented.CardID)
+	if err != nil {
+		return "", fmt.Errorf("card not recognised")
+	}
+	if issued.PAN != presented.PAN || issued.Expiry != presented.Expiry || issued.Issuer != presented.Issuer {
+		return "", fmt.Errorf("card not recognised")
+	}
+	if issued.Revoked {
+		return "", fmt.Errorf("card has been reported lost or stolen")
+	}
+	if issued.Expired(time.Now()) {
+		return "", fmt.Errorf("card expired %s", issued.Expiry)
+	}
+	if issued.Username == "" {
+		return "", fmt.Errorf("card is not bound to an account")
+	}
+	return issued.Username, nil
+}
+
+// Change a card's expiry date (MM/YY). The card file must be reissued to match.
+func SetCardExpiry(db *sql.DB, actor models.Actor, cardID, expiry string) error {
+	if err := authorize(db, actor, models.PermCardsManage); err != nil {
+		return err
+	}
+	if _, err := models.ParseCardExpiry(expiry); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	card, err := scanCard(tx.QueryRow(cardColumns+" WHERE c.card_id = ?", cardID))
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("no card found with id '%s'", cardID)
+	} else if err != nil {
+		return fmt.Errorf("failed to fetch card: %v", err)
+	}
+
+	if _, err := tx.Exec("UPDATE cards SET expiry = ? WHERE card_id = ?", expiry, cardID); err != nil {
+		return fmt.Errorf("failed to update card expiry: %v", err)
+	}
+	err = recordAudit(tx, actor, AuditCardExpiry, cardID, map[string]string{"expiry": card.Expiry}, map[string]string{"expiry": expiry})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Hot-list a card so it can no longer be used
+func RevokeCard(db *sql.DB, actor models.Actor, cardID string) error {
+	if err := authorize(db, actor, models.PermCardsManage); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE cards SET revoked = 1, revoked_at = datetime('now', 'localtime')
+		WHERE card_id = ? AND revoked = 0`, cardID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke card: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("no active card found with id '%s'", cardID)
+	}
+
+	err = recordAudit(tx, actor, AuditCardRevoke, cardID, map[string]bool{"revoked": false}, map[string]bool{"revoked": true})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
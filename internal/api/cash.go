@@ -0,0 +1,555 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrCountInProgress = errors.New("a cash count is in progress")
+
+// Records cash a staff member moved in or out of a terminal's cassettes.
+// notes are signed changes ordered like Denominations.
+func recordCashEvent(tx *sql.Tx, terminal, handler, kind string, notes []int, countID int, approvedBy string) error {
+	handlerID, err := userIDByName(tx, handler)
+	if err != nil {
+		return err
+	}
+	var approver any
+	if approvedBy != "" {
+		id, err := userIDByName(tx, approvedBy)
+		if err != nil {
+			return err
+		}
+		approver = id
+	}
+	var count any
+	if countID != 0 {
+		count = countID
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO cash_events (at, terminal_id, handler_id, kind, ones, fives, tens, twenties, fifties, hundreds, amount, count_id, approved_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		time.Now().Format(dbDateLayout), terminal, handlerID, kind,
+		notes[0], notes[1], notes[2], notes[3], notes[4], notes[5],
+		denominationTotal(notes), count, approver)
+	if err != nil {
+		return fmt.Errorf("failed to record cash event: %v", err)
+	}
+	return nil
+}
+
+func userIDByName(q dbtx, username string) (int, error) {
+	var id int
+	if err := q.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&id); err != nil {
+		return 0, fmt.Errorf("could not get user id: %v", err)
+	}
+	return id, nil
+}
+
+// Gets the most recent cash events across every terminal, newest first
+func GetCashEvents(db *sql.DB, actor models.Actor, n int) ([]models.CashEvent, error) {
+	if err := authorize(db, actor, models.PermCashReview); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`
+		SELECT e.id, e.at, COALESCE(e.terminal_id, ''), u.username, e.kind,
+			e.ones, e.fives, e.tens, e.twenties, e.fifties, e.hundreds,
+			e.amount, COALESCE(e.count_id, 0), COALESCE(a.username, '')
+		FROM cash_events e
+		JOIN users u ON u.id = e.handler_id
+		LEFT JOIN users a ON a.id = e.approved_by
+		ORDER BY e.id DESC
+		LIMIT ?`, n)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query cash events: %v", err)
+	}
+	defer rows.Close()
+
+	var events []models.CashEvent
+	for rows.Next() {
+		e := models.CashEvent{Notes: make([]int, len(Denominations))}
+		err := rows.Scan(&e.ID, &e.At, &e.Terminal, &e.Handler, &e.Kind,
+			&e.Notes[0], &e.Notes[1], &e.Notes[2], &e.Notes[3], &e.Notes[4], &e.Notes[5],
+			&e.Amount, &e.CountID, &e.ApprovedBy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cash event: %v", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Fails with ErrCountInProgress while a count of terminal is open or waiting
+// for review, since moving cash would make the count meaningless
+func checkNoActiveCount(q dbtx, terminal string) error {
+	var n int
+	err := q.QueryRow("SELECT COUNT(*) FROM cash_counts WHERE terminal_id = ? AND status IN (?, ?)",
+		terminal, models.CountOpen, models.CountPending).Scan(&n)
+	if err != nil {
+		return fmt.Errorf("could not check cash counts: %v", err)
+	}
+	if n > 0 {
+		return ErrCountInProgress
+	}
+	return nil
+}
+
+func getCashCount(q dbtx, id int) (models.CashCount, error) {
+	var c models.CashCount
+	var submitted, reviewedAt sql.NullString
+	err := q.QueryRow(`
+		SELECT c.id, COALESCE(c.terminal_id, ''), u.username, c.status, c.started_at, c.submitted_at,
+			COALESCE(r.username, ''), c.reviewed_at
+		FROM cash_counts c
+		JOIN users u ON u.id = c.handler_id
+		LEFT JOIN users r ON r.id = c.reviewed_by
+		WHERE c.id = ?`, id).Scan(&c.ID, &c.Terminal, &c.Handler, &c.Status, &c.StartedAt, &submitted, &c.ReviewedBy, &reviewedAt)
+	if err == sql.ErrNoRows {
+		return c, fmt.Errorf("cash count %d not found", id)
+	}
+	if err != nil {
+		return c, fmt.Errorf("could not get cash count: %v", err)
+	}
+	c.SubmittedAt = submitted.String
+	c.ReviewedAt = reviewedAt.String
+
+	rows, err := q.Query(`
+		SELECT denomination, expected, counted FROM cash_count_notes
+		WHERE count_id = ? ORDER BY denomination`, id)
+	if err != nil {
+		return c, fmt.Errorf("could not get counted notes: %v", err)
+	}
+	defer rows.Close()
+
+	c.Expected = make([]int, len(Denominations))
+	counted := make([]int, len(Denominations))
+	hasCounts := false
+	for rows.Next() {
+		var denom, expected int
+		var n sql.NullInt64
+		if err := rows.Scan(&denom, &expected, &n); err != nil {
+			return c, fmt.Errorf("could not read counted notes: %v", err)
+		}
+		i := denominationIndex(denom)
+		if i < 0 {
+			return c, fmt.Errorf("cash count %d has unknown denomination %d", id, denom)
+		}
+		c.Expected[i] = expected
+		counted[i] = int(n.Int64)
+		hasCounts = hasCounts || n.Valid
+	}
+	if err := rows.Err(); err != nil {
+		return c, err
+	}
+	if hasCounts {
+		c.Counted = counted
+		c.VarianceAmount = denominationTotal(c.Variance())
+	}
+	return c, nil
+}
+
+func denominationIndex(value int) int {
+	for i, d := range Denominations {
+		if d == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// Gets a cash count with its expected and counted notes
+func GetCashCount(db *sql.DB, actor models.Actor, id int) (models.CashCount, error) {
+	if err := authorize(db, actor, models.PermCashReview); err != nil {
+		return models.CashCount{}, err
+	}
+	return getCashCount(db, id)
+}
+
+// Lists cash counts at every terminal with the given status, or every count
+// if status is empty, oldest first
+func ListCashCounts(db *sql.DB, actor models.Actor, status string) ([]models.CashCount, error) {
+	if err := authorize(db, actor, models.PermCashReview); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`
+		SELECT id FROM cash_counts
+		WHERE ? = '' OR status = ?
+		ORDER BY id`, status, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query cash counts: %v", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	counts := make([]models.CashCount, 0, len(ids))
+	for _, id := range ids {
+		c, err := getCashCount(db, id)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, nil
+}
+
+// Gets the handler's count of terminal that is still open, if any
+func GetOpenCashCount(db *sql.DB, handler, terminal string) (models.CashCount, bool, error) {
+	var id int
+	err := db.QueryRow(`
+		SELECT c.id FROM cash_counts c
+		JOIN users u ON u.id = c.handler_id
+		WHERE u.username = ? AND c.terminal_id = ? AND c.status = ?`, handler, terminal, models.CountOpen).Scan(&id)
+	if err == sql.ErrNoRows {
+		return models.CashCount{}, false, nil
+	}
+	if err != nil {
+		return models.CashCount{}, false, fmt.Errorf("could not get open cash count: %v", err)
+	}
+	c, err := getCashCount(db, id)
+	return c, err == nil, err
+}
+
+// Cash handler starts counting a terminal's cassettes. What its atm row holds
+// now is recorded as the expected notes. Only one count per terminal can be
+// open or waiting for review at a time.
+func StartCashCount(db *sql.DB, actor models.Actor, terminal string) (models.CashCount, error) {
+	if err := authorize(db, actor, models.PermCashCount); err != nil {
+		return models.CashCount{}, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return models.CashCount{}, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkTerminalAccess(tx, actor, terminal); err != nil {
+		return models.CashCount{}, err
+	}
+	if err := checkNoActiveCount(tx, terminal); err != nil {
+		return models.CashCount{}, err
+	}
+	handlerID, err := userIDByName(tx, actor.Username)
+	if err != nil {
+		return models.CashCount{}, err
+	}
+	stock, err := atmDenominations(tx, terminal)
+	if err != nil {
+		return models.CashCount{}, err
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO cash_counts (terminal_id, handler_id, status, started_at) VALUES (?, ?, ?, ?)`,
+		terminal, handlerID, models.CountOpen, time.Now().Format(dbDateLayout))
+	if err != nil {
+		return models.CashCount{}, fmt.Errorf("failed to start cash count: %v", err)
+	}
+	id, _ := res.LastInsertId()
+
+	for i, d := range Denominations {
+		_, err := tx.Exec(`
+			INSERT INTO cash_count_notes (count_id, denomination, expected) VALUES (?, ?, ?)`,
+			id, d, stock[i])
+		if err != nil {
+			return models.CashCount{}, fmt.Errorf("failed to record expected notes: %v", err)
+		}
+	}
+
+	c, err := getCashCount(tx, int(id))
+	if err != nil {
+		return models.CashCount{}, err
+	}
+	return c, tx.Commit()
+}
+
+// Loads a count that actor is allowed to act on as the handler who opened it
+func ownOpenCount(tx *sql.Tx, actor models.Actor, countID int) (models.CashCount, error) {
+	c, err := getCashCount(tx, countID)
+	if err != nil {
+		return c, err
+	}
+	if c.Handler != actor.Username {
+		return c, fmt.Errorf("cash count %d belongs to %s", countID, c.Handler)
+	}
+	if c.Status != models.CountOpen {
+		return c, fmt.Errorf("cash count %d is %s", countID, c.Status)
+	}
+	return c, checkTerminalAccess(tx, actor, c.Terminal)
+}
+
+func sameNotes(a, b []int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Cash handler enters the notes found in each cassette, ordered like
+// Denominations. A count that matches the atm row is closed as balanced;
+// otherwise it waits for an admin to approve the write-off. If the cassettes
+// changed since the count started (e.g. a customer withdrew), the count is
+// cancelled and has to be started again.
+func SubmitCashCount(db *sql.DB, actor models.Actor, countID int, counted []int) (models.CashCount, error) {
+	if err := authorize(db, actor, models.PermCashCount); err != nil {
+		return models.CashCount{}, err
+	}
+	if len(counted) != len(Denominations) {
+		return models.CashCount{}, fmt.Errorf("expected %d denominations, got %d", len(Denominations), len(counted))
+	}
+	for _, n := range counted {
+		if n < 0 {
+			return models.CashCount{}, fmt.Errorf("counted notes cannot be negative")
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return models.CashCount{}, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	c, err := ownOpenCount(tx, actor, countID)
+	if err != nil {
+		return c, err
+	}
+	now := time.Now().Format(dbDateLayout)
+
+	stock, err := atmDenominations(tx, c.Terminal)
+	if err != nil {
+		return c, err
+	}
+	if !sameNotes(stock, c.Expected) {
+		_, err := tx.Exec("UPDATE cash_counts SET status = ?, submitted_at = ? WHERE id = ?",
+			models.CountCancelled, now, countID)
+		if err != nil {
+			return c, fmt.Errorf("failed to cancel cash count: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return c, fmt.Errorf("failed to cancel cash count: %v", err)
+		}
+		return c, fmt.Errorf("the ATM's cash changed during the count; start a new count")
+	}
+
+	for i, d := range Denominations {
+		_, err := tx.Exec(`
+			UPDATE cash_count_notes SET counted = ?
+			WHERE count_id = ? AND denomination = ?`, counted[i], countID, d)
+		if err != nil {
+			return c, fmt.Errorf("failed to record counted notes: %v", err)
+		}
+	}
+
+	status := models.CountBalanced
+	if !sameNotes(counted, c.Expected) {
+		status = models.CountPending
+	}
+	_, err = tx.Exec("UPDATE cash_counts SET status = ?, submitted_at = ? WHERE id = ?", status, now, countID)
+	if err != nil {
+		return c, fmt.Errorf("failed to submit cash count: %v", err)
+	}
+
+	c, err = getCashCount(tx, countID)
+	if err != nil {
+		return c, err
+	}
+	err = recordAudit(tx, actor, AuditCashCount, fmt.Sprintf("count %d", countID),
+		map[string]any{"notes": noteMap(c.Expected)},
+		map[string]any{"notes": noteMap(c.Counted), "variance": c.VarianceAmount, "status": c.Status})
+	if err != nil {
+		return c, err
+	}
+	return c, tx.Commit()
+}
+
+// Cash handler abandons their open count
+func CancelCashCount(db *sql.DB, actor models.Actor, countID int) error {
+	if err := authorize(db, actor, models.PermCashCount); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := ownOpenCount(tx, actor, countID); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE cash_counts SET status = ? WHERE id = ?", models.CountCancelled, countID)
+	if err != nil {
+		return fmt.Errorf("failed to cancel cash count: %v", err)
+	}
+	return tx.Commit()
+}
+
+// Loads a count waiting for review by an admin other than the counter
+func pendingCount(tx *sql.Tx, actor models.Actor, countID int) (models.CashCount, error) {
+	c, err := getCashCount(tx, countID)
+	if err != nil {
+		return c, err
+	}
+	if c.Status != models.CountPending {
+		return c, fmt.Errorf("cash count %d is %s, not pending", countID, c.Status)
+	}
+	if c.Handler == actor.Username {
+		return c, fmt.Errorf("cash count %d must be reviewed by someone other than who counted it", countID)
+	}
+	return c, nil
+}
+
+func markReviewed(tx *sql.Tx, actor models.Actor, countID int, status string) error {
+	reviewerID, err := userIDByName(tx, actor.Username)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE cash_counts SET status = ?, reviewed_by = ?, reviewed_at = ?
+		WHERE id = ?`, status, reviewerID, time.Now().Format(dbDateLayout), countID)
+	if err != nil {
+		return fmt.Errorf("failed to update cash count: %v", err)
+	}
+	return nil
+}
+
+// Admin approves a count's variance. The cassettes are set to the counted
+// notes, the difference is written off against the cash variance account and
+// recorded as a cash event for the handler who counted it.
+func ApproveCashCount(db *sql.DB, actor models.Actor, countID int) error {
+	if err := authorize(db, actor, models.PermCashReview); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	c, err := pendingCount(tx, actor, countID)
+	if err != nil {
+		return err
+	}
+	before, err := cashSnapshot(tx, c.Terminal)
+	if err != nil {
+		return err
+	}
+
+	// Only write off against the cassettes the handler actually counted
+	res, err := tx.Exec(`
+		UPDATE atm
+		SET ones = ?, fives = ?, tens = ?, twenties = ?, fifties = ?, hundreds = ?
+		WHERE terminal_id = ?
+			AND ones = ? AND fives = ? AND tens = ?
+			AND twenties = ? AND fifties = ? AND hundreds = ?`,
+		c.Counted[0], c.Counted[1], c.Counted[2], c.Counted[3], c.Counted[4], c.Counted[5], c.Terminal,
+		c.Expected[0], c.Expected[1], c.Expected[2], c.Expected[3], c.Expected[4], c.Expected[5])
+	if err != nil {
+		return fmt.Errorf("failed to adjust ATM cash: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("the ATM's cash changed since count %d; reject it and recount", countID)
+	}
+	if err := checkCashAlerts(tx, c.Terminal); err != nil {
+		return err
+	}
+
+	memo := fmt.Sprintf("count %d of %s by %s, approved by %s", countID, c.Terminal, c.Handler, actor.Username)
+	variance := c.VarianceAmount
+	if variance > 0 {
+		err = postTransfer(tx, EntryVariance, memo, VaultAccount, VarianceAccount, variance)
+	} else if variance < 0 {
+		err = postTransfer(tx, EntryVariance, memo, VarianceAccount, VaultAccount, -variance)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := recordCashEvent(tx, c.Terminal, c.Handler, models.CashEventWriteOff, c.Variance(), countID, actor.Username); err != nil {
+		return err
+	}
+	if err := logCashMovement(tx, c.Terminal, c.Handler, models.KindCashVariance, variance); err != nil {
+		return err
+	}
+	if err := markReviewed(tx, actor, countID, models.CountApproved); err != nil {
+		return err
+	}
+
+	after, err := cashSnapshot(tx, c.Terminal)
+	if err != nil {
+		return err
+	}
+	after["count"] = countID
+	after["variance"] = variance
+	if err := recordAudit(tx, actor, AuditCashWriteOff, c.Terminal, before, after); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Admin refuses a count's variance. The cassettes are left as they were and
+// the handler has to count again.
+func RejectCashCount(db *sql.DB, actor models.Actor, countID int) error {
+	if err := authorize(db, actor, models.PermCashReview); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	c, err := pendingCount(tx, actor, countID)
+	if err != nil {
+		return err
+	}
+	if err := markReviewed(tx, actor, countID, models.CountRejected); err != nil {
+		return err
+	}
+	err = recordAudit(tx, actor, AuditCashCountReject, fmt.Sprintf("count %d", countID),
+		map[string]any{"status": c.Status, "variance": c.VarianceAmount},
+		map[string]any{"status": models.CountRejected})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Note counts keyed by denomination, for the audit log
+func noteMap(notes []int) map[string]int {
+	m := map[string]int{}
+	for i, d := range Denominations {
+		m[fmt.Sprint(d)] = notes[i]
+	}
+	return m
+}
+
+// The nonzero note counts, e.g. "2 x $20, 1 x $100"
+func FormatNotes(notes []int) string {
+	var parts []string
+	for i, d := range Denominations {
+		if notes[i] != 0 {
+			parts = append(parts, fmt.Sprintf("%d x $%d", notes[i], d))
+		}
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,398 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Cassette settings given to newly registered terminals
+const (
+	DefaultCassetteCapacity = 2500
+	DefaultLowWater         = 20
+)
+
+var ErrOutOfService = errors.New("this ATM is out of service for withdrawals")
+
+// Gets a terminal's cassettes, ordered like Denominations. Cash handlers can
+// only see the terminals assigned to them.
+func GetCassettes(db *sql.DB, actor models.Actor, terminal string) ([]models.Cassette, error) {
+	if err := authorize(db, actor, models.PermService); err != nil {
+		return nil, err
+	}
+	if err := checkTerminalAccess(db, actor, terminal); err != nil {
+		return nil, err
+	}
+	return cassettes(db, terminal)
+}
+
+func cassettes(q dbtx, terminal string) ([]models.Cassette, error) {
+	stock, err := atmDenominations(q, terminal)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(`
+		SELECT denomination, capacity, low_water, out_of_service
+		FROM cassettes WHERE terminal_id = ?`, terminal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query cassettes: %v", err)
+	}
+	defer rows.Close()
+
+	list := make([]models.Cassette, len(Denominations))
+	for i, d := range Denominations {
+		list[i] = models.Cassette{Denomination: d, Notes: stock[i]}
+	}
+	for rows.Next() {
+		var c models.Cassette
+		if err := rows.Scan(&c.Denomination, &c.Capacity, &c.LowWater, &c.OutOfService); err != nil {
+			return nil, fmt.Errorf("failed to read cassette: %v", err)
+		}
+		i := denominationIndex(c.Denomination)
+		if i < 0 {
+			return nil, fmt.Errorf("terminal %s has a cassette for unknown denomination %d", terminal, c.Denomination)
+		}
+		c.Notes = stock[i]
+		list[i] = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, c := range list {
+		if c.Capacity == 0 {
+			return nil, fmt.Errorf("terminal %s has no $%d cassette", terminal, c.Denomination)
+		}
+	}
+	return list, nil
+}
+
+func addDefaultCassettes(tx *sql.Tx, terminal string) error {
+	for _, d := range Denominations {
+		_, err := tx.Exec(`
+			INSERT INTO cassettes (terminal_id, denomination, capacity, low_water)
+			VALUES (?, ?, ?, ?)`, terminal, d, DefaultCassetteCapacity, DefaultLowWater)
+		if err != nil {
+			return fmt.Errorf("failed to add cassette: %v", err)
+		}
+	}
+	return nil
+}
+
+// Fails if adding notes (ordered like Denominations) would overfill any cassette
+func checkCapacity(q dbtx, terminal string, notes []int) error {
+	list, err := cassettes(q, terminal)
+	if err != nil {
+		return err
+	}
+	for i, c := range list {
+		if c.Notes+notes[i] > c.Capacity {
+			return fmt.Errorf("the $%d cassette holds %d of %d notes and has no room for %d more",
+				c.Denomination, c.Notes, c.Capacity, notes[i])
+		}
+	}
+	return nil
+}
+
+// Raises an alert for every cassette at or below its low-water mark and
+// resolves the alerts of cassettes that have been refilled. A cassette out of
+// service doesn't raise alerts.
+func checkCashAlerts(q dbtx, terminal string) error {
+	list, err := cassettes(q, terminal)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Format(dbDateLayout)
+
+	for _, c := range list {
+		if c.OutOfService || c.Notes > c.LowWater {
+			_, err := q.Exec(`
+				UPDATE cash_alerts SET resolved_at = ?
+				WHERE terminal_id = ? AND denomination = ? AND resolved_at IS NULL`,
+				now, terminal, c.Denomination)
+			if err != nil {
+				return fmt.Errorf("failed to resolve cash alert: %v", err)
+			}
+			continue
+		}
+
+		kind := models.AlertLowCash
+		if c.Notes == 0 {
+			kind = models.AlertEmpty
+		}
+		// A cassette that goes from low to empty needs to be seen again
+		_, err := q.Exec(`
+			INSERT INTO cash_alerts (terminal_id, denomination, kind, notes, created_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (terminal_id, denomination) WHERE resolved_at IS NULL
+			DO UPDATE SET kind = excluded.kind, notes = excluded.notes, created_at = excluded.created_at,
+				acknowledged_by = NULL, acknowledged_at = NULL
+			WHERE cash_alerts.kind != excluded.kind`,
+			terminal, c.Denomination, kind, c.Notes, now)
+		if err != nil {
+			return fmt.Errorf("failed to raise cash alert: %v", err)
+		}
+	}
+	return nil
+}
+
+// Sets a cassette's capacity and low-water mark. The capacity can't be set
+// below what the cassette holds now.
+func SetCassetteLimits(db *sql.DB, actor models.Actor, terminal string, denomination, capacity, lowWater int) error {
+	if err := authorize(db, actor, models.PermTerminalsManage); err != nil {
+		return err
+	}
+	i := denominationIndex(denomination)
+	if i < 0 {
+		return fmt.Errorf("unknown denomination $%d", denomination)
+	}
+	if lowWater < 0 || capacity <= lowWater {
+		return fmt.Errorf("capacity must be greater than the low-water mark, which can't be negative")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	list, err := cassettes(tx, terminal)
+	if err != nil {
+		return err
+	}
+	old := list[i]
+	if capacity < old.Notes {
+		return fmt.Errorf("the $%d cassette holds %d notes, more than a capacity of %d", denomination, old.Notes, capacity)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE cassettes SET capacity = ?, low_water = ?
+		WHERE terminal_id = ? AND denomination = ?`, capacity, lowWater, terminal, denomination)
+	if err != nil {
+		return fmt.Errorf("failed to update cassette: %v", err)
+	}
+	if err := checkCashAlerts(tx, terminal); err != nil {
+		return err
+	}
+
+	err = recordAudit(tx, actor, AuditCassetteUpdate, fmt.Sprintf("%s $%d", terminal, denomination),
+		map[string]any{"capacity": old.Capacity, "low_water": old.LowWater},
+		map[string]any{"capacity": capacity, "low_water": lowWater})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Gets whether a terminal is taking withdrawals and which denominations are disabled
+func GetServiceStatus(db *sql.DB, terminal string) (models.ServiceStatus, error) {
+	return serviceStatus(db, terminal)
+}
+
+func serviceStatus(q dbtx, terminal string) (models.ServiceStatus, error) {
+	var st models.ServiceStatus
+	var reason sql.NullString
+	err := q.QueryRow("SELECT out_of_service, out_of_service_reason FROM atm WHERE terminal_id = ?", terminal).
+		Scan(&st.OutOfService, &reason)
+	if err == sql.ErrNoRows {
+		return st, unknownTerminal(terminal)
+	}
+	if err != nil {
+		return st, fmt.Errorf("could not get service status: %v", err)
+	}
+	st.Reason = reason.String
+
+	list, err := cassettes(q, terminal)
+	if err != nil {
+		return st, err
+	}
+	for _, c := range list {
+		if c.OutOfService {
+			st.Disabled = append(st.Disabled, c.Denomination)
+		}
+	}
+	return st, nil
+}
+
+// Fails with ErrOutOfService if the terminal isn't taking withdrawals
+func checkInService(q dbtx, terminal string) error {
+	st, err := serviceStatus(q, terminal)
+	if err != nil {
+		return err
+	}
+	if st.OutOfService {
+		if st.Reason != "" {
+			return fmt.Errorf("%w (%s)", ErrOutOfService, st.Reason)
+		}
+		return ErrOutOfService
+	}
+	return nil
+}
+
+// Takes a terminal out of service for withdrawals, or puts it back. A
+// denomination of 0 means the whole terminal; otherwise only that cassette
+// is switched and the reason is ignored.
+func SetOutOfService(db *sql.DB, actor models.Actor, terminal string, denomination int, outOfService bool, reason string) error {
+	if err := authorize(db, actor, models.PermService); err != nil {
+		return err
+	}
+	if denomination != 0 && denominationIndex(denomination) < 0 {
+		return fmt.Errorf("unknown denomination $%d", denomination)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkTerminalAccess(tx, actor, terminal); err != nil {
+		return err
+	}
+	before, err := serviceStatus(tx, terminal)
+	if err != nil {
+		return err
+	}
+
+	target := terminal
+	if denomination == 0 {
+		var r any
+		if outOfService && reason != "" {
+			r = reason
+		}
+		_, err = tx.Exec("UPDATE atm SET out_of_service = ?, out_of_service_reason = ? WHERE terminal_id = ?",
+			outOfService, r, terminal)
+	} else {
+		target = fmt.Sprintf("%s $%d", terminal, denomination)
+		_, err = tx.Exec("UPDATE cassettes SET out_of_service = ? WHERE terminal_id = ? AND denomination = ?",
+			outOfService, terminal, denomination)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update service status: %v", err)
+	}
+	if err := checkCashAlerts(tx, terminal); err != nil {
+		return err
+	}
+
+	after, err := serviceStatus(tx, terminal)
+	if err != nil {
+		return err
+	}
+	if err := recordAudit(tx, actor, AuditServiceStatus, target, before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Gets the unresolved cash alerts a user should see: a cash handler's assigned
+// terminals, or every terminal for anyone else. Oldest first.
+func GetCashAlerts(db *sql.DB, actor models.Actor) ([]models.CashAlert, error) {
+	if err := authorize(db, actor, models.PermCashAlerts); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`
+		SELECT a.id, a.terminal_id, a.denomination, a.kind, a.notes, a.created_at,
+			COALESCE(a.acknowledged_by, ''), COALESCE(a.acknowledged_at, '')
+		FROM cash_alerts a
+		WHERE a.resolved_at IS NULL
+			AND (? != ? OR a.terminal_id IN (
+				SELECT th.terminal_id FROM terminal_handlers th
+				JOIN users u ON u.id = th.user_id
+				WHERE u.username = ?))
+		ORDER BY a.id`, actor.Role, models.RoleCashHandler, actor.Username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query cash alerts: %v", err)
+	}
+	defer rows.Close()
+
+	var alerts []models.CashAlert
+	for rows.Next() {
+		var a models.CashAlert
+		err := rows.Scan(&a.ID, &a.Terminal, &a.Denomination, &a.Kind, &a.Notes, &a.CreatedAt,
+			&a.AcknowledgedBy, &a.AcknowledgedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cash alert: %v", err)
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// Records that actor has seen an alert. It stays active until the cassette
+// is refilled.
+func AcknowledgeCashAlert(db *sql.DB, actor models.Actor, alertID int) error {
+	if err := authorize(db, actor, models.PermCashAlerts); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var terminal string
+	err = tx.QueryRow("SELECT terminal_id FROM cash_alerts WHERE id = ? AND resolved_at IS NULL", alertID).Scan(&terminal)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("no active cash alert %d", alertID)
+	}
+	if err != nil {
+		return fmt.Errorf("could not get cash alert: %v", err)
+	}
+	if err := checkTerminalAccess(tx, actor, terminal); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE cash_alerts SET acknowledged_by = ?, acknowledged_at = ? WHERE id = ?",
+		actor.Username, time.Now().Format(dbDateLayout), alertID)
+	if err != nil {
+		return fmt.Errorf("failed to acknowledge cash alert: %v", err)
+	}
+	return tx.Commit()
+}
+
+// Describes an alert for the cash handler, e.g. "ATM-0001: $20 cassette is low (12 notes left)"
+func FormatCashAlert(a models.CashAlert) string {
+	if a.Kind == models.AlertEmpty {
+		return fmt.Sprintf("%s: $%d cassette is empty", a.Terminal, a.Denomination)
+	}
+	return fmt.Sprintf("%s: $%d cassette is low (%d notes left)", a.Terminal, a.Denomination, a.Notes)
+}
+
+// Prints a terminal's cassettes and whether it is taking withdrawals
+func ShowCassettes(db *sql.DB, actor models.Actor, terminal string) error {
+	list, err := GetCassettes(db, actor, terminal)
+	if err != nil {
+		return err
+	}
+	st, err := GetServiceStatus(db, terminal)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\n===== CASSETTES AT %s =====\n", terminal)
+	if st.OutOfService {
+		fmt.Print("OUT OF SERVICE for withdrawals")
+		if st.Reason != "" {
+			fmt.Printf(": %s", st.Reason)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%-6s | %8s | %8s | %9s | %s\n", "Note", "Notes", "Capacity", "Low Water", "Status")
+	fmt.Println(strings.Repeat("-", 55))
+	for _, c := range list {
+		status := "ok"
+		switch {
+		case c.OutOfService:
+			status = "out of service"
+		case c.Notes == 0:
+			status = "EMPTY"
+		case c.Notes <= c.LowWater:
+			status = "LOW"
+		}
+		fmt.Printf("$%-5d | %8d | %8d | %9d | %s\n", c.Denomination, c.Notes, c.Capacity, c.LowWater, status)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPlanWithdrawalOutOfService(t *testing.T) {
+	tests := []struct {
+		name         string
+		denomination int // taken out of service; 0 for the whole terminal, -1 for none
+		want         []int
+		wantErr      error
+	}{
+		{"in service", -1, []int{0, 0, 0, 0, 2, 0}, nil},
+		{"fifties out of service", 50, []int{0, 0, 0, 5, 0, 0}, nil},
+		{"terminal out of service", 0, nil, ErrOutOfService},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := openWithdrawDB(t, 0, 100)
+			if _, err := conn.Exec("UPDATE atm SET fifties = 100 WHERE terminal_id = ?", testTerminal); err != nil {
+				t.Fatalf("load fifties: %v", err)
+			}
+			if tc.denomination >= 0 {
+				err := SetOutOfService(conn, models.Actor{Username: "admin"}, testTerminal, tc.denomination, true, "jammed")
+				if err != nil {
+					t.Fatalf("set out of service: %v", err)
+				}
+			}
+
+			plan, err := PlanWithdrawal(conn, testTerminal, models.Dollars(100), false)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(plan.Counts, tc.want) {
+				t.Errorf("got %v (%s), want %v", plan.Counts, plan, tc.want)
+			}
+		})
+	}
+}
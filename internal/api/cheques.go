@@ -0,0 +1,271 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Customer deposits a cheque or envelope at terminal into one of their
+// accounts. Nothing is credited yet: the amount is held until an admin clears
+// the deposit. imagePath is the scanned image of the item and must exist. An
+// empty account number means their main checking account.
+func DepositCheque(db *sql.DB, actor models.Actor, terminal, account string, amount models.Money, imagePath string) (models.ChequeDeposit, error) {
+	if err := authorize(db, actor, models.PermDeposit); err != nil {
+		return models.ChequeDeposit{}, err
+	}
+	if amount <= 0 {
+		return models.ChequeDeposit{}, fmt.Errorf("deposit amount must be greater than zero")
+	}
+	imagePath = strings.TrimSpace(imagePath)
+	if imagePath == "" {
+		return models.ChequeDeposit{}, fmt.Errorf("a cheque deposit needs the image of the cheque or envelope")
+	}
+	if info, err := os.Stat(imagePath); err != nil || info.IsDir() {
+		return models.ChequeDeposit{}, fmt.Errorf("could not find image file %s", imagePath)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return models.ChequeDeposit{}, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, depositLimit, err := atmLimits(tx, terminal)
+	if err != nil {
+		return models.ChequeDeposit{}, fmt.Errorf("could not get ATM limits: %v", err)
+	}
+	if amount > depositLimit {
+		return models.ChequeDeposit{}, fmt.Errorf("your deposit amount $%s is over the deposit limit: $%s", amount, depositLimit)
+	}
+	acct, err := customerAccount(tx, actor.Username, account)
+	if err != nil {
+		return models.ChequeDeposit{}, err
+	}
+	if err := checkCredit(acct); err != nil {
+		return models.ChequeDeposit{}, err
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO cheque_deposits (account_id, terminal_id, amount, image_path, deposited_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		acct.ID, nullString(terminal), amount, imagePath, time.Now().Format(dbDateLayout))
+	if err != nil {
+		return models.ChequeDeposit{}, fmt.Errorf("failed to record deposit: %v", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return models.ChequeDeposit{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.ChequeDeposit{}, fmt.Errorf("failed to commit deposit: %v", err)
+	}
+	return getChequeDeposit(db, int(id))
+}
+
+const chequeColumns = `
+	SELECT d.id, a.number, u.username, COALESCE(d.terminal_id, ''), d.amount, d.image_path,
+		d.deposited_at, d.status, COALESCE(r.username, ''), COALESCE(d.reviewed_at, ''),
+		COALESCE(d.reason, ''), COALESCE(d.transaction_id, 0)
+	FROM cheque_deposits d
+	JOIN accounts a ON a.id = d.account_id
+	JOIN users u ON u.id = a.user_id
+	LEFT JOIN users r ON r.id = d.reviewed_by`
+
+func scanChequeDeposit(row scanner) (models.ChequeDeposit, error) {
+	var d models.ChequeDeposit
+	err := row.Scan(&d.ID, &d.Account, &d.Owner, &d.Terminal, &d.Amount, &d.ImagePath,
+		&d.DepositedAt, &d.Status, &d.ReviewedBy, &d.ReviewedAt, &d.Reason, &d.TransactionID)
+	return d, err
+}
+
+func getChequeDeposit(q dbtx, id int) (models.ChequeDeposit, error) {
+	d, err := scanChequeDeposit(q.QueryRow(chequeColumns+" WHERE d.id = ?", id))
+	if err == sql.ErrNoRows {
+		return models.ChequeDeposit{}, fmt.Errorf("cheque deposit %d not found", id)
+	}
+	if err != nil {
+		return models.ChequeDeposit{}, fmt.Errorf("could not get cheque deposit: %v", err)
+	}
+	return d, nil
+}
+
+func listChequeDeposits(db *sql.DB, query string, args ...any) ([]models.ChequeDeposit, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query cheque deposits: %v", err)
+	}
+	defer rows.Close()
+
+	var list []models.ChequeDeposit
+	for rows.Next() {
+		d, err := scanChequeDeposit(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan cheque deposit: %v", err)
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+// Lists cheque deposits with status, or every one when status is empty,
+// oldest first. With status pending this is the clearing queue.
+func ListChequeDeposits(db *sql.DB, actor models.Actor, status string) ([]models.ChequeDeposit, error) {
+	if err := authorize(db, actor, models.PermDepositsClear); err != nil {
+		return nil, err
+	}
+	return listChequeDeposits(db, chequeColumns+` WHERE ? = '' OR d.status = ? ORDER BY d.id`, status, status)
+}
+
+// Gets a customer's cheque deposits across their accounts, newest first.
+// Customers can read their own; anyone else needs users.view.
+func GetChequeDeposits(db *sql.DB, actor models.Actor, username string) ([]models.ChequeDeposit, error) {
+	if err := authorizeUserRead(db, actor, username); err != nil {
+		return nil, err
+	}
+	return listChequeDeposits(db, chequeColumns+` WHERE u.username = ? ORDER BY d.id DESC`, username)
+}
+
+// Gets the customer's rejected deposits they haven't been told about yet and
+// marks them as told, so each rejection notice is shown once. Only the
+// customer can take their own notices.
+func TakeChequeNotices(db *sql.DB, actor models.Actor, username string) ([]models.ChequeDeposit, error) {
+	if actor.Username != username {
+		return nil, fmt.Errorf("%w: %s cannot take %s's notices", ErrPermissionDenied, actor.Username, username)
+	}
+	list, err := listChequeDeposits(db, chequeColumns+`
+		WHERE u.username = ? AND d.status = ? AND d.notice_seen = 0
+		ORDER BY d.id`, username, models.ChequeRejected)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range list {
+		if _, err := db.Exec("UPDATE cheque_deposits SET notice_seen = 1 WHERE id = ?", d.ID); err != nil {
+			return nil, fmt.Errorf("failed to update cheque deposit: %v", err)
+		}
+	}
+	return list, nil
+}
+
+// Loads a deposit that is still waiting to be cleared
+func pendingCheque(tx *sql.Tx, id int) (models.ChequeDeposit, error) {
+	d, err := getChequeDeposit(tx, id)
+	if err != nil {
+		return models.ChequeDeposit{}, err
+	}
+	if d.Status != models.ChequePending {
+		return models.ChequeDeposit{}, fmt.Errorf("cheque deposit %d is already %s", id, d.Status)
+	}
+	return d, nil
+}
+
+// Clears a held deposit: the amount is credited to the account from the
+// CHEQUE_CLEARING ledger account and logged as a cheque_deposit transaction
+func AcceptCheque(db *sql.DB, actor models.Actor, id int) (models.ChequeDeposit, error) {
+	if err := authorize(db, actor, models.PermDepositsClear); err != nil {
+		return models.ChequeDeposit{}, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return models.ChequeDeposit{}, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	d, err := pendingCheque(tx, id)
+	if err != nil {
+		return models.ChequeDeposit{}, err
+	}
+	acct, err := customerAccount(tx, d.Owner, d.Account)
+	if err != nil {
+		return models.ChequeDeposit{}, err
+	}
+	if err := checkCredit(acct); err != nil {
+		return models.ChequeDeposit{}, fmt.Errorf("%v; reject the deposit instead", err)
+	}
+
+	memo := fmt.Sprintf("cheque deposit %d by %s to %s", id, d.Owner, acct.Number)
+	if err := postTransfer(tx, EntryCheque, memo, ClearingAccount, acct.Ledger, d.Amount); err != nil {
+		return models.ChequeDeposit{}, err
+	}
+	newBalance := acct.Balance + d.Amount
+	txID, err := logTransaction(tx, models.Transaction{
+		USER_ID:      acct.UserID,
+		Account:      acct.Number,
+		Kind:         models.KindCheque,
+		Amount:       d.Amount,
+		BalanceAfter: &newBalance,
+		Terminal:     d.Terminal,
+	})
+	if err != nil {
+		return models.ChequeDeposit{}, err
+	}
+
+	if err := reviewCheque(tx, actor, d, models.ChequeAccepted, "", txID); err != nil {
+		return models.ChequeDeposit{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.ChequeDeposit{}, fmt.Errorf("failed to commit deposit: %v", err)
+	}
+	return getChequeDeposit(db, id)
+}
+
+// Returns a held deposit to the customer without crediting it. The reason is
+// the rejection notice they are shown at their next login.
+func RejectCheque(db *sql.DB, actor models.Actor, id int, reason string) (models.ChequeDeposit, error) {
+	if err := authorize(db, actor, models.PermDepositsClear); err != nil {
+		return models.ChequeDeposit{}, err
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return models.ChequeDeposit{}, fmt.Errorf("a reason is needed to reject a deposit")
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return models.ChequeDeposit{}, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	d, err := pendingCheque(tx, id)
+	if err != nil {
+		return models.ChequeDeposit{}, err
+	}
+	if err := reviewCheque(tx, actor, d, models.ChequeRejected, reason, 0); err != nil {
+		return models.ChequeDeposit{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.ChequeDeposit{}, fmt.Errorf("failed to commit rejection: %v", err)
+	}
+	return getChequeDeposit(db, id)
+}
+
+// Records an admin's decision on a deposit and audits it
+func reviewCheque(tx *sql.Tx, actor models.Actor, d models.ChequeDeposit, status, reason string, txID int64) error {
+	reviewerID, err := userIDByName(tx, actor.Username)
+	if err != nil {
+		return err
+	}
+	var transaction any
+	if txID != 0 {
+		transaction = txID
+	}
+	_, err = tx.Exec(`
+		UPDATE cheque_deposits SET status = ?, reviewed_by = ?, reviewed_at = ?, reason = ?, transaction_id = ?
+		WHERE id = ?`, status, reviewerID, time.Now().Format(dbDateLayout), nullString(reason), transaction, d.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update cheque deposit: %v", err)
+	}
+
+	action := AuditChequeAccept
+	if status == models.ChequeRejected {
+		action = AuditChequeReject
+	}
+	before := map[string]any{"deposit": d.ID, "account": d.Account, "amount": d.Amount, "status": d.Status}
+	after := map[string]any{"deposit": d.ID, "account": d.Account, "amount": d.Amount, "status": status}
+	if reason != "" {
+		after["reason"] = reason
+	}
+	return recordAudit(tx, actor, action, d.Owner, before, after)
+}
@@ -0,0 +1,316 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/config"
+	"SPG_ATM_Machine/internal/models"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var namePattern = regexp.MustCompile(`^[A-Za-z]+( [A-Za-z]+)*$`)
+
+// Layout of users.dob
+const dobLayout = "01/02/2006"
+
+// Checks a customer's full name and date of birth (MM/DD/YYYY). The name is
+// letters and single spaces; the date must be real, in the past and after 1900.
+func ValidateProfile(fullName, dob string) error {
+	if len(fullName) > 100 || !namePattern.MatchString(fullName) {
+		return fmt.Errorf("name can only contain letters and single spaces (at most 100 characters)")
+	}
+	born, err := time.ParseInLocation(dobLayout, dob, time.Local)
+	if err != nil {
+		return fmt.Errorf("date of birth must be a real date in MM/DD/YYYY format")
+	}
+	if born.Year() < 1900 || born.After(time.Now()) {
+		return fmt.Errorf("date of birth must be between 1900 and today")
+	}
+	return nil
+}
+
+// Finds customers whose username or full name contains query, ignoring case.
+// A blank query lists every customer. PIN hashes are left out.
+func SearchCustomers(db *sql.DB, actor models.Actor, query string) ([]models.User, error) {
+	if err := authorize(db, actor, models.PermUsersView); err != nil {
+		return nil, err
+	}
+	users, err := listUsers(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %v", err)
+	}
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	var found []models.User
+	for _, u := range users {
+		if u.Role != models.RoleCustomer {
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(u.Username), query) &&
+			!strings.Contains(strings.ToLower(u.FullName), query) {
+			continue
+		}
+		u.PIN = ""
+		found = append(found, u)
+	}
+	return found, nil
+}
+
+// Gets a customer's profile, lock state and accounts with their balances
+func GetCustomerProfile(db *sql.DB, actor models.Actor, username string) (models.CustomerProfile, error) {
+	if err := authorize(db, actor, models.PermUsersView); err != nil {
+		return models.CustomerProfile{}, err
+	}
+	var p models.CustomerProfile
+	u := &p.User
+	var locked bool
+	err := db.QueryRow(`
+		SELECT u.id, u.full_name, COALESCE(u.dob, ''), `+userLedgerBalance+`, u.username, u.role,
+			u.failed_attempts, u.locked, COALESCE(u.locked_until, '')
+		FROM users u WHERE u.username = ?`, username).Scan(&u.ID, &u.FullName, &u.DOB, &u.StartingBal,
+		&u.Username, &u.Role, &u.FailedAttempts, &locked, &p.LockedUntil)
+	if err == sql.ErrNoRows {
+		return models.CustomerProfile{}, fmt.Errorf("no user found with username '%s'", username)
+	}
+	if err != nil {
+		return models.CustomerProfile{}, fmt.Errorf("could not get user: %v", err)
+	}
+	if u.Role != models.RoleCustomer {
+		return models.CustomerProfile{}, fmt.Errorf("'%s' is not a customer", username)
+	}
+	if locked {
+		u.Locked = 1
+	}
+
+	p.Accounts, err = listAccounts(db, username)
+	if err != nil {
+		return models.CustomerProfile{}, err
+	}
+	for _, a := range p.Accounts {
+		p.Total += a.Balance
+	}
+	return p, nil
+}
+
+// Changes a customer's full name and date of birth on behalf of actor
+func UpdateCustomer(db *sql.DB, actor models.Actor, username, fullName, dob string) error {
+	if err := authorize(db, actor, models.PermUsersUpdate); err != nil {
+		return err
+	}
+	fullName = strings.TrimSpace(fullName)
+	if err := ValidateProfile(fullName, dob); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var oldName, oldDOB, role string
+	err = tx.QueryRow("SELECT full_name, COALESCE(dob, ''), role FROM users WHERE username = ?", username).
+		Scan(&oldName, &oldDOB, &role)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("no user found with username '%s'", username)
+	}
+	if err != nil {
+		return fmt.Errorf("could not get user: %v", err)
+	}
+	if role != models.RoleCustomer {
+		return fmt.Errorf("'%s' is not a customer", username)
+	}
+	if oldName == fullName && oldDOB == dob {
+		return fmt.Errorf("nothing to change")
+	}
+
+	if _, err := tx.Exec("UPDATE users SET full_name = ?, dob = ? WHERE username = ?", fullName, dob, username); err != nil {
+		return fmt.Errorf("failed to update user: %v", err)
+	}
+
+	// Ledger account names follow the username, so they don't change here
+	before := map[string]any{"full_name": oldName, "dob": oldDOB}
+	after := map[string]any{"full_name": fullName, "dob": dob}
+	if err := recordAudit(tx, actor, AuditCustomerUpdate, username, before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Freezes an open account: it still takes deposits and incoming transfers,
+// but nothing can be withdrawn or transferred out
+func FreezeAccount(db *sql.DB, actor models.Actor, number, reason string) error {
+	if strings.TrimSpace(reason) == "" {
+		return fmt.Errorf("a reason is required to freeze an account")
+	}
+	return setAccountStatus(db, actor, number, models.AccountOpen, models.AccountFrozen, reason, AuditAccountFreeze)
+}
+
+// Lifts a freeze
+func UnfreezeAccount(db *sql.DB, actor models.Actor, number, reason string) error {
+	return setAccountStatus(db, actor, number, models.AccountFrozen, models.AccountOpen, reason, AuditAccountUnfreeze)
+}
+
+// Closes an open or frozen account with a zero balance. The account and its
+// history stay; it just can't be used again.
+func CloseAccount(db *sql.DB, actor models.Actor, number, reason string) error {
+	return setAccountStatus(db, actor, number, "", models.AccountClosed, reason, AuditAccountClose)
+}
+
+// Moves an account to status to and audits it. It must be in status from, or
+// in any status but closed when from is empty.
+func setAccountStatus(db *sql.DB, actor models.Actor, number, from, to, reason, action string) error {
+	if err := authorize(db, actor, models.PermAccountsStatus); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	a, err := scanAccount(tx.QueryRow(accountColumns+` WHERE a.number = ?`, number))
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("account %s not found", number)
+	}
+	if err != nil {
+		return fmt.Errorf("could not get account: %v", err)
+	}
+	if a.Status == models.AccountClosed {
+		return fmt.Errorf("account %s is closed", number)
+	}
+	if from != "" && a.Status != from {
+		return fmt.Errorf("account %s is %s, not %s", number, a.Status, from)
+	}
+
+	if to == models.AccountClosed {
+		balance, err := ledgerBalance(tx, a.Ledger)
+		if err != nil {
+			return fmt.Errorf("could not get balance: %v", err)
+		}
+		if balance != 0 {
+			return fmt.Errorf("account %s has a balance of $%s; it must be zero to close", number, balance)
+		}
+		if a.Held != 0 {
+			return fmt.Errorf("account %s has $%s of deposits waiting to clear; accept or reject them first", number, a.Held)
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE accounts SET status = ?, status_reason = ?, status_changed_at = ?, status_changed_by = ?
+		WHERE id = ?`, to, nullString(strings.TrimSpace(reason)), time.Now().Format(dbDateLayout), actor.Username, a.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update account: %v", err)
+	}
+
+	before := map[string]any{"account": number, "status": a.Status}
+	after := map[string]any{"account": number, "status": to, "reason": strings.TrimSpace(reason)}
+	if err := recordAudit(tx, actor, action, a.Owner, before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// A manual balance adjustment of a positive amount. Money can't be negative
+// in JSON, so debits are flagged.
+type adjustment struct {
+	Account string       `json:"account"`
+	Amount  models.Money `json:"amount"`
+	Debit   bool         `json:"debit,omitempty"`
+	Memo    string       `json:"memo"`
+}
+
+// Corrects an account's balance by amount, against the MANUAL_ADJUSTMENT
+// ledger account: a positive amount credits it, a negative one debits it. Adjustments of limits.LargeAdjustmentDollars or more are
+// only requested and need another admin's approval; smaller ones are posted
+// straight away and the returned approval has ID 0.
+func AdjustBalance(db *sql.DB, actor models.Actor, number string, amount models.Money, memo string, limits config.Limits) (models.Approval, error) {
+	if err := authorize(db, actor, models.PermAccountsAdjust); err != nil {
+		return models.Approval{}, err
+	}
+	memo = strings.TrimSpace(memo)
+	if amount == 0 {
+		return models.Approval{}, fmt.Errorf("adjustment amount must not be zero")
+	}
+	if memo == "" {
+		return models.Approval{}, fmt.Errorf("a reason is required for an adjustment")
+	}
+	change := adjustment{Account: number, Amount: amount, Memo: memo}
+	if amount < 0 {
+		change.Amount, change.Debit = -amount, true
+	}
+
+	if change.Amount < models.Dollars(int64(limits.LargeAdjustmentDollars)) {
+		tx, err := db.Begin()
+		if err != nil {
+			return models.Approval{}, fmt.Errorf("failed to start transaction: %v", err)
+		}
+		defer tx.Rollback()
+		if err := adjustBalance(tx, actor, change); err != nil {
+			return models.Approval{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return models.Approval{}, fmt.Errorf("failed to commit adjustment: %v", err)
+		}
+		return models.Approval{}, nil
+	}
+
+	// Check the account now so a request that can't apply isn't queued
+	a, err := scanAccount(db.QueryRow(accountColumns+` WHERE a.number = ?`, number))
+	if err == sql.ErrNoRows {
+		return models.Approval{}, fmt.Errorf("account %s not found", number)
+	}
+	if err != nil {
+		return models.Approval{}, fmt.Errorf("could not get account: %v", err)
+	}
+	if err := checkCredit(a); err != nil {
+		return models.Approval{}, err
+	}
+	summary := fmt.Sprintf("credit $%s to %s (%s): %s", change.Amount, number, a.Owner, memo)
+	if change.Debit {
+		summary = fmt.Sprintf("debit $%s from %s (%s): %s", change.Amount, number, a.Owner, memo)
+	}
+	return requestApproval(db, actor, AuditAdjustment, a.Owner, summary, change, limits)
+}
+
+func adjustBalance(tx *sql.Tx, actor models.Actor, change adjustment) error {
+	a, err := scanAccount(tx.QueryRow(accountColumns+` WHERE a.number = ?`, change.Account))
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("account %s not found", change.Account)
+	}
+	if err != nil {
+		return fmt.Errorf("could not get account: %v", err)
+	}
+	if err := checkCredit(a); err != nil {
+		return err
+	}
+	balance, err := ledgerBalance(tx, a.Ledger)
+	if err != nil {
+		return fmt.Errorf("could not get balance: %v", err)
+	}
+
+	memo := fmt.Sprintf("adjustment to %s: %s", a.Number, change.Memo)
+	amount := change.Amount
+	if change.Debit {
+		if balance < amount {
+			return fmt.Errorf("account %s has only $%s; the adjustment would make it negative", a.Number, balance)
+		}
+		err = postTransfer(tx, EntryAdjustment, memo, a.Ledger, AdjustmentAccount, amount)
+		amount = -amount
+	} else {
+		err = postTransfer(tx, EntryAdjustment, memo, AdjustmentAccount, a.Ledger, amount)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to post adjustment: %v", err)
+	}
+
+	after := balance + amount
+	if _, err := logTransaction(tx, models.Transaction{USER_ID: a.UserID, Account: a.Number, Kind: models.KindAdjustment, Amount: amount, BalanceAfter: &after}); err != nil {
+		return err
+	}
+	return recordAudit(tx, actor, AuditAdjustment, a.Owner,
+		map[string]any{"account": a.Number, "balance": balance},
+		map[string]any{"account": a.Number, "balance": after, "amount": amount, "memo": change.Memo})
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Bill values in the order the atm columns and deposit.txt list them
+var Denominations = []int{1, 5, 10, 20, 50, 100}
+
+// Largest note handed out in a small bills plan
+const SmallBillMax = 20
+
+// A cassette at or below this many notes is low on stock. Its notes are only
+// used when the amount can't be made any other way.
+const ReserveNotes = 5
+
+// The notes the ATM will hand out for a withdrawal
+type DispensePlan struct {
+	Amount     models.Money
+	Counts     []int // ordered like Denominations
+	SmallBills bool
+}
+
+// Describes the plan from the largest note down, e.g. "2 x $100, 1 x $20"
+func (p DispensePlan) String() string {
+	var parts []string
+	for i := len(p.Counts) - 1; i >= 0; i-- {
+		if p.Counts[i] > 0 {
+			parts = append(parts, fmt.Sprintf("%d x $%d", p.Counts[i], Denominations[i]))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Number of notes in the plan
+func (p DispensePlan) Notes() int {
+	n := 0
+	for _, c := range p.Counts {
+		n += c
+	}
+	return n
+}
+
+// Get the number of notes of each denomination in a terminal
+func GetATMDenominations(db *sql.DB, terminal string) ([]int, error) {
+	return atmDenominations(db, terminal)
+}
+
+func atmDenominations(q dbtx, terminal string) ([]int, error) {
+	stock := make([]int, len(Denominations))
+	err := q.QueryRow(`
+		SELECT ones, fives, tens, twenties, fifties, hundreds
+		FROM atm WHERE terminal_id = ?`, terminal).Scan(&stock[0], &stock[1], &stock[2], &stock[3], &stock[4], &stock[5])
+	if err == sql.ErrNoRows {
+		return nil, unknownTerminal(terminal)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching ATM denominations: %v", err)
+	}
+	return stock, nil
+}
+
+// Plans a withdrawal against a terminal's current stock, leaving out
+// cassettes that are out of service
+func PlanWithdrawal(db *sql.DB, terminal string, amount models.Money, smallBills bool) (DispensePlan, error) {
+	if err := checkInService(db, terminal); err != nil {
+		return DispensePlan{}, err
+	}
+	list, err := cassettes(db, terminal)
+	if err != nil {
+		return DispensePlan{}, err
+	}
+	stock := make([]int, len(list))
+	for i, c := range list {
+		if !c.OutOfService {
+			stock[i] = c.Notes
+		}
+	}
+	return PlanDispense(stock, amount, smallBills)
+}
+
+// Works out which notes to dispense for amount from stock (ordered like
+// Denominations). Larger notes are preferred, and low stock cassettes are kept
+// in reserve unless there's no other way to make the amount. A small bills
+// plan only uses notes up to SmallBillMax.
+func PlanDispense(stock []int, amount models.Money, smallBills bool) (DispensePlan, error) {
+	if amount <= 0 {
+		return DispensePlan{}, fmt.Errorf("withdrawal amount must be greater than zero")
+	}
+	if amount.Cents() != 0 {
+		return DispensePlan{}, fmt.Errorf("the ATM only dispenses whole dollar amounts, $%s cannot be made with notes", amount)
+	}
+	if len(stock) != len(Denominations) {
+		return DispensePlan{}, fmt.Errorf("expected %d denominations, got %d", len(Denominations), len(stock))
+	}
+
+	maxNote := Denominations[len(Denominations)-1]
+	if smallBills {
+		maxNote = SmallBillMax
+	}
+
+	// First try leaving the reserve untouched, then allow the whole cassette
+	withReserve := make([]int, len(stock))
+	for i, n := range stock {
+		if n > ReserveNotes {
+			withReserve[i] = n - ReserveNotes
+		}
+	}
+
+	dollars := int(amount.Dollars())
+	for _, available := range [][]int{withReserve, stock} {
+		counts := make([]int, len(Denominations))
+		if planNotes(available, counts, len(Denominations)-1, dollars, maxNote, map[[2]int]bool{}) {
+			return DispensePlan{Amount: amount, Counts: counts, SmallBills: smallBills}, nil
+		}
+	}
+
+	if smallBills {
+		return DispensePlan{}, fmt.Errorf("the ATM cannot make $%s in small bills right now", amount)
+	}
+	return DispensePlan{}, fmt.Errorf("the ATM cannot make $%s with the notes it has right now", amount)
+}
+
+// Depth-first search from the largest note down, taking as many of each note
+// as possible first. Dead ends are remembered so the search stays small.
+func planNotes(available, counts []int, i, remaining, maxNote int, failed map[[2]int]bool) bool {
+	if remaining == 0 {
+		return true
+	}
+	if i < 0 || failed[[2]int{i, remaining}] {
+		return false
+	}
+
+	value := Denominations[i]
+	most := 0
+	if value <= maxNote {
+		most = min(available[i], remaining/value)
+	}
+	for n := most; n >= 0; n-- {
+		counts[i] = n
+		if planNotes(available, counts, i-1, remaining-n*value, maxNote, failed) {
+			return true
+		}
+	}
+
+	counts[i] = 0
+	failed[[2]int{i, remaining}] = true
+	return false
+}
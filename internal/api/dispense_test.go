@@ -0,0 +1,59 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlanDispense(t *testing.T) {
+	plenty := []int{100, 100, 100, 100, 100, 100}
+	tests := []struct {
+		name       string
+		stock      []int // ordered like Denominations
+		amount     models.Money
+		smallBills bool
+		want       []int
+		wantErr    string
+	}{
+		{"largest notes first", plenty, models.Dollars(180), false, []int{0, 0, 1, 1, 1, 1}, ""},
+		{"small bills", plenty, models.Dollars(60), true, []int{0, 0, 0, 3, 0, 0}, ""},
+		{"small bills up to twenties", plenty, models.Dollars(136), true, []int{1, 1, 1, 6, 0, 0}, ""},
+		{"keeps the reserve", []int{0, 0, 0, 50, 0, 6}, models.Dollars(200), false, []int{0, 0, 0, 5, 0, 1}, ""},
+		{"dips into the reserve", []int{0, 0, 0, 0, 0, 3}, models.Dollars(200), false, []int{0, 0, 0, 0, 0, 2}, ""},
+		{"backs out of a dead end", []int{0, 0, 0, 10, 10, 0}, models.Dollars(60), false, []int{0, 0, 0, 3, 0, 0}, ""},
+		{"not enough notes", []int{0, 0, 0, 2, 0, 0}, models.Dollars(60), false, nil, "cannot make $60.00 with the notes"},
+		{"no small bills", []int{0, 0, 0, 0, 0, 10}, models.Dollars(100), true, nil, "in small bills"},
+		{"cents", plenty, models.Dollars(20) + 50, false, nil, "whole dollar amounts"},
+		{"zero", plenty, 0, false, nil, "greater than zero"},
+		{"wrong stock length", []int{100}, models.Dollars(20), false, nil, "expected 6 denominations"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			plan, err := PlanDispense(tc.stock, tc.amount, tc.smallBills)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(plan.Counts, tc.want) {
+				t.Errorf("got %v (%s), want %v", plan.Counts, plan, tc.want)
+			}
+			var total int
+			for i, n := range plan.Counts {
+				total += n * Denominations[i]
+				if n > tc.stock[i] {
+					t.Errorf("plan takes %d $%d notes from a cassette of %d", n, Denominations[i], tc.stock[i])
+				}
+			}
+			if models.Dollars(int64(total)) != tc.amount {
+				t.Errorf("plan adds up to $%d, want $%s", total, tc.amount)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"SPG_ATM_Machine/internal/config"
 	"SPG_ATM_Machine/internal/models"
 	"database/sql"
 	"fmt"
@@ -9,10 +10,112 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-// Create the user
-func CreateUser(db *sql.DB, fullName, dob, pin string, startingBal float64, username, role string) error {
+// A user to be created. PINHash is the bcrypt hash of their PIN, so a
+// request waiting for approval never holds the PIN itself.
+type newUser struct {
+	FullName    string       `json:"full_name"`
+	DOB         string       `json:"dob"`
+	PINHash     string       `json:"pin_hash"`
+	StartingBal models.Money `json:"starting_balance"`
+	Username    string       `json:"username"`
+	Role        string       `json:"role"`
+	Scope       []string     `json:"permissions,omitempty"`
+}
+
+func hashPIN(pin string) (string, error) {
+	hashedPin, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash PIN: %v", err)
+	}
+	return string(hashedPin), nil
+}
+
+// Create a customer on behalf of actor. Admins and cash handlers are created
+// through RequestStaffUser and a second admin's approval.
+func CreateUser(db *sql.DB, actor models.Actor, fullName, dob, pin string, startingBal models.Money, username string) error {
+	if err := authorize(db, actor, models.PermUsersCreate); err != nil {
+		return err
+	}
+
+	//Hashes pin to store in database
+	hashedPin, err := hashPIN(pin)
+	if err != nil {
+		return err
+	}
+
+	//Create the user and their opening ledger entry together
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	u := newUser{FullName: fullName, DOB: dob, PINHash: hashedPin, StartingBal: startingBal, Username: username, Role: models.RoleCustomer}
+	if err := createUser(tx, actor, u); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Asks for an admin or cash handler to be created. They're created once
+// another admin approves the request, scoped as CreateUser describes.
+func RequestStaffUser(db *sql.DB, actor models.Actor, fullName, dob, pin, username, role string, scope []string, limits config.Limits) (models.Approval, error) {
+	if err := authorize(db, actor, models.PermStaffCreate); err != nil {
+		return models.Approval{}, err
+	}
+	if role != models.RoleAdmin && role != models.RoleCashHandler {
+		return models.Approval{}, fmt.Errorf("staff must be an admin or a cash handler, not %q", role)
+	}
+	if err := expireApprovals(db); err != nil {
+		return models.Approval{}, err
+	}
+
+	// Catch what would stop the request applying before it's queued
+	var taken bool
+	err := db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)
+			OR EXISTS(SELECT 1 FROM approvals WHERE kind = ? AND target = ? AND status = ?)`,
+		username, AuditCreateUser, username, models.ApprovalPending).Scan(&taken)
+	if err != nil {
+		return models.Approval{}, fmt.Errorf("failed to check username: %v", err)
+	}
+	if taken {
+		return models.Approval{}, fmt.Errorf("username '%s' already exists or is awaiting approval", username)
+	}
+	if _, err := grantablePermissions(db, actor, role, scope); err != nil {
+		return models.Approval{}, err
+	}
+
+	hashedPin, err := hashPIN(pin)
+	if err != nil {
+		return models.Approval{}, err
+	}
+	perms := "all permissions"
+	if len(scope) > 0 {
+		perms = strings.Join(scope, ", ")
+	}
+	summary := fmt.Sprintf("create %s '%s' with %s", role, username, perms)
+	u := newUser{FullName: fullName, DOB: dob, PINHash: hashedPin, Username: username, Role: role, Scope: scope}
+	return requestApproval(db, actor, AuditCreateUser, username, summary, u, limits)
+}
+
+// Creates u inside tx and audits it. Admins and cash handlers can be scoped
+// to some of their role's permissions; an empty scope gives them all of them.
+// Customers can't be scoped.
+func createUser(tx *sql.Tx, actor models.Actor, u newUser) error {
+	var known bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM roles WHERE name = ?)", u.Role).Scan(&known); err != nil {
+		return fmt.Errorf("failed to check role: %v", err)
+	}
+	if !known {
+		return fmt.Errorf("unknown role %q", u.Role)
+	}
+	if u.Role == models.RoleCustomer && len(u.Scope) > 0 {
+		return fmt.Errorf("customers can't be scoped")
+	}
+
 	//Check database to see if it exist (use prepare statement to separate code and data)
-	stmtCheck, err := db.Prepare("SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)")
+	stmtCheck, err := tx.Prepare("SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)")
 	if err != nil {
 		return err
 	}
@@ -20,27 +123,21 @@ func CreateUser(db *sql.DB, fullName, dob, pin string, startingBal float64, user
 
 	//Error handling to check if the username exists or not
 	var exists bool
-	if err := stmtCheck.QueryRow(username).Scan(&exists); err != nil {
+	if err := stmtCheck.QueryRow(u.Username).Scan(&exists); err != nil {
 		return fmt.Errorf("failed to check username: %v", err)
 	}
 	if exists {
-		return fmt.Errorf("username '%s' already exists", username)
-	}
-
-	//Hashes pin to store in database
-	hashedPin, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
-	if err != nil {
-		return fmt.Errorf("failed to hash PIN: %v", err)
+		return fmt.Errorf("username '%s' already exists", u.Username)
 	}
 
 	//Update id number for each user
-	nextID, err := GetNextUserID(db)
+	nextID, err := nextUserID(tx)
 	if err != nil {
 		return err
 	}
 
 	//Upload all USER metadata into database
-	stmtInsert, err := db.Prepare(`
+	stmtInsert, err := tx.Prepare(`
 		INSERT INTO users (id, full_name, dob, pin, starting_bal, username, role)
 		VALUES (?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
@@ -48,14 +145,71 @@ func CreateUser(db *sql.DB, fullName, dob, pin string, startingBal float64, user
 	}
 	defer stmtInsert.Close()
 
-	_, err = stmtInsert.Exec(nextID, fullName, dob, string(hashedPin), startingBal, username, role)
-	return err
+	_, err = stmtInsert.Exec(nextID, u.FullName, u.DOB, u.PINHash, u.StartingBal, u.Username, u.Role)
+	if err != nil {
+		return err
+	}
+
+	//Open the user's ledger account and, for customers, their checking account, funding it from suspense
+	if err := openCustomerAccount(tx, nextID, u.Username); err != nil {
+		return err
+	}
+	number := ""
+	if u.Role == models.RoleCustomer {
+		var accountID int
+		accountID, number, err = nextAccountNumber(tx, models.AccountChecking)
+		if err != nil {
+			return err
+		}
+		if err := addAccount(tx, accountID, number, nextID, models.AccountChecking, 0, CustomerAccount(nextID)); err != nil {
+			return err
+		}
+	}
+	if u.StartingBal > 0 {
+		err = postTransfer(tx, EntryOpening, "opening balance for "+u.Username, SuspenseAccount, CustomerAccount(nextID), u.StartingBal)
+		if err != nil {
+			return err
+		}
+		startingBal := u.StartingBal
+		_, err = logTransaction(tx, models.Transaction{
+			USER_ID:      nextID,
+			Account:      number,
+			Kind:         models.KindAdjustment,
+			Amount:       startingBal,
+			BalanceAfter: &startingBal,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	after := map[string]any{"full_name": u.FullName, "dob": u.DOB, "role": u.Role, "starting_balance": u.StartingBal}
+	if u.Role != models.RoleCustomer {
+		perms, err := grantablePermissions(tx, actor, u.Role, u.Scope)
+		if err != nil {
+			return err
+		}
+		// Unscoped users get no rows so they follow later changes to their role
+		if len(u.Scope) > 0 {
+			for _, p := range perms {
+				if _, err := tx.Exec("INSERT INTO user_permissions (user_id, permission) VALUES (?, ?)", nextID, p); err != nil {
+					return fmt.Errorf("failed to grant %s: %v", p, err)
+				}
+			}
+		}
+		after["permissions"] = perms
+	}
+	return recordAudit(tx, actor, AuditCreateUser, u.Username, nil, after)
 }
 
 // Increments the userID by one each time a new user is created
 func GetNextUserID(db *sql.DB) (int, error) {
+	return nextUserID(db)
+}
+
+func nextUserID(q dbtx) (int, error) {
 	//Gets most recent userID number
-	stmt, err := db.Prepare("SELECT COUNT(*) FROM users")
+	stmt, err := q.Prepare("SELECT COUNT(*) FROM users")
 	if err != nil {
 		return 0, err
 	}
@@ -70,142 +224,116 @@ func GetNextUserID(db *sql.DB) (int, error) {
 	return count + 1, nil
 }
 
-// Gets the User's current balance
-func GetUserBalance(db *sql.DB, username string) (float64, error) {
-	//Retrieve the current balance of the user
-	stmt, err := db.Prepare("SELECT starting_bal FROM users WHERE username = ?")
-	if err != nil {
-		return 0, err
+// Credits a deposit inside tx: checks the terminal's deposit limit, posts the
+// ledger entry and logs the transaction, returning the new balance and the
+// transaction's id
+func depositBalance(tx *sql.Tx, terminal, username, account string, amount models.Money) (models.Money, int64, error) {
+	if amount <= 0 {
+		return 0, 0, fmt.Errorf("deposit amount must be greater than zero")
 	}
-	defer stmt.Close()
 
-	//Error handling then returns the balance
-	var bal float64
-	err = stmt.QueryRow(username).Scan(&bal)
+	//Gets the withdraw and deposit limits and do error handling
+	_, depositLimit, err := atmLimits(tx, terminal)
 	if err != nil {
-		return 0, err
+		return 0, 0, fmt.Errorf("could not get ATM limits: %v", err)
 	}
-	return bal, nil
-}
-
-// Deposit money to the user's account
-func DepositBalance(db *sql.DB, username string, amount float64) (float64, error) {
-	//Retrieve the user's current balance
-	balance, err := GetUserBalance(db, username)
-	if err != nil {
-		return 0, fmt.Errorf("could not get balance: %v", err)
+	if amount > depositLimit {
+		return 0, 0, fmt.Errorf("your deposit amount $%s is over the deposit limit: $%s", amount, depositLimit)
 	}
 
-	//Gets the withdraw and deposit limits and do error handling
-	_, depositLimit, err := GetATMLimits(db)
+	acct, err := customerAccount(tx, username, account)
 	if err != nil {
-		fmt.Println("Error fetching limits:", err)
-	} else {
-		if amount > depositLimit {
-			return 0, fmt.Errorf("your deposit amount %f is over the deposit limit: %f", amount, depositLimit)
-		}
+		return 0, 0, err
 	}
-
-	//Find new balance
-	newBalance := balance + amount
-
-	//Update the user's balance with the new balance
-	stmtUpdUser, err := db.Prepare("UPDATE users SET starting_bal = ? WHERE username = ?")
-	if err != nil {
-		return 0, err
+	if err := checkCredit(acct); err != nil {
+		return 0, 0, err
 	}
-	defer stmtUpdUser.Close()
 
-	if _, err = stmtUpdUser.Exec(newBalance, username); err != nil {
-		return 0, fmt.Errorf("failed to update balance: %v", err)
+	//Check the customer's daily and rolling 24 hour caps
+	if err := checkVelocity(tx, acct.ID, models.KindDeposit, amount); err != nil {
+		return 0, 0, err
 	}
 
-	userID, err := GetUserID(db, username)
-	if err != nil {
-		return newBalance, fmt.Errorf("could not get user id: %v", err)
+	//Cash goes into the vault and is owed to the customer
+	memo := fmt.Sprintf("deposit by %s to %s at %s", username, acct.Number, terminal)
+	if err := postTransfer(tx, EntryDeposit, memo, VaultAccount, acct.Ledger, amount); err != nil {
+		return 0, 0, err
 	}
+	newBalance := acct.Balance + amount
 
 	//Update transaction log
-	stmtTrans, err := db.Prepare(`
-		INSERT INTO transactions (user_id, date, balance)
-		VALUES (?, datetime('now', 'localtime'), ?)`)
+	id, err := logTransaction(tx, models.Transaction{
+		USER_ID:      acct.UserID,
+		Account:      acct.Number,
+		Kind:         models.KindDeposit,
+		Amount:       amount,
+		BalanceAfter: &newBalance,
+		Terminal:     terminal,
+	})
 	if err != nil {
-		return newBalance, err
-	}
-	defer stmtTrans.Close()
-
-	_, err = stmtTrans.Exec(userID, amount)
-	if err != nil {
-		return newBalance, fmt.Errorf("failed to log transaction: %v", err)
+		return 0, 0, err
 	}
-
-	return newBalance, err
+	return newBalance, id, nil
 }
 
-// Withdraw money from the user's account
-func WithdrawBalance(db *sql.DB, username string, amount float64) (float64, error) {
-	//Get the user's current balance
-	balance, err := GetUserBalance(db, username)
-	if err != nil {
-		return 0, fmt.Errorf("could not get balance: %v", err)
+// Debits a withdrawal inside tx: checks the terminal's withdrawal limit and
+// the customer's balance, posts the ledger entry and logs the transaction, returning
+// the new balance and the transaction's id
+func withdrawBalance(tx *sql.Tx, terminal, username, account string, amount models.Money) (models.Money, int64, error) {
+	if amount <= 0 {
+		return 0, 0, fmt.Errorf("withdrawal amount must be greater than zero")
+	}
+	if err := checkInService(tx, terminal); err != nil {
+		return 0, 0, err
 	}
 
 	//Check if the user has enough money to withdraw the amount
-	withdrawLimit, _, err := GetATMLimits(db)
+	withdrawLimit, _, err := atmLimits(tx, terminal)
 	if err != nil {
-		fmt.Println("Error fetching limits:", err)
-	} else {
-		if amount > withdrawLimit {
-			return 0, fmt.Errorf("your withdrawl amount %f is over the withdrawl limit: %f", amount, withdrawLimit)
-		}
+		return 0, 0, fmt.Errorf("could not get ATM limits: %v", err)
+	}
+	if amount > withdrawLimit {
+		return 0, 0, fmt.Errorf("your withdrawl amount $%s is over the withdrawl limit: $%s", amount, withdrawLimit)
 	}
 
-	bal, err := GetATMBalance(db)
+	acct, err := customerAccount(tx, username, account)
 	if err != nil {
-		fmt.Println("Error checking total cash in ATM:", err)
-	} else {
-		if amount > bal {
-			return 0, fmt.Errorf("your withdrawl amount %f is over the ATM balance: %f", amount, bal)
-		}
+		return 0, 0, err
 	}
-
-	//Find the new balance after withdraw amount
-	newBalance := balance - amount
-	if newBalance < 0 {
-		return 0, fmt.Errorf("not enough in balance to withdraw. Current balance: $%.2f", balance)
+	if err := checkDebit(acct); err != nil {
+		return 0, 0, err
 	}
 
-	//Update the user's balance
-	stmtUpdUser, err := db.Prepare("UPDATE users SET starting_bal = ? WHERE username = ?")
-	if err != nil {
-		return 0, err
+	//Check the customer's daily and rolling 24 hour caps
+	if err := checkVelocity(tx, acct.ID, models.KindWithdrawal, amount); err != nil {
+		return 0, 0, err
 	}
-	defer stmtUpdUser.Close()
 
-	if _, err = stmtUpdUser.Exec(newBalance, username); err != nil {
-		return 0, fmt.Errorf("failed to update balance: %v", err)
+	//Find the new balance after withdraw amount
+	newBalance := acct.Balance - amount
+	if newBalance < 0 {
+		return 0, 0, fmt.Errorf("not enough in balance to withdraw. Current balance: $%s", acct.Balance)
 	}
 
-	userID, err := GetUserID(db, username)
-	if err != nil {
-		return newBalance, fmt.Errorf("could not get user id: %v", err)
+	//The customer is paid out of the vault
+	memo := fmt.Sprintf("withdrawal by %s from %s at %s", username, acct.Number, terminal)
+	if err := postTransfer(tx, EntryWithdraw, memo, acct.Ledger, VaultAccount, amount); err != nil {
+		return 0, 0, err
 	}
 
 	//Update transaction log
-	stmtTrans, err := db.Prepare(`
-		INSERT INTO transactions (user_id, date, balance)
-		VALUES (?, datetime('now', 'localtime'), ?)`)
-	if err != nil {
-		return newBalance, err
-	}
-	defer stmtTrans.Close()
-
-	_, err = stmtTrans.Exec(userID, -amount)
+	id, err := logTransaction(tx, models.Transaction{
+		USER_ID:      acct.UserID,
+		Account:      acct.Number,
+		Kind:         models.KindWithdrawal,
+		Amount:       -amount,
+		BalanceAfter: &newBalance,
+		Terminal:     terminal,
+	})
 	if err != nil {
-		return newBalance, fmt.Errorf("failed to log transaction: %v", err)
+		return 0, 0, err
 	}
-
-	return newBalance, err
+	return newBalance, id, nil
 }
 
 // Get the user's ID based on username
@@ -222,61 +350,107 @@ func GetUserID(db *sql.DB, username string) (int, error) {
 	return id, err
 }
 
-// Transfer funds from source user to target user
-func TransferFunds(db *sql.DB, sourceUser string, targetUser string, amount float64) error {
-	//Get the source user's current balance
-	sourceBalance, err := GetUserBalance(db, sourceUser)
-	if err != nil {
-		return fmt.Errorf("could not get source balance: %v", err)
-	}
+// Whether username belongs to a customer
+func CustomerExists(db *sql.DB, username string) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = ? AND role = ?)", username, models.RoleCustomer).Scan(&exists)
+	return exists, err
+}
 
-	//Get the target user's current balance
-	targetBalance, err := GetUserBalance(db, targetUser)
-	if err != nil {
-		return fmt.Errorf("could not get target balance: %v", err)
+// Transfer funds from one of actor's accounts to target user's main checking
+// account, made at terminal. An empty account number means actor's main
+// checking account. Returns the receipt for actor's side of the transfer.
+func TransferFunds(db *sql.DB, actor models.Actor, terminal, sourceAccount, targetUser string, amount models.Money) (models.Receipt, error) {
+	if err := authorize(db, actor, models.PermTransfer); err != nil {
+		return models.Receipt{}, err
 	}
-
-	//Find the new source balance after withdraw amount
-	newSourceBalance := sourceBalance - amount
-	if newSourceBalance < 0 {
-		return fmt.Errorf("not enough in balance to withdraw. Current balance: $%.2f", sourceBalance)
+	sourceUser := actor.Username
+	if amount <= 0 {
+		return models.Receipt{}, fmt.Errorf("transfer amount must be greater than zero")
 	}
 
-	//Find the new target balance after withdraw amount
-	newTargetBalance := targetBalance + amount
-
-	// Start a transaction to update both balances or none
+	// Start a transaction to post both legs or none
 	tx, err := db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to start transaction: %v", err)
+		return models.Receipt{}, fmt.Errorf("failed to start transaction: %v", err)
 	}
 	defer tx.Rollback() // Will rollback if we exit the function early
 
-	stmtUpdUser, err := tx.Prepare("UPDATE users SET starting_bal = ? WHERE username = ?")
+	source, err := customerAccount(tx, sourceUser, sourceAccount)
+	if err != nil {
+		return models.Receipt{}, fmt.Errorf("could not find source account: %v", err)
+	}
+	target, err := customerAccount(tx, targetUser, "")
 	if err != nil {
-		return fmt.Errorf("failed to prepare transfer transaction: %v", err)
+		return models.Receipt{}, fmt.Errorf("could not find target account: %v", err)
+	}
+	if err := checkDebit(source); err != nil {
+		return models.Receipt{}, err
+	}
+	if err := checkCredit(target); err != nil {
+		return models.Receipt{}, err
 	}
-	defer stmtUpdUser.Close()
 
-	if _, err = stmtUpdUser.Exec(newSourceBalance, sourceUser); err != nil {
-		return fmt.Errorf("failed to update source user balance: %v", err)
+	if source.Balance-amount < 0 {
+		return models.Receipt{}, fmt.Errorf("not enough in balance to withdraw. Current balance: $%s", source.Balance)
 	}
 
-	if _, err = stmtUpdUser.Exec(newTargetBalance, targetUser); err != nil {
-		return fmt.Errorf("failed to update target user balance: %v", err)
+	memo := fmt.Sprintf("transfer from %s to %s", sourceUser, targetUser)
+	err = postTransfer(tx, EntryTransfer, memo, source.Ledger, target.Ledger, amount)
+	if err != nil {
+		return models.Receipt{}, fmt.Errorf("failed to post transfer: %v", err)
+	}
+
+	//Log both legs under one correlation id
+	correlationID, err := newCorrelationID()
+	if err != nil {
+		return models.Receipt{}, err
+	}
+	newSourceBalance := source.Balance - amount
+	targetBalance := target.Balance + amount
+	legs := []models.Transaction{
+		{USER_ID: source.UserID, Account: source.Number, Kind: models.KindTransferOut, Amount: -amount, CounterpartyID: target.UserID, BalanceAfter: &newSourceBalance, CorrelationID: correlationID, Terminal: terminal},
+		{USER_ID: target.UserID, Account: target.Number, Kind: models.KindTransferIn, Amount: amount, CounterpartyID: source.UserID, BalanceAfter: &targetBalance, CorrelationID: correlationID, Terminal: terminal},
+	}
+	var ids []int64
+	for _, leg := range legs {
+		id, err := logTransaction(tx, leg)
+		if err != nil {
+			return models.Receipt{}, err
+		}
+		ids = append(ids, id)
+	}
+	r, err := receipt(tx, int(ids[0]))
+	if err != nil {
+		return models.Receipt{}, err
 	}
 
 	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %v", err)
+		return models.Receipt{}, fmt.Errorf("failed to commit transaction: %v", err)
 	}
 
-	return nil
+	return r, nil
 }
 
 // List all the current users inside the databases
-func ListUsers(db *sql.DB) ([]models.User, error) {
+func ListUsers(db *sql.DB, actor models.Actor) ([]models.User, error) {
+	if err := authorize(db, actor, models.PermUsersView); err != nil {
+		return nil, err
+	}
+	return listUsers(db)
+}
+
+// A user's balance on their main account according to the ledger, to select
+// from users u. starting_bal only records what they opened with.
+const userLedgerBalance = `(
+	SELECT COALESCE(SUM(p.credit - p.debit), 0)
+	FROM postings p
+	JOIN ledger_accounts a ON a.id = p.account_id
+	WHERE a.code = 'CUST-' || u.id)`
+
+func listUsers(q dbtx) ([]models.User, error) {
 	//Pull entire list of users in Database
-	stmt, err := db.Prepare(`SELECT id, full_name, dob, pin, starting_bal, username, role FROM users`)
+	stmt, err := q.Prepare(`SELECT u.id, u.full_name, u.dob, u.pin, ` + userLedgerBalance + `, u.username, u.role FROM users u`)
 	if err != nil {
 		return nil, err
 	}
@@ -300,112 +474,177 @@ func ListUsers(db *sql.DB) ([]models.User, error) {
 	return users, nil
 }
 
-// Show the list of transactions
-func ShowTransactions(db *sql.DB) error {
+// Show the list of transactions across every terminal
+func ShowTransactions(db *sql.DB, actor models.Actor) error {
 	//Retrieves all transcations
-	stmt, err := db.Prepare(`
-		SELECT t.id, u.username, t.date, t.balance
-		FROM transactions t
-		LEFT JOIN users u ON t.user_id = u.id
-		ORDER BY t.id ASC`)
+	txns, err := ListTransactions(db, actor)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
-
-	rows, err := stmt.Query()
-	if err != nil {
-		return fmt.Errorf("failed to query transactions: %v", err)
-	}
-	defer rows.Close()
 
 	//Print out each of the transactions
 	fmt.Println("\n===== TRANSACTION HISTORY =====")
-	fmt.Printf("%-5s | %-15s | %-20s | %-10s\n", "ID", "Username", "Date", "Amount ($)")
-	fmt.Println(strings.Repeat("-", 60))
-
-	for rows.Next() {
-		var id int
-		var username, date string
-		var amount float64
-		if err := rows.Scan(&id, &username, &date, &amount); err != nil {
-			return fmt.Errorf("failed to scan transaction: %v", err)
+	fmt.Printf("%-5s | %-15s | %-10s | %-10s | %-19s | %-13s | %12s | %12s | %-15s | %-16s\n",
+		"ID", "Username", "Account", "Terminal", "Date", "Type", "Amount ($)", "Balance ($)", "Counterparty", "Correlation")
+	fmt.Println(strings.Repeat("-", 158))
+
+	for _, t := range txns {
+		balanceAfter := "-"
+		if t.BalanceAfter != nil {
+			balanceAfter = t.BalanceAfter.String()
+		}
+		terminal := t.Terminal
+		if terminal == "" {
+			terminal = "-"
 		}
-		fmt.Printf("%-5d | %-15s | %-20s | %10.2f\n", id, username, date, amount)
+		account := t.Account
+		if account == "" {
+			account = "-"
+		}
+		fmt.Printf("%-5d | %-15s | %-10s | %-10s | %-19s | %-13s | %12s | %12s | %-15s | %-16s\n",
+			t.ID, t.Username, account, terminal, t.Date, t.Kind, t.Amount, balanceAfter, t.Counterparty, t.CorrelationID)
 	}
 
-	return rows.Err()
+	return nil
+}
+
+// A new limit for one of a terminal's atm limit columns
+type atmLimitChange struct {
+	Terminal string       `json:"terminal"`
+	Limit    models.Money `json:"limit"`
 }
 
-// Update the withdrawal limit
-func UpdateWithdrawalLimit(db *sql.DB, newLimit float64) error {
+// Asks for a terminal's withdrawal limit to be changed. It takes effect once
+// another admin approves it.
+func UpdateWithdrawalLimit(db *sql.DB, actor models.Actor, terminal string, newLimit models.Money, limits config.Limits) (models.Approval, error) {
 	if newLimit < 0 {
-		return fmt.Errorf("withdrawal limit cannot be negative")
+		return models.Approval{}, fmt.Errorf("withdrawal limit cannot be negative")
 	}
-	stmt, err := db.Prepare("UPDATE atm SET withdrawal_limit = ? WHERE id = 1")
+	return requestATMLimit(db, actor, terminal, "withdrawal", AuditWithdrawalLimit, newLimit, limits)
+}
+
+// Asks for a terminal's deposit limit to be changed. It takes effect once
+// another admin approves it.
+func UpdateDepositLimit(db *sql.DB, actor models.Actor, terminal string, newLimit models.Money, limits config.Limits) (models.Approval, error) {
+	if newLimit < 0 {
+		return models.Approval{}, fmt.Errorf("deposit limit cannot be negative")
+	}
+	return requestATMLimit(db, actor, terminal, "deposit", AuditDepositLimit, newLimit, limits)
+}
+
+func requestATMLimit(db *sql.DB, actor models.Actor, terminal, name, kind string, newLimit models.Money, limits config.Limits) (models.Approval, error) {
+	if err := authorize(db, actor, models.PermLimitsUpdate); err != nil {
+		return models.Approval{}, err
+	}
+	withdrawalLimit, depositLimit, err := atmLimits(db, terminal)
 	if err != nil {
-		return err
+		return models.Approval{}, err
 	}
-	defer stmt.Close()
-	_, err = stmt.Exec(newLimit)
-	return err
+	oldLimit := withdrawalLimit
+	if kind == AuditDepositLimit {
+		oldLimit = depositLimit
+	}
+	summary := fmt.Sprintf("%s limit at %s: $%s -> $%s", name, terminal, oldLimit, newLimit)
+	return requestApproval(db, actor, kind, terminal, summary, atmLimitChange{Terminal: terminal, Limit: newLimit}, limits)
+}
+
+// The atm column each limit approval kind sets
+var atmLimitColumns = map[string]string{
+	AuditWithdrawalLimit: "withdrawal_limit",
+	AuditDepositLimit:    "deposit_limit",
 }
 
-// Update the deposit limit
-func UpdateDepositLimit(db *sql.DB, newLimit float64) error {
+// Sets one of the atm limit columns inside tx and audits the change as
+// action, one of the keys of atmLimitColumns
+func updateATMLimit(tx *sql.Tx, actor models.Actor, action string, change atmLimitChange) error {
+	column := atmLimitColumns[action]
+	terminal, newLimit := change.Terminal, change.Limit
 	if newLimit < 0 {
-		return fmt.Errorf("deposit limit cannot be negative")
+		return fmt.Errorf("limit cannot be negative")
+	}
+
+	var oldLimit models.Money
+	err := tx.QueryRow("SELECT "+column+" FROM atm WHERE terminal_id = ?", terminal).Scan(&oldLimit)
+	if err == sql.ErrNoRows {
+		return unknownTerminal(terminal)
 	}
-	stmt, err := db.Prepare("UPDATE atm SET deposit_limit = ? WHERE id = 1")
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to read %s: %v", column, err)
 	}
-	defer stmt.Close()
-	_, err = stmt.Exec(newLimit)
-	return err
+	if _, err := tx.Exec("UPDATE atm SET "+column+" = ? WHERE terminal_id = ?", newLimit, terminal); err != nil {
+		return fmt.Errorf("failed to update %s: %v", column, err)
+	}
+	return recordAudit(tx, actor, action, terminal, map[string]any{column: oldLimit}, map[string]any{column: newLimit})
 }
 
-// Retrieve the ATM deposit and withdrawal limits
-func GetATMLimits(db *sql.DB) (float64, float64, error) {
-	stmt, err := db.Prepare(`SELECT withdrawal_limit, deposit_limit FROM atm WHERE id = 1`)
+// Retrieve a terminal's deposit and withdrawal limits
+func GetATMLimits(db *sql.DB, terminal string) (models.Money, models.Money, error) {
+	return atmLimits(db, terminal)
+}
+
+// Retrieve any terminal's deposit and withdrawal limits for an admin
+// reviewing or changing them
+func GetTerminalLimits(db *sql.DB, actor models.Actor, terminal string) (models.Money, models.Money, error) {
+	if err := authorize(db, actor, models.PermLimitsUpdate); err != nil {
+		return 0, 0, err
+	}
+	return atmLimits(db, terminal)
+}
+
+func atmLimits(q dbtx, terminal string) (models.Money, models.Money, error) {
+	stmt, err := q.Prepare(`SELECT withdrawal_limit, deposit_limit FROM atm WHERE terminal_id = ?`)
 	if err != nil {
 		return 0, 0, err
 	}
 	defer stmt.Close()
 
-	var withdrawalLimit, depositLimit float64
-	if err := stmt.QueryRow().Scan(&withdrawalLimit, &depositLimit); err != nil {
+	var withdrawalLimit, depositLimit models.Money
+	err = stmt.QueryRow(terminal).Scan(&withdrawalLimit, &depositLimit)
+	if err == sql.ErrNoRows {
+		return 0, 0, unknownTerminal(terminal)
+	}
+	if err != nil {
 		return 0, 0, err
 	}
 	return withdrawalLimit, depositLimit, nil
 }
 
-// Get the ATM's current balance
-func GetATMBalance(db *sql.DB) (float64, error) {
-	stmt, err := db.Prepare("SELECT balance FROM atm WHERE id = 1")
+// Get a terminal's current cash balance
+func GetATMBalance(db *sql.DB, terminal string) (models.Money, error) {
+	return atmBalance(db, terminal)
+}
+
+func atmBalance(q dbtx, terminal string) (models.Money, error) {
+	stmt, err := q.Prepare("SELECT balance FROM atm WHERE terminal_id = ?")
 	if err != nil {
 		return 0, err
 	}
 	defer stmt.Close()
 
-	var bal float64
-	err = stmt.QueryRow().Scan(&bal)
+	var bal models.Money
+	err = stmt.QueryRow(terminal).Scan(&bal)
+	if err == sql.ErrNoRows {
+		return 0, unknownTerminal(terminal)
+	}
 	return bal, err
 }
 
-// Print the ATM's new balance after updating it
-func PrintNewATMBalance(db *sql.DB) {
-	newBal, err := GetATMBalance(db)
+// Print a terminal's new balance after updating it
+func PrintNewATMBalance(db *sql.DB, terminal string) {
+	newBal, err := GetATMBalance(db, terminal)
 	if err != nil {
 		fmt.Println("Could not get balance:", err)
 		return
 	}
-	fmt.Printf("New ATM balance: $%.2f\n", newBal)
+	fmt.Printf("New ATM balance: $%s\n", newBal)
 }
 
-// Withdraw money from the atm from the Cash Handler
-func WithdrawATM(db *sql.DB, dec_amount float64, nHundreds, nFifties, nTwenties, nTens, nFives, nOnes int) error {
-	bal, err := GetATMBalance(db)
+// Removes bills from the ATM cassettes. The counts are only decremented if
+// every cassette still holds enough notes, so concurrent withdrawals can't
+// drive a cassette negative.
+func withdrawBills(q dbtx, terminal string, dec_amount models.Money, nHundreds, nFifties, nTwenties, nTens, nFives, nOnes int) error {
+	var bal models.Money
+	err := q.QueryRow("SELECT balance FROM atm WHERE terminal_id = ?", terminal).Scan(&bal)
 	if err != nil {
 		return fmt.Errorf("could not get ATM balance: %v", err)
 	}
@@ -414,30 +653,12 @@ func WithdrawATM(db *sql.DB, dec_amount float64, nHundreds, nFifties, nTwenties,
 		return fmt.Errorf("ATM does not have enough cash.")
 	}
 
-	// Get current bill counts
-	row := db.QueryRow(`
-		SELECT ones, fives, tens, twenties, fifties, hundreds
-		FROM atm WHERE id = 1;
-	`)
-
-	var ones, fives, tens, twenties, fifties, hundreds int
-	err = row.Scan(&ones, &fives, &tens, &twenties, &fifties, &hundreds)
-	if err != nil {
-		return fmt.Errorf("failed fetching ATM denominations: %v", err)
-	}
-
 	// Total from input
 	withdrawTotal := (nHundreds * 100) + (nFifties * 50) + (nTwenties * 20) +
 		(nTens * 10) + (nFives * 5) + (nOnes * 1)
 
-	if withdrawTotal != int(dec_amount) {
-		return fmt.Errorf("bills selected ($%d) do not match withdrawal amount $%.2f", withdrawTotal, dec_amount)
-	}
-
-	// Check availability
-	if nHundreds > hundreds || nFifties > fifties || nTwenties > twenties ||
-		nTens > tens || nFives > fives || nOnes > ones {
-		return fmt.Errorf("ATM does not have enough of one or more bill denominations")
+	if models.Dollars(int64(withdrawTotal)) != dec_amount {
+		return fmt.Errorf("bills selected ($%d) do not match withdrawal amount $%s", withdrawTotal, dec_amount)
 	}
 
 	if nHundreds < 0 || nFifties < 0 || nTwenties < 0 ||
@@ -445,40 +666,42 @@ func WithdrawATM(db *sql.DB, dec_amount float64, nHundreds, nFifties, nTwenties,
 		return fmt.Errorf("ATM does not have enough of one or more bill denominations")
 	}
 
-	// Deduct bills
-	hundreds -= nHundreds
-	fifties -= nFifties
-	twenties -= nTwenties
-	tens -= nTens
-	fives -= nFives
-	ones -= nOnes
-
-	// Update DB
-	stmt, err := db.Prepare(`
+	// Deduct bills, checking availability in the same statement
+	stmt, err := q.Prepare(`
 		UPDATE atm
-		SET ones = ?, fives = ?, tens = ?, twenties = ?, fifties = ?, hundreds = ?
-		WHERE id = 1`)
+		SET ones = ones - ?, fives = fives - ?, tens = tens - ?,
+			twenties = twenties - ?, fifties = fifties - ?, hundreds = hundreds - ?
+		WHERE terminal_id = ?
+			AND ones >= ? AND fives >= ? AND tens >= ?
+			AND twenties >= ? AND fifties >= ? AND hundreds >= ?`)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(ones, fives, tens, twenties, fifties, hundreds)
+	res, err := stmt.Exec(nOnes, nFives, nTens, nTwenties, nFifties, nHundreds, terminal,
+		nOnes, nFives, nTens, nTwenties, nFifties, nHundreds)
 	if err != nil {
 		return fmt.Errorf("failed to withdraw bills: %v", err)
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("ATM does not have enough of one or more bill denominations")
+	}
 
-	return nil
+	return checkCashAlerts(q, terminal)
 }
 
-// Withdraw money from the atm from the Cash Handler
-func DepositATM(db *sql.DB, denoms []int) error {
+// Adds bills to a terminal's cassettes, as long as they fit
+func depositBills(q dbtx, terminal string, denoms []int) error {
+	if err := checkCapacity(q, terminal, denoms); err != nil {
+		return err
+	}
 
 	// Get current bill counts
-	row := db.QueryRow(`
+	row := q.QueryRow(`
 		SELECT ones, fives, tens, twenties, fifties, hundreds
-		FROM atm WHERE id = 1;
-	`)
+		FROM atm WHERE terminal_id = ?;
+	`, terminal)
 
 	var ones, fives, tens, twenties, fifties, hundreds int
 	err := row.Scan(&ones, &fives, &tens, &twenties, &fifties, &hundreds)
@@ -495,19 +718,209 @@ func DepositATM(db *sql.DB, denoms []int) error {
 	ones += denoms[0]
 
 	// Update DB
-	stmt, err := db.Prepare(`
+	stmt, err := q.Prepare(`
 		UPDATE atm
 		SET ones = ?, fives = ?, tens = ?, twenties = ?, fifties = ?, hundreds = ?
-		WHERE id = 1`)
+		WHERE terminal_id = ?`)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(ones, fives, tens, twenties, fifties, hundreds)
+	_, err = stmt.Exec(ones, fives, tens, twenties, fifties, hundreds, terminal)
 	if err != nil {
 		return fmt.Errorf("failed to deposit bills: %v", err)
 	}
 
-	return nil
+	return checkCashAlerts(q, terminal)
+}
+
+// Total value of a deposit given as note counts ordered like Denominations
+func denominationTotal(denoms []int) models.Money {
+	total := 0
+	for i, value := range Denominations {
+		total += denoms[i] * value
+	}
+	return models.Dollars(int64(total))
+}
+
+// Customer deposits cash at terminal into one of their accounts. The notes go
+// into its cassettes and the amount is credited to the account in one
+// transaction. Returns the deposit's receipt.
+func DepositCash(db *sql.DB, actor models.Actor, terminal, account string, denoms []int) (models.Receipt, error) {
+	if err := authorize(db, actor, models.PermDeposit); err != nil {
+		return models.Receipt{}, err
+	}
+	if len(denoms) != len(Denominations) {
+		return models.Receipt{}, fmt.Errorf("expected %d denominations, got %d", len(Denominations), len(denoms))
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return models.Receipt{}, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := depositBills(tx, terminal, denoms); err != nil {
+		return models.Receipt{}, err
+	}
+
+	_, id, err := depositBalance(tx, terminal, actor.Username, account, denominationTotal(denoms))
+	if err != nil {
+		return models.Receipt{}, err
+	}
+	if err := recordTransactionNotes(tx, id, denoms); err != nil {
+		return models.Receipt{}, err
+	}
+	r, err := receipt(tx, int(id))
+	if err != nil {
+		return models.Receipt{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Receipt{}, fmt.Errorf("failed to commit deposit: %v", err)
+	}
+	return r, nil
+}
+
+// A terminal's cash balance and notes per denomination, for the audit log
+func cashSnapshot(q dbtx, terminal string) (map[string]any, error) {
+	var balance models.Money
+	if err := q.QueryRow("SELECT balance FROM atm WHERE terminal_id = ?", terminal).Scan(&balance); err != nil {
+		return nil, fmt.Errorf("could not get ATM balance: %v", err)
+	}
+	stock, err := atmDenominations(q, terminal)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"balance": balance, "notes": noteMap(stock)}, nil
+}
+
+// Logs a cash handler's load, unload or write-off with the terminal's balance
+// it left behind
+func logCashMovement(tx *sql.Tx, terminal, username, kind string, amount models.Money) error {
+	var userID int
+	if err := tx.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID); err != nil {
+		return fmt.Errorf("could not get user id: %v", err)
+	}
+
+	var atmBalance models.Money
+	if err := tx.QueryRow("SELECT balance FROM atm WHERE terminal_id = ?", terminal).Scan(&atmBalance); err != nil {
+		return fmt.Errorf("could not get ATM balance: %v", err)
+	}
+
+	_, err := logTransaction(tx, models.Transaction{
+		USER_ID:      userID,
+		Kind:         kind,
+		Amount:       amount,
+		BalanceAfter: &atmBalance,
+		Terminal:     terminal,
+	})
+	return err
+}
+
+// Cash handler loads bills into a terminal. The cash comes in from suspense
+// until it is reconciled against the branch's books.
+func LoadATMCash(db *sql.DB, actor models.Actor, terminal string, denoms []int) error {
+	if err := authorize(db, actor, models.PermCashLoad); err != nil {
+		return err
+	}
+	if len(denoms) != len(Denominations) {
+		return fmt.Errorf("expected %d denominations, got %d", len(Denominations), len(denoms))
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkTerminalAccess(tx, actor, terminal); err != nil {
+		return err
+	}
+	if err := checkNoActiveCount(tx, terminal); err != nil {
+		return err
+	}
+	before, err := cashSnapshot(tx, terminal)
+	if err != nil {
+		return err
+	}
+
+	if err := depositBills(tx, terminal, denoms); err != nil {
+		return err
+	}
+
+	amount := denominationTotal(denoms)
+	if amount > 0 {
+		err = postTransfer(tx, EntryCashLoad, "cash loaded by "+actor.Username+" at "+terminal, VaultAccount, SuspenseAccount, amount)
+		if err != nil {
+			return err
+		}
+		if err := logCashMovement(tx, terminal, actor.Username, models.KindCashLoad, amount); err != nil {
+			return err
+		}
+		if err := recordCashEvent(tx, terminal, actor.Username, models.CashEventLoad, denoms, 0, ""); err != nil {
+			return err
+		}
+	}
+
+	after, err := cashSnapshot(tx, terminal)
+	if err != nil {
+		return err
+	}
+	if err := recordAudit(tx, actor, AuditCashLoad, terminal, before, after); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Cash handler removes bills from a terminal into suspense
+func UnloadATMCash(db *sql.DB, actor models.Actor, terminal string, dec_amount models.Money, nHundreds, nFifties, nTwenties, nTens, nFives, nOnes int) error {
+	if err := authorize(db, actor, models.PermCashUnload); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkTerminalAccess(tx, actor, terminal); err != nil {
+		return err
+	}
+	if err := checkNoActiveCount(tx, terminal); err != nil {
+		return err
+	}
+	before, err := cashSnapshot(tx, terminal)
+	if err != nil {
+		return err
+	}
+
+	if err := withdrawBills(tx, terminal, dec_amount, nHundreds, nFifties, nTwenties, nTens, nFives, nOnes); err != nil {
+		return err
+	}
+
+	err = postTransfer(tx, EntryCashOut, "cash unloaded by "+actor.Username+" at "+terminal, SuspenseAccount, VaultAccount, dec_amount)
+	if err != nil {
+		return err
+	}
+
+	if err := logCashMovement(tx, terminal, actor.Username, models.KindCashUnload, -dec_amount); err != nil {
+		return err
+	}
+	notes := []int{-nOnes, -nFives, -nTens, -nTwenties, -nFifties, -nHundreds}
+	if err := recordCashEvent(tx, terminal, actor.Username, models.CashEventUnload, notes, 0, ""); err != nil {
+		return err
+	}
+
+	after, err := cashSnapshot(tx, terminal)
+	if err != nil {
+		return err
+	}
+	if err := recordAudit(tx, actor, AuditCashUnload, terminal, before, after); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
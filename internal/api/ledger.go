@@ -0,0 +1,156 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"database/sql"
+	"fmt"
+)
+
+// Ledger account codes for the ATM's own books
+const (
+	VaultAccount      = "ATM_VAULT"
+	SuspenseAccount   = "SUSPENSE"
+	VarianceAccount   = "CASH_VARIANCE"
+	InterestAccount   = "INTEREST_EXPENSE"
+	AdjustmentAccount = "MANUAL_ADJUSTMENT"
+	ClearingAccount   = "CHEQUE_CLEARING"
+)
+
+// Journal entry kinds
+const (
+	EntryOpening    = "opening"
+	EntryDeposit    = "deposit"
+	EntryWithdraw   = "withdrawal"
+	EntryTransfer   = "transfer"
+	EntryCashLoad   = "cash_load"
+	EntryCashOut    = "cash_unload"
+	EntryVariance   = "cash_variance"
+	EntryInterest   = "interest"
+	EntryAdjustment = "adjustment"
+	EntryCheque     = "cheque_deposit"
+)
+
+// Satisfied by both *sql.DB and *sql.Tx so helpers can run inside a transaction
+type dbtx interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Prepare(query string) (*sql.Stmt, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Ledger account code for a customer
+func CustomerAccount(userID int) string {
+	return fmt.Sprintf("CUST-%d", userID)
+}
+
+// Opens the ledger account for a user if it doesn't exist yet
+func openCustomerAccount(q dbtx, userID int, username string) error {
+	_, err := q.Exec(`
+		INSERT OR IGNORE INTO ledger_accounts (code, name, type, user_id)
+		VALUES (?, ?, 'customer', ?)`, CustomerAccount(userID), username, userID)
+	if err != nil {
+		return fmt.Errorf("failed to open ledger account: %v", err)
+	}
+	return nil
+}
+
+// Posts a balanced journal entry and returns its id. Every posting must be
+// either a debit or a credit, and total debits must equal total credits.
+func PostJournal(tx *sql.Tx, kind, memo string, postings []models.Posting) (int64, error) {
+	if len(postings) < 2 {
+		return 0, fmt.Errorf("journal entry needs at least two postings")
+	}
+
+	var debits, credits models.Money
+	for _, p := range postings {
+		if p.Debit < 0 || p.Credit < 0 || (p.Debit == 0) == (p.Credit == 0) {
+			return 0, fmt.Errorf("posting to %s must be a positive debit or credit", p.Account)
+		}
+		debits += p.Debit
+		credits += p.Credit
+	}
+	if debits != credits {
+		return 0, fmt.Errorf("unbalanced journal entry: debits %s, credits %s", debits, credits)
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO journal_entries (date, kind, memo)
+		VALUES (datetime('now', 'localtime'), ?, ?)`, kind, memo)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write journal entry: %v", err)
+	}
+	entryID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO postings (entry_id, account_id, debit, credit)
+		SELECT ?, id, ?, ? FROM ledger_accounts WHERE code = ?`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	for _, p := range postings {
+		res, err := stmt.Exec(entryID, p.Debit, p.Credit, p.Account)
+		if err != nil {
+			return 0, fmt.Errorf("failed to post to %s: %v", p.Account, err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return 0, fmt.Errorf("unknown ledger account %s", p.Account)
+		}
+	}
+
+	return entryID, nil
+}
+
+// Posts amount as a debit to one account and a credit to another
+func postTransfer(tx *sql.Tx, kind, memo, debitAccount, creditAccount string, amount models.Money) error {
+	_, err := PostJournal(tx, kind, memo, []models.Posting{
+		{Account: debitAccount, Debit: amount},
+		{Account: creditAccount, Credit: amount},
+	})
+	return err
+}
+
+// Returns credits minus debits for a ledger account, which is the balance the
+// bank owes on a customer account. Asset accounts such as the vault come out negative.
+func ledgerBalance(q dbtx, code string) (models.Money, error) {
+	var bal models.Money
+	err := q.QueryRow(`
+		SELECT COALESCE(SUM(p.credit - p.debit), 0)
+		FROM postings p
+		JOIN ledger_accounts a ON a.id = p.account_id
+		WHERE a.code = ?`, code).Scan(&bal)
+	return bal, err
+}
+
+// Gets the cash held in the ATM vault according to the ledger
+func GetVaultLedgerBalance(db *sql.DB) (models.Money, error) {
+	bal, err := ledgerBalance(db, VaultAccount)
+	return -bal, err
+}
+
+// Returns the ids of journal entries whose debits and credits don't match
+func UnbalancedEntries(db *sql.DB) ([]int, error) {
+	rows, err := db.Query(`
+		SELECT entry_id FROM postings
+		GROUP BY entry_id
+		HAVING SUM(debit) != SUM(credit)
+		ORDER BY entry_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
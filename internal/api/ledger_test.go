@@ -0,0 +1,89 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"database/sql"
+	"testing"
+)
+
+// Checks every journal entry balances and so does the ledger as a whole
+func checkLedgerBalanced(t *testing.T, conn *sql.DB) {
+	t.Helper()
+	ids, err := UnbalancedEntries(conn)
+	if err != nil {
+		t.Fatalf("find unbalanced entries: %v", err)
+	}
+	if len(ids) > 0 {
+		t.Errorf("unbalanced journal entries: %v", ids)
+	}
+	var debits, credits models.Money
+	if err := conn.QueryRow("SELECT COALESCE(SUM(debit), 0), COALESCE(SUM(credit), 0) FROM postings").Scan(&debits, &credits); err != nil {
+		t.Fatalf("sum postings: %v", err)
+	}
+	if debits != credits {
+		t.Errorf("debits $%s != credits $%s", debits, credits)
+	}
+}
+
+func TestLedgerBalances(t *testing.T) {
+	conn := openWithdrawDB(t, models.Dollars(500), 100)
+	admin := models.Actor{Username: "admin"}
+	customer := models.Actor{Username: "customer"}
+	if err := CreateUser(conn, admin, "Other Customer", "1990-01-01", "5678", 0, "other"); err != nil {
+		t.Fatalf("create other customer: %v", err)
+	}
+	checkLedgerBalanced(t, conn)
+
+	acct, err := customerAccount(conn, customer.Username, "")
+	if err != nil {
+		t.Fatalf("get account: %v", err)
+	}
+	other, err := customerAccount(conn, "other", "")
+	if err != nil {
+		t.Fatalf("get other account: %v", err)
+	}
+
+	// Applied in order to the same database
+	steps := []struct {
+		name      string
+		do        func() error
+		wantOwn   models.Money
+		wantOther models.Money
+	}{
+		{"deposit", func() error {
+			_, err := DepositCash(conn, customer, testTerminal, "", []int{0, 0, 1, 2, 0, 0})
+			return err
+		}, models.Dollars(550), 0},
+		{"withdraw", func() error {
+			_, err := WithdrawCash(conn, customer, testTerminal, "", DispensePlan{Amount: models.Dollars(60), Counts: []int{0, 0, 0, 3, 0, 0}})
+			return err
+		}, models.Dollars(490), 0},
+		{"transfer", func() error {
+			_, err := TransferFunds(conn, customer, testTerminal, "", "other", models.Dollars(90))
+			return err
+		}, models.Dollars(400), models.Dollars(90)},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			if err := step.do(); err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+			checkLedgerBalanced(t, conn)
+
+			own, err := ledgerBalance(conn, acct.Ledger)
+			if err != nil {
+				t.Fatalf("get ledger balance: %v", err)
+			}
+			if own != step.wantOwn {
+				t.Errorf("customer's balance is $%s, want $%s", own, step.wantOwn)
+			}
+			theirs, err := ledgerBalance(conn, other.Ledger)
+			if err != nil {
+				t.Fatalf("get other ledger balance: %v", err)
+			}
+			if theirs != step.wantOther {
+				t.Errorf("other customer's balance is $%s, want $%s", theirs, step.wantOther)
+			}
+		})
+	}
+}
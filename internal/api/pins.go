@@ -0,0 +1,175 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/config"
+	"SPG_ATM_Machine/internal/models"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"regexp"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var pinPattern = regexp.MustCompile(`^\d{6}$`)
+
+// Checks a new PIN against the PIN policy: 6 digits, no digit three times in
+// a row, no run of three sequential digits (e.g. 123 or 987) and not the
+// user's date of birth (dob as MM/DD/YYYY) in any common 6-digit form.
+func CheckPINPolicy(pin, dob string) error {
+	if !pinPattern.MatchString(pin) {
+		return fmt.Errorf("PIN must be exactly 6 digits")
+	}
+	for i := 2; i < len(pin); i++ {
+		a, b, c := int(pin[i-2]), int(pin[i-1]), int(pin[i])
+		if a == b && b == c {
+			return fmt.Errorf("PIN cannot repeat a digit three times in a row")
+		}
+		if (b-a == 1 && c-b == 1) || (a-b == 1 && b-c == 1) {
+			return fmt.Errorf("PIN cannot contain three sequential digits")
+		}
+	}
+
+	if born, err := time.Parse("01/02/2006", dob); err == nil {
+		for _, layout := range []string{"010206", "020106", "060102", "012006", "200601"} {
+			if pin == born.Format(layout) {
+				return fmt.Errorf("PIN cannot be your date of birth")
+			}
+		}
+	}
+	return nil
+}
+
+// Whether the user logged in with a temporary PIN and has to pick a new one
+func PINChangeRequired(db *sql.DB, username string) (bool, error) {
+	var mustChange bool
+	err := db.QueryRow("SELECT pin_must_change FROM users WHERE username = ?", username).Scan(&mustChange)
+	if err == sql.ErrNoRows {
+		return false, fmt.Errorf("user '%s' not found", username)
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not check PIN status: %v", err)
+	}
+	return mustChange, nil
+}
+
+// Changes a user's PIN at terminal after checking their current one. A wrong
+// current PIN counts as a failed login attempt.
+func ChangePIN(db *sql.DB, actor models.Actor, terminal, username, oldPIN, newPIN string, limits config.Limits) error {
+	if err := authorize(db, actor, models.PermPINChange); err != nil {
+		return err
+	}
+	if err := CheckPIN(db, terminal, username, oldPIN, limits); err != nil {
+		return err
+	}
+	if newPIN == oldPIN {
+		return fmt.Errorf("new PIN must be different from the current PIN")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var dob string
+	var mustChange bool
+	err = tx.QueryRow("SELECT COALESCE(dob, ''), pin_must_change FROM users WHERE username = ?", username).Scan(&dob, &mustChange)
+	if err != nil {
+		return fmt.Errorf("could not get user: %v", err)
+	}
+	if err := CheckPINPolicy(newPIN, dob); err != nil {
+		return err
+	}
+
+	hashedPin, err := bcrypt.GenerateFromPassword([]byte(newPIN), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash PIN: %v", err)
+	}
+	_, err = tx.Exec("UPDATE users SET pin = ?, pin_must_change = 0, pin_changed_at = ? WHERE username = ?",
+		string(hashedPin), time.Now().Format(dbDateLayout), username)
+	if err != nil {
+		return fmt.Errorf("failed to change PIN: %v", err)
+	}
+
+	// The audit log never holds PINs or their hashes
+	before := map[string]any{"must_change": mustChange}
+	after := map[string]any{"must_change": false}
+	if err := recordAudit(tx, actor, AuditPINChange, username, before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Replaces a user's PIN with a random temporary PIN that they must change at
+// their next login. A locked account stays locked: unlocking needs a second
+// admin's approval. Returns the temporary PIN to hand to the user. Admins
+// can't reset their own PIN.
+func ResetPIN(db *sql.DB, actor models.Actor, username string) (string, error) {
+	if err := authorize(db, actor, models.PermPINReset); err != nil {
+		return "", err
+	}
+	if username == actor.Username {
+		return "", fmt.Errorf("you cannot reset your own PIN")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var dob string
+	var attempts int
+	var mustChange bool
+	err = tx.QueryRow(`
+		SELECT COALESCE(dob, ''), failed_attempts, pin_must_change
+		FROM users WHERE username = ?`, username).Scan(&dob, &attempts, &mustChange)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("no user found with username '%s'", username)
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not get user: %v", err)
+	}
+
+	tempPIN, err := temporaryPIN(dob)
+	if err != nil {
+		return "", err
+	}
+	hashedPin, err := bcrypt.GenerateFromPassword([]byte(tempPIN), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash PIN: %v", err)
+	}
+	_, err = tx.Exec(`
+		UPDATE users SET pin = ?, pin_must_change = 1, pin_changed_at = ?, failed_attempts = 0
+		WHERE username = ?`, string(hashedPin), time.Now().Format(dbDateLayout), username)
+	if err != nil {
+		return "", fmt.Errorf("failed to reset PIN: %v", err)
+	}
+
+	before := map[string]any{"failed_attempts": attempts, "must_change": mustChange}
+	after := map[string]any{"failed_attempts": 0, "must_change": true}
+	if err := recordAudit(tx, actor, AuditPINReset, username, before, after); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return tempPIN, nil
+}
+
+// Picks a random PIN that passes the PIN policy
+func temporaryPIN(dob string) (string, error) {
+	for {
+		n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+		if err != nil {
+			return "", fmt.Errorf("failed to generate PIN: %v", err)
+		}
+		pin := fmt.Sprintf("%06d", n.Int64())
+		if CheckPINPolicy(pin, dob) == nil {
+			return pin, nil
+		}
+	}
+}
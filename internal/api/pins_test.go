@@ -0,0 +1,47 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckPINPolicy(t *testing.T) {
+	const dob = "03/14/1990"
+	tests := []struct {
+		pin     string
+		wantErr string // "" when the PIN is allowed
+	}{
+		{"482915", ""},
+		{"135792", ""},
+		{"12345", "exactly 6 digits"},
+		{"1234567", "exactly 6 digits"},
+		{"48a915", "exactly 6 digits"},
+		{"481115", "repeat a digit"},
+		{"000000", "repeat a digit"},
+		{"481235", "sequential digits"},
+		{"498765", "sequential digits"},
+		// The date of birth in each layout
+		{"031490", "date of birth"},
+		{"140390", "date of birth"},
+		{"900314", "date of birth"},
+		{"031990", "date of birth"},
+		{"199003", "date of birth"},
+	}
+	for _, tc := range tests {
+		err := CheckPINPolicy(tc.pin, dob)
+		if tc.wantErr == "" {
+			if err != nil {
+				t.Errorf("CheckPINPolicy(%q) = %v, want nil", tc.pin, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("CheckPINPolicy(%q) = %v, want an error containing %q", tc.pin, err, tc.wantErr)
+		}
+	}
+
+	// An unreadable date of birth only skips the birthday check
+	if err := CheckPINPolicy("031490", ""); err != nil {
+		t.Errorf("CheckPINPolicy with no date of birth = %v, want nil", err)
+	}
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var ErrPermissionDenied = errors.New("permission denied")
+
+// The permissions a user holds: everything their role grants, narrowed to
+// their user_permissions rows if they have any
+const userPermissionsQuery = `
+	SELECT p.name, p.description
+	FROM users u
+	JOIN role_permissions rp ON rp.role = LOWER(u.role)
+	JOIN permissions p ON p.name = rp.permission
+	WHERE u.username = ?
+		AND (NOT EXISTS (SELECT 1 FROM user_permissions WHERE user_id = u.id)
+			OR EXISTS (SELECT 1 FROM user_permissions up WHERE up.user_id = u.id AND up.permission = p.name))`
+
+// The permissions a role grants
+const rolePermissionsQuery = `
+	SELECT p.name, p.description FROM role_permissions rp
+	JOIN permissions p ON p.name = rp.permission
+	WHERE rp.role = ? ORDER BY p.name`
+
+// Checks that actor holds permission. The actor's role and scope are read
+// from the database, so a caller can't widen them by filling in Actor.Role.
+func authorize(q dbtx, actor models.Actor, permission string) error {
+	var ok bool
+	err := q.QueryRow(`SELECT EXISTS(SELECT 1 FROM (`+userPermissionsQuery+`) WHERE name = ?)`,
+		actor.Username, permission).Scan(&ok)
+	if err != nil {
+		return fmt.Errorf("could not check permissions: %v", err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s does not have %s", ErrPermissionDenied, actor.Username, permission)
+	}
+	return nil
+}
+
+// Lets actor read username's details if they are their own, or if actor
+// holds users.view
+func authorizeUserRead(q dbtx, actor models.Actor, username string) error {
+	if actor.Username == username {
+		return nil
+	}
+	return authorize(q, actor, models.PermUsersView)
+}
+
+func listPermissions(q dbtx, query string, args ...any) ([]models.Permission, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query permissions: %v", err)
+	}
+	defer rows.Close()
+
+	var perms []models.Permission
+	for rows.Next() {
+		var p models.Permission
+		if err := rows.Scan(&p.Name, &p.Description); err != nil {
+			return nil, fmt.Errorf("failed to scan permission: %v", err)
+		}
+		perms = append(perms, p)
+	}
+	return perms, rows.Err()
+}
+
+// The permissions username holds
+func UserPermissions(db *sql.DB, username string) ([]models.Permission, error) {
+	return listPermissions(db, userPermissionsQuery+" ORDER BY p.name", username)
+}
+
+// Whether actor holds permission. Menus use it to hide what the actor can't do.
+func HasPermission(db *sql.DB, actor models.Actor, permission string) (bool, error) {
+	err := authorize(db, actor, permission)
+	if errors.Is(err, ErrPermissionDenied) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Gets a role and the permissions it grants
+func GetRole(db *sql.DB, name string) (models.Role, error) {
+	r := models.Role{Name: name}
+	err := db.QueryRow("SELECT description FROM roles WHERE name = ?", name).Scan(&r.Description)
+	if err == sql.ErrNoRows {
+		return models.Role{}, fmt.Errorf("unknown role %q", name)
+	}
+	if err != nil {
+		return models.Role{}, fmt.Errorf("could not get role: %v", err)
+	}
+	r.Permissions, err = listPermissions(db, rolePermissionsQuery, name)
+	if err != nil {
+		return models.Role{}, err
+	}
+	return r, nil
+}
+
+// Lists every role with the permissions it grants
+func ListRoles(db *sql.DB, actor models.Actor) ([]models.Role, error) {
+	if err := authorize(db, actor, models.PermStaffCreate); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT name FROM roles ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query roles: %v", err)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan role: %v", err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	roles := make([]models.Role, 0, len(names))
+	for _, name := range names {
+		r, err := GetRole(db, name)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, r)
+	}
+	return roles, nil
+}
+
+// Checks the permissions a new user is to be scoped to and returns the ones
+// they will hold: all of role's when scope is empty. A new admin can't be
+// given anything the actor doesn't hold, so a scoped admin can't create a
+// wider one.
+func grantablePermissions(q dbtx, actor models.Actor, role string, scope []string) ([]string, error) {
+	rolePerms, err := listPermissions(q, rolePermissionsQuery, role)
+	if err != nil {
+		return nil, err
+	}
+	granted := map[string]bool{}
+	for _, p := range rolePerms {
+		granted[p.Name] = false
+	}
+
+	if len(scope) == 0 {
+		for _, p := range rolePerms {
+			scope = append(scope, p.Name)
+		}
+	}
+	var perms []string
+	for _, name := range scope {
+		done, ok := granted[name]
+		if !ok {
+			return nil, fmt.Errorf("role %q does not grant %s", role, name)
+		}
+		if done {
+			continue
+		}
+		granted[name] = true
+		if role == models.RoleAdmin {
+			if err := authorize(q, actor, name); err != nil {
+				return nil, fmt.Errorf("cannot grant %s: %w", name, err)
+			}
+		}
+		perms = append(perms, name)
+	}
+	return perms, nil
+}
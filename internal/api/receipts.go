@@ -0,0 +1,245 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Folder receipts are spooled to for the receipt printer
+const ReceiptDir = "receipts"
+
+// Width of a printed receipt in characters
+const receiptWidth = 40
+
+// Records the notes a cash transaction took in or paid out, ordered like
+// Denominations
+func recordTransactionNotes(tx *sql.Tx, id int64, notes []int) error {
+	_, err := tx.Exec(`
+		INSERT INTO transaction_notes (transaction_id, ones, fives, tens, twenties, fifties, hundreds)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id, notes[0], notes[1], notes[2], notes[3], notes[4], notes[5])
+	if err != nil {
+		return fmt.Errorf("failed to record notes: %v", err)
+	}
+	return nil
+}
+
+// Builds the receipt for a deposit, withdrawal or outgoing transfer from the
+// transaction log
+func receipt(q dbtx, id int) (models.Receipt, error) {
+	rows, err := q.Query(transactionColumns+" WHERE t.id = ?", id)
+	if err != nil {
+		return models.Receipt{}, fmt.Errorf("failed to query transaction: %v", err)
+	}
+	txns, err := scanTransactions(rows)
+	if err != nil {
+		return models.Receipt{}, err
+	}
+	if len(txns) == 0 {
+		return models.Receipt{}, fmt.Errorf("transaction %d not found", id)
+	}
+	t := txns[0]
+	switch t.Kind {
+	case models.KindDeposit, models.KindCheque, models.KindWithdrawal, models.KindTransferOut:
+	default:
+		return models.Receipt{}, fmt.Errorf("transaction %d is a %s; receipts are only printed for deposits, withdrawals and transfers", id, t.Kind)
+	}
+	if t.BalanceAfter == nil || t.Account == "" {
+		return models.Receipt{}, fmt.Errorf("transaction %d predates account balances and has no receipt", id)
+	}
+
+	amount := t.Amount
+	if amount < 0 {
+		amount = -amount
+	}
+	r := models.Receipt{
+		Terminal:      t.Terminal,
+		TransactionID: t.ID,
+		Kind:          t.Kind,
+		Account:       models.MaskAccount(t.Account),
+		Amount:        amount,
+		Available:     *t.BalanceAfter,
+		Timestamp:     t.Date,
+	}
+
+	var notes [6]int
+	err = q.QueryRow(`
+		SELECT ones, fives, tens, twenties, fifties, hundreds
+		FROM transaction_notes WHERE transaction_id = ?`, id).
+		Scan(&notes[0], &notes[1], &notes[2], &notes[3], &notes[4], &notes[5])
+	switch {
+	case err == nil:
+		r.Notes = map[string]int{}
+		for i, d := range Denominations {
+			if notes[i] != 0 {
+				r.Notes[fmt.Sprint(d)] = notes[i]
+			}
+		}
+	case err != sql.ErrNoRows:
+		return models.Receipt{}, fmt.Errorf("could not get notes: %v", err)
+	}
+
+	// A transfer to another customer names them; one between the customer's
+	// own accounts shows where the money went
+	if t.Kind == models.KindTransferOut {
+		if t.Counterparty != "" {
+			r.ToUser = t.Counterparty
+		} else if t.CorrelationID != "" {
+			var to string
+			err := q.QueryRow(`
+				SELECT COALESCE(a.number, '')
+				FROM transactions t
+				LEFT JOIN accounts a ON a.id = t.account_id
+				WHERE t.correlation_id = ? AND t.id != ?`, t.CorrelationID, id).Scan(&to)
+			if err != nil && err != sql.ErrNoRows {
+				return models.Receipt{}, fmt.Errorf("could not get transfer account: %v", err)
+			}
+			if to != "" {
+				r.ToAccount = models.MaskAccount(to)
+			}
+		}
+	}
+	return r, nil
+}
+
+// Builds a copy of a customer's receipt by transaction id, for an admin to
+// reprint. The reprint is audited.
+func ReprintReceipt(db *sql.DB, actor models.Actor, id int) (models.Receipt, error) {
+	if err := authorize(db, actor, models.PermTransactionsView); err != nil {
+		return models.Receipt{}, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return models.Receipt{}, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	r, err := receipt(tx, id)
+	if err != nil {
+		return models.Receipt{}, err
+	}
+	r.Reprint = true
+	after := map[string]any{"kind": r.Kind, "account": r.Account, "amount": r.Amount}
+	if err := recordAudit(tx, actor, AuditReceiptReprint, fmt.Sprintf("transaction %d", id), nil, after); err != nil {
+		return models.Receipt{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.Receipt{}, fmt.Errorf("failed to commit reprint: %v", err)
+	}
+	return r, nil
+}
+
+func receiptKind(kind string) string {
+	switch kind {
+	case models.KindDeposit:
+		return "Cash deposit"
+	case models.KindCheque:
+		return "Cheque deposit"
+	case models.KindWithdrawal:
+		return "Cash withdrawal"
+	case models.KindTransferOut:
+		return "Transfer"
+	}
+	return kind
+}
+
+// Writes the receipt as it is printed
+func WriteReceiptText(w io.Writer, r models.Receipt) error {
+	var b strings.Builder
+	rule := strings.Repeat("=", receiptWidth)
+	line := func(label, value string) {
+		if label != "" {
+			label += ":"
+		}
+		fmt.Fprintf(&b, "%-13s%s\n", label, value)
+	}
+
+	fmt.Fprintln(&b, rule)
+	if r.Bank != "" {
+		fmt.Fprintln(&b, r.Bank)
+	}
+	fmt.Fprintln(&b, "ATM Receipt")
+	if r.Reprint {
+		fmt.Fprintln(&b, "*** DUPLICATE ***")
+	}
+	fmt.Fprintln(&b, rule)
+	line("Date", r.Timestamp)
+	if r.Terminal != "" {
+		line("Terminal", r.Terminal)
+	}
+	line("Transaction", fmt.Sprint(r.TransactionID))
+	line("Account", r.Account)
+	line("Type", receiptKind(r.Kind))
+	switch {
+	case r.ToAccount != "":
+		line("To account", r.ToAccount)
+	case r.ToUser != "":
+		line("To", r.ToUser)
+	}
+	line("Amount", "$"+r.Amount.String())
+	if r.Notes != nil {
+		first := true
+		for _, d := range Denominations {
+			n := r.Notes[fmt.Sprint(d)]
+			if n == 0 {
+				continue
+			}
+			label := ""
+			if first {
+				label = "Notes"
+			}
+			line(label, fmt.Sprintf("%d x $%d", n, d))
+			first = false
+		}
+	}
+	fmt.Fprintln(&b, strings.Repeat("-", receiptWidth))
+	line("Available", "$"+r.Available.String())
+	fmt.Fprintln(&b, rule)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Writes the receipt to the receipts folder as text and JSON for the printer
+// and returns the path of the text file. The JSON file sits next to it. A
+// reprint gets its own files so the original is kept.
+func SpoolReceipt(r models.Receipt) (string, error) {
+	if err := os.MkdirAll(ReceiptDir, 0o700); err != nil {
+		return "", fmt.Errorf("could not create receipt folder: %v", err)
+	}
+
+	terminal := r.Terminal
+	if terminal == "" {
+		terminal = "none"
+	}
+	name := fmt.Sprintf("%s_%d", terminal, r.TransactionID)
+	if r.Reprint {
+		name += "_reprint_" + time.Now().Format("20060102150405")
+	}
+	base := filepath.Join(ReceiptDir, name)
+
+	encoded, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode receipt: %v", err)
+	}
+	if err := os.WriteFile(base+".json", append(encoded, '\n'), 0o600); err != nil {
+		return "", fmt.Errorf("could not write receipt: %v", err)
+	}
+
+	f, err := os.OpenFile(base+".txt", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("could not create receipt file: %v", err)
+	}
+	defer f.Close()
+	if err := WriteReceiptText(f, r); err != nil {
+		return "", err
+	}
+	return base + ".txt", f.Close()
+}
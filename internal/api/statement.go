@@ -0,0 +1,197 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Folder exported statements are written to
+const StatementDir = "statements"
+
+// Layout of dates in journal_entries and transactions
+const dbDateLayout = "2006-01-02 15:04:05"
+
+// Get the most recent transactions on one of a user's accounts, newest first.
+// An empty account number means their main checking account. Customers can
+// read their own; anyone else needs users.view.
+func GetRecentTransactions(db *sql.DB, actor models.Actor, username, account string, n int) ([]models.Transaction, error) {
+	if err := authorizeUserRead(db, actor, username); err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return nil, fmt.Errorf("number of transactions must be greater than zero")
+	}
+
+	acct, err := customerAccount(db, username, account)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(transactionColumns+`
+		WHERE t.account_id = ?
+		ORDER BY t.date DESC, t.id DESC
+		LIMIT ?`, acct.ID, n)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transactions: %v", err)
+	}
+	return scanTransactions(rows)
+}
+
+// Builds a statement for the days from through to (inclusive) from the
+// ledger postings of one of the user's accounts. The opening balance is
+// everything posted before from, and each line carries the running balance.
+// Customers can read their own; anyone else needs users.view.
+func GetStatement(db *sql.DB, actor models.Actor, username, account string, from, to time.Time) (models.Statement, error) {
+	if err := authorizeUserRead(db, actor, username); err != nil {
+		return models.Statement{}, err
+	}
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.Local)
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.Local)
+	if to.Before(from) {
+		return models.Statement{}, fmt.Errorf("statement end date is before the start date")
+	}
+	end := to.AddDate(0, 0, 1)
+
+	st := models.Statement{Username: username, From: from, To: to}
+
+	err := db.QueryRow("SELECT full_name FROM users WHERE username = ?", username).Scan(&st.FullName)
+	if err != nil {
+		return models.Statement{}, fmt.Errorf("could not find user: %v", err)
+	}
+	acct, err := customerAccount(db, username, account)
+	if err != nil {
+		return models.Statement{}, err
+	}
+	st.Account, st.AccountType = acct.Number, acct.Type
+
+	err = db.QueryRow(`
+		SELECT COALESCE(SUM(p.credit - p.debit), 0)
+		FROM postings p
+		JOIN ledger_accounts a ON a.id = p.account_id
+		JOIN journal_entries je ON je.id = p.entry_id
+		WHERE a.code = ? AND je.date < ?`, acct.Ledger, from.Format(dbDateLayout)).Scan(&st.Opening)
+	if err != nil {
+		return models.Statement{}, fmt.Errorf("could not get opening balance: %v", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT je.id, je.date, je.kind, COALESCE(je.memo, ''), p.credit - p.debit
+		FROM postings p
+		JOIN ledger_accounts a ON a.id = p.account_id
+		JOIN journal_entries je ON je.id = p.entry_id
+		WHERE a.code = ? AND je.date >= ? AND je.date < ?
+		ORDER BY je.date ASC, je.id ASC`, acct.Ledger, from.Format(dbDateLayout), end.Format(dbDateLayout))
+	if err != nil {
+		return models.Statement{}, fmt.Errorf("failed to query statement: %v", err)
+	}
+	defer rows.Close()
+
+	running := st.Opening
+	for rows.Next() {
+		var line models.StatementLine
+		if err := rows.Scan(&line.EntryID, &line.Date, &line.Kind, &line.Description, &line.Amount); err != nil {
+			return models.Statement{}, fmt.Errorf("failed to scan statement line: %v", err)
+		}
+		running += line.Amount
+		line.Balance = running
+		st.Lines = append(st.Lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return models.Statement{}, err
+	}
+
+	st.Closing = running
+	return st, nil
+}
+
+// Writes the statement as CSV with a header row per line item
+func WriteStatementCSV(w io.Writer, st models.Statement) error {
+	cw := csv.NewWriter(w)
+	records := [][]string{
+		{"Account", st.Account, st.AccountType, st.Username},
+		{"Period", st.From.Format("2006-01-02"), st.To.Format("2006-01-02")},
+		{"Opening Balance", st.Opening.String()},
+		{"Entry", "Date", "Type", "Description", "Amount", "Balance"},
+	}
+	for _, l := range st.Lines {
+		records = append(records, []string{
+			fmt.Sprint(l.EntryID), l.Date, l.Kind, l.Description, l.Amount.String(), l.Balance.String(),
+		})
+	}
+	records = append(records, []string{"Closing Balance", st.Closing.String()})
+
+	if err := cw.WriteAll(records); err != nil {
+		return fmt.Errorf("failed to write statement: %v", err)
+	}
+	return nil
+}
+
+// Writes the statement as a printable text page
+func WriteStatementText(w io.Writer, st models.Statement) error {
+	var b strings.Builder
+	rule := strings.Repeat("=", 96)
+	fmt.Fprintln(&b, rule)
+	if st.Bank != "" {
+		fmt.Fprintf(&b, "%s - Account Statement\n", st.Bank)
+	} else {
+		fmt.Fprintln(&b, "Account Statement")
+	}
+	fmt.Fprintln(&b, rule)
+	fmt.Fprintf(&b, "Account holder: %s (%s)\n", st.FullName, st.Username)
+	fmt.Fprintf(&b, "Account:        %s (%s)\n", st.Account, st.AccountType)
+	fmt.Fprintf(&b, "Period:         %s to %s\n", st.From.Format("01/02/2006"), st.To.Format("01/02/2006"))
+	fmt.Fprintf(&b, "Generated:      %s\n\n", time.Now().Format("01/02/2006 15:04"))
+	fmt.Fprintf(&b, "%-19s | %-11s | %-30s | %12s | %12s\n", "Date", "Type", "Description", "Amount ($)", "Balance ($)")
+	fmt.Fprintln(&b, strings.Repeat("-", 96))
+	fmt.Fprintf(&b, "%-19s | %-11s | %-30s | %12s | %12s\n", st.From.Format(dbDateLayout), "", "Opening balance", "", st.Opening)
+	for _, l := range st.Lines {
+		desc := l.Description
+		if len(desc) > 30 {
+			desc = desc[:27] + "..."
+		}
+		fmt.Fprintf(&b, "%-19s | %-11s | %-30s | %12s | %12s\n", l.Date, l.Kind, desc, l.Amount, l.Balance)
+	}
+	fmt.Fprintln(&b, strings.Repeat("-", 96))
+	fmt.Fprintf(&b, "%-19s | %-11s | %-30s | %12s | %12s\n", st.To.Format("2006-01-02")+" 23:59:59", "", "Closing balance", "", st.Closing)
+	fmt.Fprintln(&b, rule)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Exports the statement to the statements folder as "csv" or "txt" and
+// returns the path of the file written
+func ExportStatement(st models.Statement, format string) (string, error) {
+	write := WriteStatementCSV
+	switch format {
+	case "csv":
+	case "txt":
+		write = WriteStatementText
+	default:
+		return "", fmt.Errorf("unknown statement format %q", format)
+	}
+
+	if err := os.MkdirAll(StatementDir, 0o700); err != nil {
+		return "", fmt.Errorf("could not create statement folder: %v", err)
+	}
+
+	name := fmt.Sprintf("%s_%s_%s_%s.%s", st.Username, st.Account, st.From.Format("20060102"), st.To.Format("20060102"), format)
+	path := filepath.Join(StatementDir, name)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("could not create statement file: %v", err)
+	}
+	defer f.Close()
+
+	if err := write(f, st); err != nil {
+		return "", err
+	}
+	return path, f.Close()
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var ErrTerminalNotAssigned = errors.New("not assigned to this terminal")
+
+var terminalIDPattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9-]{0,15}$`)
+
+func unknownTerminal(terminal string) error {
+	return fmt.Errorf("unknown terminal %q", terminal)
+}
+
+// Cash handlers may only service the terminals assigned to them. Other roles
+// aren't restricted by terminal.
+func checkTerminalAccess(q dbtx, actor models.Actor, terminal string) error {
+	if actor.Role != models.RoleCashHandler {
+		return nil
+	}
+	ok, err := canService(q, actor.Username, terminal)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s is %w %s", actor.Username, ErrTerminalNotAssigned, terminal)
+	}
+	return nil
+}
+
+func canService(q dbtx, username, terminal string) (bool, error) {
+	var ok bool
+	err := q.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM terminal_handlers th
+			JOIN users u ON u.id = th.user_id
+			WHERE u.username = ? AND th.terminal_id = ?)`, username, terminal).Scan(&ok)
+	if err != nil {
+		return false, fmt.Errorf("could not check terminal assignment: %v", err)
+	}
+	return ok, nil
+}
+
+// Whether the cash handler is assigned to terminal
+func CanServiceTerminal(db *sql.DB, username, terminal string) (bool, error) {
+	return canService(db, username, terminal)
+}
+
+// Lists every terminal with its cash, limits and assigned cash handlers
+func ListTerminals(db *sql.DB, actor models.Actor) ([]models.Terminal, error) {
+	if err := authorize(db, actor, models.PermTerminalsManage); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`
+		SELECT terminal_id, branch, balance, withdrawal_limit, deposit_limit, out_of_service,
+			ones, fives, tens, twenties, fifties, hundreds
+		FROM atm ORDER BY terminal_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query terminals: %v", err)
+	}
+
+	var terminals []models.Terminal
+	for rows.Next() {
+		t := models.Terminal{Notes: make([]int, len(Denominations))}
+		err := rows.Scan(&t.ID, &t.Branch, &t.Balance, &t.WithdrawalLimit, &t.DepositLimit, &t.OutOfService,
+			&t.Notes[0], &t.Notes[1], &t.Notes[2], &t.Notes[3], &t.Notes[4], &t.Notes[5])
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read terminal: %v", err)
+		}
+		terminals = append(terminals, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range terminals {
+		handlers, err := terminalHandlers(db, terminals[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		terminals[i].Handlers = handlers
+	}
+	return terminals, nil
+}
+
+func terminalHandlers(q dbtx, terminal string) ([]string, error) {
+	rows, err := q.Query(`
+		SELECT u.username FROM terminal_handlers th
+		JOIN users u ON u.id = th.user_id
+		WHERE th.terminal_id = ?
+		ORDER BY u.username`, terminal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query terminal handlers: %v", err)
+	}
+	defer rows.Close()
+
+	var handlers []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		handlers = append(handlers, name)
+	}
+	return handlers, rows.Err()
+}
+
+// Registers a new, empty terminal at branch
+func RegisterTerminal(db *sql.DB, actor models.Actor, terminal, branch string, withdrawalLimit, depositLimit models.Money) error {
+	if err := authorize(db, actor, models.PermTerminalsManage); err != nil {
+		return err
+	}
+	terminal = strings.ToUpper(strings.TrimSpace(terminal))
+	branch = strings.TrimSpace(branch)
+	if !terminalIDPattern.MatchString(terminal) {
+		return fmt.Errorf("terminal id must be 1-16 letters, digits or dashes")
+	}
+	if branch == "" {
+		return fmt.Errorf("branch cannot be empty")
+	}
+	if withdrawalLimit < 0 || depositLimit < 0 {
+		return fmt.Errorf("limits cannot be negative")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM atm WHERE terminal_id = ?)", terminal).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check terminal: %v", err)
+	}
+	if exists {
+		return fmt.Errorf("terminal %q already exists", terminal)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO atm (terminal_id, branch, withdrawal_limit, deposit_limit, ones, fives, tens, twenties, fifties, hundreds)
+		VALUES (?, ?, ?, ?, 0, 0, 0, 0, 0, 0)`, terminal, branch, withdrawalLimit, depositLimit)
+	if err != nil {
+		return fmt.Errorf("failed to register terminal: %v", err)
+	}
+	if err := addDefaultCassettes(tx, terminal); err != nil {
+		return err
+	}
+	if err := checkCashAlerts(tx, terminal); err != nil {
+		return err
+	}
+
+	after := map[string]any{"branch": branch, "withdrawal_limit": withdrawalLimit, "deposit_limit": depositLimit}
+	if err := recordAudit(tx, actor, AuditTerminalCreate, terminal, nil, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Lets a cash handler service terminal
+func AssignTerminal(db *sql.DB, actor models.Actor, terminal, username string) error {
+	return setTerminalAssignment(db, actor, terminal, username, true)
+}
+
+// Stops a cash handler servicing terminal
+func UnassignTerminal(db *sql.DB, actor models.Actor, terminal, username string) error {
+	return setTerminalAssignment(db, actor, terminal, username, false)
+}
+
+func setTerminalAssignment(db *sql.DB, actor models.Actor, terminal, username string, assign bool) error {
+	if err := authorize(db, actor, models.PermTerminalsManage); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM atm WHERE terminal_id = ?)", terminal).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check terminal: %v", err)
+	}
+	if !exists {
+		return unknownTerminal(terminal)
+	}
+
+	var userID int
+	var role string
+	err = tx.QueryRow("SELECT id, role FROM users WHERE username = ?", username).Scan(&userID, &role)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("user '%s' not found", username)
+	}
+	if err != nil {
+		return fmt.Errorf("could not get user: %v", err)
+	}
+	if role != models.RoleCashHandler {
+		return fmt.Errorf("'%s' is not a cash handler", username)
+	}
+
+	before, err := terminalHandlers(tx, terminal)
+	if err != nil {
+		return err
+	}
+
+	action := AuditTerminalAssign
+	if assign {
+		_, err = tx.Exec("INSERT OR IGNORE INTO terminal_handlers (terminal_id, user_id) VALUES (?, ?)", terminal, userID)
+	} else {
+		action = AuditTerminalRemove
+		_, err = tx.Exec("DELETE FROM terminal_handlers WHERE terminal_id = ? AND user_id = ?", terminal, userID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update terminal assignment: %v", err)
+	}
+
+	after, err := terminalHandlers(tx, terminal)
+	if err != nil {
+		return err
+	}
+	err = recordAudit(tx, actor, action, terminal, map[string]any{"handlers": before}, map[string]any{"handlers": after})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
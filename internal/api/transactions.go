@@ -0,0 +1,94 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+)
+
+// Writes a row to the transaction log and returns its id. For customer rows
+// BalanceAfter is the customer's balance; for cash loads and unloads it is
+// the ATM's cash balance.
+func logTransaction(q dbtx, t models.Transaction) (int64, error) {
+	stmtTrans, err := q.Prepare(`
+		INSERT INTO transactions (user_id, date, amount, kind, counterparty_id, balance_after, correlation_id, terminal_id, account_id)
+		VALUES (?, datetime('now', 'localtime'), ?, ?, ?, ?, ?, ?, (SELECT id FROM accounts WHERE number = ?))`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmtTrans.Close()
+
+	var counterparty, correlation, terminal, account any
+	if t.CounterpartyID != 0 {
+		counterparty = t.CounterpartyID
+	}
+	if t.CorrelationID != "" {
+		correlation = t.CorrelationID
+	}
+	if t.Terminal != "" {
+		terminal = t.Terminal
+	}
+	if t.Account != "" {
+		account = t.Account
+	}
+
+	res, err := stmtTrans.Exec(t.USER_ID, t.Amount, t.Kind, counterparty, t.BalanceAfter, correlation, terminal, account)
+	if err != nil {
+		return 0, fmt.Errorf("failed to log transaction: %v", err)
+	}
+	return res.LastInsertId()
+}
+
+// Random id shared by the rows that make up one operation, such as both legs of a transfer
+func newCorrelationID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate correlation id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+const transactionColumns = `
+	SELECT t.id, COALESCE(t.user_id, 0), COALESCE(u.username, ''), t.date, COALESCE(t.kind, ''),
+		t.amount, COALESCE(t.counterparty_id, 0), COALESCE(c.username, ''),
+		t.balance_after, COALESCE(t.correlation_id, ''), COALESCE(t.terminal_id, ''),
+		COALESCE(acct.number, '')
+	FROM transactions t
+	LEFT JOIN users u ON t.user_id = u.id
+	LEFT JOIN users c ON t.counterparty_id = c.id
+	LEFT JOIN accounts acct ON t.account_id = acct.id`
+
+func scanTransactions(rows *sql.Rows) ([]models.Transaction, error) {
+	defer rows.Close()
+
+	var txns []models.Transaction
+	for rows.Next() {
+		var t models.Transaction
+		var balanceAfter sql.NullInt64
+		err := rows.Scan(&t.ID, &t.USER_ID, &t.Username, &t.Date, &t.Kind,
+			&t.Amount, &t.CounterpartyID, &t.Counterparty, &balanceAfter, &t.CorrelationID, &t.Terminal, &t.Account)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan transaction: %v", err)
+		}
+		if balanceAfter.Valid {
+			bal := models.Money(balanceAfter.Int64)
+			t.BalanceAfter = &bal
+		}
+		txns = append(txns, t)
+	}
+	return txns, rows.Err()
+}
+
+// List every transaction, oldest first
+func ListTransactions(db *sql.DB, actor models.Actor) ([]models.Transaction, error) {
+	if err := authorize(db, actor, models.PermTransactionsView); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(transactionColumns + ` ORDER BY t.id ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transactions: %v", err)
+	}
+	return scanTransactions(rows)
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/config"
+	"SPG_ATM_Machine/internal/models"
+	"database/sql"
+	"fmt"
+)
+
+// Velocity limit fields an admin can override for a customer's account
+const (
+	LimitDailyWithdrawal   = "daily_withdrawal"
+	LimitDailyDeposit      = "daily_deposit"
+	LimitRollingWithdrawal = "rolling_withdrawal"
+	LimitRollingDeposit    = "rolling_deposit"
+)
+
+var velocityFields = map[string]bool{
+	LimitDailyWithdrawal:   true,
+	LimitDailyDeposit:      true,
+	LimitRollingWithdrawal: true,
+	LimitRollingDeposit:    true,
+}
+
+// Get the default velocity limits applied to accounts without overrides
+func GetDefaultVelocityLimits(db *sql.DB) (models.VelocityLimits, error) {
+	return velocityLimits(db, 0)
+}
+
+// Get the velocity limits that apply to one of a customer's accounts, with
+// its overrides. An empty account number means their main checking account.
+// Customers can read their own; anyone else needs users.view.
+func GetVelocityLimits(db *sql.DB, actor models.Actor, username, account string) (models.VelocityLimits, error) {
+	if err := authorizeUserRead(db, actor, username); err != nil {
+		return models.VelocityLimits{}, err
+	}
+	acct, err := customerAccount(db, username, account)
+	if err != nil {
+		return models.VelocityLimits{}, err
+	}
+	return velocityLimits(db, acct.ID)
+}
+
+func velocityLimits(q dbtx, accountID int) (models.VelocityLimits, error) {
+	var l models.VelocityLimits
+	err := q.QueryRow(`
+		SELECT COALESCE(u.daily_withdrawal, d.daily_withdrawal),
+			COALESCE(u.daily_deposit, d.daily_deposit),
+			COALESCE(u.rolling_withdrawal, d.rolling_withdrawal),
+			COALESCE(u.rolling_deposit, d.rolling_deposit)
+		FROM velocity_limits d
+		LEFT JOIN velocity_limits u ON u.account_id = ?
+		WHERE d.account_id = 0`, accountID).Scan(&l.DailyWithdrawal, &l.DailyDeposit, &l.RollingWithdrawal, &l.RollingDeposit)
+	if err != nil {
+		return l, fmt.Errorf("could not get velocity limits: %v", err)
+	}
+	return l, nil
+}
+
+// Velocity limits keyed by field name, for the audit log
+func velocityValues(l models.VelocityLimits) map[string]models.Money {
+	return map[string]models.Money{
+		LimitDailyWithdrawal:   l.DailyWithdrawal,
+		LimitDailyDeposit:      l.DailyDeposit,
+		LimitRollingWithdrawal: l.RollingWithdrawal,
+		LimitRollingDeposit:    l.RollingDeposit,
+	}
+}
+
+// A change to the velocity limits of a customer's account. An empty Field
+// resets them all to the defaults.
+type velocityChange struct {
+	Username string       `json:"username"`
+	Account  string       `json:"account,omitempty"`
+	Field    string       `json:"field,omitempty"`
+	Limit    models.Money `json:"limit"`
+}
+
+// Asks for one of the velocity limits of a customer's account to be set,
+// overriding the default. An empty account number means their main checking
+// account. It takes effect once another admin approves it.
+func SetVelocityOverride(db *sql.DB, actor models.Actor, username, account, field string, limit models.Money, limits config.Limits) (models.Approval, error) {
+	if err := authorize(db, actor, models.PermLimitsUpdate); err != nil {
+		return models.Approval{}, err
+	}
+	if !velocityFields[field] {
+		return models.Approval{}, fmt.Errorf("unknown limit %q", field)
+	}
+	if limit < 0 {
+		return models.Approval{}, fmt.Errorf("limit cannot be negative")
+	}
+	acct, err := customerAccount(db, username, account)
+	if err != nil {
+		return models.Approval{}, err
+	}
+	current, err := velocityLimits(db, acct.ID)
+	if err != nil {
+		return models.Approval{}, err
+	}
+
+	summary := fmt.Sprintf("%s for '%s' account %s: $%s -> $%s", field, username, acct.Number, velocityValues(current)[field], limit)
+	change := velocityChange{Username: username, Account: acct.Number, Field: field, Limit: limit}
+	return requestApproval(db, actor, AuditVelocityLimit, username, summary, change, limits)
+}
+
+// Asks for the overrides on a customer's account to be removed so the
+// defaults apply again. They are removed once another admin approves it.
+func ClearVelocityOverrides(db *sql.DB, actor models.Actor, username, account string, limits config.Limits) (models.Approval, error) {
+	if err := authorize(db, actor, models.PermLimitsUpdate); err != nil {
+		return models.Approval{}, err
+	}
+	acct, err := customerAccount(db, username, account)
+	if err != nil {
+		return models.Approval{}, err
+	}
+	summary := fmt.Sprintf("reset every daily limit for '%s' account %s to the defaults", username, acct.Number)
+	change := velocityChange{Username: username, Account: acct.Number}
+	return requestApproval(db, actor, AuditVelocityReset, username, summary, change, limits)
+}
+
+// Applies a velocity limit change inside tx and audits it. A change requested
+// before limits were per account applies to the customer's main account.
+func setVelocityLimits(tx *sql.Tx, actor models.Actor, change velocityChange) error {
+	acct, err := customerAccount(tx, change.Username, change.Account)
+	if err != nil {
+		return err
+	}
+	target := fmt.Sprintf("%s %s", acct.Owner, acct.Number)
+	before, err := velocityLimits(tx, acct.ID)
+	if err != nil {
+		return err
+	}
+
+	if change.Field == "" {
+		if _, err := tx.Exec("DELETE FROM velocity_limits WHERE account_id = ?", acct.ID); err != nil {
+			return fmt.Errorf("failed to reset limits: %v", err)
+		}
+		after, err := velocityLimits(tx, acct.ID)
+		if err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, AuditVelocityReset, target, velocityValues(before), velocityValues(after))
+	}
+
+	field := change.Field
+	if !velocityFields[field] {
+		return fmt.Errorf("unknown limit %q", field)
+	}
+	if change.Limit < 0 {
+		return fmt.Errorf("limit cannot be negative")
+	}
+	// field is checked against velocityFields above, so it is safe to splice in
+	_, err = tx.Exec(fmt.Sprintf(`
+		INSERT INTO velocity_limits (account_id, %[1]s) VALUES (?, ?)
+		ON CONFLICT(account_id) DO UPDATE SET %[1]s = excluded.%[1]s`, field), acct.ID, change.Limit)
+	if err != nil {
+		return fmt.Errorf("failed to set limit: %v", err)
+	}
+	return recordAudit(tx, actor, AuditVelocityLimit, target,
+		map[string]models.Money{field: velocityValues(before)[field]}, map[string]models.Money{field: change.Limit})
+}
+
+// Get how much cash has been withdrawn from and deposited into one of a
+// customer's accounts today and in the last 24 hours. An empty account number
+// means their main checking account. Customers can read their own; anyone
+// else needs users.view.
+func GetVelocityUsage(db *sql.DB, actor models.Actor, username, account string) (models.VelocityUsage, error) {
+	if err := authorizeUserRead(db, actor, username); err != nil {
+		return models.VelocityUsage{}, err
+	}
+	acct, err := customerAccount(db, username, account)
+	if err != nil {
+		return models.VelocityUsage{}, err
+	}
+	return velocityUsage(db, acct.ID)
+}
+
+func velocityUsage(q dbtx, accountID int) (models.VelocityUsage, error) {
+	var u models.VelocityUsage
+	err := q.QueryRow(`
+		SELECT
+			COALESCE(SUM(CASE WHEN kind = ? AND date >= date('now', 'localtime') THEN -amount END), 0),
+			COALESCE(SUM(CASE WHEN kind = ? AND date >= date('now', 'localtime') THEN amount END), 0),
+			COALESCE(SUM(CASE WHEN kind = ? THEN -amount END), 0),
+			COALESCE(SUM(CASE WHEN kind = ? THEN amount END), 0)
+		FROM transactions
+		WHERE account_id = ? AND date >= datetime('now', 'localtime', '-1 day')`,
+		models.KindWithdrawal, models.KindDeposit, models.KindWithdrawal, models.KindDeposit, accountID,
+	).Scan(&u.WithdrawnToday, &u.DepositedToday, &u.WithdrawnRolling, &u.DepositedRolling)
+	if err != nil {
+		return u, fmt.Errorf("could not get velocity usage: %v", err)
+	}
+	return u, nil
+}
+
+// How much more can be withdrawn from and deposited into an account right
+// now, taking the tighter of the daily and rolling 24 hour caps
+func RemainingVelocity(l models.VelocityLimits, u models.VelocityUsage) (withdrawal, deposit models.Money) {
+	withdrawal = max(min(l.DailyWithdrawal-u.WithdrawnToday, l.RollingWithdrawal-u.WithdrawnRolling), 0)
+	deposit = max(min(l.DailyDeposit-u.DepositedToday, l.RollingDeposit-u.DepositedRolling), 0)
+	return withdrawal, deposit
+}
+
+// Rejects a withdrawal or deposit that would take the account past its daily
+// or rolling 24 hour cap
+func checkVelocity(q dbtx, accountID int, kind string, amount models.Money) error {
+	limits, err := velocityLimits(q, accountID)
+	if err != nil {
+		return err
+	}
+	usage, err := velocityUsage(q, accountID)
+	if err != nil {
+		return err
+	}
+	remainingWithdrawal, remainingDeposit := RemainingVelocity(limits, usage)
+
+	switch kind {
+	case models.KindWithdrawal:
+		if amount > remainingWithdrawal {
+			return fmt.Errorf("withdrawing $%s would exceed your daily withdrawal limits ($%s per day, $%s per 24 hours). Remaining today: $%s",
+				amount, limits.DailyWithdrawal, limits.RollingWithdrawal, remainingWithdrawal)
+		}
+	case models.KindDeposit:
+		if amount > remainingDeposit {
+			return fmt.Errorf("depositing $%s would exceed your daily deposit limits ($%s per day, $%s per 24 hours). Remaining today: $%s",
+				amount, limits.DailyDeposit, limits.RollingDeposit, remainingDeposit)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"strings"
+	"testing"
+)
+
+func TestRemainingVelocity(t *testing.T) {
+	limits := models.VelocityLimits{
+		DailyWithdrawal:   models.Dollars(500),
+		DailyDeposit:      models.Dollars(1000),
+		RollingWithdrawal: models.Dollars(800),
+		RollingDeposit:    models.Dollars(1500),
+	}
+	tests := []struct {
+		name           string
+		usage          models.VelocityUsage
+		wantWithdrawal models.Money
+		wantDeposit    models.Money
+	}{
+		{"nothing used", models.VelocityUsage{}, models.Dollars(500), models.Dollars(1000)},
+		{"daily cap is tighter", models.VelocityUsage{WithdrawnToday: models.Dollars(200), WithdrawnRolling: models.Dollars(200)},
+			models.Dollars(300), models.Dollars(1000)},
+		{"rolling cap is tighter", models.VelocityUsage{WithdrawnToday: models.Dollars(100), WithdrawnRolling: models.Dollars(700),
+			DepositedToday: models.Dollars(100), DepositedRolling: models.Dollars(1400)},
+			models.Dollars(100), models.Dollars(100)},
+		{"over the cap", models.VelocityUsage{WithdrawnToday: models.Dollars(600), WithdrawnRolling: models.Dollars(600)},
+			0, models.Dollars(1000)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			withdrawal, deposit := RemainingVelocity(limits, tc.usage)
+			if withdrawal != tc.wantWithdrawal || deposit != tc.wantDeposit {
+				t.Errorf("got $%s withdrawal, $%s deposit, want $%s, $%s",
+					withdrawal, deposit, tc.wantWithdrawal, tc.wantDeposit)
+			}
+		})
+	}
+}
+
+// An override and the usage on one account don't carry over to the
+// customer's other accounts
+func TestVelocityPerAccount(t *testing.T) {
+	conn := openWithdrawDB(t, models.Dollars(1000), 100)
+	admin := models.Actor{Username: "admin"}
+	customer := models.Actor{Username: "customer"}
+	second, err := OpenAccount(conn, admin, customer.Username, models.AccountChecking, 0)
+	if err != nil {
+		t.Fatalf("open account: %v", err)
+	}
+	first, err := customerAccount(conn, customer.Username, "")
+	if err != nil {
+		t.Fatalf("get account: %v", err)
+	}
+	if _, err := conn.Exec(`INSERT INTO velocity_limits (account_id, daily_withdrawal) VALUES (?, ?)`, first.ID, models.Dollars(100)); err != nil {
+		t.Fatalf("set override: %v", err)
+	}
+	if _, err := TransferBetweenAccounts(conn, customer, testTerminal, "", second.Number, models.Dollars(500)); err != nil {
+		t.Fatalf("fund second account: %v", err)
+	}
+
+	sixty := DispensePlan{Amount: models.Dollars(60), Counts: []int{0, 0, 0, 3, 0, 0}}
+	steps := []struct {
+		name    string
+		account string
+		wantErr string
+	}{
+		{"within the override", "", ""},
+		{"past the override", "", "exceed your daily withdrawal limits"},
+		{"other account uses the defaults", second.Number, ""},
+		{"again on the other account", second.Number, ""},
+	}
+	for _, step := range steps {
+		_, err := WithdrawCash(conn, customer, testTerminal, step.account, sixty)
+		if step.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", step.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), step.wantErr) {
+			t.Errorf("%s: got error %v, want one containing %q", step.name, err, step.wantErr)
+		}
+	}
+
+	usage, err := GetVelocityUsage(conn, customer, customer.Username, second.Number)
+	if err != nil {
+		t.Fatalf("get usage: %v", err)
+	}
+	if usage.WithdrawnToday != models.Dollars(120) {
+		t.Errorf("second account withdrew $%s today, want $120.00", usage.WithdrawnToday)
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"database/sql"
+	"fmt"
+)
+
+// Withdraws cash from a customer's account at terminal as one SQL transaction. The withdrawal limit,
+// the customer's balance and the cassette counts are checked under the
+// database write lock, then the notes are removed, the ledger is posted and
+// the transaction is logged. Any failure leaves both tables untouched. Returns
+// the withdrawal's receipt.
+func WithdrawCash(db *sql.DB, actor models.Actor, terminal, account string, plan DispensePlan) (models.Receipt, error) {
+	if err := authorize(db, actor, models.PermWithdraw); err != nil {
+		return models.Receipt{}, err
+	}
+	username := actor.Username
+	amount := plan.Amount
+	if amount <= 0 {
+		return models.Receipt{}, fmt.Errorf("withdrawal amount must be greater than zero")
+	}
+	if len(plan.Counts) != len(Denominations) {
+		return models.Receipt{}, fmt.Errorf("invalid dispense plan")
+	}
+
+	// Begins with the write lock held, which locks the ATM row for us
+	tx, err := db.Begin()
+	if err != nil {
+		return models.Receipt{}, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, id, err := withdrawBalance(tx, terminal, username, account, amount)
+	if err != nil {
+		return models.Receipt{}, err
+	}
+
+	// A cassette may have been taken out of service since the plan was made
+	list, err := cassettes(tx, terminal)
+	if err != nil {
+		return models.Receipt{}, err
+	}
+	for i, c := range list {
+		if c.OutOfService && plan.Counts[i] > 0 {
+			return models.Receipt{}, fmt.Errorf("the $%d cassette is out of service", c.Denomination)
+		}
+	}
+
+	c := plan.Counts
+	if err := withdrawBills(tx, terminal, amount, c[5], c[4], c[3], c[2], c[1], c[0]); err != nil {
+		return models.Receipt{}, err
+	}
+
+	if err := recordTransactionNotes(tx, id, plan.Counts); err != nil {
+		return models.Receipt{}, err
+	}
+	r, err := receipt(tx, int(id))
+	if err != nil {
+		return models.Receipt{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Receipt{}, fmt.Errorf("failed to commit withdrawal: %v", err)
+	}
+	return r, nil
+}
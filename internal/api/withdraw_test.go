@@ -0,0 +1,152 @@
+package api
+
+import (
+	"SPG_ATM_Machine/internal/config"
+	"SPG_ATM_Machine/internal/db"
+	"SPG_ATM_Machine/internal/models"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+const testTerminal = "ATM-0001"
+
+// Opens a fresh migrated database with an admin, a customer holding balance
+// and the default terminal loaded with twenties $20 notes
+func openWithdrawDB(t *testing.T, balance models.Money, twenties int) *sql.DB {
+	t.Helper()
+	cfg := config.Default().Database
+	cfg.Path = filepath.Join(t.TempDir(), "test.db")
+	conn, err := db.Connect(cfg)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	_, err = conn.Exec(`INSERT INTO users (full_name, username, role) VALUES ('Test Admin', 'admin', ?)`, models.RoleAdmin)
+	if err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+	admin := models.Actor{Username: "admin"}
+	if err := CreateUser(conn, admin, "Test Customer", "2000-01-01", "1234", balance, "customer"); err != nil {
+		t.Fatalf("create customer: %v", err)
+	}
+
+	// Lift the withdrawal and velocity limits so only the balance and the
+	// cassette can stop a withdrawal
+	_, err = conn.Exec(`UPDATE atm SET twenties = ?, withdrawal_limit = ? WHERE terminal_id = ?`,
+		twenties, models.Dollars(100000), testTerminal)
+	if err != nil {
+		t.Fatalf("load terminal: %v", err)
+	}
+	_, err = conn.Exec(`UPDATE velocity_limits SET daily_withdrawal = ?, rolling_withdrawal = ? WHERE account_id = 0`,
+		models.Dollars(100000), models.Dollars(100000))
+	if err != nil {
+		t.Fatalf("lift velocity limits: %v", err)
+	}
+	return conn
+}
+
+func TestWithdrawCashConcurrent(t *testing.T) {
+	tests := []struct {
+		name     string
+		balance  models.Money
+		twenties int
+	}{
+		// $1000 covers 16 withdrawals of $60 but 30 notes only cover 10
+		{"cassette runs out", models.Dollars(1000), 30},
+		// 100 notes cover 33 withdrawals but $500 only covers 8
+		{"balance runs out", models.Dollars(500), 100},
+	}
+	const workers = 40
+	plan := DispensePlan{Amount: models.Dollars(60), Counts: []int{0, 0, 0, 3, 0, 0}}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := openWithdrawDB(t, tc.balance, tc.twenties)
+			customer := models.Actor{Username: "customer"}
+			acct, err := customerAccount(conn, customer.Username, "")
+			if err != nil {
+				t.Fatalf("get account: %v", err)
+			}
+			startATM, err := GetATMBalance(conn, testTerminal)
+			if err != nil {
+				t.Fatalf("get ATM balance: %v", err)
+			}
+
+			var (
+				wg       sync.WaitGroup
+				mu       sync.Mutex
+				receipts []models.Receipt
+			)
+			for range workers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					r, err := WithdrawCash(conn, customer, testTerminal, "", plan)
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					receipts = append(receipts, r)
+					mu.Unlock()
+				}()
+			}
+			wg.Wait()
+
+			successes := len(receipts)
+			want := min(int(tc.balance/plan.Amount), tc.twenties/plan.Counts[3])
+			if successes != want {
+				t.Fatalf("got %d successful withdrawals, want %d", successes, want)
+			}
+			paid := plan.Amount * models.Money(successes)
+			if paid > tc.balance {
+				t.Errorf("paid out $%s from a balance of $%s", paid, tc.balance)
+			}
+			if notes := successes * plan.Counts[3]; notes > tc.twenties {
+				t.Errorf("dispensed %d notes from a cassette of %d", notes, tc.twenties)
+			}
+
+			var twenties int
+			err = conn.QueryRow("SELECT twenties FROM atm WHERE terminal_id = ?", testTerminal).Scan(&twenties)
+			if err != nil {
+				t.Fatalf("get cassette: %v", err)
+			}
+			if want := tc.twenties - successes*plan.Counts[3]; twenties != want {
+				t.Errorf("cassette holds %d notes, want %d", twenties, want)
+			}
+			endATM, err := GetATMBalance(conn, testTerminal)
+			if err != nil {
+				t.Fatalf("get ATM balance: %v", err)
+			}
+			if endATM != startATM-paid {
+				t.Errorf("ATM balance is $%s, want $%s", endATM, startATM-paid)
+			}
+			ledger, err := ledgerBalance(conn, acct.Ledger)
+			if err != nil {
+				t.Fatalf("get ledger balance: %v", err)
+			}
+			if ledger != tc.balance-paid {
+				t.Errorf("ledger balance is $%s, want $%s", ledger, tc.balance-paid)
+			}
+
+			var logged int
+			err = conn.QueryRow("SELECT COUNT(*) FROM transactions WHERE kind = ? AND user_id = ?",
+				models.KindWithdrawal, acct.UserID).Scan(&logged)
+			if err != nil {
+				t.Fatalf("count transactions: %v", err)
+			}
+			if logged != successes {
+				t.Errorf("logged %d withdrawals, want %d", logged, successes)
+			}
+			seen := map[int]bool{}
+			for _, r := range receipts {
+				if seen[r.TransactionID] {
+					t.Errorf("transaction %d was returned twice", r.TransactionID)
+				}
+				seen[r.TransactionID] = true
+			}
+		})
+	}
+}
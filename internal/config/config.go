@@ -0,0 +1,253 @@
+// Package config loads the ATM's settings from defaults, an optional JSON
+// file and ATM_* environment variables, in that order of precedence.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// File read when no config path is given and ATM_CONFIG is unset. It is
+// optional; the defaults apply without it.
+const DefaultPath = "atm.json"
+
+type Config struct {
+	Database Database `json:"database"`
+	Limits   Limits   `json:"limits"`
+	Branding Branding `json:"branding"`
+	Terminal Terminal `json:"terminal"`
+	Session  Session  `json:"session"`
+}
+
+// Where data.db lives and the SQLite pragmas set on every connection
+type Database struct {
+	Path          string `json:"path"`
+	JournalMode   string `json:"journal_mode"`
+	BusyTimeoutMS int    `json:"busy_timeout_ms"`
+	ForeignKeys   bool   `json:"foreign_keys"`
+}
+
+// Operational limits that aren't kept in the database
+type Limits struct {
+	// Wrong PINs in a row before an account is locked for a while
+	MaxPINAttempts int `json:"max_pin_attempts"`
+	// How long the first lockout lasts; each lockout after it doubles, up
+	// to MaxLockoutSeconds
+	LockoutSeconds    int `json:"lockout_seconds"`
+	MaxLockoutSeconds int `json:"max_lockout_seconds"`
+	// Lockouts in a row, without a good PIN in between, before the account
+	// is locked until an admin unlocks it
+	MaxLockouts int `json:"max_lockouts"`
+	// Rejected cards a terminal accepts within UnknownCardWindowSeconds
+	// before it stops taking logins for the rest of the window
+	UnknownCardAttempts      int `json:"unknown_card_attempts"`
+	UnknownCardWindowSeconds int `json:"unknown_card_window_seconds"`
+	// Transactions shown on a customer's mini statement
+	MiniStatementSize int `json:"mini_statement_size"`
+	// How long a maker-checker request waits for a second admin before it
+	// expires
+	ApprovalExpiryHours int `json:"approval_expiry_hours"`
+	// Manual balance adjustments of at least this many dollars need a second
+	// admin's approval; smaller ones are posted straight away
+	LargeAdjustmentDollars int `json:"large_adjustment_dollars"`
+}
+
+// How long a user logged in at the ATM may stay idle, and how much they may
+// do, before their session is ended
+type Session struct {
+	IdleSeconds int `json:"idle_seconds"`
+	// How long before the idle timeout the countdown starts
+	WarningSeconds int `json:"warning_seconds"`
+	// Menu choices allowed in one session
+	MaxOperations int `json:"max_operations"`
+}
+
+// Names shown to users
+type Branding struct {
+	BankName string `json:"bank_name"`
+}
+
+// Which ATM this program runs as. The id must match a terminal registered in
+// the atm table.
+type Terminal struct {
+	ID string `json:"id"`
+}
+
+func Default() Config {
+	return Config{
+		Database: Database{
+			Path:          "./data.db",
+			JournalMode:   "WAL",
+			BusyTimeoutMS: 5000,
+			ForeignKeys:   true,
+		},
+		Limits: Limits{
+			MaxPINAttempts:           3,
+			LockoutSeconds:           60,
+			MaxLockoutSeconds:        3600,
+			MaxLockouts:              3,
+			UnknownCardAttempts:      5,
+			UnknownCardWindowSeconds: 300,
+			MiniStatementSize:        10,
+			ApprovalExpiryHours:      24,
+			LargeAdjustmentDollars:   500,
+		},
+		Branding: Branding{
+			BankName: "JP Goldman Stanley",
+		},
+		Terminal: Terminal{
+			ID: "ATM-0001",
+		},
+		Session: Session{
+			IdleSeconds:    90,
+			WarningSeconds: 30,
+			MaxOperations:  20,
+		},
+	}
+}
+
+// Loads the config file at path, or at $ATM_CONFIG or DefaultPath when path
+// is empty, then applies environment overrides. Only an explicitly named
+// file has to exist.
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	explicit := path != ""
+	if !explicit {
+		path = os.Getenv("ATM_CONFIG")
+		explicit = path != ""
+	}
+	if path == "" {
+		path = DefaultPath
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&cfg); err != nil {
+			return cfg, fmt.Errorf("invalid config file %s: %v", path, err)
+		}
+	case errors.Is(err, fs.ErrNotExist) && !explicit:
+		// no file, defaults apply
+	default:
+		return cfg, fmt.Errorf("could not read config file: %v", err)
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// Overrides settings from ATM_* environment variables
+func applyEnv(cfg *Config) error {
+	strs := map[string]*string{
+		"ATM_DB_PATH":         &cfg.Database.Path,
+		"ATM_DB_JOURNAL_MODE": &cfg.Database.JournalMode,
+		"ATM_BANK_NAME":       &cfg.Branding.BankName,
+		"ATM_TERMINAL_ID":     &cfg.Terminal.ID,
+	}
+	for name, field := range strs {
+		if v, ok := os.LookupEnv(name); ok {
+			*field = v
+		}
+	}
+
+	ints := map[string]*int{
+		"ATM_DB_BUSY_TIMEOUT_MS":          &cfg.Database.BusyTimeoutMS,
+		"ATM_MAX_PIN_ATTEMPTS":            &cfg.Limits.MaxPINAttempts,
+		"ATM_LOCKOUT_SECONDS":             &cfg.Limits.LockoutSeconds,
+		"ATM_MAX_LOCKOUT_SECONDS":         &cfg.Limits.MaxLockoutSeconds,
+		"ATM_MAX_LOCKOUTS":                &cfg.Limits.MaxLockouts,
+		"ATM_UNKNOWN_CARD_ATTEMPTS":       &cfg.Limits.UnknownCardAttempts,
+		"ATM_UNKNOWN_CARD_WINDOW_SECONDS": &cfg.Limits.UnknownCardWindowSeconds,
+		"ATM_MINI_STATEMENT_SIZE":         &cfg.Limits.MiniStatementSize,
+		"ATM_APPROVAL_EXPIRY_HOURS":       &cfg.Limits.ApprovalExpiryHours,
+		"ATM_LARGE_ADJUSTMENT_DOLLARS":    &cfg.Limits.LargeAdjustmentDollars,
+		"ATM_SESSION_IDLE_SECONDS":        &cfg.Session.IdleSeconds,
+		"ATM_SESSION_WARNING_SECONDS":     &cfg.Session.WarningSeconds,
+		"ATM_SESSION_MAX_OPERATIONS":      &cfg.Session.MaxOperations,
+	}
+	for name, field := range ints {
+		if v, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s must be a whole number, got %q", name, v)
+			}
+			*field = n
+		}
+	}
+
+	if v, ok := os.LookupEnv("ATM_DB_FOREIGN_KEYS"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("ATM_DB_FOREIGN_KEYS must be true or false, got %q", v)
+		}
+		cfg.Database.ForeignKeys = b
+	}
+	return nil
+}
+
+var journalModes = map[string]bool{
+	"DELETE": true, "TRUNCATE": true, "PERSIST": true, "MEMORY": true, "WAL": true, "OFF": true,
+}
+
+func (c Config) Validate() error {
+	if c.Database.Path == "" {
+		return fmt.Errorf("database path is empty")
+	}
+	if strings.ContainsAny(c.Database.Path, "?#") {
+		return fmt.Errorf("database path %q cannot contain '?' or '#'", c.Database.Path)
+	}
+	if !journalModes[strings.ToUpper(c.Database.JournalMode)] {
+		return fmt.Errorf("unknown journal mode %q", c.Database.JournalMode)
+	}
+	if c.Database.BusyTimeoutMS < 0 {
+		return fmt.Errorf("busy timeout cannot be negative")
+	}
+	if c.Limits.MaxPINAttempts < 1 {
+		return fmt.Errorf("max PIN attempts must be at least 1")
+	}
+	if c.Limits.LockoutSeconds < 1 || c.Limits.MaxLockoutSeconds < c.Limits.LockoutSeconds {
+		return fmt.Errorf("lockout must be at least 1 second and no longer than the max lockout")
+	}
+	if c.Limits.MaxLockouts < 1 {
+		return fmt.Errorf("max lockouts must be at least 1")
+	}
+	if c.Limits.UnknownCardAttempts < 1 || c.Limits.UnknownCardWindowSeconds < 1 {
+		return fmt.Errorf("unknown card attempts and window must be at least 1")
+	}
+	if c.Limits.MiniStatementSize < 1 {
+		return fmt.Errorf("mini statement size must be at least 1")
+	}
+	if c.Limits.ApprovalExpiryHours < 1 {
+		return fmt.Errorf("approval expiry must be at least 1 hour")
+	}
+	if c.Limits.LargeAdjustmentDollars < 0 {
+		return fmt.Errorf("large adjustment amount cannot be negative")
+	}
+	if c.Session.IdleSeconds < 1 {
+		return fmt.Errorf("session idle timeout must be at least 1 second")
+	}
+	if c.Session.WarningSeconds < 0 || c.Session.WarningSeconds >= c.Session.IdleSeconds {
+		return fmt.Errorf("session warning must be at least 0 and shorter than the idle timeout")
+	}
+	if c.Session.MaxOperations < 1 {
+		return fmt.Errorf("session max operations must be at least 1")
+	}
+	if c.Branding.BankName == "" {
+		return fmt.Errorf("bank name is empty")
+	}
+	if c.Terminal.ID == "" {
+		return fmt.Errorf("terminal id is empty")
+	}
+	return nil
+}
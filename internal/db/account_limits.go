@@ -0,0 +1,64 @@
+package db
+
+import "database/sql"
+
+// Migration 19: velocity limits per account rather than per customer. The row
+// for account 0 holds the defaults, as user 0 did. A customer's overrides are
+// copied to each of their accounts, so no account gets a looser cap than it
+// had.
+func upAccountLimits(tx *sql.Tx) error {
+	typ, err := columnType(tx, "velocity_limits", "account_id")
+	if err != nil || typ != "" {
+		return err
+	}
+	_, err = tx.Exec(`
+	CREATE TABLE velocity_limits_accounts (
+		account_id INTEGER PRIMARY KEY,
+		daily_withdrawal INTEGER,
+		daily_deposit INTEGER,
+		rolling_withdrawal INTEGER,
+		rolling_deposit INTEGER
+	);
+
+	INSERT INTO velocity_limits_accounts (account_id, daily_withdrawal, daily_deposit, rolling_withdrawal, rolling_deposit)
+	SELECT 0, daily_withdrawal, daily_deposit, rolling_withdrawal, rolling_deposit
+	FROM velocity_limits WHERE user_id = 0;
+
+	INSERT INTO velocity_limits_accounts (account_id, daily_withdrawal, daily_deposit, rolling_withdrawal, rolling_deposit)
+	SELECT a.id, v.daily_withdrawal, v.daily_deposit, v.rolling_withdrawal, v.rolling_deposit
+	FROM velocity_limits v
+	JOIN accounts a ON a.user_id = v.user_id
+	WHERE v.user_id != 0;
+
+	DROP TABLE velocity_limits;
+	ALTER TABLE velocity_limits_accounts RENAME TO velocity_limits;`)
+	return err
+}
+
+// A customer whose accounts have different overrides keeps the tightest of
+// each limit
+func downAccountLimits(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE velocity_limits_users (
+		user_id INTEGER PRIMARY KEY,
+		daily_withdrawal INTEGER,
+		daily_deposit INTEGER,
+		rolling_withdrawal INTEGER,
+		rolling_deposit INTEGER
+	);
+
+	INSERT INTO velocity_limits_users (user_id, daily_withdrawal, daily_deposit, rolling_withdrawal, rolling_deposit)
+	SELECT 0, daily_withdrawal, daily_deposit, rolling_withdrawal, rolling_deposit
+	FROM velocity_limits WHERE account_id = 0;
+
+	INSERT INTO velocity_limits_users (user_id, daily_withdrawal, daily_deposit, rolling_withdrawal, rolling_deposit)
+	SELECT a.user_id, MIN(v.daily_withdrawal), MIN(v.daily_deposit), MIN(v.rolling_withdrawal), MIN(v.rolling_deposit)
+	FROM velocity_limits v
+	JOIN accounts a ON a.id = v.account_id
+	WHERE v.account_id != 0
+	GROUP BY a.user_id;
+
+	DROP TABLE velocity_limits;
+	ALTER TABLE velocity_limits_users RENAME TO velocity_limits;`)
+	return err
+}
@@ -0,0 +1,27 @@
+package db
+
+import "database/sql"
+
+// Migration 14: why and when an account was last frozen, unfrozen or closed,
+// and by whom. Closed accounts are kept for their history.
+func upAccountStatus(tx *sql.Tx) error {
+	columns := []struct{ name, definition string }{
+		{"status_reason", "TEXT"},
+		{"status_changed_at", "TEXT"},
+		{"status_changed_by", "TEXT"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(tx, "accounts", c.name, c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func downAccountStatus(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	ALTER TABLE accounts DROP COLUMN status_changed_by;
+	ALTER TABLE accounts DROP COLUMN status_changed_at;
+	ALTER TABLE accounts DROP COLUMN status_reason;`)
+	return err
+}
@@ -0,0 +1,73 @@
+package db
+
+import "database/sql"
+
+// Migration 13: customer accounts. A customer can hold several checking and
+// savings accounts, each with its own ledger account. Every existing customer's
+// ledger account becomes their checking account, and their past
+// transactions are assigned to it. Accounts opened before a rollback of this
+// migration come back from their ledger accounts, without their interest
+// rate. Account numbers are the type digit (1
+// checking, 2 savings) followed by the 9-digit account id.
+func upAccounts(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "transactions", "account_id", "INTEGER"); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS accounts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		number TEXT NOT NULL UNIQUE,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		type TEXT NOT NULL CHECK (type IN ('checking', 'savings')),
+		status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'frozen', 'closed')),
+		ledger_code TEXT NOT NULL UNIQUE REFERENCES ledger_accounts(code),
+		interest_rate_bp INTEGER NOT NULL DEFAULT 0 CHECK (interest_rate_bp >= 0),
+		opened_at TEXT NOT NULL,
+		interest_accrued_to TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_accounts_user ON accounts(user_id);
+
+	-- Accounts opened before a rollback kept their ACCT-<number> ledger accounts
+	INSERT INTO accounts (id, number, user_id, type, ledger_code, opened_at)
+	SELECT CAST(substr(la.code, 7) AS INTEGER), substr(la.code, 6), la.user_id,
+		CASE substr(la.code, 6, 1) WHEN '2' THEN 'savings' ELSE 'checking' END,
+		la.code, datetime('now', 'localtime')
+	FROM ledger_accounts la
+	WHERE la.code LIKE 'ACCT-%'
+		AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.ledger_code = la.code);
+
+	INSERT INTO accounts (number, user_id, type, ledger_code, opened_at)
+	SELECT 'migrating-' || la.code, la.user_id, 'checking', la.code, datetime('now', 'localtime')
+	FROM ledger_accounts la
+	JOIN users u ON u.id = la.user_id
+	WHERE la.code LIKE 'CUST-%' AND u.role = 'customer'
+		AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.ledger_code = la.code);
+
+	UPDATE accounts SET number = printf('1%09d', id) WHERE number LIKE 'migrating-%';
+
+	UPDATE transactions SET account_id = (
+		SELECT a.id FROM accounts a
+		WHERE a.user_id = transactions.user_id AND a.type = 'checking'
+		ORDER BY a.id LIMIT 1)
+	WHERE account_id IS NULL AND kind NOT IN ('cash_load', 'cash_unload', 'cash_variance');
+
+	CREATE INDEX IF NOT EXISTS idx_transactions_account ON transactions(account_id);
+
+	INSERT OR IGNORE INTO ledger_accounts (code, name, type)
+	VALUES ('INTEREST_EXPENSE', 'Savings interest', 'expense');`)
+	return err
+}
+
+// Ledger accounts with postings stay, since postings can't be deleted
+func downAccounts(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	DROP INDEX IF EXISTS idx_transactions_account;
+	ALTER TABLE transactions DROP COLUMN account_id;
+	DROP TABLE accounts;
+	DELETE FROM ledger_accounts
+	WHERE code = 'INTEREST_EXPENSE'
+		AND NOT EXISTS (SELECT 1 FROM postings p WHERE p.account_id = ledger_accounts.id);`)
+	return err
+}
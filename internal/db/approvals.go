@@ -0,0 +1,53 @@
+package db
+
+import "database/sql"
+
+// Migration 16: dual control. Sensitive admin changes are stored as pending
+// approvals holding the change as JSON, and only take effect once a second
+// admin approves them. A pending approval expires at expires_at. Manual
+// balance adjustments are posted against the MANUAL_ADJUSTMENT account.
+func upApprovals(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS approvals (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		target TEXT NOT NULL,
+		summary TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		requested_by INTEGER NOT NULL REFERENCES users(id),
+		requested_at TEXT NOT NULL,
+		expires_at TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending'
+			CHECK (status IN ('pending', 'approved', 'rejected', 'expired')),
+		decided_by INTEGER REFERENCES users(id),
+		decided_at TEXT,
+		note TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_approvals_status ON approvals(status, expires_at);
+
+	INSERT OR IGNORE INTO permissions (name, description) VALUES
+		('approvals.review', 'Approve or reject other admins'' requests'),
+		('accounts.adjust', 'Request manual balance adjustments');
+
+	INSERT OR IGNORE INTO role_permissions (role, permission) VALUES
+		('admin', 'approvals.review'),
+		('admin', 'accounts.adjust');
+
+	INSERT OR IGNORE INTO ledger_accounts (code, name, type) VALUES
+		('MANUAL_ADJUSTMENT', 'Manual balance adjustments', 'expense');`)
+	return err
+}
+
+// The MANUAL_ADJUSTMENT account stays if it has postings, since postings can't be deleted
+func downApprovals(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	DROP TABLE approvals;
+	DELETE FROM user_permissions WHERE permission IN ('approvals.review', 'accounts.adjust');
+	DELETE FROM role_permissions WHERE permission IN ('approvals.review', 'accounts.adjust');
+	DELETE FROM permissions WHERE name IN ('approvals.review', 'accounts.adjust');
+	DELETE FROM ledger_accounts
+	WHERE code = 'MANUAL_ADJUSTMENT'
+		AND NOT EXISTS (SELECT 1 FROM postings p WHERE p.account_id = ledger_accounts.id);`)
+	return err
+}
@@ -0,0 +1,37 @@
+package db
+
+import "database/sql"
+
+// Migration 7: the audit log of privileged actions. Like the ledger it is
+// append-only, enforced by triggers; each row carries a hash chained to the
+// row before it.
+func upAuditLog(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		at TEXT NOT NULL,
+		actor TEXT NOT NULL,
+		role TEXT NOT NULL,
+		action TEXT NOT NULL,
+		target TEXT NOT NULL,
+		before TEXT NOT NULL,
+		after TEXT NOT NULL,
+		prev_hash TEXT NOT NULL,
+		hash TEXT NOT NULL UNIQUE
+	);
+
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+	BEGIN SELECT RAISE(ABORT, 'the audit log is append-only'); END;
+
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+	BEGIN SELECT RAISE(ABORT, 'the audit log is append-only'); END;`)
+	return err
+}
+
+func downAuditLog(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	DROP TRIGGER IF EXISTS audit_log_no_update;
+	DROP TRIGGER IF EXISTS audit_log_no_delete;
+	DROP TABLE audit_log;`)
+	return err
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"SPG_ATM_Machine/internal/models"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Folder the seeded users' card files are written to. Cards admins issue
+// later are written there too.
+const cardDir = "cards"
+
+// Migration 6: the table of issued ATM cards, with a card for every existing user
+func upCards(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS cards (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		card_id TEXT NOT NULL UNIQUE,
+		pan TEXT NOT NULL UNIQUE,
+		expiry TEXT NOT NULL,
+		issuer TEXT NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		revoked INTEGER NOT NULL DEFAULT 0,
+		issued_at TEXT NOT NULL,
+		revoked_at TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_cards_user ON cards(user_id);`)
+	if err != nil {
+		return err
+	}
+	return seedCards(tx)
+}
+
+func downCards(tx *sql.Tx) error {
+	_, err := tx.Exec("DROP TABLE cards")
+	return err
+}
+
+// Gives users created before card issuance existed a card, so they can still
+// log in. This only does anything while the cards table is empty; later users
+// get their cards from an admin. Each card file is written to
+// cards/<username>.txt.
+func seedCards(tx *sql.Tx) error {
+	var issued int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM cards").Scan(&issued); err != nil {
+		return err
+	}
+	if issued > 0 {
+		return nil
+	}
+
+	rows, err := tx.Query("SELECT id, username FROM users")
+	if err != nil {
+		return err
+	}
+	var users []models.Card
+	for rows.Next() {
+		var u models.Card
+		if err := rows.Scan(&u.UserID, &u.Username); err != nil {
+			rows.Close()
+			return err
+		}
+		users = append(users, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(users) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(cardDir, 0o700); err != nil {
+		return fmt.Errorf("could not create card folder: %v", err)
+	}
+	for _, card := range users {
+		card.CardID, card.PAN, err = models.NewCardNumbers()
+		if err != nil {
+			return err
+		}
+		card.Expiry = "12/30"
+		card.Issuer = models.CardIssuer
+		_, err := tx.Exec(`
+			INSERT INTO cards (card_id, pan, expiry, issuer, user_id, issued_at)
+			VALUES (?, ?, ?, ?, ?, datetime('now', 'localtime'))`,
+			card.CardID, card.PAN, card.Expiry, card.Issuer, card.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to seed card for user %d: %v", card.UserID, err)
+		}
+		path := filepath.Join(cardDir, card.Username+".txt")
+		if err := os.WriteFile(path, []byte(card.Record()), 0o600); err != nil {
+			return fmt.Errorf("could not write card file for %s: %v", card.Username, err)
+		}
+	}
+	return nil
+}
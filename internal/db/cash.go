@@ -0,0 +1,71 @@
+package db
+
+import "database/sql"
+
+// Migration 8: cash handler count sessions and the log of cash moved in and
+// out of the cassettes by staff. Cash events are append-only. Variances
+// written off after a count are posted against the CASH_VARIANCE account.
+func upCashReconciliation(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS cash_counts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		handler_id INTEGER NOT NULL REFERENCES users(id),
+		status TEXT NOT NULL,
+		started_at TEXT NOT NULL,
+		submitted_at TEXT,
+		reviewed_by INTEGER REFERENCES users(id),
+		reviewed_at TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_cash_counts_status ON cash_counts(status);
+
+	CREATE TABLE IF NOT EXISTS cash_count_notes (
+		count_id INTEGER NOT NULL REFERENCES cash_counts(id),
+		denomination INTEGER NOT NULL,
+		expected INTEGER NOT NULL,
+		counted INTEGER,
+		PRIMARY KEY (count_id, denomination)
+	);
+
+	CREATE TABLE IF NOT EXISTS cash_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		at TEXT NOT NULL,
+		handler_id INTEGER NOT NULL REFERENCES users(id),
+		kind TEXT NOT NULL,
+		ones INTEGER NOT NULL DEFAULT 0,
+		fives INTEGER NOT NULL DEFAULT 0,
+		tens INTEGER NOT NULL DEFAULT 0,
+		twenties INTEGER NOT NULL DEFAULT 0,
+		fifties INTEGER NOT NULL DEFAULT 0,
+		hundreds INTEGER NOT NULL DEFAULT 0,
+		amount INTEGER NOT NULL,
+		count_id INTEGER REFERENCES cash_counts(id),
+		approved_by INTEGER REFERENCES users(id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_cash_events_handler ON cash_events(handler_id, at);
+
+	CREATE TRIGGER IF NOT EXISTS cash_events_no_update BEFORE UPDATE ON cash_events
+	BEGIN SELECT RAISE(ABORT, 'cash events are append-only'); END;
+
+	CREATE TRIGGER IF NOT EXISTS cash_events_no_delete BEFORE DELETE ON cash_events
+	BEGIN SELECT RAISE(ABORT, 'cash events are append-only'); END;
+
+	INSERT OR IGNORE INTO ledger_accounts (code, name, type) VALUES
+		('CASH_VARIANCE', 'Cash over/short', 'expense');`)
+	return err
+}
+
+// The CASH_VARIANCE account stays if it has postings, since postings can't be deleted
+func downCashReconciliation(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	DROP TRIGGER IF EXISTS cash_events_no_update;
+	DROP TRIGGER IF EXISTS cash_events_no_delete;
+	DROP TABLE cash_events;
+	DROP TABLE cash_count_notes;
+	DROP TABLE cash_counts;
+	DELETE FROM ledger_accounts
+	WHERE code = 'CASH_VARIANCE'
+		AND NOT EXISTS (SELECT 1 FROM postings p WHERE p.account_id = ledger_accounts.id);`)
+	return err
+}
@@ -0,0 +1,76 @@
+package db
+
+import "database/sql"
+
+// Migration 10: cassette capacities and low-water marks per terminal and
+// denomination, out-of-service flags for whole terminals and single
+// cassettes, and the queue of low-cash alerts for cash handlers. Existing
+// cassettes get a capacity of at least what they hold now, and those already
+// at or below the low-water mark get an alert. Each cassette has at most one
+// unresolved alert.
+func upCassettes(tx *sql.Tx) error {
+	columns := []struct{ name, definition string }{
+		{"out_of_service", "INTEGER NOT NULL DEFAULT 0"},
+		{"out_of_service_reason", "TEXT"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(tx, "atm", c.name, c.definition); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS cassettes (
+		terminal_id TEXT NOT NULL REFERENCES atm(terminal_id),
+		denomination INTEGER NOT NULL,
+		capacity INTEGER NOT NULL,
+		low_water INTEGER NOT NULL,
+		out_of_service INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (terminal_id, denomination),
+		CHECK (low_water >= 0 AND capacity > low_water)
+	);
+
+	WITH d(value) AS (VALUES (1), (5), (10), (20), (50), (100))
+	INSERT OR IGNORE INTO cassettes (terminal_id, denomination, capacity, low_water)
+	SELECT a.terminal_id, d.value,
+		MAX(2500, CASE d.value
+			WHEN 1 THEN a.ones WHEN 5 THEN a.fives WHEN 10 THEN a.tens
+			WHEN 20 THEN a.twenties WHEN 50 THEN a.fifties ELSE a.hundreds END),
+		20
+	FROM atm a, d;
+
+	CREATE TABLE IF NOT EXISTS cash_alerts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		terminal_id TEXT NOT NULL REFERENCES atm(terminal_id),
+		denomination INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		notes INTEGER NOT NULL,
+		created_at TEXT NOT NULL,
+		acknowledged_by TEXT,
+		acknowledged_at TEXT,
+		resolved_at TEXT
+	);
+
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_cash_alerts_open
+		ON cash_alerts(terminal_id, denomination) WHERE resolved_at IS NULL;
+
+	WITH stock(terminal_id, denomination, low_water, notes) AS (
+		SELECT a.terminal_id, c.denomination, c.low_water, CASE c.denomination
+			WHEN 1 THEN a.ones WHEN 5 THEN a.fives WHEN 10 THEN a.tens
+			WHEN 20 THEN a.twenties WHEN 50 THEN a.fifties ELSE a.hundreds END
+		FROM atm a JOIN cassettes c ON c.terminal_id = a.terminal_id)
+	INSERT OR IGNORE INTO cash_alerts (terminal_id, denomination, kind, notes, created_at)
+	SELECT terminal_id, denomination, CASE WHEN notes = 0 THEN 'empty' ELSE 'low_cash' END,
+		notes, datetime('now', 'localtime')
+	FROM stock WHERE notes <= low_water;`)
+	return err
+}
+
+func downCassettes(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	DROP TABLE cash_alerts;
+	DROP TABLE cassettes;
+	ALTER TABLE atm DROP COLUMN out_of_service_reason;
+	ALTER TABLE atm DROP COLUMN out_of_service;`)
+	return err
+}
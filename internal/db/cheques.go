@@ -0,0 +1,51 @@
+package db
+
+import "database/sql"
+
+// Migration 18: cheque and envelope deposits. A deposit waits in
+// cheque_deposits, its amount held, until an admin accepts it (posting the
+// credit from the CHEQUE_CLEARING account and logging the transaction) or
+// rejects it with a reason the customer is shown once as a notice.
+func upChequeDeposits(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS cheque_deposits (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		account_id INTEGER NOT NULL REFERENCES accounts(id),
+		terminal_id TEXT,
+		amount INTEGER NOT NULL CHECK (amount > 0),
+		image_path TEXT NOT NULL,
+		deposited_at TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending'
+			CHECK (status IN ('pending', 'accepted', 'rejected')),
+		reviewed_by INTEGER REFERENCES users(id),
+		reviewed_at TEXT,
+		reason TEXT,
+		transaction_id INTEGER REFERENCES transactions(id),
+		notice_seen INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_cheque_deposits_status ON cheque_deposits(status, account_id);
+
+	INSERT OR IGNORE INTO permissions (name, description) VALUES
+		('deposits.clear', 'Accept or reject cheque and envelope deposits');
+
+	INSERT OR IGNORE INTO role_permissions (role, permission) VALUES
+		('admin', 'deposits.clear');
+
+	INSERT OR IGNORE INTO ledger_accounts (code, name, type) VALUES
+		('CHEQUE_CLEARING', 'Cheques in clearing', 'asset');`)
+	return err
+}
+
+// The CHEQUE_CLEARING account stays if it has postings, since postings can't be deleted
+func downChequeDeposits(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	DROP TABLE cheque_deposits;
+	DELETE FROM user_permissions WHERE permission = 'deposits.clear';
+	DELETE FROM role_permissions WHERE permission = 'deposits.clear';
+	DELETE FROM permissions WHERE name = 'deposits.clear';
+	DELETE FROM ledger_accounts
+	WHERE code = 'CHEQUE_CLEARING'
+		AND NOT EXISTS (SELECT 1 FROM postings p WHERE p.account_id = ledger_accounts.id);`)
+	return err
+}
@@ -1,81 +1,157 @@
 package db
 
 import (
+	"SPG_ATM_Machine/internal/config"
 	"database/sql"
+	"fmt"
+	"strings"
 
 	_ "modernc.org/sqlite"
 )
 
-func Connect() (*sql.DB, error) {
-	db, err := sql.Open("sqlite", "./data.db")
+// Builds the connection string for cfg. Transactions take SQLite's write lock
+// as soon as they begin, so a read-modify-write inside one can't interleave
+// with another connection's. Writers that find the lock held wait up to the
+// busy timeout instead of failing immediately.
+func dsn(cfg config.Database) string {
+	foreignKeys := 0
+	if cfg.ForeignKeys {
+		foreignKeys = 1
+	}
+	return fmt.Sprintf("file:%s?_txlock=immediate&_pragma=busy_timeout(%d)&_pragma=journal_mode(%s)&_pragma=foreign_keys(%d)",
+		cfg.Path, cfg.BusyTimeoutMS, strings.ToUpper(cfg.JournalMode), foreignKeys)
+}
+
+// What schema changes need from a *sql.DB or *sql.Tx
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Opens the database without touching its schema, beyond making sure the
+// schema_migrations table exists
+func Open(cfg config.Database) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", dsn(cfg))
 	if err != nil {
 		return nil, err
 	}
+	if err := createMigrationsTable(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not open %s: %v", cfg.Path, err)
+	}
+	return db, nil
+}
 
-	query := `
-    CREATE TABLE IF NOT EXISTS users (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        full_name TEXT NOT NULL,
-        dob TEXT,
-        pin TEXT,
-        starting_bal REAL,
-        username TEXT UNIQUE,
-        role TEXT,
-		failed_attempts INTEGER DEFAULT 0,
-    	locked INTEGER DEFAULT 0
-    );`
-	_, err = db.Exec(query)
+// Opens the database and applies any pending migrations. Refuses to run
+// against a database migrated by a newer build. All money columns hold
+// integer cents.
+func Connect(cfg config.Database) (*sql.DB, error) {
+	db, err := Open(cfg)
 	if err != nil {
 		return nil, err
 	}
+	if err := MigrateUp(db, LatestVersion()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
 
-	atmTable := `
-    CREATE TABLE IF NOT EXISTS atm (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        balance REAL GENERATED ALWAYS AS (ones * 1 + fives * 5 + tens * 10 + twenties * 20 + fifties * 50 + hundreds * 100) STORED,
-		withdrawal_limit REAL DEFAULT 0,
-    	deposit_limit REAL DEFAULT 0,
-		ones INTEGER DEFAULT 0,
-		fives INTEGER DEFAULT 0,
-		tens INTEGER DEFAULT 0,
-		twenties INTEGER DEFAULT 0,
-		fifties INTEGER DEFAULT 0,
-		hundreds INTEGER DEFAULT 0
-    );`
+// The database handle and settings shared by everything in one program. It
+// is opened once at startup and passed down to whatever needs it.
+type Store struct {
+	DB     *sql.DB
+	Config config.Config
+}
 
-	_, err = db.Exec(atmTable)
+// Connects to the configured database, migrating it if needed. The
+// configured terminal must be registered in it.
+func OpenStore(cfg config.Config) (*Store, error) {
+	db, err := Connect(cfg.Database)
 	if err != nil {
 		return nil, err
 	}
 
-	var count int
-	err = db.QueryRow("SELECT COUNT(*) FROM atm").Scan(&count)
+	var exists bool
+	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM atm WHERE terminal_id = ?)", cfg.Terminal.ID).Scan(&exists)
+	if err == nil && !exists {
+		err = fmt.Errorf("terminal %q is not registered in the database", cfg.Terminal.ID)
+	}
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
+	return &Store{DB: db, Config: cfg}, nil
+}
 
-	if count == 0 {
-		_, err = db.Exec(`
-			INSERT INTO atm (withdrawal_limit, deposit_limit, ones, fives, tens, twenties, fifties, hundreds)
-			VALUES (500, 1000, 0, 0, 0, 0, 0, 0);
-		`)
-		if err != nil {
-			return nil, err
-		}
-	}
+func (s *Store) Close() error {
+	return s.DB.Close()
+}
+
+// Migration 1: the users, atm and transactions tables as they were first
+// shipped, with dollars in REAL columns
+func upInitialSchema(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		full_name TEXT NOT NULL,
+		dob TEXT,
+		pin TEXT,
+		starting_bal REAL,
+		username TEXT UNIQUE,
+		role TEXT,
+		failed_attempts INTEGER DEFAULT 0,
+		locked INTEGER DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS atm (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		balance REAL GENERATED ALWAYS AS (ones * 1 + fives * 5 + tens * 10 + twenties * 20 + fifties * 50 + hundreds * 100) STORED,
+		withdrawal_limit REAL DEFAULT 0,
+		deposit_limit REAL DEFAULT 0,
+		ones INTEGER DEFAULT 0,
+		fives INTEGER DEFAULT 0,
+		tens INTEGER DEFAULT 0,
+		twenties INTEGER DEFAULT 0,
+		fifties INTEGER DEFAULT 0,
+		hundreds INTEGER DEFAULT 0
+	);
 
-	transactions := `
 	CREATE TABLE IF NOT EXISTS transactions (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		user_id int,
 		date TEXT NOT NULL,
 		balance REAL
-	);`
-
-	_, err = db.Exec(transactions)
+	);`)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return db, nil
+	// Early databases were created before the lockout columns existed
+	if err := addColumnIfMissing(tx, "users", "failed_attempts", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(tx, "users", "locked", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM atm").Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		_, err = tx.Exec(`
+			INSERT INTO atm (withdrawal_limit, deposit_limit, ones, fives, tens, twenties, fifties, hundreds)
+			VALUES (500, 1000, 0, 0, 0, 0, 0, 0);`)
+	}
+	return err
+}
+
+func downInitialSchema(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	DROP TABLE transactions;
+	DROP TABLE atm;
+	DROP TABLE users;`)
+	return err
 }
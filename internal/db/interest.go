@@ -0,0 +1,23 @@
+package db
+
+import "database/sql"
+
+// Migration 20: the permission to run the daily savings interest accrual,
+// granted to admins
+func upInterestPermission(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	INSERT OR IGNORE INTO permissions (name, description) VALUES
+		('accounts.interest', 'Pay daily interest on savings accounts');
+
+	INSERT OR IGNORE INTO role_permissions (role, permission) VALUES
+		('admin', 'accounts.interest');`)
+	return err
+}
+
+func downInterestPermission(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	DELETE FROM user_permissions WHERE permission = 'accounts.interest';
+	DELETE FROM role_permissions WHERE permission = 'accounts.interest';
+	DELETE FROM permissions WHERE name = 'accounts.interest';`)
+	return err
+}
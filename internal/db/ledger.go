@@ -0,0 +1,197 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Migration 4: the journal/postings tables used as the book of record for
+// balances, with opening entries for the balances already held. Journal
+// entries and postings are append-only, enforced by triggers.
+func upLedger(tx *sql.Tx) error {
+	if err := createLedgerTables(tx); err != nil {
+		return err
+	}
+	return backfillLedger(tx)
+}
+
+// The ledger is the book of record once it exists, so each customer's ledger
+// balance is folded back into starting_bal before the postings are dropped
+func downLedger(tx *sql.Tx) error {
+	typ, err := columnType(tx, "users", "starting_bal")
+	if err != nil {
+		return err
+	}
+	balance := "SUM(p.credit - p.debit)"
+	if typ == "REAL" {
+		// Still in dollars, below the cents migration
+		balance = "SUM(p.credit - p.debit) / 100.0"
+	}
+	_, err = tx.Exec(`
+	UPDATE users SET starting_bal = (
+		SELECT COALESCE(` + balance + `, 0)
+		FROM postings p
+		JOIN ledger_accounts a ON a.id = p.account_id
+		WHERE a.user_id = users.id
+	)
+	WHERE EXISTS (SELECT 1 FROM ledger_accounts a WHERE a.user_id = users.id)`)
+	if err != nil {
+		return fmt.Errorf("failed to fold ledger balances into users: %v", err)
+	}
+
+	_, err = tx.Exec(`
+	DROP TRIGGER IF EXISTS journal_entries_no_update;
+	DROP TRIGGER IF EXISTS journal_entries_no_delete;
+	DROP TRIGGER IF EXISTS postings_no_update;
+	DROP TRIGGER IF EXISTS postings_no_delete;
+	DROP TABLE postings;
+	DROP TABLE journal_entries;
+	DROP TABLE ledger_accounts;`)
+	return err
+}
+
+func createLedgerTables(tx *sql.Tx) error {
+	ledgerTables := `
+	CREATE TABLE IF NOT EXISTS ledger_accounts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		user_id INTEGER
+	);
+
+	CREATE TABLE IF NOT EXISTS journal_entries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		date TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		memo TEXT
+	);
+
+	CREATE TABLE IF NOT EXISTS postings (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		entry_id INTEGER NOT NULL REFERENCES journal_entries(id),
+		account_id INTEGER NOT NULL REFERENCES ledger_accounts(id),
+		debit INTEGER NOT NULL DEFAULT 0,
+		credit INTEGER NOT NULL DEFAULT 0,
+		CHECK (debit >= 0 AND credit >= 0)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_postings_account ON postings(account_id);
+
+	CREATE TRIGGER IF NOT EXISTS journal_entries_no_update BEFORE UPDATE ON journal_entries
+	BEGIN SELECT RAISE(ABORT, 'journal entries are append-only'); END;
+
+	CREATE TRIGGER IF NOT EXISTS journal_entries_no_delete BEFORE DELETE ON journal_entries
+	BEGIN SELECT RAISE(ABORT, 'journal entries are append-only'); END;
+
+	CREATE TRIGGER IF NOT EXISTS postings_no_update BEFORE UPDATE ON postings
+	BEGIN SELECT RAISE(ABORT, 'postings are append-only'); END;
+
+	CREATE TRIGGER IF NOT EXISTS postings_no_delete BEFORE DELETE ON postings
+	BEGIN SELECT RAISE(ABORT, 'postings are append-only'); END;
+
+	INSERT OR IGNORE INTO ledger_accounts (code, name, type) VALUES
+		('ATM_VAULT', 'ATM cash vault', 'asset'),
+		('SUSPENSE', 'Suspense', 'suspense');`
+
+	_, err := tx.Exec(ledgerTables)
+	return err
+}
+
+// Opens ledger accounts for users created before the ledger existed. Their
+// current starting_bal is posted against suspense as an opening entry, and the
+// same is done once for the cash already sitting in the ATM vault.
+func backfillLedger(tx *sql.Tx) error {
+	rows, err := tx.Query(`
+		SELECT id, username, COALESCE(starting_bal, 0)
+		FROM users
+		WHERE NOT EXISTS (SELECT 1 FROM ledger_accounts a WHERE a.user_id = users.id)`)
+	if err != nil {
+		return err
+	}
+
+	type openingBalance struct {
+		userID   int
+		username string
+		amount   int64
+	}
+	var pending []openingBalance
+	for rows.Next() {
+		var ob openingBalance
+		if err := rows.Scan(&ob.userID, &ob.username, &ob.amount); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, ob)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, ob := range pending {
+		res, err := tx.Exec(`INSERT INTO ledger_accounts (code, name, type, user_id) VALUES (?, ?, 'customer', ?)`,
+			fmt.Sprintf("CUST-%d", ob.userID), ob.username, ob.userID)
+		if err != nil {
+			return fmt.Errorf("failed to open ledger account for %s: %v", ob.username, err)
+		}
+		accountID, _ := res.LastInsertId()
+		if ob.amount != 0 {
+			if err := postOpening(tx, "migrated balance for "+ob.username, "SUSPENSE", accountID, ob.amount); err != nil {
+				return err
+			}
+		}
+	}
+
+	var vaultPostings int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM postings p
+		JOIN ledger_accounts a ON a.id = p.account_id
+		WHERE a.code = 'ATM_VAULT'`).Scan(&vaultPostings)
+	if err != nil {
+		return err
+	}
+	if vaultPostings == 0 {
+		var vaultBal int64
+		if err := tx.QueryRow("SELECT COALESCE(SUM(balance), 0) FROM atm").Scan(&vaultBal); err != nil {
+			return err
+		}
+		if vaultBal != 0 {
+			var vaultID int64
+			if err := tx.QueryRow("SELECT id FROM ledger_accounts WHERE code = 'ATM_VAULT'").Scan(&vaultID); err != nil {
+				return err
+			}
+			// The vault is an asset, so the opening amount is a debit
+			if err := postOpening(tx, "migrated ATM cash", "SUSPENSE", vaultID, -vaultBal); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Posts an opening entry of amount credited to accountID (debited when
+// negative), balanced against the contra account code.
+func postOpening(tx *sql.Tx, memo, contraCode string, accountID int64, amount int64) error {
+	var contraID int64
+	if err := tx.QueryRow("SELECT id FROM ledger_accounts WHERE code = ?", contraCode).Scan(&contraID); err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`INSERT INTO journal_entries (date, kind, memo) VALUES (datetime('now', 'localtime'), 'opening', ?)`, memo)
+	if err != nil {
+		return err
+	}
+	entryID, _ := res.LastInsertId()
+
+	debitAcct, creditAcct := contraID, accountID
+	if amount < 0 {
+		debitAcct, creditAcct, amount = accountID, contraID, -amount
+	}
+	if _, err := tx.Exec(`INSERT INTO postings (entry_id, account_id, debit, credit) VALUES (?, ?, ?, 0)`, entryID, debitAcct, amount); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO postings (entry_id, account_id, debit, credit) VALUES (?, ?, 0, ?)`, entryID, creditAcct, amount)
+	return err
+}
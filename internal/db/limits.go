@@ -0,0 +1,25 @@
+package db
+
+import "database/sql"
+
+// Migration 5: the per-customer velocity limit table. The row for user 0 holds
+// the defaults; a NULL column in a customer's row falls back to the default.
+func upVelocityLimits(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS velocity_limits (
+		user_id INTEGER PRIMARY KEY,
+		daily_withdrawal INTEGER,
+		daily_deposit INTEGER,
+		rolling_withdrawal INTEGER,
+		rolling_deposit INTEGER
+	);
+
+	INSERT OR IGNORE INTO velocity_limits (user_id, daily_withdrawal, daily_deposit, rolling_withdrawal, rolling_deposit)
+	VALUES (0, 100000, 500000, 150000, 750000);`)
+	return err
+}
+
+func downVelocityLimits(tx *sql.Tx) error {
+	_, err := tx.Exec("DROP TABLE velocity_limits")
+	return err
+}
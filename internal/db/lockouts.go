@@ -0,0 +1,52 @@
+package db
+
+import "database/sql"
+
+// Migration 12: timed lockouts. locked_until holds the end of a temporary
+// lockout and lockout_count the lockouts since the last good PIN; locked
+// stays the lock only an admin can clear. lock_events keeps the history of
+// every lock and unlock, and login_failures the rejected cards per terminal
+// for throttling.
+func upLockouts(tx *sql.Tx) error {
+	columns := []struct{ name, definition string }{
+		{"locked_until", "TEXT"},
+		{"lockout_count", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(tx, "users", c.name, c.definition); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS lock_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		at TEXT NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		event TEXT NOT NULL,
+		locked_until TEXT,
+		actor TEXT NOT NULL,
+		terminal_id TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_lock_events_user ON lock_events(user_id, id);
+
+	CREATE TABLE IF NOT EXISTS login_failures (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		terminal_id TEXT NOT NULL,
+		at TEXT NOT NULL,
+		reason TEXT NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_login_failures_terminal ON login_failures(terminal_id, at);`)
+	return err
+}
+
+func downLockouts(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	DROP TABLE login_failures;
+	DROP TABLE lock_events;
+	ALTER TABLE users DROP COLUMN lockout_count;
+	ALTER TABLE users DROP COLUMN locked_until;`)
+	return err
+}
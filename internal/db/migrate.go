@@ -0,0 +1,214 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// A numbered schema change. Up moves the schema from Version-1 to Version and
+// Down undoes it. Both run inside one transaction together with the
+// schema_migrations bookkeeping, so a failed step leaves the database as it was.
+//
+// Databases from before migrations were versioned have no schema_migrations
+// rows and start at version 0, with some of the changes already in place, so
+// every Up checks the schema before changing it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+	Down    func(tx *sql.Tx) error
+}
+
+// A migration and when it was applied, or "" if it is pending
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt string
+}
+
+// Every migration in order. Add new ones at the end; never renumber or edit
+// one that has shipped.
+var migrations = []Migration{
+	{1, "initial schema", upInitialSchema, downInitialSchema},
+	{2, "money in integer cents", upMoneyToCents, downMoneyToCents},
+	{3, "transaction kinds", upTransactionKinds, downTransactionKinds},
+	{4, "double-entry ledger", upLedger, downLedger},
+	{5, "velocity limits", upVelocityLimits, downVelocityLimits},
+	{6, "atm cards", upCards, downCards},
+	{7, "audit log", upAuditLog, downAuditLog},
+	{8, "cash reconciliation", upCashReconciliation, downCashReconciliation},
+	{9, "terminals", upTerminals, downTerminals},
+	{10, "cassettes", upCassettes, downCassettes},
+	{11, "pin changes", upPINChanges, downPINChanges},
+	{12, "lockouts", upLockouts, downLockouts},
+	{13, "accounts", upAccounts, downAccounts},
+	{14, "account status", upAccountStatus, downAccountStatus},
+	{15, "roles and permissions", upPermissions, downPermissions},
+	{16, "approvals", upApprovals, downApprovals},
+	{17, "receipts", upReceipts, downReceipts},
+	{18, "cheque deposits", upChequeDeposits, downChequeDeposits},
+	{19, "per-account velocity limits", upAccountLimits, downAccountLimits},
+	{20, "interest permission", upInterestPermission, downInterestPermission},
+}
+
+// Version of the newest migration this build knows about
+func LatestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+func createMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	);`)
+	return err
+}
+
+// The highest migration applied to the database, 0 if none
+func CurrentVersion(db *sql.DB) (int, error) {
+	return currentVersion(db)
+}
+
+func currentVersion(q querier) (int, error) {
+	var version int
+	err := q.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("could not read schema version: %v", err)
+	}
+	return version, nil
+}
+
+// Fails if the database was migrated by a newer build than this one
+func CheckVersion(db *sql.DB) error {
+	version, err := CurrentVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > LatestVersion() {
+		return fmt.Errorf("database schema is at version %d but this build only knows up to version %d; run a newer build", version, LatestVersion())
+	}
+	return nil
+}
+
+// Lists every known migration and when it was applied
+func Status(db *sql.DB) ([]MigrationStatus, error) {
+	applied := map[int]string{}
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("could not read schema_migrations: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var status []MigrationStatus
+	for _, m := range migrations {
+		status = append(status, MigrationStatus{Version: m.Version, Name: m.Name, AppliedAt: applied[m.Version]})
+	}
+	return status, nil
+}
+
+// Applies the pending migrations up to and including target. It never rolls
+// anything back: a target below the current version is refused. Each
+// migration runs in its own transaction.
+func MigrateUp(db *sql.DB, target int) error {
+	current, err := checkTarget(db, target)
+	if err != nil {
+		return err
+	}
+	if target < current {
+		return fmt.Errorf("database is at version %d, above %d; use down to roll back", current, target)
+	}
+
+	for _, m := range migrations {
+		if m.Version > target {
+			break
+		}
+		if err := applyMigration(db, m, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rolls back the migrations newer than target, newest first. A target above
+// the current version is refused. Each migration runs in its own transaction.
+func MigrateDown(db *sql.DB, target int) error {
+	current, err := checkTarget(db, target)
+	if err != nil {
+		return err
+	}
+	if target > current {
+		return fmt.Errorf("database is at version %d, below %d; use up to apply migrations", current, target)
+	}
+
+	for i := len(migrations) - 1; i >= 0 && migrations[i].Version > target; i-- {
+		if err := applyMigration(db, migrations[i], false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks target is a known version and the database isn't from a newer
+// build, and returns its current version
+func checkTarget(db *sql.DB, target int) (int, error) {
+	if target < 0 || target > LatestVersion() {
+		return 0, fmt.Errorf("no migration %d; versions run from 0 to %d", target, LatestVersion())
+	}
+	if err := CheckVersion(db); err != nil {
+		return 0, err
+	}
+	return currentVersion(db)
+}
+
+// Runs one migration up or down unless the database is already past it. The
+// version is re-read inside the transaction, so two programs starting at
+// once don't both apply it.
+func applyMigration(db *sql.DB, m Migration, up bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	version, err := currentVersion(tx)
+	if err != nil {
+		return err
+	}
+
+	direction := "up"
+	if up {
+		if version >= m.Version {
+			return nil
+		}
+		if err := m.Up(tx); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %v", m.Version, m.Name, err)
+		}
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, datetime('now', 'localtime'))`, m.Version, m.Name)
+	} else {
+		direction = "down"
+		if version != m.Version {
+			return nil
+		}
+		if err := m.Down(tx); err != nil {
+			return fmt.Errorf("rolling back migration %d (%s) failed: %v", m.Version, m.Name, err)
+		}
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("could not record migration %d %s: %v", m.Version, direction, err)
+	}
+	return tx.Commit()
+}
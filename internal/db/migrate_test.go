@@ -0,0 +1,121 @@
+package db
+
+import (
+	"SPG_ATM_Machine/internal/config"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Opens an empty, unmigrated database. Seeding cards writes card files to
+// the working directory, so the test runs in a temporary one.
+func openEmptyDB(t *testing.T) *sql.DB {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	cfg := config.Default().Database
+	cfg.Path = filepath.Join(t.TempDir(), "test.db")
+	conn, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// Every table's columns and types, and every index, by name
+func schemaOf(t *testing.T, conn *sql.DB) map[string][]string {
+	t.Helper()
+	rows, err := conn.Query(`
+		SELECT m.type, m.name, COALESCE(c.name, ''), COALESCE(c.type, '')
+		FROM sqlite_master m
+		LEFT JOIN pragma_table_info(m.name) c ON m.type = 'table'
+		WHERE m.name NOT LIKE 'sqlite_%' AND m.name != 'schema_migrations'
+		ORDER BY m.name, c.cid`)
+	if err != nil {
+		t.Fatalf("read schema: %v", err)
+	}
+	defer rows.Close()
+
+	schema := map[string][]string{}
+	for rows.Next() {
+		var typ, name, column, columnType string
+		if err := rows.Scan(&typ, &name, &column, &columnType); err != nil {
+			t.Fatalf("read schema: %v", err)
+		}
+		key := typ + " " + name
+		if column != "" {
+			schema[key] = append(schema[key], column+" "+columnType)
+		} else if _, ok := schema[key]; !ok {
+			schema[key] = nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("read schema: %v", err)
+	}
+	return schema
+}
+
+// The customer's balance in cents according to the ledger
+func customerBalance(t *testing.T, conn *sql.DB, userID int) int64 {
+	t.Helper()
+	var bal int64
+	err := conn.QueryRow(`
+		SELECT COALESCE(SUM(p.credit - p.debit), 0)
+		FROM postings p
+		JOIN ledger_accounts a ON a.id = p.account_id
+		WHERE a.code = ?`, fmt.Sprintf("CUST-%d", userID)).Scan(&bal)
+	if err != nil {
+		t.Fatalf("read balance: %v", err)
+	}
+	return bal
+}
+
+func TestMigrateRoundTrip(t *testing.T) {
+	conn := openEmptyDB(t)
+
+	// A customer from before the ledger, with their balance in dollars
+	if err := MigrateUp(conn, 1); err != nil {
+		t.Fatalf("migrate up to 1: %v", err)
+	}
+	res, err := conn.Exec(`INSERT INTO users (full_name, username, role, starting_bal) VALUES ('Test Customer', 'customer', 'customer', 125.5)`)
+	if err != nil {
+		t.Fatalf("create customer: %v", err)
+	}
+	id, _ := res.LastInsertId()
+	userID := int(id)
+
+	if err := MigrateUp(conn, LatestVersion()); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	want := schemaOf(t, conn)
+	if got := customerBalance(t, conn, userID); got != 12550 {
+		t.Fatalf("balance after migrating up = %d cents, want 12550", got)
+	}
+
+	// Rolling back to each version and up again must give the same schema,
+	// and keep the balance as long as the users table survives
+	for target := LatestVersion() - 1; target >= 0; target-- {
+		t.Run(fmt.Sprintf("down to %d", target), func(t *testing.T) {
+			if err := MigrateDown(conn, target); err != nil {
+				t.Fatalf("migrate down: %v", err)
+			}
+			if v, err := CurrentVersion(conn); err != nil || v != target {
+				t.Fatalf("version after down = %d (%v), want %d", v, err, target)
+			}
+			if err := MigrateUp(conn, LatestVersion()); err != nil {
+				t.Fatalf("migrate up: %v", err)
+			}
+			if got := schemaOf(t, conn); !reflect.DeepEqual(got, want) {
+				t.Errorf("schema after round trip differs:\ngot  %v\nwant %v", got, want)
+			}
+			if target == 0 {
+				return
+			}
+			if got := customerBalance(t, conn, userID); got != 12550 {
+				t.Errorf("balance after round trip = %d cents, want 12550", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Returns the declared type of a column, or "" if the table or column doesn't exist
+func columnType(q querier, table, column string) (string, error) {
+	rows, err := q.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name, typ  string
+			notNull    int
+			defaultVal sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &defaultVal, &pk); err != nil {
+			return "", err
+		}
+		if name == column {
+			return strings.ToUpper(typ), nil
+		}
+	}
+	return "", rows.Err()
+}
+
+// Adds a column to an existing table if it is missing
+func addColumnIfMissing(q querier, table, column, definition string) error {
+	typ, err := columnType(q, table, column)
+	if err != nil || typ != "" {
+		return err
+	}
+	_, err = q.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// Each money table from the days of REAL dollar columns, rebuilt with integer cents
+var centsRebuilds = []struct {
+	table, column string
+	rebuild       string
+}{
+	{"users", "starting_bal", `
+	CREATE TABLE users_cents (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		full_name TEXT NOT NULL,
+		dob TEXT,
+		pin TEXT,
+		starting_bal INTEGER,
+		username TEXT UNIQUE,
+		role TEXT,
+		failed_attempts INTEGER DEFAULT 0,
+		locked INTEGER DEFAULT 0
+	);
+	INSERT INTO users_cents (id, full_name, dob, pin, starting_bal, username, role, failed_attempts, locked)
+	SELECT id, full_name, dob, pin, CAST(ROUND(starting_bal * 100) AS INTEGER), username, role, failed_attempts, locked
+	FROM users;
+	DROP TABLE users;
+	ALTER TABLE users_cents RENAME TO users;`},
+
+	{"atm", "withdrawal_limit", `
+	CREATE TABLE atm_cents (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		balance INTEGER GENERATED ALWAYS AS ((ones * 1 + fives * 5 + tens * 10 + twenties * 20 + fifties * 50 + hundreds * 100) * 100) STORED,
+		withdrawal_limit INTEGER DEFAULT 0,
+		deposit_limit INTEGER DEFAULT 0,
+		ones INTEGER DEFAULT 0,
+		fives INTEGER DEFAULT 0,
+		tens INTEGER DEFAULT 0,
+		twenties INTEGER DEFAULT 0,
+		fifties INTEGER DEFAULT 0,
+		hundreds INTEGER DEFAULT 0
+	);
+	INSERT INTO atm_cents (id, withdrawal_limit, deposit_limit, ones, fives, tens, twenties, fifties, hundreds)
+	SELECT id, CAST(ROUND(withdrawal_limit * 100) AS INTEGER), CAST(ROUND(deposit_limit * 100) AS INTEGER),
+		ones, fives, tens, twenties, fifties, hundreds
+	FROM atm;
+	DROP TABLE atm;
+	ALTER TABLE atm_cents RENAME TO atm;`},
+
+	{"transactions", "balance", `
+	CREATE TABLE transactions_cents (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id int,
+		date TEXT NOT NULL,
+		balance INTEGER
+	);
+	INSERT INTO transactions_cents (id, user_id, date, balance)
+	SELECT id, user_id, date, CAST(ROUND(balance * 100) AS INTEGER) FROM transactions;
+	DROP TABLE transactions;
+	ALTER TABLE transactions_cents RENAME TO transactions;`},
+
+	// Only on databases that had the ledger before cents; the indexes and
+	// append-only triggers are recreated by upLedger
+	{"postings", "debit", `
+	CREATE TABLE postings_cents (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		entry_id INTEGER NOT NULL REFERENCES journal_entries(id),
+		account_id INTEGER NOT NULL REFERENCES ledger_accounts(id),
+		debit INTEGER NOT NULL DEFAULT 0,
+		credit INTEGER NOT NULL DEFAULT 0,
+		CHECK (debit >= 0 AND credit >= 0)
+	);
+	INSERT INTO postings_cents (id, entry_id, account_id, debit, credit)
+	SELECT id, entry_id, account_id, CAST(ROUND(debit * 100) AS INTEGER), CAST(ROUND(credit * 100) AS INTEGER)
+	FROM postings;
+	DROP TABLE postings;
+	ALTER TABLE postings_cents RENAME TO postings;`},
+}
+
+// Migration 2: converts tables that still store dollars in REAL columns to
+// integer cents
+func upMoneyToCents(tx *sql.Tx) error {
+	for _, r := range centsRebuilds {
+		typ, err := columnType(tx, r.table, r.column)
+		if err != nil {
+			return err
+		}
+		if typ != "REAL" {
+			continue
+		}
+		if _, err := tx.Exec(r.rebuild); err != nil {
+			return fmt.Errorf("failed to convert %s to cents: %v", r.table, err)
+		}
+	}
+	return nil
+}
+
+// Puts the money columns back into REAL dollars. The ledger is rolled back
+// before this runs, so postings are already gone.
+func downMoneyToCents(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE users_dollars (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		full_name TEXT NOT NULL,
+		dob TEXT,
+		pin TEXT,
+		starting_bal REAL,
+		username TEXT UNIQUE,
+		role TEXT,
+		failed_attempts INTEGER DEFAULT 0,
+		locked INTEGER DEFAULT 0
+	);
+	INSERT INTO users_dollars (id, full_name, dob, pin, starting_bal, username, role, failed_attempts, locked)
+	SELECT id, full_name, dob, pin, starting_bal / 100.0, username, role, failed_attempts, locked
+	FROM users;
+	DROP TABLE users;
+	ALTER TABLE users_dollars RENAME TO users;
+
+	CREATE TABLE atm_dollars (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		balance REAL GENERATED ALWAYS AS (ones * 1 + fives * 5 + tens * 10 + twenties * 20 + fifties * 50 + hundreds * 100) STORED,
+		withdrawal_limit REAL DEFAULT 0,
+		deposit_limit REAL DEFAULT 0,
+		ones INTEGER DEFAULT 0,
+		fives INTEGER DEFAULT 0,
+		tens INTEGER DEFAULT 0,
+		twenties INTEGER DEFAULT 0,
+		fifties INTEGER DEFAULT 0,
+		hundreds INTEGER DEFAULT 0
+	);
+	INSERT INTO atm_dollars (id, withdrawal_limit, deposit_limit, ones, fives, tens, twenties, fifties, hundreds)
+	SELECT id, withdrawal_limit / 100.0, deposit_limit / 100.0, ones, fives, tens, twenties, fifties, hundreds
+	FROM atm;
+	DROP TABLE atm;
+	ALTER TABLE atm_dollars RENAME TO atm;
+
+	CREATE TABLE transactions_dollars (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id int,
+		date TEXT NOT NULL,
+		balance REAL
+	);
+	INSERT INTO transactions_dollars (id, user_id, date, balance)
+	SELECT id, user_id, date, balance / 100.0 FROM transactions;
+	DROP TABLE transactions;
+	ALTER TABLE transactions_dollars RENAME TO transactions;`)
+	return err
+}
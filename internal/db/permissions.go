@@ -0,0 +1,95 @@
+package db
+
+import "database/sql"
+
+// Migration 15: roles and the named permissions each one grants. users.role
+// names a row of roles. A user with rows in user_permissions is scoped: they
+// hold only the permissions listed there that their role also grants. Users
+// without any rows hold everything their role grants.
+func upPermissions(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS roles (
+		name TEXT PRIMARY KEY,
+		description TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS permissions (
+		name TEXT PRIMARY KEY,
+		description TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS role_permissions (
+		role TEXT NOT NULL REFERENCES roles(name),
+		permission TEXT NOT NULL REFERENCES permissions(name),
+		PRIMARY KEY (role, permission)
+	);
+
+	CREATE TABLE IF NOT EXISTS user_permissions (
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		permission TEXT NOT NULL REFERENCES permissions(name),
+		PRIMARY KEY (user_id, permission)
+	);`)
+	if err != nil {
+		return err
+	}
+
+	roles := []struct{ name, description string }{
+		{"admin", "Bank staff who manage customers, limits, cards and terminals"},
+		{"cash handler", "Staff who load, unload and count cash at their assigned terminals"},
+		{"customer", "Account holders using the ATM"},
+	}
+	for _, r := range roles {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO roles (name, description) VALUES (?, ?)", r.name, r.description); err != nil {
+			return err
+		}
+	}
+
+	permissions := []struct {
+		name, description string
+		roles             []string
+	}{
+		{"account.deposit", "Deposit into own accounts", []string{"customer"}},
+		{"account.withdraw", "Withdraw from own accounts", []string{"customer"}},
+		{"account.transfer", "Transfer from own accounts", []string{"customer"}},
+		{"pin.change", "Change own PIN", []string{"admin", "cash handler", "customer"}},
+		{"atm.cash.load", "Load cash into an ATM", []string{"cash handler"}},
+		{"atm.cash.unload", "Unload cash from an ATM", []string{"cash handler"}},
+		{"atm.cash.count", "Count the cash in an ATM", []string{"cash handler"}},
+		{"atm.cash.review", "Review cash counts and cash events", []string{"admin"}},
+		{"atm.service", "Take an ATM or cassette out of service", []string{"admin", "cash handler"}},
+		{"atm.alerts", "View and acknowledge low-cash alerts", []string{"admin", "cash handler"}},
+		{"terminals.manage", "Register terminals, assign cash handlers and set cassettes", []string{"admin"}},
+		{"limits.update", "Change ATM and customer limits", []string{"admin"}},
+		{"users.create", "Create customers", []string{"admin"}},
+		{"staff.create", "Create admins and cash handlers", []string{"admin"}},
+		{"users.view", "Search customers and view their profiles", []string{"admin"}},
+		{"users.update", "Edit customer profiles", []string{"admin"}},
+		{"users.unlock", "Unlock locked users", []string{"admin"}},
+		{"users.pin.reset", "Issue temporary PINs", []string{"admin"}},
+		{"accounts.open", "Open customer accounts", []string{"admin"}},
+		{"accounts.status", "Freeze, unfreeze and close accounts", []string{"admin"}},
+		{"cards.manage", "Issue, renew and revoke cards", []string{"admin"}},
+		{"transactions.view", "View all transactions", []string{"admin"}},
+		{"audit.view", "View and verify the audit log", []string{"admin"}},
+	}
+	for _, p := range permissions {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO permissions (name, description) VALUES (?, ?)", p.name, p.description); err != nil {
+			return err
+		}
+		for _, role := range p.roles {
+			if _, err := tx.Exec("INSERT OR IGNORE INTO role_permissions (role, permission) VALUES (?, ?)", role, p.name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func downPermissions(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	DROP TABLE user_permissions;
+	DROP TABLE role_permissions;
+	DROP TABLE permissions;
+	DROP TABLE roles;`)
+	return err
+}
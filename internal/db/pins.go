@@ -0,0 +1,25 @@
+package db
+
+import "database/sql"
+
+// Migration 11: PIN changes. pin_must_change is set when an admin issues a
+// temporary PIN and cleared once the user picks their own.
+func upPINChanges(tx *sql.Tx) error {
+	columns := []struct{ name, definition string }{
+		{"pin_must_change", "INTEGER NOT NULL DEFAULT 0"},
+		{"pin_changed_at", "TEXT"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(tx, "users", c.name, c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func downPINChanges(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	ALTER TABLE users DROP COLUMN pin_changed_at;
+	ALTER TABLE users DROP COLUMN pin_must_change;`)
+	return err
+}
@@ -0,0 +1,25 @@
+package db
+
+import "database/sql"
+
+// Migration 17: the notes deposited or dispensed by a customer's cash
+// transaction, so its receipt can be reprinted with the same breakdown.
+// Transactions from before this migration have no notes recorded.
+func upReceipts(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS transaction_notes (
+		transaction_id INTEGER PRIMARY KEY REFERENCES transactions(id),
+		ones INTEGER NOT NULL DEFAULT 0,
+		fives INTEGER NOT NULL DEFAULT 0,
+		tens INTEGER NOT NULL DEFAULT 0,
+		twenties INTEGER NOT NULL DEFAULT 0,
+		fifties INTEGER NOT NULL DEFAULT 0,
+		hundreds INTEGER NOT NULL DEFAULT 0
+	);`)
+	return err
+}
+
+func downReceipts(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP TABLE transaction_notes;`)
+	return err
+}
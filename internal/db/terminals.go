@@ -0,0 +1,85 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Migration 9: gives each row of the atm table a terminal id and branch, and
+// records which terminal every transaction, cash event and cash count
+// happened at. Existing rows are from the single ATM that existed before, so
+// they are assigned to it. Cash handlers are assigned to the terminals they
+// may service; existing handlers get every existing terminal.
+func upTerminals(tx *sql.Tx) error {
+	columns := []struct{ table, name, definition string }{
+		{"atm", "terminal_id", "TEXT"},
+		{"atm", "branch", "TEXT NOT NULL DEFAULT 'Main'"},
+		{"transactions", "terminal_id", "TEXT"},
+		{"cash_events", "terminal_id", "TEXT"},
+		{"cash_counts", "terminal_id", "TEXT"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(tx, c.table, c.name, c.definition); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(`
+	UPDATE atm SET terminal_id = printf('ATM-%04d', id) WHERE terminal_id IS NULL;
+
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_atm_terminal ON atm(terminal_id);
+
+	CREATE TABLE IF NOT EXISTS terminal_handlers (
+		terminal_id TEXT NOT NULL REFERENCES atm(terminal_id),
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		PRIMARY KEY (terminal_id, user_id)
+	);
+
+	INSERT OR IGNORE INTO terminal_handlers (terminal_id, user_id)
+	SELECT a.terminal_id, u.id FROM atm a, users u WHERE u.role = 'cash handler';`)
+	if err != nil {
+		return err
+	}
+
+	var first sql.NullString
+	if err := tx.QueryRow("SELECT terminal_id FROM atm ORDER BY id LIMIT 1").Scan(&first); err != nil {
+		return fmt.Errorf("could not find the original ATM: %v", err)
+	}
+
+	// Opening balances weren't made at a terminal. Cash events are
+	// append-only, so their trigger is lifted for the backfill.
+	backfill := []string{
+		"UPDATE transactions SET terminal_id = ? WHERE terminal_id IS NULL AND kind != 'adjustment'",
+		"DROP TRIGGER IF EXISTS cash_events_no_update",
+		"UPDATE cash_events SET terminal_id = ? WHERE terminal_id IS NULL",
+		`CREATE TRIGGER cash_events_no_update BEFORE UPDATE ON cash_events
+		BEGIN SELECT RAISE(ABORT, 'cash events are append-only'); END`,
+		"UPDATE cash_counts SET terminal_id = ? WHERE terminal_id IS NULL",
+	}
+	for _, stmt := range backfill {
+		var args []any
+		if strings.Contains(stmt, "?") {
+			args = append(args, first)
+		}
+		if _, err := tx.Exec(stmt, args...); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("CREATE INDEX IF NOT EXISTS idx_transactions_terminal ON transactions(terminal_id, date)")
+	return err
+}
+
+func downTerminals(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	DROP TABLE terminal_handlers;
+	DROP INDEX IF EXISTS idx_transactions_terminal;
+	DROP INDEX IF EXISTS idx_atm_terminal;
+	ALTER TABLE cash_events DROP COLUMN terminal_id;
+	ALTER TABLE cash_counts DROP COLUMN terminal_id;
+	ALTER TABLE transactions DROP COLUMN terminal_id;
+	ALTER TABLE atm DROP COLUMN branch;
+	ALTER TABLE atm DROP COLUMN terminal_id;`)
+	return err
+}
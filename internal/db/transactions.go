@@ -0,0 +1,51 @@
+package db
+
+import "database/sql"
+
+// Migration 3: brings a transactions table from before transaction kinds up
+// to date. The old balance column actually held the signed amount, so it is
+// renamed, and old rows are classified by the sign of their amount.
+func upTransactionKinds(tx *sql.Tx) error {
+	typ, err := columnType(tx, "transactions", "balance")
+	if err != nil {
+		return err
+	}
+	if typ != "" {
+		if _, err := tx.Exec("ALTER TABLE transactions RENAME COLUMN balance TO amount"); err != nil {
+			return err
+		}
+	}
+
+	columns := []struct{ name, definition string }{
+		{"kind", "TEXT"},
+		{"counterparty_id", "INTEGER"},
+		{"balance_after", "INTEGER"},
+		{"correlation_id", "TEXT"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(tx, "transactions", c.name, c.definition); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE transactions
+		SET kind = CASE WHEN amount < 0 THEN 'withdrawal' ELSE 'deposit' END
+		WHERE kind IS NULL;
+
+		CREATE INDEX IF NOT EXISTS idx_transactions_user ON transactions(user_id, date);
+		CREATE INDEX IF NOT EXISTS idx_transactions_correlation ON transactions(correlation_id);`)
+	return err
+}
+
+func downTransactionKinds(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		DROP INDEX IF EXISTS idx_transactions_user;
+		DROP INDEX IF EXISTS idx_transactions_correlation;
+		ALTER TABLE transactions DROP COLUMN kind;
+		ALTER TABLE transactions DROP COLUMN counterparty_id;
+		ALTER TABLE transactions DROP COLUMN balance_after;
+		ALTER TABLE transactions DROP COLUMN correlation_id;
+		ALTER TABLE transactions RENAME COLUMN amount TO balance;`)
+	return err
+}
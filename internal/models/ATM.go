@@ -1,12 +1,12 @@
 package models
 
 type ATM struct {
-	ID      int
-	Balance float64
-	ones int
-	fives int
-	tens int
+	ID       int
+	Balance  Money
+	ones     int
+	fives    int
+	tens     int
 	twenties int
-	fifties int
+	fifties  int
 	hundreds int
 }
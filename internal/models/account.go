@@ -0,0 +1,41 @@
+package models
+
+// Account types
+const (
+	AccountChecking = "checking"
+	AccountSavings  = "savings"
+)
+
+// Account statuses. A frozen account takes credits but no debits; a closed
+// account takes neither.
+const (
+	AccountOpen   = "open"
+	AccountFrozen = "frozen"
+	AccountClosed = "closed"
+)
+
+// One of a customer's accounts, backed by its own ledger account
+type Account struct {
+	ID           int
+	Number       string
+	UserID       int
+	Owner        string // username
+	Type         string
+	Status       string
+	Balance      Money // available balance, excluding held funds
+	Held         Money // pending cheque and envelope deposits
+	InterestRate int   // yearly rate in basis points, savings only
+	OpenedAt     string
+	Ledger       string // ledger account code
+	StatusReason string // why it was last frozen, unfrozen or closed
+	StatusAt     string
+	StatusBy     string // admin who changed the status
+}
+
+// A customer's details as shown to admins
+type CustomerProfile struct {
+	User        User // without the PIN hash
+	LockedUntil string
+	Accounts    []Account
+	Total       Money // balance across all accounts
+}
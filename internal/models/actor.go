@@ -0,0 +1,14 @@
+package models
+
+// Roles a user can have, as stored in users.role
+const (
+	RoleAdmin       = "admin"
+	RoleCustomer    = "customer"
+	RoleCashHandler = "cash handler"
+)
+
+// The logged in user an operation is carried out for
+type Actor struct {
+	Username string
+	Role     string
+}
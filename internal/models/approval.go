@@ -0,0 +1,25 @@
+package models
+
+// Stages of a maker-checker request
+const (
+	ApprovalPending  = "pending"  // waiting for a second admin
+	ApprovalApproved = "approved" // approved and applied
+	ApprovalRejected = "rejected" // refused by a second admin
+	ApprovalExpired  = "expired"  // nobody decided in time
+)
+
+// A sensitive change one admin has asked for and another has to approve.
+// Kind is the audit action the change is logged as once applied.
+type Approval struct {
+	ID          int
+	Kind        string
+	Target      string
+	Summary     string
+	RequestedBy string
+	RequestedAt string
+	ExpiresAt   string
+	Status      string
+	DecidedBy   string
+	DecidedAt   string
+	Note        string
+}
@@ -0,0 +1,17 @@
+package models
+
+// One privileged action in the audit log. Before and After hold the changed
+// values as JSON. Hash covers every other field including PrevHash, the hash
+// of the entry before it, so editing or removing an entry breaks the chain.
+type AuditEntry struct {
+	ID       int
+	At       string
+	Actor    string
+	Role     string
+	Action   string
+	Target   string
+	Before   string
+	After    string
+	PrevHash string
+	Hash     string
+}
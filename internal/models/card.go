@@ -0,0 +1,106 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Issuer name printed on every card
+const CardIssuer = "JP Goldman Stanley"
+
+// First digits of every PAN this bank issues
+const IssuerBIN = "400000"
+
+// An ATM card. A card file only carries CardID, PAN, Expiry and Issuer; the
+// user binding and revoked flag come from the cards table.
+type Card struct {
+	ID       int
+	CardID   string
+	PAN      string
+	Expiry   string // MM/YY
+	Issuer   string
+	UserID   int
+	Username string
+	Revoked  bool
+	IssuedAt string
+}
+
+// PAN with all but the last four digits hidden
+func (c Card) MaskedPAN() string {
+	if len(c.PAN) <= 4 {
+		return c.PAN
+	}
+	return strings.Repeat("*", len(c.PAN)-4) + c.PAN[len(c.PAN)-4:]
+}
+
+// The "key: value" lines of the card's card file
+func (c Card) Record() string {
+	return fmt.Sprintf("card_id: %s\npan: %s\nexpiry: %s\nissuer: %s\n", c.CardID, c.PAN, c.Expiry, c.Issuer)
+}
+
+// Random card id and PAN for a new card. The PAN is random account digits
+// after the BIN, then the Luhn check digit.
+func NewCardNumbers() (cardID, pan string, err error) {
+	idBytes := make([]byte, 4)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", fmt.Errorf("failed to generate card id: %v", err)
+	}
+	accountDigits, err := rand.Int(rand.Reader, big.NewInt(1_000_000_000))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate card number: %v", err)
+	}
+	pan = fmt.Sprintf("%s%09d", IssuerBIN, accountDigits.Int64())
+	pan += string(LuhnDigit(pan))
+	return "CARD" + hex.EncodeToString(idBytes), pan, nil
+}
+
+// Parses an MM/YY expiry into the last moment the card is valid
+func ParseCardExpiry(expiry string) (time.Time, error) {
+	t, err := time.ParseInLocation("01/06", expiry, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expiry must be in MM/YY format")
+	}
+	// Cards are valid through the end of the expiry month
+	return t.AddDate(0, 1, 0).Add(-time.Second), nil
+}
+
+// Whether the card's expiry has passed at now
+func (c Card) Expired(now time.Time) bool {
+	end, err := ParseCardExpiry(c.Expiry)
+	return err != nil || now.After(end)
+}
+
+// Luhn check digit for a string of digits
+func LuhnDigit(digits string) byte {
+	sum := 0
+	double := true
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// Whether a PAN's last digit is a valid Luhn check digit
+func ValidPAN(pan string) bool {
+	if len(pan) < 12 || len(pan) > 19 {
+		return false
+	}
+	for _, r := range pan {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return LuhnDigit(pan[:len(pan)-1]) == pan[len(pan)-1]
+}
@@ -0,0 +1,61 @@
+package models
+
+// Stages of a cash handler's count
+const (
+	CountOpen      = "open"      // started, notes not entered yet
+	CountPending   = "pending"   // counted with a variance, waiting for an admin
+	CountBalanced  = "balanced"  // counted with no variance
+	CountApproved  = "approved"  // variance written off by an admin
+	CountRejected  = "rejected"  // variance refused by an admin; recount needed
+	CountCancelled = "cancelled" // abandoned, or the cassettes changed mid-count
+)
+
+// Kinds of cash events
+const (
+	CashEventLoad     = "load"
+	CashEventUnload   = "unload"
+	CashEventWriteOff = "write_off"
+)
+
+// A physical count of the cassettes. Expected is what the atm row held when
+// the count started and Counted is what the handler found, both ordered like
+// the ATM's denominations, smallest first.
+type CashCount struct {
+	ID             int
+	Terminal       string
+	Handler        string
+	Status         string
+	StartedAt      string
+	SubmittedAt    string
+	ReviewedBy     string
+	ReviewedAt     string
+	Expected       []int
+	Counted        []int // nil until the count is submitted
+	VarianceAmount Money // counted minus expected, in value
+}
+
+// Counted minus expected notes per denomination, nil if not counted yet
+func (c CashCount) Variance() []int {
+	if c.Counted == nil {
+		return nil
+	}
+	v := make([]int, len(c.Expected))
+	for i := range c.Expected {
+		v[i] = c.Counted[i] - c.Expected[i]
+	}
+	return v
+}
+
+// Cash put into or taken out of the cassettes by staff. Notes are the change
+// per denomination, negative for notes removed.
+type CashEvent struct {
+	ID         int
+	At         string
+	Terminal   string
+	Handler    string
+	Kind       string
+	Notes      []int
+	Amount     Money
+	CountID    int
+	ApprovedBy string
+}
@@ -0,0 +1,37 @@
+package models
+
+// Kinds of cash alerts
+const (
+	AlertLowCash = "low_cash" // at or below the low-water mark
+	AlertEmpty   = "empty"
+)
+
+// One denomination's cassette in a terminal
+type Cassette struct {
+	Denomination int
+	Notes        int
+	Capacity     int
+	LowWater     int // at or below this many notes the cassette raises an alert
+	OutOfService bool
+}
+
+// A cassette that ran low. It stays active until the cassette is refilled
+// above its low-water mark; acknowledging it only records that a cash
+// handler has seen it.
+type CashAlert struct {
+	ID             int
+	Terminal       string
+	Denomination   int
+	Kind           string
+	Notes          int // notes left when the alert was raised
+	CreatedAt      string
+	AcknowledgedBy string
+	AcknowledgedAt string
+}
+
+// Whether a terminal is taking withdrawals
+type ServiceStatus struct {
+	OutOfService bool
+	Reason       string
+	Disabled     []int // denominations taken out of service
+}
@@ -0,0 +1,25 @@
+package models
+
+// Stages of a cheque or envelope deposit
+const (
+	ChequePending  = "pending"  // held, waiting for an admin to clear it
+	ChequeAccepted = "accepted" // credited to the account
+	ChequeRejected = "rejected" // returned to the customer with a reason
+)
+
+// A cheque or envelope deposited at an ATM. Its amount is held, and not
+// part of the account's available balance, until an admin clears it.
+type ChequeDeposit struct {
+	ID            int
+	Account       string // account number
+	Owner         string // username
+	Terminal      string
+	Amount        Money
+	ImagePath     string // scanned image of the cheque or envelope
+	DepositedAt   string
+	Status        string
+	ReviewedBy    string
+	ReviewedAt    string
+	Reason        string // rejection notice shown to the customer
+	TransactionID int    // the credit, once accepted
+}
@@ -0,0 +1,9 @@
+package models
+
+type Posting struct {
+	ID      int
+	EntryID int
+	Account string
+	Debit   Money
+	Credit  Money
+}
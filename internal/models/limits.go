@@ -0,0 +1,18 @@
+package models
+
+// Per-customer caps on cash moved through the ATM. Daily caps reset at
+// midnight, rolling caps cover the last 24 hours.
+type VelocityLimits struct {
+	DailyWithdrawal   Money
+	DailyDeposit      Money
+	RollingWithdrawal Money
+	RollingDeposit    Money
+}
+
+// Cash a customer has moved against their velocity limits
+type VelocityUsage struct {
+	WithdrawnToday   Money
+	DepositedToday   Money
+	WithdrawnRolling Money
+	DepositedRolling Money
+}
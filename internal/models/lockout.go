@@ -0,0 +1,24 @@
+package models
+
+// Lock history events
+const (
+	LockTemporary  = "lock"           // too many wrong PINs, locked for a while
+	LockPermanent  = "lock_permanent" // too many lockouts, locked until an admin unlocks it
+	UnlockAuto     = "unlock_auto"    // a temporary lockout ran out
+	UnlockAdmin    = "unlock_admin"
+	UnlockPINReset = "unlock_pin_reset" // only in history from before PIN resets stopped unlocking
+)
+
+// Actor recorded for lock events the ATM makes itself
+const SystemActor = "system"
+
+// One lock or unlock of a user's account
+type LockEvent struct {
+	ID          int
+	At          string
+	Username    string
+	Event       string
+	LockedUntil string // end of a temporary lockout
+	Actor       string
+	Terminal    string
+}
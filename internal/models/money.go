@@ -0,0 +1,104 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Money is an amount in integer cents. Balances, limits and transaction
+// amounts are all stored this way so no value is ever rounded or truncated.
+type Money int64
+
+// Largest number of dollar digits ParseMoney accepts, well inside int64 cents
+const maxDollarDigits = 15
+
+// Builds a Money value from whole dollars
+func Dollars(d int64) Money {
+	return Money(d * 100)
+}
+
+// Whole dollars, dropping any cents
+func (m Money) Dollars() int64 {
+	return int64(m) / 100
+}
+
+// Cents past the whole dollar amount
+func (m Money) Cents() int64 {
+	c := int64(m) % 100
+	if c < 0 {
+		c = -c
+	}
+	return c
+}
+
+// Formats the amount as dollars with two decimal places, e.g. 20.75
+func (m Money) String() string {
+	sign := ""
+	d := m.Dollars()
+	if m < 0 {
+		sign = "-"
+		d = -d
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, d, m.Cents())
+}
+
+// Parses a non-negative decimal amount such as "20", "20.5" or "20.75".
+// Signs, exponents, separators and more than two fractional digits are rejected.
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("amount is empty")
+	}
+
+	whole, frac, hasPoint := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if hasPoint && frac == "" {
+		return 0, fmt.Errorf("invalid amount %q: missing cents after decimal point", s)
+	}
+	if len(frac) > 2 {
+		return 0, fmt.Errorf("invalid amount %q: at most two decimal places are allowed", s)
+	}
+	if len(whole) > maxDollarDigits {
+		return 0, fmt.Errorf("amount %q is too large", s)
+	}
+
+	var dollars int64
+	for _, r := range whole {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("invalid amount %q", s)
+		}
+		dollars = dollars*10 + int64(r-'0')
+	}
+
+	var cents int64
+	for i, r := range frac {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("invalid amount %q", s)
+		}
+		if i == 0 {
+			cents += int64(r-'0') * 10
+		} else {
+			cents += int64(r - '0')
+		}
+	}
+
+	return Money(dollars*100 + cents), nil
+}
+
+// Encodes the amount as a decimal string such as "20.75" so it survives
+// JSON clients that read numbers as floats
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + m.String() + `"`), nil
+}
+
+// Accepts "20.75" or 20.75 and parses it with ParseMoney
+func (m *Money) UnmarshalJSON(data []byte) error {
+	parsed, err := ParseMoney(strings.Trim(string(data), `"`))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
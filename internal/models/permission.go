@@ -0,0 +1,44 @@
+package models
+
+// Permissions checked by internal/api, as stored in the permissions table
+const (
+	PermDeposit          = "account.deposit"
+	PermWithdraw         = "account.withdraw"
+	PermTransfer         = "account.transfer"
+	PermPINChange        = "pin.change"
+	PermCashLoad         = "atm.cash.load"
+	PermCashUnload       = "atm.cash.unload"
+	PermCashCount        = "atm.cash.count"
+	PermCashReview       = "atm.cash.review"
+	PermService          = "atm.service"
+	PermCashAlerts       = "atm.alerts"
+	PermTerminalsManage  = "terminals.manage"
+	PermLimitsUpdate     = "limits.update"
+	PermUsersCreate      = "users.create"
+	PermStaffCreate      = "staff.create"
+	PermUsersView        = "users.view"
+	PermUsersUpdate      = "users.update"
+	PermUsersUnlock      = "users.unlock"
+	PermPINReset         = "users.pin.reset"
+	PermAccountsOpen     = "accounts.open"
+	PermAccountsStatus   = "accounts.status"
+	PermCardsManage      = "cards.manage"
+	PermTransactionsView = "transactions.view"
+	PermAuditView        = "audit.view"
+	PermApprovalsReview  = "approvals.review"
+	PermAccountsAdjust   = "accounts.adjust"
+	PermDepositsClear    = "deposits.clear"
+	PermInterestAccrue   = "accounts.interest"
+)
+
+type Permission struct {
+	Name        string
+	Description string
+}
+
+// A role and the permissions it grants
+type Role struct {
+	Name        string
+	Description string
+	Permissions []Permission
+}
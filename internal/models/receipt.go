@@ -0,0 +1,30 @@
+package models
+
+import "strings"
+
+// A customer's receipt for one deposit, withdrawal or transfer, as printed
+// and spooled
+type Receipt struct {
+	Bank          string `json:"bank"`
+	Terminal      string `json:"terminal"`
+	TransactionID int    `json:"transaction_id"`
+	Kind          string `json:"kind"`
+	Account       string `json:"account"`              // masked account number
+	ToAccount     string `json:"to_account,omitempty"` // masked; own-account transfers only
+	ToUser        string `json:"to_user,omitempty"`    // transfers to another customer only
+	Amount        Money  `json:"amount"`
+	// Notes deposited or dispensed keyed by denomination, nil for transfers
+	// and for transactions from before notes were recorded
+	Notes     map[string]int `json:"notes,omitempty"`
+	Available Money          `json:"available_balance"`
+	Timestamp string         `json:"timestamp"`
+	Reprint   bool           `json:"reprint"`
+}
+
+// An account number with all but the last four digits hidden
+func MaskAccount(number string) string {
+	if len(number) <= 4 {
+		return number
+	}
+	return strings.Repeat("*", len(number)-4) + number[len(number)-4:]
+}
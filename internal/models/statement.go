@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+type StatementLine struct {
+	EntryID     int
+	Date        string
+	Kind        string
+	Description string
+	Amount      Money
+	Balance     Money
+}
+
+type Statement struct {
+	Bank        string // printed in the statement header
+	Username    string
+	FullName    string
+	Account     string // account number
+	AccountType string
+	From        time.Time
+	To          time.Time
+	Opening     Money
+	Closing     Money
+	Lines       []StatementLine
+}
@@ -0,0 +1,13 @@
+package models
+
+// One ATM, identified by its terminal id
+type Terminal struct {
+	ID              string
+	Branch          string
+	Balance         Money
+	Notes           []int // ordered like the ATM's denominations, smallest first
+	WithdrawalLimit Money
+	DepositLimit    Money
+	OutOfService    bool
+	Handlers        []string // cash handlers allowed to service it
+}
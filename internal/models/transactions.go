@@ -1,8 +1,30 @@
 package models
 
+// Kinds of rows in the transactions table
+const (
+	KindDeposit      = "deposit"
+	KindWithdrawal   = "withdrawal"
+	KindTransferIn   = "transfer_in"
+	KindTransferOut  = "transfer_out"
+	KindCashLoad     = "cash_load"
+	KindCashUnload   = "cash_unload"
+	KindCashVariance = "cash_variance"
+	KindAdjustment   = "adjustment"
+	KindInterest     = "interest"
+	KindCheque       = "cheque_deposit"
+)
+
 type Transaction struct {
-	ID      int
-	USER_ID int
-	Date    string
-	Balance float64
+	ID             int
+	USER_ID        int
+	Username       string
+	Date           string
+	Kind           string
+	Amount         Money
+	CounterpartyID int
+	Counterparty   string
+	BalanceAfter   *Money // nil for rows logged before balances were recorded
+	CorrelationID  string
+	Terminal       string // empty for rows not made at an ATM, e.g. opening balances
+	Account        string // account number; empty for cash handler rows
 }
@@ -1,13 +1,13 @@
 package models
 
 type User struct {
-	ID          int
-	FullName    string
-	DOB         string
-	PIN         string
-	StartingBal float64
-	Username    string
-	Role        string
+	ID             int
+	FullName       string
+	DOB            string
+	PIN            string
+	StartingBal    Money
+	Username       string
+	Role           string
 	FailedAttempts int
 	Locked         int
 }
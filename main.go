@@ -2,19 +2,57 @@ package main
 
 import (
 	"SPG_ATM_Machine/auth"
+	"SPG_ATM_Machine/internal/config"
+	"SPG_ATM_Machine/internal/db"
 	"SPG_ATM_Machine/utils"
+	"flag"
 	"fmt"
+	"log"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func main() {
-	fmt.Println("Welcome to JP Goldman Stanley ATM!")
+	scriptPath := flag.String("script", "", "replay answers from a script file and print each step as JSON")
+	cardPath := flag.String("card", auth.DefaultCardPath, "card file the card reader reads")
+	configPath := flag.String("config", "", "config file (default $ATM_CONFIG or "+config.DefaultPath+")")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalln("Error loading config:", err)
+	}
+
+	// One handle for the whole session. Connecting brings the schema up to
+	// date and refuses a database from a newer build.
+	store, err := db.OpenStore(cfg)
+	if err != nil {
+		log.Fatalln("Error connecting to database:", err)
+	}
+	defer store.Close()
+
+	cardReader := auth.NewFileCardReader(*cardPath)
+	if *scriptPath != "" {
+		script, err := startScript(*scriptPath, cardReader)
+		if err != nil {
+			log.Fatalln("Could not start script:", err)
+		}
+		defer script.Close()
+	}
+
+	fmt.Printf("Welcome to %s ATM!\n", cfg.Branding.BankName)
 	for {
-		answer := strings.ToUpper(utils.TypeInput("Would you like to Login? Y/N"))
+		answer, err := utils.TypeInput("Would you like to Login? Y/N")
+		if err != nil {
+			continue
+		}
+		answer = strings.ToUpper(answer)
 		if answer == "Y" {
-			isSucess, username := auth.Login()
+			isSucess, sess := auth.Login(store, cardReader)
 			if isSucess {
-				auth.RouteUser(username)
+				auth.RouteUser(store, sess)
 			} else {
 				fmt.Println("Login failed, try Again")
 			}
@@ -27,3 +65,39 @@ func main() {
 	}
 
 }
+
+// Sends every prompt to the script and captures what the ATM prints, so the
+// real stdout only carries the JSON steps. "!card <path>" in the script
+// inserts a different card, and "!wait <seconds>" pauses as if the user had
+// walked away.
+func startScript(path string, cardReader *auth.FileCardReader) (*utils.ScriptInput, error) {
+	capture, err := os.CreateTemp("", "atm-script-*.out")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(capture.Name())
+
+	script, err := utils.NewScriptInput(path, capture, os.Stdout)
+	if err != nil {
+		return nil, err
+	}
+	script.HandleDirective("card", func(arg string) error {
+		if arg == "" {
+			return fmt.Errorf("missing card path")
+		}
+		cardReader.Path = arg
+		fmt.Println("Card inserted:", arg)
+		return nil
+	})
+	script.HandleDirective("wait", func(arg string) error {
+		seconds, err := strconv.Atoi(arg)
+		if err != nil || seconds < 0 {
+			return fmt.Errorf("wait needs a number of seconds")
+		}
+		return utils.Idle(time.Duration(seconds) * time.Second)
+	})
+
+	os.Stdout = capture
+	utils.SetInput(script)
+	return script, nil
+}
@@ -0,0 +1,103 @@
+// Package session keeps track of a logged in user's time at the ATM and ends
+// it when they walk away or have used up their operations.
+package session
+
+import (
+	"SPG_ATM_Machine/internal/config"
+	"SPG_ATM_Machine/utils"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Operations left at which the menus start warning the user
+const warnOperations = 3
+
+// One login, from the PIN being accepted until the user exits or the session
+// is ended for them
+type Session struct {
+	Username     string
+	Role         string
+	Started      time.Time
+	LastActivity time.Time
+	// Menu choices made so far, not counting showing the options or exiting
+	Operations int
+
+	limits config.Session
+	ended  bool
+}
+
+// Starts a session for a user who has just logged in
+func New(username, role string, limits config.Session) *Session {
+	now := time.Now()
+	return &Session{Username: username, Role: role, Started: now, LastActivity: now, limits: limits}
+}
+
+// Prompts must be answered within the idle timeout of the last answer
+func (s *Session) Deadline() time.Time {
+	return s.LastActivity.Add(time.Duration(s.limits.IdleSeconds) * time.Second)
+}
+
+func (s *Session) Warning() time.Duration {
+	return time.Duration(s.limits.WarningSeconds) * time.Second
+}
+
+// Records activity, keeping the session alive
+func (s *Session) Touch() {
+	s.LastActivity = time.Now()
+}
+
+// Runs a role's menu for the session. If the user doesn't answer a prompt in
+// time, wherever they are in the menu, the menu returns
+// utils.ErrPromptExpired and the session ends.
+func (s *Session) Run(menu func() error) {
+	utils.Watch(s)
+	defer utils.Watch(nil)
+	err := menu()
+	if errors.Is(err, utils.ErrPromptExpired) {
+		s.ended = true
+		fmt.Printf("Session ended after %d seconds without activity. Please take your card.\n", s.limits.IdleSeconds)
+	} else if err != nil {
+		fmt.Println("Session ended:", err)
+	}
+}
+
+// Reads a menu choice. Every choice except the free ones (showing the options
+// again, exiting) counts as an operation, and the user is warned as they near
+// the session's cap.
+func (s *Session) Choose(prompt string, free ...string) (string, error) {
+	choice, err := utils.TypeInput(prompt)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range free {
+		if choice == f {
+			return choice, nil
+		}
+	}
+
+	s.Operations++
+	switch left := s.limits.MaxOperations - s.Operations; {
+	case left == 0:
+		fmt.Println("This is the last operation of this session.")
+	case left == 1:
+		fmt.Println("1 operation left in this session.")
+	case left <= warnOperations:
+		fmt.Printf("%d operations left in this session.\n", left)
+	}
+	return choice, nil
+}
+
+// Whether the menu should keep going. Once the operation cap is reached the
+// session ends and the user is told why.
+func (s *Session) Active() bool {
+	if s.ended {
+		return false
+	}
+	if s.Operations >= s.limits.MaxOperations {
+		s.ended = true
+		fmt.Printf("Session ended: the limit of %d operations per session was reached. Please take your card.\n", s.limits.MaxOperations)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,345 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Where interactive input comes from. Implementations print or record the
+// prompt themselves, and return ErrPromptExpired if a watched session's
+// deadline passes before the prompt is answered.
+type Input interface {
+	ReadLine(prompt string) (string, error)
+	// Like ReadLine, but the value must not be echoed or logged
+	ReadSecret(prompt string) (string, error)
+}
+
+// Input every prompt in the program reads from
+var input Input = NewConsoleInput(os.Stdin)
+
+// Swap the input source, e.g. for script mode
+func SetInput(in Input) {
+	input = in
+}
+
+// Called once input runs out. Flushes the input source if it needs it, then
+// ends the program since there is nobody left to answer the prompts.
+func inputClosed() {
+	if c, ok := input.(io.Closer); ok {
+		c.Close()
+	} else {
+		fmt.Println("\nInput closed. Goodbye!")
+	}
+	os.Exit(0)
+}
+
+// Reads lines from a terminal or a pipe. One reader goroutine makes every
+// read, so piped input isn't lost to read-ahead buffering, and a read left
+// waiting when its prompt expires answers the next prompt instead of racing a
+// second read for the same line.
+type ConsoleInput struct {
+	file     *os.File
+	reader   *bufio.Reader
+	requests chan bool // one per read the reader goroutine should make, true for a secret
+	results  chan readResult
+	pending  bool // a read has been asked for and its line not yet taken
+}
+
+func NewConsoleInput(f *os.File) *ConsoleInput {
+	c := &ConsoleInput{
+		file:     f,
+		reader:   bufio.NewReader(f),
+		requests: make(chan bool),
+		results:  make(chan readResult),
+	}
+	go c.readLoop()
+	return c
+}
+
+// The reader goroutine. It only reads when asked to, so a PIN prompt can turn
+// the terminal's echo off first.
+func (c *ConsoleInput) readLoop() {
+	for secret := range c.requests {
+		var r readResult
+		if secret {
+			r.line, r.err = c.readSecret()
+		} else {
+			r.line, r.err = c.readLine()
+		}
+		c.results <- r
+	}
+}
+
+func (c *ConsoleInput) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimSpace(line), err
+}
+
+func (c *ConsoleInput) readSecret() (string, error) {
+	// Piped input has no terminal to hide the echo on
+	if !term.IsTerminal(int(c.file.Fd())) {
+		return c.readLine()
+	}
+	secret, err := term.ReadPassword(int(c.file.Fd()))
+	fmt.Println()
+	return strings.TrimSpace(string(secret)), err
+}
+
+// Prints the prompt and waits for its answer, or for the line of a read an
+// expired prompt left waiting
+func (c *ConsoleInput) read(prompt string, secret bool) (string, error) {
+	fmt.Print(prompt + " ")
+	if !c.pending {
+		c.requests <- secret
+		c.pending = true
+	}
+	r, ok := waitFor(c.results)
+	if !ok {
+		return "", ErrPromptExpired
+	}
+	c.pending = false
+	return r.line, r.err
+}
+
+func (c *ConsoleInput) ReadLine(prompt string) (string, error) {
+	return c.read(prompt, false)
+}
+
+func (c *ConsoleInput) ReadSecret(prompt string) (string, error) {
+	return c.read(prompt, true)
+}
+
+// One prompt answered from a script, with everything the program printed in response
+type ScriptStep struct {
+	Step      int      `json:"step"`
+	Prompt    string   `json:"prompt,omitempty"`
+	Input     *string  `json:"input,omitempty"`
+	Directive string   `json:"directive,omitempty"`
+	Output    []string `json:"output"`
+	Error     string   `json:"error,omitempty"`
+	EOF       bool     `json:"eof,omitempty"`
+}
+
+// Replays answers from a script file, one per line. Blank lines answer with
+// an empty line, lines starting with "#" are comments, and lines starting with
+// "!" are directives such as "!card cards/AdminBob.txt".
+//
+// While a script runs, the program's stdout is redirected to a capture file.
+// Each answered prompt is written to the results writer as one JSON object
+// holding the prompt, the answer and the output it produced.
+type ScriptInput struct {
+	lines      []string
+	next       int
+	directives map[string]func(arg string) error
+	capture    *os.File
+	captured   int64
+	results    *json.Encoder
+	step       int
+	pending    *ScriptStep
+	closed     bool
+}
+
+// Loads a script. capture must be the file stdout now points to, and results
+// is where the JSON steps are written.
+func NewScriptInput(path string, capture *os.File, results io.Writer) (*ScriptInput, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimRight(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	return &ScriptInput{
+		lines:      strings.Split(text, "\n"),
+		directives: map[string]func(string) error{},
+		capture:    capture,
+		results:    json.NewEncoder(results),
+	}, nil
+}
+
+// Registers a handler for "!name arg" lines in the script
+func (s *ScriptInput) HandleDirective(name string, handler func(arg string) error) {
+	s.directives[name] = handler
+}
+
+// Everything printed since the last call, split into lines
+func (s *ScriptInput) takeOutput() []string {
+	info, err := s.capture.Stat()
+	if err != nil || info.Size() <= s.captured {
+		return []string{}
+	}
+	buf := make([]byte, info.Size()-s.captured)
+	n, _ := s.capture.ReadAt(buf, s.captured)
+	s.captured += int64(n)
+
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(string(buf[:n]), "\n"), "\n") {
+		lines = append(lines, strings.TrimRight(line, " "))
+	}
+	return lines
+}
+
+// Writes out the step waiting for its output, or the program's opening output
+func (s *ScriptInput) flush() {
+	output := s.takeOutput()
+	if s.pending == nil {
+		if len(output) == 0 {
+			return
+		}
+		s.pending = &ScriptStep{Step: s.step}
+		s.step++
+	}
+	s.pending.Output = output
+	s.results.Encode(s.pending)
+	s.pending = nil
+}
+
+func (s *ScriptInput) read(prompt string, secret bool) (string, error) {
+	s.flush()
+	for s.next < len(s.lines) {
+		line := s.lines[s.next]
+		s.next++
+
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "!") {
+			name, arg, _ := strings.Cut(strings.TrimPrefix(line, "!"), " ")
+			step := &ScriptStep{Step: s.step, Directive: strings.TrimSpace(line)}
+			s.step++
+			if handler, ok := s.directives[name]; !ok {
+				step.Error = fmt.Sprintf("unknown directive %q", name)
+			} else if err := handler(strings.TrimSpace(arg)); errors.Is(err, ErrPromptExpired) {
+				// The prompt this directive came before has timed out
+				s.pending = step
+				return "", err
+			} else if err != nil {
+				step.Error = err.Error()
+			}
+			s.pending = step
+			s.flush()
+			continue
+		}
+
+		answer := strings.TrimSpace(line)
+		recorded := answer
+		if secret {
+			recorded = strings.Repeat("*", len(answer))
+		}
+		s.pending = &ScriptStep{Step: s.step, Prompt: strings.TrimSpace(prompt), Input: &recorded}
+		s.step++
+		return answer, nil
+	}
+
+	s.pending = &ScriptStep{Step: s.step, Prompt: strings.TrimSpace(prompt), EOF: true}
+	s.step++
+	return "", io.EOF
+}
+
+func (s *ScriptInput) ReadLine(prompt string) (string, error) {
+	return s.read(prompt, false)
+}
+
+func (s *ScriptInput) ReadSecret(prompt string) (string, error) {
+	return s.read(prompt, true)
+}
+
+// Writes out the last step. Safe to call more than once.
+func (s *ScriptInput) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.flush()
+	return nil
+}
+
+// Holds every prompt to a deadline while a login session is open
+type Watcher interface {
+	// When the prompt being read must be answered by
+	Deadline() time.Time
+	// How long before the deadline to start counting down
+	Warning() time.Duration
+	// Called with each answer
+	Touch()
+}
+
+// Returned by a prompt whose deadline passed before it was answered. The
+// menus return it so the session can log the user out from wherever they
+// were.
+var ErrPromptExpired = errors.New("prompt expired")
+
+// Interval between countdown warnings
+const countdownStep = 10 * time.Second
+
+var watcher Watcher
+
+// Starts holding prompts to w's deadlines, or stops when w is nil
+func Watch(w Watcher) {
+	watcher = w
+}
+
+type readResult struct {
+	line string
+	err  error
+}
+
+// Waits for ready. While a session is being watched, counts down to its
+// deadline and gives up once it passes, returning false.
+func waitFor[T any](ready <-chan T) (T, bool) {
+	for {
+		var timeout <-chan time.Time
+		if watcher != nil {
+			remaining := time.Until(watcher.Deadline())
+			if remaining <= 0 {
+				fmt.Println()
+				var zero T
+				return zero, false
+			}
+			wait := remaining - watcher.Warning()
+			if wait <= 0 {
+				fmt.Printf("\nNo activity: this session ends in %d seconds.\n", int(remaining.Round(time.Second).Seconds()))
+				wait = min(countdownStep, remaining)
+			}
+			timeout = time.After(wait)
+		}
+
+		select {
+		case v := <-ready:
+			return v, true
+		case <-timeout:
+		}
+	}
+}
+
+// Pauses for d as if nobody were at the ATM. Returns ErrPromptExpired if the
+// watched session's deadline passes first.
+func Idle(d time.Duration) error {
+	if _, ok := waitFor(time.After(d)); !ok {
+		return ErrPromptExpired
+	}
+	return nil
+}
+
+func read(prompt string, secret bool) (string, error) {
+	var line string
+	var err error
+	if secret {
+		line, err = input.ReadSecret(prompt)
+	} else {
+		line, err = input.ReadLine(prompt)
+	}
+	if err == nil && watcher != nil {
+		watcher.Touch()
+	}
+	return line, err
+}
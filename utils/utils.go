@@ -1,39 +1,62 @@
 package utils
 
 import (
+	"SPG_ATM_Machine/internal/models"
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
-func TypeInput(prompt string) string {
-	fmt.Print(prompt + " ")
-	reader := bufio.NewReader(os.Stdin)
-	input, _ := reader.ReadString('\n')
-	return strings.TrimSpace(input)
+// Reads the answer to prompt. The only error is ErrPromptExpired; once input
+// runs out the program ends.
+func TypeInput(prompt string) (string, error) {
+	line, err := read(prompt, false)
+	if errors.Is(err, ErrPromptExpired) {
+		return "", err
+	}
+	if err != nil {
+		inputClosed()
+	}
+	return line, nil
 }
 
-func TypeInt(prompt string) int {
+// Like TypeInput, but the answer is not echoed, e.g. for PINs
+func TypeSecret(prompt string) (string, error) {
+	secret, err := read(prompt, true)
+	if errors.Is(err, ErrPromptExpired) {
+		return "", err
+	}
+	if err != nil {
+		inputClosed()
+	}
+	return secret, nil
+}
+
+func TypeInt(prompt string) (int, error) {
 	for {
-		input := TypeInput(prompt) // reuse your existing function
+		input, err := TypeInput(prompt) // reuse your existing function
+		if err != nil {
+			return 0, err
+		}
 		val, err := strconv.Atoi(input)
 		if err != nil {
 			fmt.Println("Invalid number, please try again.")
 			continue
 		}
-		return val
+		return val, nil
 	}
 }
 
-func ParseAmount(amountStr string) (float64, bool) {
-	amountStr = strings.TrimSpace(amountStr)
-
-	amount, err := strconv.ParseFloat(amountStr, 64)
+// Parses a dollar amount into cents. At most two decimal places are allowed.
+func ParseAmount(amountStr string) (models.Money, bool) {
+	amount, err := models.ParseMoney(amountStr)
 	if err != nil {
-		fmt.Println("Invalid input. Please enter a valid number (e.g., 100.50).")
+		fmt.Println("Invalid input. Please enter a valid amount with at most two decimal places (e.g., 100.50).")
 		return 0, false
 	}
 
@@ -69,6 +92,23 @@ func ValidateDate(date string) bool {
 	return match
 }
 
+// Parses a date typed as MM/DD/YY or MM/DD/YYYY
+func ParseDate(date string) (time.Time, bool) {
+	if !ValidateDate(date) {
+		return time.Time{}, false
+	}
+	layout := "01/02/2006"
+	if len(date) == len("01/02/06") {
+		layout = "01/02/06"
+	}
+	t, err := time.ParseInLocation(layout, date, time.Local)
+	if err != nil {
+		fmt.Println("Invalid date:", err)
+		return time.Time{}, false
+	}
+	return t, true
+}
+
 func ParseDeposit(filePath string) ([]int, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -100,3 +140,52 @@ func ParseDeposit(filePath string) ([]int, error) {
 	}
 	return deposit_denoms, nil
 }
+
+// Reads a card record file made of "key: value" lines for card_id, pan,
+// expiry and issuer
+func ParseCardFile(filePath string) (models.Card, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return models.Card{}, err
+	}
+	defer file.Close()
+
+	var card models.Card
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return models.Card{}, fmt.Errorf("invalid card record line %q", line)
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "card_id":
+			card.CardID = value
+		case "pan":
+			card.PAN = value
+		case "expiry":
+			card.Expiry = value
+		case "issuer":
+			card.Issuer = value
+		default:
+			return models.Card{}, fmt.Errorf("unknown card record field %q", key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return models.Card{}, err
+	}
+
+	if card.CardID == "" || card.PAN == "" || card.Expiry == "" || card.Issuer == "" {
+		return models.Card{}, fmt.Errorf("card record is incomplete")
+	}
+	return card, nil
+}
+
+// Writes a card record file that ParseCardFile can read back
+func WriteCardFile(filePath string, card models.Card) error {
+	return os.WriteFile(filePath, []byte(card.Record()), 0o600)
+}